@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/receipts"
+)
+
+// ReceiptHeader carries the checkout receipt a caller got from assign
+const ReceiptHeader = "X-Token-Receipt"
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the receipt the middleware verified
+func ClaimsFromContext(ctx context.Context) (*receipts.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*receipts.Claims)
+	return claims, ok
+}
+
+// verify checks the request's receipt and returns the status to fail it with,
+// or 0 and a context carrying the claims
+func verify(r *http.Request, v Verifier) (context.Context, int, string) {
+	receipt := r.Header.Get(ReceiptHeader)
+	if receipt == "" {
+		return nil, http.StatusUnauthorized, "Missing " + ReceiptHeader + " header"
+	}
+	claims, err := v.Verify(r.Context(), receipt)
+	switch {
+	case errors.Is(err, constants.ErrInvalidReceipt), errors.Is(err, constants.ErrReceiptExpired):
+		return nil, http.StatusUnauthorized, err.Error()
+	case err != nil:
+		return nil, http.StatusBadGateway, "Failed to verify receipt"
+	}
+	return context.WithValue(r.Context(), claimsKey{}, claims), 0, ""
+}
+
+// Middleware rejects requests without a valid receipt in ReceiptHeader with
+// 401, or 502 when v could not check it, and hands the rest to next with the
+// claims in the request context
+func Middleware(v Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, status, msg := verify(r, v)
+		if status != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": msg})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Gin is Middleware for gin routers
+func Gin(v Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, status, msg := verify(c.Request, v)
+		if status != 0 {
+			c.AbortWithStatusJSON(status, gin.H{"error": msg})
+			return
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+// Package client helps services that are handed tokens by the token manager
+// check that their callers really hold them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/receipts"
+)
+
+// Verifier checks a checkout receipt and returns the claims it vouches for.
+// It fails with constants.ErrInvalidReceipt or constants.ErrReceiptExpired
+// when the receipt is rejected, and with other errors when it could not be
+// checked at all.
+type Verifier interface {
+	Verify(ctx context.Context, receipt string) (*receipts.Claims, error)
+}
+
+// OfflineVerifier checks receipts against the signing key shared with the
+// token manager (Receipts.SigningKey), without calling it
+type OfflineVerifier struct {
+	signer *receipts.Signer
+}
+
+// NewOfflineVerifier returns a verifier for receipts signed with key
+func NewOfflineVerifier(key string) (*OfflineVerifier, error) {
+	signer := receipts.NewSigner(key)
+	if signer == nil {
+		return nil, errors.New("client: receipt key is required")
+	}
+	return &OfflineVerifier{signer: signer}, nil
+}
+
+func (v *OfflineVerifier) Verify(_ context.Context, receipt string) (*receipts.Claims, error) {
+	return v.signer.Verify(receipt)
+}
+
+// RemoteVerifier asks the token manager at BaseURL to check receipts through
+// POST /tokens/receipts/verify, for services that don't hold the key
+type RemoteVerifier struct {
+	BaseURL    string       // e.g. "http://token-manager:8080"
+	HTTPClient *http.Client // defaults to http.DefaultClient
+}
+
+type verifyResponse struct {
+	Valid  bool             `json:"valid"`
+	Claims *receipts.Claims `json:"claims"`
+	Error  string           `json:"error"`
+}
+
+func (v *RemoteVerifier) Verify(ctx context.Context, receipt string) (*receipts.Claims, error) {
+	body, err := json.Marshal(map[string]string{"receipt": receipt})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(v.BaseURL, "/")+"/tokens/receipts/verify", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach token manager: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result verifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode verify response (status %d): %w", resp.StatusCode, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK && result.Valid:
+		return result.Claims, nil
+	case resp.StatusCode == http.StatusUnauthorized && result.Error == constants.ErrReceiptExpired.Error():
+		return result.Claims, constants.ErrReceiptExpired
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, constants.ErrInvalidReceipt
+	default:
+		return nil, fmt.Errorf("token manager failed to verify receipt: status %d: %s", resp.StatusCode, result.Error)
+	}
+}
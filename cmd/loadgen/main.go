@@ -0,0 +1,176 @@
+// Command loadgen drives assign/keepalive/release load against a running
+// token-manager and reports latency percentiles per operation, for capacity
+// planning before launches.
+//
+//	go run ./cmd/loadgen -url http://localhost:8080 -pool default -concurrency 50 -duration 1m
+//
+// Each worker loops: assign a token, send -keepalives keepalives, then release
+// it with unblock. An empty pool counts as a "miss" rather than an error. Run
+// it against a staging deployment seeded with tokens, never production.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type result struct {
+	op      string
+	latency time.Duration
+	outcome string // ok, miss or error
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "token-manager base URL")
+	pool := flag.String("pool", "default", "pool to assign from")
+	clientID := flag.String("client", "loadgen", "X-Client-ID sent with every request")
+	concurrency := flag.Int("concurrency", 10, "concurrent workers")
+	duration := flag.Duration("duration", 30*time.Second, "how long to generate load")
+	keepalives := flag.Int("keepalives", 1, "keepalives per assignment")
+	hold := flag.Duration("hold", 0, "time each worker holds a token between keepalives")
+	timeout := flag.Duration("timeout", 5*time.Second, "per request timeout")
+	flag.Parse()
+
+	lg := &loadgen{
+		base:   *baseURL,
+		pool:   *pool,
+		client: *clientID,
+		http:   &http.Client{Timeout: *timeout},
+	}
+
+	results := make(chan result, 1024)
+	deadline := time.Now().Add(*duration)
+
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				lg.cycle(results, *keepalives, *hold)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	byOp := make(map[string][]result)
+	for r := range results {
+		byOp[r.op] = append(byOp[r.op], r)
+	}
+	report(os.Stdout, byOp, *duration)
+}
+
+type loadgen struct {
+	base   string
+	pool   string
+	client string
+	http   *http.Client
+}
+
+// cycle runs one assign, keepalive and release round
+func (lg *loadgen) cycle(results chan<- result, keepalives int, hold time.Duration) {
+	var assigned struct {
+		Token string `json:"token"`
+	}
+	r := lg.do("assign", "/tokens/assign?pool="+url.QueryEscape(lg.pool), nil, &assigned)
+	results <- r
+	if r.outcome != "ok" {
+		if r.outcome == "miss" {
+			// Back off briefly so an empty pool doesn't turn into a busy loop
+			time.Sleep(50 * time.Millisecond)
+		}
+		return
+	}
+
+	token := url.PathEscape(assigned.Token)
+	for range keepalives {
+		time.Sleep(hold)
+		results <- lg.do("keepalive", "/tokens/keepalive/"+token, nil, nil)
+	}
+	// unblock reads the token from the JSON body as well as the path
+	body, _ := json.Marshal(map[string]string{"token": assigned.Token})
+	results <- lg.do("release", "/tokens/unblock/"+token, body, nil)
+}
+
+// do POSTs body to path and times the round trip, decoding a 200 body into out
+func (lg *loadgen) do(op, path string, body []byte, out any) result {
+	req, err := http.NewRequest(http.MethodPost, lg.base+path, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
+	req.Header.Set("X-Client-ID", lg.client)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := lg.http.Do(req)
+	if err != nil {
+		return result{op: op, latency: time.Since(start), outcome: "error"}
+	}
+	defer resp.Body.Close()
+
+	outcome := "ok"
+	switch {
+	case resp.StatusCode == http.StatusOK && out != nil:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			outcome = "error"
+		}
+	case resp.StatusCode == http.StatusOK:
+	case op == "assign" && resp.StatusCode >= 400 && resp.StatusCode < 600 && resp.StatusCode != http.StatusInternalServerError:
+		// The empty pool status is configurable, so anything but a 500 counts as a miss
+		outcome = "miss"
+	default:
+		outcome = "error"
+	}
+	io.Copy(io.Discard, resp.Body)
+	return result{op: op, latency: time.Since(start), outcome: outcome}
+}
+
+// report prints throughput, outcomes and latency percentiles per operation
+func report(w io.Writer, byOp map[string][]result, duration time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\treqs\treq/s\tok\tmiss\terror\tp50\tp90\tp99\tmax\t")
+
+	for _, op := range []string{"assign", "keepalive", "release"} {
+		results := byOp[op]
+		if len(results) == 0 {
+			continue
+		}
+
+		outcomes := make(map[string]int)
+		latencies := make([]time.Duration, len(results))
+		for i, r := range results {
+			outcomes[r.outcome]++
+			latencies[i] = r.latency
+		}
+		slices.Sort(latencies)
+
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			op, len(results), float64(len(results))/duration.Seconds(),
+			outcomes["ok"], outcomes["miss"], outcomes["error"],
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+			latencies[len(latencies)-1].Round(time.Microsecond))
+	}
+	tw.Flush()
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	return sorted[max(rank-1, 0)].Round(time.Microsecond)
+}
@@ -3,67 +3,73 @@ package main
 import (
 	"context"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"sync"
 	"syscall"
 
+	"github.com/manankarani/token-manager/constants"
 	"github.com/manankarani/token-manager/datasources"
 	"github.com/manankarani/token-manager/env"
-	"github.com/manankarani/token-manager/internal/handlers"
-	"github.com/manankarani/token-manager/internal/repositories"
-	"github.com/manankarani/token-manager/internal/services"
-	"github.com/manankarani/token-manager/internal/workers"
+	"github.com/manankarani/token-manager/internal/app"
+	"github.com/manankarani/token-manager/logging"
 )
 
 func main() {
-	// Initialize logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	// Load environment variables
 	env.Load()
 
+	// Initialize logger
+	logger := logging.New(os.Stdout, env.Conf.Server.ENV, env.Conf.Server.LogLevel)
+	slog.SetDefault(logger)
+	logger.Info("Starting token manager", slog.Any("config", env.Describe()))
+
 	// Initialize Redis client
 	redisClient := datasources.NewRedisClient()
 	defer redisClient.Close()
 
-	// Initialize repositories, services, and controllers
-	tokenRepo := repositories.NewTokenRepository(redisClient)
-	tokenService := services.NewTokenService(tokenRepo)
-	tokenHandler := handlers.NewTokenHandler(tokenService)
+	application, err := app.New(logger, redisClient)
+	if err != nil {
+		logger.Error("Failed to initialize", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
-	// Setup routes
-	router := handlers.SetupRoutes(tokenHandler)
+	// Context for graceful shutdown on OS signals
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if err := application.ReconcilePools(ctx); err != nil {
+		logger.Error("Failed to reconcile pools", slog.String("error", err.Error()))
+	}
+	if err := application.WarmPools(ctx); err != nil {
+		logger.Error("Failed to warm up pools", slog.String("error", err.Error()))
+	}
+	if bound, err := application.Service.BindWebhookSecrets(ctx); err != nil {
+		logger.Error("Failed to bind webhook secrets", slog.String("error", err.Error()))
+	} else if bound > 0 {
+		logger.Info("Bound webhook secrets to their webhooks", slog.Int("webhooks", bound))
+	}
+	env.WatchPools(func() {
+		if err := application.ReconcilePools(ctx); err != nil {
+			logger.Error("Failed to reconcile pools after config change", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("Reconciled pools after config change")
+	})
 
 	// TODO: can be migrated to a new microservice
-	go workers.StartCleanupWorker(ctx, tokenService.CleanupExpiredTokens, logger)
-
-	// Create HTTP server
-	srv := &http.Server{Addr: ":" + strconv.Itoa(env.Conf.Server.Port), Handler: router}
-
-	// Handle OS signals for graceful shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
-		<-stop
-		logger.Info("Shutting down server...")
-
-		// Stop cleanup worker
-		cancel()
-
-		// Gracefully shutdown HTTP server
-		if err := srv.Shutdown(context.Background()); err != nil {
-			logger.Error("HTTP server shutdown error", slog.String("error", err.Error()))
-		}
+		defer wg.Done()
+		application.RunWorkers(ctx)
 	}()
 
-	logger.Info("Server running on :8080")
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if env.Conf.Server.Mode == constants.ModeWorker {
+		logger.Info("Running in worker mode, HTTP server disabled")
+	} else if err := application.Serve(ctx); err != nil {
 		logger.Error("Server error", slog.String("error", err.Error()))
+		stop()
 	}
+	wg.Wait()
 }
@@ -0,0 +1,93 @@
+// Command restore loads a backup snapshot from the Backup bucket into the
+// Redis configured for the current Env (the secondary when failed over).
+//
+//	Env=prod ./token-restore -list
+//	Env=prod ./token-restore                                  # newest snapshot
+//	Env=prod ./token-restore -object token-manager/prod/20261016T120000Z.json.gz.enc
+//
+// It refuses to write into a non-empty Redis unless -force is given; with
+// -force, keys in the snapshot replace existing ones and other keys are kept.
+// Stop the service first so it doesn't write while the restore runs.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/env"
+	"github.com/manankarani/token-manager/internal/app"
+	"github.com/manankarani/token-manager/internal/backup"
+)
+
+func main() {
+	list := flag.Bool("list", false, "list snapshots, oldest first, and exit")
+	object := flag.String("object", "", "snapshot object key to restore; the newest when empty")
+	force := flag.Bool("force", false, "restore into a Redis that already holds keys")
+	flag.Parse()
+
+	env.Load()
+	ctx := context.Background()
+
+	store, err := datasources.NewBackupStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if store == nil {
+		log.Fatal("Backup.Bucket is not set")
+	}
+
+	snapshots, err := backup.Snapshots(ctx, store, env.Conf.Backup.Prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *list {
+		for _, key := range snapshots {
+			fmt.Println(key)
+		}
+		return
+	}
+	key := *object
+	if key == "" {
+		if len(snapshots) == 0 {
+			log.Fatalf("no snapshots under %q", env.Conf.Backup.Prefix)
+		}
+		key = snapshots[len(snapshots)-1]
+	}
+
+	cipher, err := app.BackupCipher()
+	if err != nil {
+		log.Fatalf("invalid backup key: %v", err)
+	}
+	if cipher == nil {
+		log.Fatal("Backup.Key or Backup.KeyEnv is not set")
+	}
+
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	snap, err := backup.Decode(data, cipher)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	redisClient := datasources.NewRedisClient()
+	defer redisClient.Close()
+	size, err := redisClient.DBSize(ctx).Result()
+	if err != nil {
+		log.Fatalf("failed to check Redis: %v", err)
+	}
+	if size > 0 && !*force {
+		log.Fatalf("Redis already holds %d keys, rerun with -force to overwrite", size)
+	}
+
+	restored, err := backup.Restore(ctx, redisClient, snap, constants.BackupBatchSize)
+	if err != nil {
+		log.Fatalf("restored %d of %d keys: %v", restored, len(snap.Keys), err)
+	}
+	fmt.Printf("Restored %d keys from %s (taken %s)\n", restored, key, snap.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+}
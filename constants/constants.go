@@ -1,6 +1,9 @@
 package constants
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 const (
 	EnvVarENV = "Env"
@@ -8,26 +11,301 @@ const (
 
 // Custom errors
 var (
-	ErrNoAvailableTokens = errors.New("no available tokens in pool")
-	ErrTokenNotFound     = errors.New("token not found in any pool")
-	ErrTokenNotAssigned  = errors.New("token not found in assigned tokens")
-	ErrFailedKeepAlive   = errors.New("failed to keep token alive")
-	ErrTokenAlreadyInUse = errors.New("token already in use")
+	ErrNoAvailableTokens     = errors.New("no available tokens in pool")
+	ErrTokenNotFound         = errors.New("token not found in any pool")
+	ErrTokenNotAssigned      = errors.New("token not found in assigned tokens")
+	ErrFailedKeepAlive       = errors.New("failed to keep token alive")
+	ErrTokenAlreadyInUse     = errors.New("token already in use")
+	ErrTicketNotFound        = errors.New("queue ticket not found")
+	ErrNotQueueHead          = errors.New("queue ticket is not at the head of the queue")
+	ErrDuplicateJob          = errors.New("an identical job is already queued")
+	ErrUnknownJob            = errors.New("no handler registered for job")
+	ErrJobNotFound           = errors.New("job not found")
+	ErrInvalidReceipt        = errors.New("invalid checkout receipt")
+	ErrReceiptExpired        = errors.New("checkout receipt expired")
+	ErrSecretNotFound        = errors.New("secret not found for handle")
+	ErrNotTokenOwner         = errors.New("token is assigned to another client")
+	ErrCleanupBudgetExceeded = errors.New("cleanup cycle exceeded its time budget")
+	ErrCleanupInProgress     = errors.New("cleanup is already running for this pool")
+	ErrTokenPrefixMismatch   = errors.New("token does not carry its pool's prefix")
+	ErrAssignmentCapReached  = errors.New("maximum concurrent assignments reached")
+	ErrPoolFull              = errors.New("pool is at its maximum size")
+	ErrInvalidTiming         = errors.New("invalid pool timing")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrInvalidWebhook        = errors.New("invalid webhook")
+	ErrInvalidTransition     = errors.New("invalid token state transition")
+	ErrVersionMismatch       = errors.New("token was modified since the given version")
+	ErrTokenNotQuarantined   = errors.New("token is not quarantined")
+	ErrKeepaliveNotAssigned  = errors.New("keepalive rejected, token is not assigned")
+	ErrTokenExists           = errors.New("token already exists")
+	ErrDuplicateImportRow    = errors.New("token repeats an earlier row of the import")
+	ErrInvalidImport         = errors.New("invalid import file")
+	ErrImportNotFound        = errors.New("import not found")
+	ErrJobCancelled          = errors.New("job cancelled")
+	ErrJobPermanent          = errors.New("job failed permanently") // wrapped by handler errors a retry can't fix
+	ErrAliasTaken            = errors.New("alias is already in use")
+	ErrAliasNotFound         = errors.New("token has no alias")
+	ErrMaxHoldReached        = errors.New("token has been held for its pool's maximum hold time")
 )
 
 // Redis keys
 const (
-	KeyTokenPool       = "token_pool"
-	KeyAssignedTokens  = "assigned_tokens"
-	KeyKeepaliveTokens = "keepalive_tokens"
-	PrefixLockKey      = "lock"
-	LockValue          = "locked"
+	KeyTokenPool           = "token_pool"
+	KeyAssignedTokens      = "assigned_tokens"
+	KeyKeepaliveTokens     = "keepalive_tokens"
+	KeyAssignQueue         = "assign_queue"
+	KeyQueueRotation       = "assign_queue_rotation"
+	KeyQueueClients        = "assign_queue_clients"
+	KeyQueueCredits        = "assign_queue_credits"
+	PrefixClientQueueKey   = "assign_queue_client"
+	PrefixLockKey          = "lock"
+	PrefixQueueTicketKey   = "queue_ticket"
+	KeyPools               = "token_pools"
+	KeyTokenPoolIndex      = "token_pool_index"     // hash of token -> pool it was created in
+	KeyIssuedTokens        = "token_issued"         // set of every token ever saved, kept past deletion so a value is never issued twice
+	KeyTokenCiphertext     = "token_ciphertext"     // hash of token index -> encrypted token, when encryption is on
+	KeyTokenLeases         = "token_leases"         // hash of token -> Vault lease ID, per pool
+	KeyTokenLabels         = "token_labels"         // hash of token -> JSON encoded labels
+	KeyTokenAliases        = "token_aliases"        // hash of alias -> token, mirrored by KeyAliasOfToken
+	KeyAliasOfToken        = "token_alias_of"       // hash of token -> its alias
+	PrefixTokenLabelKey    = "token_label"          // set of tokens per pool and key=value label
+	KeyTokenRateLimits     = "token_rate_limits"    // hash of token -> upstream requests per minute
+	PrefixTokenUsageKey    = "token_usage"          // sorted set of token utilisation per pool and minute
+	KeyTokenQuarantine     = "token_quarantine"     // set of tokens pulled from the pool after failing a health probe
+	KeyTokenProbes         = "token_probes"         // hash of token -> JSON encoded last probe result
+	KeyTokenProbeFailures  = "token_probe_failures" // hash of token -> consecutive failed probes
+	KeyQuarantinedAt       = "token_quarantined_at" // sorted set of quarantined tokens by when they were pulled, per pool
+	PrefixRecentAssignKey  = "assign_recent"        // token last handed to a client, per pool and selector
+	PrefixTokenCallbackKey = "token_callback"       // hash of the holder's callback URL, per assigned token
+	KeyTokenReclaims       = "token_reclaims"       // sorted set of tokens to release once their holder's grace runs out, per pool
+	KeyTokenOwners         = "token_owners"         // hash of the client holding each assigned token
+	PrefixClientTokensKey  = "client_tokens"        // set of tokens assigned to a client; may lag releases, see TokenRepository.ClientTokens
+	PrefixTokenRecordKey   = "token_record"         // hash of a token's versioned record: state, owner, lease and timestamps
+	KeyPendingTokens       = "token_pending"        // sorted set of inactive tokens by activation time, per pool
+	KeyAssignmentSlots     = "assignment_slots"     // set of assigned tokens counted against Tokens.MaxConcurrentAssignments, across pools
+	PrefixWarmupLockKey    = "pool_warmup"          // held by the replica seeding a pool at startup
+	PrefixCleanupLockKey   = "cleanup_lock"         // held per pool and phase by the cleanup run working on it
+	KeyCleanupPause        = "cleanup_pause"        // hash set while scheduled cleanup is paused through the admin API
+	KeyPoolTiming          = "pool_timing"          // hash of pool -> JSON timing set at runtime through the admin API
+	KeyJobStream           = "jobs:stream"
+	KeyJobDelayed          = "jobs:delayed"    // retries waiting for their backoff, scored by due time (ms)
+	KeyJobDeadLetter       = "jobs:deadletter" // jobs that exhausted their attempts
+	PrefixJobUniqueKey     = "jobs:unique"
+	PrefixJobStatusKey     = "jobs:status"
+	LockValue              = "locked"
+)
+
+// Process modes
+const (
+	ModeServer = "server" // HTTP API plus background workers
+	ModeWorker = "worker" // background workers only, for sidecar or operator deployments
 )
 
-// Token pool configuration
+// DefaultPool is used when a request doesn't name a pool
+const DefaultPool = "default"
+
+// MaxBatchAssignCount is the most tokens one batch assign request may ask for
+const MaxBatchAssignCount = 100
+
+// Token pool defaults, in seconds; overridden by the Tokens config section
 const (
 	TokenLockTime        = 60
-	TokenAutoReleaseTime = 60     // 60 seconds
+	TokenAutoReleaseTime = 60     // assignment TTL, and the keepalive grace after it
 	TokenDeletionTime    = 5 * 60 // 5 minutes
 	TokenCleanupInterval = 10     // 10 seconds
+
+	TokenUsageWindowTTL = 2 * time.Minute // usage is bucketed per minute; keep the previous bucket briefly
+	TokenUsageSample    = 16              // random candidates compared when preferring the least utilised token
+
+	MaxGenerateAttempts = 5 // values a generate tries before giving up on finding one not yet issued
+)
+
+// Assignment wait queue
+const (
+	QueueTicketTTL            = 30 * time.Second // waiters must poll within this window to keep their place
+	QueuePollInterval         = 250 * time.Millisecond
+	DefaultQueueLongPollLimit = 25 * time.Second
+	AnonymousClientID         = "anonymous"
+	HeaderClientID            = "X-Client-ID"
+)
+
+// Admin actors
+const (
+	HeaderOnBehalfOf = "X-On-Behalf-Of" // who an automated admin caller acts for, recorded next to the actor
+	MaxActorLength   = 256
+)
+
+// Per-request deadline budgets
+const (
+	HeaderRequestDeadline = "X-Request-Deadline-Ms" // milliseconds the caller allows for the whole request
+	MaxRequestDeadline    = 5 * time.Minute
+)
+
+// Response compression
+const DefaultCompressionMinSize = 1024 // bytes; smaller bodies gain less than compressing them costs
+
+// Token records
+const (
+	// TokenRecordVersion is the schema version of newly written token records.
+	// Bump it when a field is added or changes meaning, and teach the
+	// migrate_records job to upgrade the older version.
+	TokenRecordVersion = 2 // 2: msgpack-encoded data field; 1: a hash field per record field
+)
+
+// Streamed listings
+const (
+	StreamScanCount = 500  // SSCAN COUNT hint, and the batch a streamed listing reveals and writes at once
+	ExpiryBatchSize = 1000 // members per ZMSCORE when listing assigned token expiries
+	MIMENDJSON      = "application/x-ndjson"
+	MIMECSV         = "text/csv"
+)
+
+// Cleanup worker pool defaults
+const (
+	DefaultCleanupWorkers          = 4
+	DefaultCleanupBatchSize        = 500
+	DefaultCleanupPipelineSize     = 500 // max commands per Redis pipeline
+	DefaultCleanupChunkRetries     = 2
+	CleanupChunkRetryBackoff       = 100 * time.Millisecond
+	DefaultCleanupOperationTimeout = 5 * time.Second        // per Redis call or pipeline chunk
+	DefaultCleanupCycleTimeout     = 2 * time.Minute        // wall-clock budget for one cleanup run
+	CleanupReportTokenLimit        = 100                    // a cleanup report lists the tokens it touched only up to this many per phase
+	CleanupLockPoll                = 250 * time.Millisecond // how often a manual run waiting for a busy pool retries
+
+	DefaultPoolDiscoveryInterval = 30 * time.Second
+	DefaultReleaseSchedule       = "@every 5s"
+	DefaultDeletionSchedule      = "@every 5m"
+	DefaultVaultSchedule         = "@every 30s"
+	VaultReplenishLimit          = 100 // max credentials minted per pool per run
+	DefaultProbeSchedule         = "@every 1m"
+	DefaultProbeTimeout          = 5 * time.Second
+	DefaultCallbackSchedule      = "@every 5s"
+	DefaultActivationSchedule    = "@every 10s"
+	DefaultAutoscaleSchedule     = "@every 30s"
+	DefaultAutoscaleWindow       = 5 * time.Minute
+	PrefixAssignOutcomesKey      = "assign_outcomes" // hash of assign attempts and empty-pool failures per pool and minute
+	AssignOutcomesTTL            = time.Hour         // bounds the longest autoscale window
+	ActivationBatchSize          = 500               // pending tokens activated per script call
+	DefaultGenerateBatchWait     = 2 * time.Millisecond
+	WarmupLockTTL                = time.Minute      // bounds how long a crashed replica blocks others from warming a pool
+	PoolTimingRefreshInterval    = 10 * time.Second // how soon other replicas pick up timing changed through the admin API
+	ClockSyncInterval            = 10 * time.Second // how often Redis TIME is re-read when it is the clock
+	ClockSkewWarning             = time.Second      // local clock drift from Redis that is logged
+	DefaultCallbackLead          = 15 * time.Second
+	DefaultCallbackGrace         = 30 * time.Second
+	DefaultCallbackTimeout       = 5 * time.Second
+	KeyWebhooks                  = "webhooks"             // hash of webhook ID -> JSON encoded subscription
+	WebhookGroup                 = "webhooks"             // audit stream consumer group delivering events to webhooks
+	WebhookDeliveryJob           = "webhook.deliver"      // job POSTing one event to one webhook, retried by the job queue
+	KeyCallbackDeliveries        = "callbacks:deliveries" // stream of callback attempts and their outcome
+	DefaultNATSSubject           = "tokens"
+	DefaultNATSQueue             = "token-manager"
+	DefaultNATSWorkers           = 16
+	NATSReconnectWait            = 2 * time.Second
+	NATSPendingRequests          = 256   // requests buffered for the workers; beyond that the client drops them as a slow consumer
+	CallbackDeliveriesMaxLen     = 10000 // approximate; oldest attempts are trimmed first
+)
+
+// Background job queue
+const (
+	JobConsumerGroup       = "token-manager"
+	JobStreamMaxLen        = 100000
+	JobReadBlock           = 2 * time.Second
+	JobPromoteInterval     = time.Second
+	JobUniqueTTL           = 10 * time.Minute
+	JobStatusTTL           = 24 * time.Hour
+	DefaultJobWorkers      = 4
+	DefaultJobMaxAttempts  = 5
+	DefaultJobRetryBackoff = time.Second
+	DefaultJobClaimIdle    = time.Minute
+)
+
+// Audit history
+const (
+	KeyAuditStream    = "audit:stream"
+	AuditStreamMaxLen = 100000 // approximate; oldest entries are trimmed first
+	AuditScanLimit    = 10000  // entries scanned when filtering history by token
+	DefaultAuditLimit = 100
+
+	AuditExportGroup          = "audit-export" // consumer group shipping the stream to Audit.Export.Sink
+	DefaultAuditExportBatch   = 100
+	DefaultAuditExportTimeout = 5 * time.Second
+	AuditExportBlock          = 2 * time.Second  // wait for new entries before checking for stale ones again
+	AuditExportClaimIdle      = time.Minute      // entries a crashed replica left unacknowledged this long are taken over
+	AuditExportMaxBackoff     = 30 * time.Second // cap on the retry delay while a sink is down
+	DefaultAuditFileMaxMB     = 100
+	DefaultAuditFileMaxFiles  = 5
+
+	EventStreamGroup         = "event-stream" // audit stream consumer group republishing entries to Audit.Stream.Key
+	DefaultEventStreamKey    = "events:stream"
+	DefaultEventStreamMaxLen = 100000 // approximate; entries no downstream group has read yet are trimmed too
+)
+
+// Cross-region replication
+const (
+	KeyReplicationLock         = "replication_lock" // held by the replica copying state to the secondary region
+	DefaultReplicationInterval = 30 * time.Second
+	DefaultReplicationBatch    = 500 // keys dumped and restored per pipeline
+)
+
+// Memory reporting
+const (
+	MemorySampleKeys   = 100 // keys measured per family of many keys; the rest are extrapolated from them
+	MemoryUsageSamples = 5   // nested values MEMORY USAGE samples per key, its own default
+)
+
+// History compaction
+const (
+	DefaultHistoryCompactionSchedule = "@every 10m"
+	HistoryCompactionTimeout         = 5 * time.Minute
+)
+
+// Backups
+const (
+	KeyBackupLock   = "backup_lock"   // held by the replica taking the scheduled snapshot
+	BackupLockTTL   = 5 * time.Minute // also the shortest gap between two scheduled snapshots
+	BackupBatchSize = 500
+	BackupTimeout   = 30 * time.Minute
+)
+
+// Alerts
+const (
+	PrefixAlertThrottleKey  = "alert_throttle" // set while an alert for a pool and kind may not be sent again
+	PrefixAlertActiveKey    = "alert_active"   // set while an alert for a pool and kind is raised
+	PrefixSweepOutcomesKey  = "sweep_outcomes" // hash of cleanup runs and errors per pool and minute
+	DefaultAlertSchedule    = "@every 30s"
+	DefaultAlertThrottle    = 15 * time.Minute
+	DefaultAlertErrorWindow = 5 * time.Minute
+	AlertMinSweeps          = 5 // cleanup runs needed in the window before its error rate can alert
+	AlertActiveTTL          = 24 * time.Hour
+	SweepOutcomesTTL        = time.Hour
+	DefaultPagerDutyURL     = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// Soft limits
+const (
+	PrefixSoftLimitKey    = "soft_limit_warned" // set while a soft limit warning for a limit and pool may not be sent again
+	SoftLimitWarnInterval = 15 * time.Minute
+)
+
+// Verification lockouts
+const (
+	PrefixLockoutFailuresKey = "lockout_failures" // count of failed verifications per subject within the window
+	PrefixLockoutKey         = "lockout"          // set while a subject is locked out of verification
+	DefaultLockoutFailures   = 10
+	DefaultLockoutWindow     = time.Minute
+	DefaultLockoutDuration   = 15 * time.Minute
+)
+
+// Bulk imports
+const (
+	PrefixImportSummaryKey = "import_summary" // JSON counts of an import, kept for its report
+	PrefixImportReportKey  = "import_report"  // list of JSON row errors of an import
+	PrefixImportUploadKey  = "import_upload"  // list of the raw chunks of an uploaded file, until its job reads it
+	ImportChunkSize        = 1000             // rows validated and saved per round trip
+	ImportReportTTL        = 24 * time.Hour
+	MaxImportReportRows    = 100000 // row errors kept per import; the failed count stays exact past it
+	ImportFileField        = "file" // multipart field holding the CSV
+	ImportUploadChunkSize  = 256 << 10
+	ImportJob              = "import"
 )
@@ -9,14 +9,15 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// NewRedisClient initializes and returns a Redis client.
+// NewRedisClient initializes and returns a Redis client. With
+// Replication.Failover it connects to the secondary region instead, whose
+// Replication.Host env.Load has checked is set.
 func NewRedisClient() *redis.Client {
-	client := redis.NewClient(&redis.Options{
-		Addr:     env.Conf.Redis.Host + ":" + strconv.Itoa(env.Conf.Redis.Port),
-		Username: "",
-		Password: "",
-		DB:       0,
-	})
+	host, port := env.Conf.Redis.Host, env.Conf.Redis.Port
+	if env.Conf.Replication.Failover {
+		host, port = env.Conf.Replication.Host, env.Conf.Replication.Port
+	}
+	client := newClient(host, port)
 
 	// Test Redis connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -28,3 +29,26 @@ func NewRedisClient() *redis.Client {
 
 	return client
 }
+
+// NewReplicaClient returns a client for the secondary region's Redis, or nil
+// when replication is off or the service has failed over to it. The secondary
+// may be unreachable at startup; the replicator reports that on each run.
+func NewReplicaClient() *redis.Client {
+	if env.Conf.Replication.Host == "" || env.Conf.Replication.Failover {
+		return nil
+	}
+	return newClient(env.Conf.Replication.Host, env.Conf.Replication.Port)
+}
+
+func newClient(host string, port int) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     host + ":" + strconv.Itoa(port),
+		Username: "",
+		Password: "",
+		DB:       0,
+	})
+	client.AddHook(slowCommandHook{
+		threshold: time.Duration(env.Conf.Redis.SlowCommandThresholdMs) * time.Millisecond,
+	})
+	return client
+}
@@ -0,0 +1,57 @@
+package datasources
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSConfig says where and as whom to connect to NATS
+type NATSConfig struct {
+	URL      string // nats://host:4222, or tls://host:4222
+	Name     string // shown in the server's connection list
+	User     string
+	Password string
+	Token    string // auth_token, instead of User and Password
+}
+
+// ConnectNATS returns a connection that keeps reconnecting for as long as it
+// is open, including when the server can't be reached at startup.
+// Subscriptions are restored after each reconnect, and publishes made while
+// disconnected are buffered until then.
+func ConnectNATS(config NATSConfig) (*nats.Conn, error) {
+	options := []nats.Option{
+		nats.Name(config.Name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(constants.NATSReconnectWait),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("NATS connection lost", slog.String("error", err.Error()))
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("NATS reconnected", slog.String("server", conn.ConnectedUrlRedacted()))
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			subject := ""
+			if sub != nil {
+				subject = sub.Subject
+			}
+			slog.Error("NATS error", slog.String("subject", subject), slog.String("error", err.Error()))
+		}),
+	}
+	if config.User != "" {
+		options = append(options, nats.UserInfo(config.User, config.Password))
+	}
+	if config.Token != "" {
+		options = append(options, nats.Token(config.Token))
+	}
+	conn, err := nats.Connect(config.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,177 @@
+package datasources
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisCommandDuration = metrics.NewHistogramVec(
+		"redis_command_duration_seconds",
+		"Latency of Redis commands issued by the service.",
+		metrics.DefaultLatencyBuckets,
+		"command",
+	)
+	redisFamilyDuration = metrics.NewHistogramVec(
+		"redis_family_duration_seconds",
+		"Latency of Redis commands and pipelines by command family (read, write or script) and pool.",
+		metrics.DefaultLatencyBuckets,
+		"family", "pool",
+	)
+	redisErrors = metrics.NewCounterVec(
+		"redis_errors_total",
+		"Failed Redis commands and pipelines by command family and pool. Missing keys don't count.",
+		"family", "pool",
+	)
+)
+
+// Command families the latency and error metrics are aggregated by
+const (
+	familyRead   = "read"
+	familyWrite  = "write"
+	familyScript = "script"
+)
+
+// writeCommands are the commands the service issues that modify data; every
+// other command outside scriptCommands counts as a read
+var writeCommands = map[string]bool{
+	"set": true, "setnx": true, "setex": true, "psetex": true, "getset": true, "getdel": true, "mset": true,
+	"append": true, "incr": true, "incrby": true, "decr": true, "decrby": true,
+	"del": true, "unlink": true, "expire": true, "pexpire": true, "expireat": true, "pexpireat": true, "persist": true,
+	"rename": true, "copy": true, "restore": true, "flushdb": true, "flushall": true,
+	"hset": true, "hsetnx": true, "hmset": true, "hdel": true, "hincrby": true, "hincrbyfloat": true,
+	"sadd": true, "srem": true, "spop": true, "smove": true,
+	"zadd": true, "zrem": true, "zincrby": true, "zremrangebyscore": true, "zremrangebyrank": true, "zpopmin": true, "zpopmax": true,
+	"lpush": true, "rpush": true, "lpop": true, "rpop": true, "lrem": true, "lset": true, "ltrim": true, "lmove": true, "blpop": true, "brpop": true,
+	"xadd": true, "xack": true, "xdel": true, "xtrim": true, "xgroup": true, "xclaim": true, "xautoclaim": true, "xreadgroup": true,
+	"publish": true,
+}
+
+var scriptCommands = map[string]bool{
+	"eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true, "fcall": true, "fcall_ro": true, "script": true,
+}
+
+// commandFamily classifies a command as a read, write or script
+func commandFamily(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case scriptCommands[name]:
+		return familyScript
+	case writeCommands[name]:
+		return familyWrite
+	}
+	return familyRead
+}
+
+// pipelineFamily is the heaviest family in a pipeline: script, then write, then read
+func pipelineFamily(cmds []redis.Cmder) string {
+	family := familyRead
+	for _, cmd := range cmds {
+		switch commandFamily(cmd.Name()) {
+		case familyScript:
+			return familyScript
+		case familyWrite:
+			family = familyWrite
+		}
+	}
+	return family
+}
+
+type poolContextKey struct{}
+
+// WithPool labels the Redis commands issued with the returned context by pool
+// in the family latency and error metrics
+func WithPool(ctx context.Context, pool string) context.Context {
+	return context.WithValue(ctx, poolContextKey{}, pool)
+}
+
+// poolLabel is the pool ctx was labelled with, or "none"
+func poolLabel(ctx context.Context) string {
+	if pool, ok := ctx.Value(poolContextKey{}).(string); ok && pool != "" {
+		return pool
+	}
+	return "none"
+}
+
+// failed reports errors other than redis.Nil, which only means a key is missing
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil)
+}
+
+// slowCommandHook logs Redis commands slower than threshold and records
+// the latency of every command in histograms, per command and per family
+type slowCommandHook struct {
+	threshold time.Duration
+}
+
+func (h slowCommandHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h slowCommandHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.observe(ctx, cmd, time.Since(start), err)
+		return err
+	}
+}
+
+func (h slowCommandHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		elapsed := time.Since(start)
+
+		redisCommandDuration.Observe(elapsed.Seconds(), "pipeline")
+		family, pool := pipelineFamily(cmds), poolLabel(ctx)
+		redisFamilyDuration.Observe(elapsed.Seconds(), family, pool)
+		if failed(err) {
+			redisErrors.Inc(family, pool)
+		}
+		if h.threshold > 0 && elapsed >= h.threshold {
+			slog.WarnContext(ctx, "Slow Redis pipeline",
+				slog.Int("commands", len(cmds)),
+				slog.Duration("duration", elapsed))
+		}
+		return err
+	}
+}
+
+func (h slowCommandHook) observe(ctx context.Context, cmd redis.Cmder, elapsed time.Duration, err error) {
+	redisCommandDuration.Observe(elapsed.Seconds(), cmd.Name())
+	family, pool := commandFamily(cmd.Name()), poolLabel(ctx)
+	redisFamilyDuration.Observe(elapsed.Seconds(), family, pool)
+	if failed(err) {
+		redisErrors.Inc(family, pool)
+	}
+
+	if h.threshold <= 0 || elapsed < h.threshold {
+		return
+	}
+	slog.WarnContext(ctx, "Slow Redis command",
+		slog.String("command", cmd.Name()),
+		slog.String("key", commandKey(cmd)),
+		slog.Duration("duration", elapsed))
+}
+
+// commandKey returns the first key argument of a command, if any
+func commandKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	if key, ok := args[1].(string); ok {
+		return key
+	}
+	return ""
+}
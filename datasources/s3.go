@@ -0,0 +1,229 @@
+package datasources
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/env"
+)
+
+// S3Client stores objects in an S3 bucket through the REST API, signed with
+// AWS Signature Version 4. Google Cloud Storage accepts the same requests at
+// https://storage.googleapis.com with HMAC interoperability keys.
+type S3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// NewBackupStore returns the bucket backups are written to, or nil when no
+// bucket is configured
+func NewBackupStore() (*S3Client, error) {
+	if env.Conf.Backup.Bucket == "" {
+		return nil, nil
+	}
+	endpoint := env.Conf.Backup.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + env.Conf.Backup.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Backup.Endpoint: %w", err)
+	}
+	region := env.Conf.Backup.Region
+	if region == "" {
+		region = "us-east-1" // what GCS and most S3 compatible stores expect
+	}
+	return &S3Client{
+		endpoint:  u,
+		region:    region,
+		bucket:    env.Conf.Backup.Bucket,
+		accessKey: os.Getenv(env.Conf.Backup.AccessKeyEnv),
+		secretKey: os.Getenv(env.Conf.Backup.SecretKeyEnv),
+		http:      &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Put uploads an object, replacing any with the same key
+func (s *S3Client) Put(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads an object
+func (s *S3Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes an object
+func (s *S3Client) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns the keys of every object under prefix, in key order
+func (s *S3Client) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode listing of %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for key in the bucket, or for the bucket itself
+// when key is empty, and fails on any non-2xx status
+func (s *S3Client) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + path
+	u.RawPath = s.endpoint.Path + uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("storage returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req
+func (s *S3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query sorted by key as Signature Version 4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and '/'
+// unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,104 @@
+package datasources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/env"
+)
+
+// VaultClient talks to the HashiCorp Vault HTTP API
+type VaultClient struct {
+	address   string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+// VaultSecret is the part of a Vault secret response the provisioner needs
+type VaultSecret struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+// NewVaultClient returns nil when no Vault address is configured
+func NewVaultClient() *VaultClient {
+	if env.Conf.Vault.Address == "" {
+		return nil
+	}
+	return &VaultClient{
+		address:   strings.TrimRight(env.Conf.Vault.Address, "/"),
+		token:     os.Getenv(env.Conf.Vault.TokenEnv),
+		namespace: env.Conf.Vault.Namespace,
+		http:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Mint reads a secret from a secrets engine path. Dynamic engines such as
+// database/creds/<role> issue a fresh credential and lease on every read.
+func (v *VaultClient) Mint(ctx context.Context, method, path string) (*VaultSecret, error) {
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var secret VaultSecret
+	if err := v.do(ctx, method, path, nil, &secret); err != nil {
+		return nil, fmt.Errorf("failed to mint credential at %s: %w", path, err)
+	}
+	return &secret, nil
+}
+
+// Revoke revokes a lease so the credential stops working upstream
+func (v *VaultClient) Revoke(ctx context.Context, leaseID string) error {
+	body := map[string]string{"lease_id": leaseID}
+	if err := v.do(ctx, http.MethodPut, "sys/leases/revoke", body, nil); err != nil {
+		return fmt.Errorf("failed to revoke lease: %w", err)
+	}
+	return nil
+}
+
+func (v *VaultClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+strings.TrimLeft(path, "/"), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
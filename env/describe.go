@@ -0,0 +1,49 @@
+package env
+
+import "github.com/spf13/viper"
+
+const redacted = "[REDACTED]"
+
+// Effective is the loaded configuration with secrets redacted, for the
+// startup log and GET /admin/config
+type Effective struct {
+	Env    string
+	File   string // config file viper actually read
+	Config config
+}
+
+// Describe returns the effective configuration with secret values replaced,
+// so operators can confirm which env file is active without leaking keys
+func Describe() Effective {
+	c := *Conf
+	c.Receipts.SigningKey = redact(c.Receipts.SigningKey)
+	c.Encryption.Key = redact(c.Encryption.Key)
+	c.Backup.Key = redact(c.Backup.Key)
+	c.Callbacks.SigningSecret = redact(c.Callbacks.SigningSecret)
+
+	// Probe headers usually carry credentials alongside the {token} placeholder
+	headers := make([]probeHeader, len(c.Prober.Headers))
+	for i, h := range c.Prober.Headers {
+		headers[i] = probeHeader{Name: h.Name, Value: redact(h.Value)}
+	}
+	c.Prober.Headers = headers
+	exportHeaders := make([]probeHeader, len(c.Audit.Export.Headers))
+	for i, h := range c.Audit.Export.Headers {
+		exportHeaders[i] = probeHeader{Name: h.Name, Value: redact(h.Value)}
+	}
+	c.Audit.Export.Headers = exportHeaders
+
+	return Effective{
+		Env:    viper.GetString(EnvVarENV),
+		File:   viper.ConfigFileUsed(),
+		Config: c,
+	}
+}
+
+// redact hides a secret but keeps whether it was set visible
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
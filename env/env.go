@@ -2,28 +2,380 @@ package env
 
 import (
 	"log"
+	"log/slog"
 	"os"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 type config struct {
-	Server server
-	Redis  source
+	Server      server
+	Redis       source
+	Replication replication
+	Backup      backup
+	Audit       audit
+	History     history
+	Cleanup     cleanup
+	Queue       queue
+	Pools       []pool
+	Tokens      tokens
+	Jobs        jobs
+	Receipts    receipts
+	Encryption  encryption
+	Secrets     secretStore
+	Vault       vault
+	Prober      prober
+	Callbacks   callbacks
+	Webhooks    webhooks
+	NATS        natsAPI
+	Alerts      alerts
+	StatsD      statsd
+	Features    features
 }
 
 type server struct {
 	ENV                         string
-	Port                        int
+	Mode                        string // server (default) or worker
+	GinMode                     string // debug, release or test; release everywhere but local when empty
+	Port                        int    // 0 binds an ephemeral port
+	AdminPort                   int    // serves /metrics and /admin apart from the public API; 0 keeps them on Port
 	HandlerTimeout              int
 	InactiveRouteHandlerTimeout int
 	Name                        string
 	LogLevel                    string
+	EmptyPoolStatusCode         int
+	TrustedProxies              []string // IPs or CIDRs allowed to set X-Forwarded-For; empty trusts none
+	RemoteIPHeaders             []string // headers holding the client IP, X-Forwarded-For and X-Real-IP when empty
+	ConcurrencyLimits           []routeLimit
+	SLOBudgets                  []sloBudget
+	RouteProfiles               []string // public, internal and/or admin; empty registers every profile
+	DisabledRoutes              []string // "METHOD /path" routes left out of the enabled profiles
+	ObfuscateTokens             string   // none (default), mask (pool prefix and last 4 characters) or hash: how token values appear in logs and the available/assigned listings
+	AdminActors                 adminActors
+	AdminAllowlist              []string // CIDRs or IPs allowed to reach /admin and /metrics, as resolved through TrustedProxies; empty allows any
+	VerifyLockout               verifyLockout
+	Compression                 compression
+}
+
+// compression gzips or deflates response bodies for callers that accept it
+type compression struct {
+	Enabled        bool
+	MinSizeBytes   int      // smaller bodies are sent as they are; 1024 when 0
+	ExcludedRoutes []string // "METHOD /path" routes never compressed, e.g. "GET /metrics"
+}
+
+// verifyLockout turns away callers that keep failing receipt verification or
+// looking tokens up by value, counted per IP across replicas
+type verifyLockout struct {
+	Failures  int // failures within the window that start a lockout; 0 disables it
+	WindowMs  int // counted from the first failure; 1 minute when 0
+	LockoutMs int // how long a locked out caller gets 429; 15 minutes when 0
+}
+
+// adminActors says who admin requests are made by, for the audit history
+type adminActors struct {
+	Required bool       // reject mutating admin requests without an actor; otherwise X-Client-ID stands in
+	Header   string     // identity header set by the auth proxy in front of /admin, e.g. X-Forwarded-User
+	Keys     []adminKey // bearer keys for automation
+}
+
+// adminKey is a bearer key an automated admin caller authenticates as Actor with
+type adminKey struct {
+	Actor  string
+	KeyEnv string // environment variable holding the key
+}
+
+// sloBudget is the latency a route is expected to stay within
+type sloBudget struct {
+	Route    string // "METHOD /path" as registered, e.g. "POST /tokens/assign"
+	BudgetMs int
+}
+
+// routeLimit caps in-flight requests on one route
+type routeLimit struct {
+	Route     string // "METHOD /path" as registered, e.g. "GET /tokens/available"
+	Limit     int
+	MaxWaitMs int // wait this long for a slot before answering 429
 }
 
 type source struct {
-	Host string
-	Port int
+	Host                   string
+	Port                   int
+	SlowCommandThresholdMs int
+}
+
+// replication mirrors pool state to a secondary Redis in another region
+type audit struct {
+	Export auditExport
+	Stream eventStream
+}
+
+// history bounds the audit and delivery history streams in Redis
+type history struct {
+	Schedule   string // interval or cron expression compaction runs on; @every 10m when empty
+	Audit      retention
+	Deliveries retention // callback and webhook delivery attempts
+}
+
+// retention bounds one history stream; a zero bound leaves it to the stream's built-in cap
+type retention struct {
+	MaxAgeHours int // entries older than this are removed
+	MaxEntries  int // only the newest this many entries are kept
+}
+
+// auditExport ships the audit history to an external sink, at least once
+type auditExport struct {
+	Sink      string        // http, kafka (through the Confluent REST Proxy), amqp (RabbitMQ, with publisher confirms) or file; empty disables export
+	URL       string        // http: endpoint POSTed JSON arrays of entries; kafka: REST Proxy base URL; amqp: amqp:// or amqps:// URL with credentials and vhost
+	Topic     string        // kafka topic
+	Exchange  string        // amqp: exchange entries are published to with their action as routing key
+	Headers   []probeHeader // sent with http and kafka requests, e.g. Authorization
+	TimeoutMs int
+	BatchSize int
+	File      string // file: JSON lines, one file per replica
+	MaxFileMB int    // file: rotate at this size
+	MaxFiles  int    // file: rotated files kept
+}
+
+// eventStream republishes lifecycle events to a Redis stream that downstream
+// services read through consumer groups of their own
+type eventStream struct {
+	Enabled bool
+	Key     string   // events:stream when empty
+	MaxLen  int      // approximate cap; 100000 when 0
+	Groups  []string // consumer groups created at startup, one per downstream service
+}
+
+// backup snapshots all Redis state to S3 or GCS
+type backup struct {
+	Schedule      string // interval ("1h") or 5-field cron expression; empty disables backups
+	Endpoint      string // S3 API endpoint, https://s3.<Region>.amazonaws.com when empty; https://storage.googleapis.com for GCS
+	Region        string
+	Bucket        string
+	Prefix        string // object key prefix, e.g. "token-manager/prod/"
+	AccessKeyEnv  string // environment variable holding the access key ID (GCS: HMAC key)
+	SecretKeyEnv  string // environment variable holding the secret key
+	Key           string // base64 AES-256 key snapshots are encrypted with; prefer KeyEnv outside local
+	KeyEnv        string
+	RetentionDays int // snapshots older than this are deleted, the newest is always kept; 0 keeps all
+}
+
+type replication struct {
+	Host        string // secondary Redis; empty disables replication
+	Port        int
+	IntervalSec int  // how often the secondary is brought up to date
+	BatchSize   int  // keys copied per pipeline
+	Failover    bool // serve from the secondary instead of Redis, and stop replicating
+}
+
+// tokens sets the token lifecycle; zero values use the built-in defaults
+type tokens struct {
+	AssignmentTTLSec          int    // validity of an assignment or keepalive
+	KeepaliveGraceSec         int    // past expiry before cleanup releases the token
+	DeletionAfterIdleSec      int    // past expiry before cleanup deletes the token
+	LockTTLSec                int    // per-token assignment lock
+	MaxHoldSec                int    // longest an assignment may last however often it is kept alive; 0 is unlimited
+	AssignDedupeMs            int    // a client retrying assign within this window gets the same token; 0 disables
+	MaxConcurrentAssignments  int    // tokens assigned at once across every pool; 0 is unlimited
+	SoftConcurrentAssignments int    // assigned tokens past which a quota.soft_limit warning is sent, below MaxConcurrentAssignments; 0 never warns
+	ActivationSchedule        string // how often tokens created with a future activate_at are checked and made available
+	GenerateBatchSize         int    // concurrent generate requests written to Redis together; 0 or 1 writes each on its own
+	GenerateBatchWaitMs       int    // how long a generate request waits for others to join its batch
+	RedisClock                bool   // measure expiry against Redis TIME instead of each node's clock
+	KeepaliveAssignedOnly     bool   // reject keepalive on tokens that are not assigned; ?assigned_only= overrides per call
+	AutoscaleSchedule         string // how often pools with Autoscale set are resized
+}
+
+type pool struct {
+	Name             string
+	Fallback         string // pool to draw from when this one is empty
+	Prefix           string // prepended to generated tokens, e.g. "stg_"; keepalive and delete reject tokens without it
+	ReleaseSchedule  string // overrides Cleanup.ReleaseSchedule for this pool
+	DeletionSchedule string // overrides Cleanup.DeletionSchedule for this pool
+	Vault            poolVault
+	Reserve          poolReserve
+	Warmup           poolWarmup
+
+	// Policies applied by the pool reconciler at startup and again whenever
+	// the config file changes; the fields above need a restart
+	Parent               string   // declared pool whose policy fills in the policy fields left unset here
+	AssignmentTTLSec     int      // overrides Tokens.AssignmentTTLSec for this pool
+	KeepaliveGraceSec    int      // overrides Tokens.KeepaliveGraceSec
+	DeletionAfterIdleSec int      // overrides Tokens.DeletionAfterIdleSec
+	MaxHoldSec           int      // overrides Tokens.MaxHoldSec
+	MinSize              int      // generate tokens until the pool holds this many
+	MaxSize              int      // refuse generate and import past this many tokens; 0 is unlimited
+	SoftMaxSize          int      // send a quota.soft_limit warning once generate or import bring the pool to this size, below MaxSize; 0 never warns
+	Generator            string   // uuid (default) or hex
+	Labels               []string // key=value labels attached to every generated token
+	Tags                 []string // listed by GET /pools so clients can pick a pool, e.g. ["region-eu", "gold"]
+	Autoscale            poolAutoscale
+}
+
+// poolAutoscale grows the pool under demand and retires idle tokens, between
+// MinSize and MaxSize
+type poolAutoscale struct {
+	FailurePercent int // grow when this share of assigns in WindowMin found the pool empty; 0 ignores failures
+	QueueDepth     int // grow when more callers than this are queued; 0 ignores the queue
+	IdlePercent    int // retire tokens while more than this share of the pool is available; 0 never shrinks
+	Step           int // tokens generated or retired per run
+	WindowMin      int // 5 when 0
+}
+
+// poolWarmup seeds the pool at startup so a fresh environment is usable right away
+type poolWarmup struct {
+	Size     int      // generate tokens until the pool holds at least this many
+	Seeds    []string // tokens to import if they don't exist yet
+	SeedFile string   // file with one token per line to import the same way; blank and # lines are skipped
+}
+
+// poolReserve keeps a share of the pool for high-priority clients
+type poolReserve struct {
+	Percent int      // share of available + assigned tokens held back
+	Clients []string // X-Client-ID values allowed into the reserve
+}
+
+// poolVault provisions a pool from a Vault secrets engine
+type poolVault struct {
+	Path         string // e.g. database/creds/readonly; empty means the pool isn't Vault backed
+	Method       string // HTTP method for the read, GET unless the engine needs POST
+	Field        string // key in the secret's data holding the token value
+	MinAvailable int    // replenish whenever fewer tokens than this are available
+}
+
+type queue struct {
+	Enabled           bool
+	LongPollTimeoutMs int
+	Policy            string
+	Weights           []clientWeight
+}
+
+// clientWeight is a list entry rather than a map because viper lowercases map keys
+type clientWeight struct {
+	Client string
+	Weight int
+}
+
+type cleanup struct {
+	Workers      int
+	BatchSize    int
+	PipelineSize int
+	ChunkRetries int
+
+	Order              string // release_first, delete_first or parallel
+	OperationTimeoutMs int    // deadline for each Redis call or pipeline chunk
+	CycleTimeoutSec    int    // a run still going after this is logged and aborted
+
+	ReleaseSchedule      string // interval ("10s") or 5-field cron expression
+	DeletionSchedule     string
+	MaxJitterMs          int
+	DiscoveryIntervalSec int
+}
+
+type jobs struct {
+	Workers        int
+	MaxAttempts    int
+	RetryBackoffMs int
+	ClaimIdleSec   int
+}
+
+type receipts struct {
+	SigningKey string // HMAC key for checkout receipts; receipts are disabled when empty
+}
+
+type encryption struct {
+	Key    string // base64 AES-256 key; prefer KeyEnv outside local
+	KeyEnv string // environment variable holding the key, e.g. injected from KMS
+}
+
+type secretStore struct {
+	HashOnly bool   // store imported tokens as SHA-256 handles only
+	Dir      string // directory of secret files used to resolve handles; empty disables retrieval
+}
+
+type vault struct {
+	Address   string
+	TokenEnv  string // environment variable holding the Vault token
+	Namespace string
+	Schedule  string // how often Vault backed pools are replenished and retired leases revoked
+}
+
+// prober health checks pooled tokens against an upstream URL
+type prober struct {
+	URL       string        // may contain {token}; empty disables probing
+	Method    string        // GET when empty
+	Headers   []probeHeader // list entries rather than a map because viper lowercases map keys
+	TimeoutMs int
+	Schedule  string
+}
+
+type probeHeader struct {
+	Name  string
+	Value string // may contain {token}
+}
+
+// callbacks lets holders register a URL at assign time that is called before
+// their token is reclaimed
+type callbacks struct {
+	Enabled      bool
+	AllowedHosts []string // callback hosts holders may register; empty allows any
+	LeadSec      int      // warn this long before an expiring token is released
+	GraceSec     int      // time a warned holder gets to keep alive or release
+	TimeoutMs    int
+	Schedule     string
+
+	SigningSecret    string // HMAC-SHA256 key callbacks are signed with; prefer SigningSecretEnv outside local
+	SigningSecretEnv string // environment variable holding the signing secret
+}
+
+// webhooks delivers audit events to subscriptions managed through /admin/webhooks
+type webhooks struct {
+	Enabled      bool
+	AllowedHosts []string // hosts subscriptions may point at; empty allows any
+	TimeoutMs    int
+}
+
+// natsAPI serves assign, release and keepalive over NATS request-reply
+type natsAPI struct {
+	URL         string // nats://host:4222 or tls://host:4222; empty disables it
+	Subject     string // requests arrive on <Subject>.assign, .release and .keepalive; "tokens" when empty
+	Queue       string // queue group replicas share; "token-manager" when empty
+	User        string
+	PasswordEnv string // environment variable holding the password
+	TokenEnv    string // environment variable holding an auth token, instead of User and PasswordEnv
+	Workers     int    // requests handled at once
+}
+
+// alerts notifies Slack and PagerDuty when pools run out or cleanup keeps failing
+type alerts struct {
+	SlackWebhookEnv        string // environment variable holding a Slack incoming webhook URL
+	PagerDutyRoutingKeyEnv string // environment variable holding a PagerDuty Events API v2 routing key
+	PagerDutyURL           string // Events API endpoint; the public one when empty
+	LowPercent             int    // alert when available tokens fall below this share of a pool; 0 only alerts when exhausted
+	ErrorPercent           int    // alert when this share of cleanup runs in ErrorWindowMin failed; 0 disables
+	ErrorWindowMin         int
+	ThrottleMin            int // least time between two sends of the same alert for a pool
+	TimeoutMs              int
+	Schedule               string
+}
+
+// statsd pushes every metric to a StatsD or DogStatsD agent as well as
+// serving it on /metrics, for fleets without a Prometheus scrape path
+type statsd struct {
+	Address string   // host:port of the agent over UDP; empty disables
+	Prefix  string   // prepended to metric names, e.g. token_manager
+	Datadog bool     // send labels as DogStatsD tags rather than name segments
+	Tags    []string // extra DogStatsD tags on every metric, e.g. [env:prod]
+	FlushMs int
+}
+
+// features toggles optional subsystems so deployments can run a minimal footprint
+type features struct {
+	Cleanup bool // scheduled release/deletion sweeps, Vault provisioning and probing
+	Jobs    bool // background job workers; without them scheduled and admin jobs only queue up
+	Metrics bool // GET /metrics
+	Admin   bool // /admin routes
 }
 
 var Conf *config
@@ -54,4 +406,22 @@ func Load() {
 	if err != nil {
 		log.Fatalf("unable to unmarshal config into struct: %v", err)
 	}
+	if Conf.Replication.Failover && Conf.Replication.Host == "" {
+		log.Fatalf("invalid config: Replication.Failover needs Replication.Host")
+	}
+}
+
+// WatchPools re-reads Pools whenever the config file changes and calls
+// onChange after updating Conf.Pools. The rest of Conf is left as loaded.
+func WatchPools(onChange func()) {
+	viper.OnConfigChange(func(fsnotify.Event) {
+		var fresh config
+		if err := viper.Unmarshal(&fresh); err != nil {
+			slog.Warn("Ignoring config change, unable to unmarshal", slog.String("error", err.Error()))
+			return
+		}
+		Conf.Pools = fresh.Pools
+		onChange()
+	})
+	viper.WatchConfig()
 }
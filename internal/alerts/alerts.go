@@ -0,0 +1,109 @@
+// Package alerts sends operational alerts to Slack and PagerDuty
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Kinds of alert raised for a pool
+const (
+	KindExhausted     = "pool_exhausted" // no tokens available
+	KindLow           = "pool_low"       // available tokens below the configured share of the pool
+	KindCleanupErrors = "cleanup_errors" // cleanup failing at a high rate
+)
+
+// Alert is raised, or resolved once its condition clears
+type Alert struct {
+	Kind     string
+	Pool     string
+	Summary  string
+	Severity string // critical, error or warning
+	Details  map[string]any
+	Resolved bool
+}
+
+// Channel delivers alerts somewhere people look
+type Channel interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Slack posts alerts to a Slack incoming webhook
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlack(webhookURL string, timeout time.Duration) *Slack {
+	return &Slack{url: webhookURL, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *Slack) Send(ctx context.Context, alert Alert) error {
+	text := fmt.Sprintf(":rotating_light: *%s* %s", alert.Severity, alert.Summary)
+	if alert.Resolved {
+		text = fmt.Sprintf(":white_check_mark: *resolved* %s", alert.Summary)
+	}
+	return post(ctx, s.client, s.url, map[string]string{"text": text})
+}
+
+// PagerDuty triggers and resolves incidents through the Events API v2,
+// deduplicated per pool and kind
+type PagerDuty struct {
+	url        string
+	routingKey string
+	source     string
+	client     *http.Client
+}
+
+func NewPagerDuty(eventsURL, routingKey, source string, timeout time.Duration) *PagerDuty {
+	return &PagerDuty{url: eventsURL, routingKey: routingKey, source: source, client: &http.Client{Timeout: timeout}}
+}
+
+func (p *PagerDuty) Send(ctx context.Context, alert Alert) error {
+	event := map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    alert.Kind + ":" + alert.Pool,
+	}
+	if alert.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]any{
+			"summary":        alert.Summary,
+			"source":         p.source,
+			"severity":       alert.Severity,
+			"component":      alert.Pool,
+			"class":          alert.Kind,
+			"custom_details": alert.Details,
+		}
+	}
+	return post(ctx, p.client, p.url, event)
+}
+
+func post(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert channel returned status %d", resp.StatusCode)
+	}
+	return nil
+}
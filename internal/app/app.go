@@ -0,0 +1,1081 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/env"
+	"github.com/manankarani/token-manager/internal/alerts"
+	"github.com/manankarani/token-manager/internal/auditsink"
+	"github.com/manankarani/token-manager/internal/callbacks"
+	"github.com/manankarani/token-manager/internal/encryption"
+	"github.com/manankarani/token-manager/internal/handlers"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/secrets"
+	"github.com/manankarani/token-manager/internal/services"
+	"github.com/manankarani/token-manager/internal/workers"
+	"github.com/manankarani/token-manager/logging"
+	"github.com/manankarani/token-manager/receipts"
+	"github.com/redis/go-redis/v9"
+)
+
+// App wires the repository, service, background workers and HTTP routes
+// together from env.Conf. The HTTP server and the workers are started
+// separately so the binary can also run as a worker only process.
+type App struct {
+	Logger    *slog.Logger
+	Service   *services.TokenService
+	Jobs      *jobs.Queue
+	Scheduler *workers.CleanupScheduler
+	Router    *gin.Engine
+	Admin     *gin.Engine // nil when admin routes are served on Router
+
+	listener      net.Listener
+	adminListener net.Listener
+	warmups       map[string]services.Warmup
+	replicator    *workers.Replicator        // nil unless Replication.Host is set and not failed over
+	backups       *workers.BackupWorker      // nil unless Backup.Schedule is set
+	history       *workers.HistoryCompactor  // nil unless History sets a retention
+	auditExporter *workers.AuditExporter     // nil unless Audit.Export.Sink is set
+	webhooks      *workers.WebhookDispatcher // nil unless Webhooks.Enabled
+	nats          *workers.NATSResponder     // nil unless NATS.URL is set
+	events        *workers.EventStreamer     // nil unless Audit.Stream.Enabled
+	statsd        *metrics.StatsD            // nil unless StatsD.Address is set
+
+	reconcileMu sync.Mutex
+	declared    map[string]bool // pools given a policy by the last reconcile
+}
+
+// New builds the application on an existing Redis client
+func New(logger *slog.Logger, redisClient *redis.Client) (*App, error) {
+	// Initialize repositories, services, and controllers
+	cipher, err := tokenCipher()
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	obfuscate, err := logging.NewObfuscator(env.Conf.Server.ObfuscateTokens)
+	if err != nil {
+		return nil, err
+	}
+	logging.SetTokenObfuscator(obfuscate)
+	queueWeights := make(map[string]int, len(env.Conf.Queue.Weights))
+	for _, w := range env.Conf.Queue.Weights {
+		queueWeights[w.Client] = w.Weight
+	}
+	timing := repositories.TimingConfig{
+		AssignmentTTL:     time.Duration(env.Conf.Tokens.AssignmentTTLSec) * time.Second,
+		KeepaliveGrace:    time.Duration(env.Conf.Tokens.KeepaliveGraceSec) * time.Second,
+		DeletionAfterIdle: time.Duration(env.Conf.Tokens.DeletionAfterIdleSec) * time.Second,
+		LockTTL:           time.Duration(env.Conf.Tokens.LockTTLSec) * time.Second,
+		MaxHold:           time.Duration(env.Conf.Tokens.MaxHoldSec) * time.Second,
+	}
+	if err := timing.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid token timing: %w", err)
+	}
+	cleanupOrder, err := repositories.ParseCleanupOrder(env.Conf.Cleanup.Order)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cleanup config: Cleanup.Order: %w", err)
+	}
+	// Assignment slots are only counted while there is a cap to count them against
+	if soft := env.Conf.Tokens.SoftConcurrentAssignments; soft > 0 {
+		if hard := env.Conf.Tokens.MaxConcurrentAssignments; hard <= 0 || soft >= hard {
+			return nil, errors.New("Tokens.SoftConcurrentAssignments must be below a non-zero Tokens.MaxConcurrentAssignments")
+		}
+	}
+	tokenRepo := repositories.NewTokenRepository(redisClient, repositories.Config{
+		Cleanup: repositories.CleanupConfig{
+			Workers:      env.Conf.Cleanup.Workers,
+			BatchSize:    env.Conf.Cleanup.BatchSize,
+			PipelineSize: env.Conf.Cleanup.PipelineSize,
+			ChunkRetries: env.Conf.Cleanup.ChunkRetries,
+			Order:        cleanupOrder,
+
+			OperationTimeout: time.Duration(env.Conf.Cleanup.OperationTimeoutMs) * time.Millisecond,
+			CycleTimeout:     time.Duration(env.Conf.Cleanup.CycleTimeoutSec) * time.Second,
+		},
+		Queue: repositories.QueueConfig{
+			Policy:  env.Conf.Queue.Policy,
+			Weights: queueWeights,
+		},
+		Timing: timing,
+		Cipher: cipher,
+
+		AssignmentCap:     env.Conf.Tokens.MaxConcurrentAssignments,
+		AssignmentSoftCap: env.Conf.Tokens.SoftConcurrentAssignments,
+		RedisClock:        env.Conf.Tokens.RedisClock,
+	})
+	fallbacks := make(map[string]string, len(env.Conf.Pools))
+	reserves := make(map[string]services.Reserve)
+	prefixes := make(map[string]string)
+	warmups := make(map[string]services.Warmup)
+	for _, p := range env.Conf.Pools {
+		warmup, err := poolWarmup(p.Warmup.Size, p.Warmup.Seeds, p.Warmup.SeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("Pools[%s].Warmup: %w", p.Name, err)
+		}
+		if warmup.Size > 0 || len(warmup.Seeds) > 0 {
+			warmups[p.Name] = warmup
+		}
+		if p.Fallback != "" {
+			fallbacks[p.Name] = p.Fallback
+		}
+		if p.Prefix != "" {
+			if !services.ValidPrefix(p.Prefix) {
+				return nil, fmt.Errorf("Pools[%s].Prefix must be 1-16 letters, digits, '_' or '-'", p.Name)
+			}
+			// Vault issues the token values itself and hash-only pools hand out
+			// handles, so neither can carry a prefix
+			if p.Vault.Path != "" {
+				return nil, fmt.Errorf("Pools[%s].Prefix can't be used with Vault", p.Name)
+			}
+			if env.Conf.Secrets.HashOnly {
+				return nil, fmt.Errorf("Pools[%s].Prefix can't be used with Secrets.HashOnly", p.Name)
+			}
+			prefixes[p.Name] = p.Prefix
+		}
+		// Autoscaling generates tokens, which Vault backed pools must mint instead
+		if p.Vault.Path != "" && (p.Autoscale.FailurePercent > 0 || p.Autoscale.QueueDepth > 0 || p.Autoscale.IdlePercent > 0) {
+			return nil, fmt.Errorf("Pools[%s].Autoscale can't be used with Vault", p.Name)
+		}
+		if p.Reserve.Percent > 0 {
+			if p.Reserve.Percent >= 100 {
+				return nil, fmt.Errorf("Pools[%s].Reserve.Percent must be below 100", p.Name)
+			}
+			clients := make(map[string]bool, len(p.Reserve.Clients))
+			for _, client := range p.Reserve.Clients {
+				clients[client] = true
+			}
+			reserves[p.Name] = services.Reserve{Percent: p.Reserve.Percent, Clients: clients}
+		}
+	}
+	var notifier *callbacks.Notifier
+	if env.Conf.Callbacks.Enabled {
+		secret := env.Conf.Callbacks.SigningSecret
+		if secret == "" && env.Conf.Callbacks.SigningSecretEnv != "" {
+			secret = os.Getenv(env.Conf.Callbacks.SigningSecretEnv)
+		}
+		notifier = callbacks.NewNotifier(
+			durationOr(env.Conf.Callbacks.TimeoutMs, time.Millisecond, constants.DefaultCallbackTimeout),
+			env.Conf.Callbacks.AllowedHosts,
+			secret,
+		)
+	}
+	webhookNotifier := callbacks.NewNotifier(
+		durationOr(env.Conf.Webhooks.TimeoutMs, time.Millisecond, constants.DefaultCallbackTimeout),
+		env.Conf.Webhooks.AllowedHosts,
+		"", // each subscription signs with its own secret
+	)
+	callbackGrace := durationOr(env.Conf.Callbacks.GraceSec, time.Second, constants.DefaultCallbackGrace)
+	tokenService := services.NewTokenService(tokenRepo, services.Config{
+		QueueEnabled: env.Conf.Queue.Enabled,
+		Fallbacks:    fallbacks,
+		HashOnly:     env.Conf.Secrets.HashOnly,
+		Reserves:     reserves,
+		Prefixes:     prefixes,
+		Batch: services.GenerateBatch{
+			Size: env.Conf.Tokens.GenerateBatchSize,
+			Wait: durationOr(env.Conf.Tokens.GenerateBatchWaitMs, time.Millisecond, constants.DefaultGenerateBatchWait),
+		},
+		DedupeWindow: time.Duration(env.Conf.Tokens.AssignDedupeMs) * time.Millisecond,
+
+		KeepaliveAssignedOnly: env.Conf.Tokens.KeepaliveAssignedOnly,
+
+		Callbacks:     notifier,
+		CallbackGrace: callbackGrace,
+		Webhooks:      webhookNotifier,
+	})
+	recordDelivery := func(ctx context.Context, delivery callbacks.Delivery) {
+		if err := tokenService.RecordDelivery(ctx, delivery); err != nil {
+			logger.Warn("Failed to record callback delivery", slog.String("error", err.Error()))
+		}
+	}
+	if notifier != nil {
+		notifier.OnDelivery = recordDelivery
+	}
+	webhookNotifier.OnDelivery = recordDelivery
+
+	// Background work runs through the job queue so it can later be moved to
+	// dedicated worker processes
+	hostname, _ := os.Hostname()
+	jobQueue := jobs.NewQueue(redisClient, jobs.Config{
+		Consumer:     hostname,
+		Workers:      env.Conf.Jobs.Workers,
+		MaxAttempts:  env.Conf.Jobs.MaxAttempts,
+		RetryBackoff: time.Duration(env.Conf.Jobs.RetryBackoffMs) * time.Millisecond,
+		ClaimIdle:    time.Duration(env.Conf.Jobs.ClaimIdleSec) * time.Second,
+	}, logger)
+
+	tokenHandler := handlers.NewTokenHandler(tokenService, handlers.HandlerConfig{
+		EmptyPoolStatus: env.Conf.Server.EmptyPoolStatusCode,
+		LongPollTimeout: time.Duration(env.Conf.Queue.LongPollTimeoutMs) * time.Millisecond,
+		Receipts:        receipts.NewSigner(env.Conf.Receipts.SigningKey),
+		ReceiptTTL:      tokenRepo.Timing.AssignmentTTL,
+		Obfuscate:       obfuscate,
+		Lockout: handlers.LockoutConfig{
+			Failures: env.Conf.Server.VerifyLockout.Failures,
+			Window:   time.Duration(env.Conf.Server.VerifyLockout.WindowMs) * time.Millisecond,
+			Lockout:  time.Duration(env.Conf.Server.VerifyLockout.LockoutMs) * time.Millisecond,
+		},
+		Jobs: jobQueue,
+	})
+
+	// CSV uploads to POST /tokens/import, stored in Redis so any replica can run them
+	jobQueue.Register(constants.ImportJob, workers.ImportJob(tokenService, jobQueue, logger))
+	jobQueue.Register("cleanup", func(ctx context.Context, job jobs.Job) error {
+		// A job, like a sweep, is scheduled work; POST /admin/cleanup is the manual override
+		pause, err := tokenService.CleanupPaused(ctx)
+		if err != nil {
+			return err
+		}
+		if pause.Paused {
+			logger.Info("Skipped cleanup job while cleanup is paused", slog.String("job", job.ID))
+			return nil
+		}
+		_, err = tokenService.CleanupExpiredTokens(ctx)
+		return err
+	})
+	// Tokens written before records existed, or whose record predates the
+	// current encoding, get one from an admin-triggered run:
+	// POST /admin/jobs/migrate_records/run?pool=<pool>
+	jobQueue.Register("migrate_records", func(ctx context.Context, job jobs.Job) error {
+		migrated, err := tokenService.MigrateRecords(ctx, job.Payload["pool"])
+		logger.Info("Migrated token records",
+			slog.String("pool", job.Payload["pool"]),
+			slog.Int("migrated", migrated),
+			slog.Int("version", constants.TokenRecordVersion))
+		return err
+	})
+	history, err := historyCompactor(tokenService, logger)
+	if err != nil {
+		return nil, err
+	}
+	if history != nil {
+		// POST /admin/jobs/compact_history/run compacts ahead of schedule
+		jobQueue.Register("compact_history", func(ctx context.Context, job jobs.Job) error {
+			history.Compact(ctx)
+			return nil
+		})
+	}
+	var secretStore secrets.Store
+	if env.Conf.Secrets.Dir != "" {
+		secretStore = secrets.NewFileStore(env.Conf.Secrets.Dir)
+	}
+	adminHandler := handlers.NewAdminHandler(tokenService, jobQueue, secretStore, env.Describe())
+
+	sweeps, err := cleanupSweeps(tokenService)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cleanup schedule: %w", err)
+	}
+	if vault := datasources.NewVaultClient(); vault != nil {
+		provisioner, vaultSweeps, err := vaultProvisioner(vault, tokenService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Vault schedule: %w", err)
+		}
+		sweeps = append(sweeps, vaultSweeps...)
+		// POST /admin/jobs/rotation/run?pool=<pool> revokes deleted credentials and mints replacements now
+		jobQueue.Register("rotation", func(ctx context.Context, job jobs.Job) error {
+			return provisioner.Rotate(ctx, job.Payload["pool"])
+		})
+	}
+	activation, err := parseSchedule(env.Conf.Tokens.ActivationSchedule, constants.DefaultActivationSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activation schedule: Tokens.ActivationSchedule: %w", err)
+	}
+	sweeps = append(sweeps, workers.Sweep{Name: "activate", Run: tokenService.ActivateDueTokens, DefaultSchedule: activation, Pausable: true})
+	autoscale, err := parseSchedule(env.Conf.Tokens.AutoscaleSchedule, constants.DefaultAutoscaleSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid autoscale schedule: Tokens.AutoscaleSchedule: %w", err)
+	}
+	sweeps = append(sweeps, workers.NewPoolAutoscaler(tokenService, logger).Sweep(autoscale))
+	if alerter := poolAlerter(tokenService, hostname, logger); alerter != nil {
+		schedule, err := parseSchedule(env.Conf.Alerts.Schedule, constants.DefaultAlertSchedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert schedule: Alerts.Schedule: %w", err)
+		}
+		// Only the release and deletion sweeps count toward the cleanup error rate
+		for i, sweep := range sweeps {
+			if sweep.Name == "release" || sweep.Name == "delete" {
+				sweeps[i].Run = alerter.Track(sweep.Run)
+			}
+		}
+		sweeps = append(sweeps, alerter.Sweep(schedule))
+	}
+	if env.Conf.Prober.URL != "" {
+		probeSweep, err := probingSweep(tokenService, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe schedule: %w", err)
+		}
+		sweeps = append(sweeps, probeSweep)
+	}
+	if notifier != nil {
+		schedule, err := parseSchedule(env.Conf.Callbacks.Schedule, constants.DefaultCallbackSchedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid callback schedule: Callbacks.Schedule: %w", err)
+		}
+		lead := durationOr(env.Conf.Callbacks.LeadSec, time.Second, constants.DefaultCallbackLead)
+		sweeps = append(sweeps, workers.NewCallbackNotifier(tokenService, notifier, lead, callbackGrace, logger).Sweep(schedule))
+	}
+	cleanupScheduler := workers.NewCleanupScheduler(
+		sweeps,
+		tokenService.ListPools,
+		jobQueue,
+		workers.CleanupScheduleConfig{
+			MaxJitter:         time.Duration(env.Conf.Cleanup.MaxJitterMs) * time.Millisecond,
+			DiscoveryInterval: discoveryInterval(),
+			Paused: func(ctx context.Context) (bool, error) {
+				pause, err := tokenService.CleanupPaused(ctx)
+				return pause.Paused, err
+			},
+		},
+		logger,
+	)
+
+	var statsd *metrics.StatsD
+	if env.Conf.StatsD.Address != "" {
+		statsd, err = metrics.NewStatsD(metrics.StatsDConfig{
+			Address:       env.Conf.StatsD.Address,
+			Prefix:        env.Conf.StatsD.Prefix,
+			Datadog:       env.Conf.StatsD.Datadog,
+			Tags:          env.Conf.StatsD.Tags,
+			FlushInterval: time.Duration(env.Conf.StatsD.FlushMs) * time.Millisecond,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid StatsD config: %w", err)
+		}
+		metrics.Default.AddEmitter(statsd)
+	}
+
+	var replicator *workers.Replicator
+	if replica := datasources.NewReplicaClient(); replica != nil {
+		replicator = workers.NewReplicator(redisClient, replica, workers.ReplicationConfig{
+			Interval:  durationOr(env.Conf.Replication.IntervalSec, time.Second, constants.DefaultReplicationInterval),
+			BatchSize: env.Conf.Replication.BatchSize,
+		}, logger)
+	}
+
+	backups, err := backupWorker(redisClient, logger)
+	if err != nil {
+		return nil, err
+	}
+	var webhookDispatcher *workers.WebhookDispatcher
+	if env.Conf.Webhooks.Enabled {
+		webhookDispatcher = workers.NewWebhookDispatcher(tokenService, webhookNotifier, jobQueue, hostname, logger)
+	}
+	var natsResponder *workers.NATSResponder
+	if env.Conf.NATS.URL != "" {
+		natsResponder = workers.NewNATSResponder(tokenService, workers.NATSConfig{
+			Conn: datasources.NATSConfig{
+				URL:      env.Conf.NATS.URL,
+				Name:     hostname,
+				User:     env.Conf.NATS.User,
+				Password: os.Getenv(env.Conf.NATS.PasswordEnv),
+				Token:    os.Getenv(env.Conf.NATS.TokenEnv),
+			},
+			Subject:         env.Conf.NATS.Subject,
+			Queue:           env.Conf.NATS.Queue,
+			Workers:         env.Conf.NATS.Workers,
+			EmptyPoolStatus: env.Conf.Server.EmptyPoolStatusCode,
+		}, logger)
+	}
+	var eventStreamer *workers.EventStreamer
+	if c := env.Conf.Audit.Stream; c.Enabled {
+		eventStreamer = workers.NewEventStreamer(tokenService, workers.EventStreamConfig{
+			Key:    c.Key,
+			MaxLen: int64(c.MaxLen),
+			Groups: c.Groups,
+		}, hostname, logger)
+	}
+	var auditExporter *workers.AuditExporter
+	if env.Conf.Audit.Export.Sink != "" {
+		sink, err := auditSink()
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit export config: %w", err)
+		}
+		auditExporter = workers.NewAuditExporter(tokenService, sink, hostname, env.Conf.Audit.Export.BatchSize, logger)
+	}
+
+	routeLimits := make([]handlers.RouteLimit, len(env.Conf.Server.ConcurrencyLimits))
+	for i, l := range env.Conf.Server.ConcurrencyLimits {
+		routeLimits[i] = handlers.RouteLimit{Route: l.Route, Limit: l.Limit, MaxWait: time.Duration(l.MaxWaitMs) * time.Millisecond}
+	}
+	sloBudgets := make([]handlers.SLOBudget, len(env.Conf.Server.SLOBudgets))
+	for i, b := range env.Conf.Server.SLOBudgets {
+		sloBudgets[i] = handlers.SLOBudget{Route: b.Route, Budget: time.Duration(b.BudgetMs) * time.Millisecond}
+	}
+	router, adminRouter, err := handlers.SetupRoutes(tokenHandler, adminHandler, handlers.RouteConfig{
+		Metrics:         env.Conf.Features.Metrics,
+		Admin:           env.Conf.Features.Admin,
+		SeparateAdmin:   env.Conf.Server.AdminPort != 0,
+		TrustedProxies:  env.Conf.Server.TrustedProxies,
+		RemoteIPHeaders: env.Conf.Server.RemoteIPHeaders,
+		Profiles:        env.Conf.Server.RouteProfiles,
+		DisabledRoutes:  env.Conf.Server.DisabledRoutes,
+
+		ConcurrencyLimits: routeLimits,
+		SLO:               handlers.NewSLOTracker(sloBudgets),
+		Mode:              ginMode(),
+		Obfuscate:         obfuscate,
+		Actors:            adminActors(),
+		AdminAllowlist:    env.Conf.Server.AdminAllowlist,
+		Compression: handlers.CompressionConfig{
+			Enabled:        env.Conf.Server.Compression.Enabled,
+			MinSize:        env.Conf.Server.Compression.MinSizeBytes,
+			ExcludedRoutes: env.Conf.Server.Compression.ExcludedRoutes,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a := &App{
+		Logger:     logger,
+		Service:    tokenService,
+		Jobs:       jobQueue,
+		Scheduler:  cleanupScheduler,
+		Router:     router,
+		Admin:      adminRouter,
+		warmups:    warmups,
+		replicator: replicator,
+		backups:    backups,
+		history:    history,
+
+		auditExporter: auditExporter,
+		webhooks:      webhookDispatcher,
+		nats:          natsResponder,
+		events:        eventStreamer,
+		statsd:        statsd,
+	}
+	// POST /admin/jobs/reconcile/run re-applies the pool policies in config;
+	// replenish also tops up warmups, and both generate tokens up to MinSize
+	jobQueue.Register("reconcile", func(ctx context.Context, job jobs.Job) error {
+		return a.ReconcilePools(ctx)
+	})
+	jobQueue.Register("replenish", func(ctx context.Context, job jobs.Job) error {
+		return errors.Join(a.WarmPools(ctx), a.ReconcilePools(ctx))
+	})
+	return a, nil
+}
+
+// WarmPools seeds the pools that declare a Warmup in config. It is safe to
+// run on every start; failures are logged per pool and joined.
+func (a *App) WarmPools(ctx context.Context) error {
+	var errs []error
+	for pool, spec := range a.warmups {
+		added, err := a.Service.WarmPool(ctx, pool, spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pool, err))
+			continue
+		}
+		if added > 0 {
+			a.Logger.Info("Warmed up pool", slog.String("pool", pool), slog.Int("added", added))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RunWorkers runs the cleanup scheduler and job workers enabled in
+// Features and the optional replication, backup, history compaction, audit
+// export, webhook and NATS workers, and keeps runtime pool timing and the clock in
+// step with Redis, until ctx is cancelled
+func (a *App) RunWorkers(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.refreshPoolTimings(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.syncClock(ctx)
+	}()
+	if env.Conf.Features.Cleanup {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Scheduler.Run(ctx)
+		}()
+	} else {
+		a.Logger.Info("Cleanup scheduler disabled by feature flag")
+	}
+	if env.Conf.Features.Jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.Jobs.Run(ctx); err != nil {
+				a.Logger.Error("Job queue error", slog.String("error", err.Error()))
+			}
+		}()
+	} else {
+		a.Logger.Info("Job workers disabled by feature flag")
+	}
+	if a.replicator != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.replicator.Run(ctx)
+		}()
+	} else if env.Conf.Replication.Failover {
+		a.Logger.Warn("Failed over to the secondary region, replication stopped")
+	}
+	if a.backups != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.backups.Run(ctx)
+		}()
+	}
+	if a.history != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.history.Run(ctx)
+		}()
+	}
+	if a.auditExporter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.auditExporter.Run(ctx)
+		}()
+	}
+	if a.webhooks != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.webhooks.Run(ctx)
+		}()
+	}
+	if a.nats != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.nats.Run(ctx)
+		}()
+	}
+	if a.events != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.events.Run(ctx)
+		}()
+	}
+	if a.statsd != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.statsd.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// refreshPoolTimings reloads the timing set through the admin API every
+// PoolTimingRefreshInterval, so changes made on one replica reach the rest
+func (a *App) refreshPoolTimings(ctx context.Context) {
+	ticker := time.NewTicker(constants.PoolTimingRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.Service.RefreshPoolTimings(ctx); err != nil && ctx.Err() == nil {
+			a.Logger.Error("Failed to refresh pool timing", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncClock re-reads Redis TIME every ClockSyncInterval. Skew past
+// ClockSkewWarning is logged even when Tokens.RedisClock is off, since that is
+// when it can expire tokens early.
+func (a *App) syncClock(ctx context.Context) {
+	ticker := time.NewTicker(constants.ClockSyncInterval)
+	defer ticker.Stop()
+	for {
+		offset, err := a.Service.SyncClock(ctx)
+		if err != nil && ctx.Err() == nil {
+			a.Logger.Error("Failed to read the Redis clock", slog.String("error", err.Error()))
+		} else if err == nil && offset.Abs() > constants.ClockSkewWarning {
+			a.Logger.Warn("Local clock is skewed from Redis", slog.Duration("offset", offset), slog.Bool("redis_clock", env.Conf.Tokens.RedisClock))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Listen binds the HTTP listeners without serving yet. With Port 0 the OS
+// picks a free port, which Addr then reports; test harnesses rely on this.
+func (a *App) Listen() error {
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(env.Conf.Server.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", env.Conf.Server.Port, err)
+	}
+	if a.Admin != nil {
+		adminLn, err := net.Listen("tcp", ":"+strconv.Itoa(env.Conf.Server.AdminPort))
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to listen on admin port %d: %w", env.Conf.Server.AdminPort, err)
+		}
+		a.adminListener = adminLn
+	}
+	a.listener = ln
+	return nil
+}
+
+// Addr returns the address the public API is bound to, nil before Listen
+func (a *App) Addr() net.Addr {
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+// AdminAddr returns the address of the separate admin listener, nil when
+// admin routes share the public listener or before Listen
+func (a *App) AdminAddr() net.Addr {
+	if a.adminListener == nil {
+		return nil
+	}
+	return a.adminListener.Addr()
+}
+
+// Serve runs the HTTP servers until ctx is cancelled, then shuts them down
+// gracefully. If either listener fails, both are stopped. Listen is called
+// first unless the caller already did.
+func (a *App) Serve(ctx context.Context) error {
+	if a.listener == nil {
+		if err := a.Listen(); err != nil {
+			return err
+		}
+	}
+	if a.adminListener == nil {
+		return a.serve(ctx, "Server", a.listener, a.Router)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- a.serve(ctx, "Admin server", a.adminListener, a.Admin)
+		cancel()
+	}()
+	err := a.serve(ctx, "Server", a.listener, a.Router)
+	cancel()
+	return errors.Join(err, <-errs)
+}
+
+func (a *App) serve(ctx context.Context, name string, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		a.Logger.Info("Shutting down " + strings.ToLower(name) + "...")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			a.Logger.Error("HTTP server shutdown error", slog.String("error", err.Error()))
+		}
+	}()
+
+	a.Logger.Info(name+" running", slog.String("addr", ln.Addr().String()))
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("%s on %s: %w", strings.ToLower(name), ln.Addr(), err)
+	}
+	return nil
+}
+
+// cleanupSweeps builds the release and deletion sweeps with their schedules from config
+func cleanupSweeps(tokenService *services.TokenService) ([]workers.Sweep, error) {
+	release := workers.Sweep{
+		Name:          "release",
+		Run:           tokenService.ReleaseExpiredTokens,
+		PoolSchedules: make(map[string]workers.Schedule),
+		Pausable:      true,
+	}
+	deletion := workers.Sweep{
+		Name:          "delete",
+		Run:           tokenService.DeleteExpiredTokens,
+		PoolSchedules: make(map[string]workers.Schedule),
+		Pausable:      true,
+	}
+
+	var err error
+	if release.DefaultSchedule, err = parseSchedule(env.Conf.Cleanup.ReleaseSchedule, constants.DefaultReleaseSchedule); err != nil {
+		return nil, fmt.Errorf("Cleanup.ReleaseSchedule: %w", err)
+	}
+	if deletion.DefaultSchedule, err = parseSchedule(env.Conf.Cleanup.DeletionSchedule, constants.DefaultDeletionSchedule); err != nil {
+		return nil, fmt.Errorf("Cleanup.DeletionSchedule: %w", err)
+	}
+
+	for _, p := range env.Conf.Pools {
+		if p.ReleaseSchedule != "" {
+			if release.PoolSchedules[p.Name], err = workers.ParseSchedule(p.ReleaseSchedule); err != nil {
+				return nil, fmt.Errorf("Pools[%s].ReleaseSchedule: %w", p.Name, err)
+			}
+		}
+		if p.DeletionSchedule != "" {
+			if deletion.PoolSchedules[p.Name], err = workers.ParseSchedule(p.DeletionSchedule); err != nil {
+				return nil, fmt.Errorf("Pools[%s].DeletionSchedule: %w", p.Name, err)
+			}
+		}
+	}
+	if tokenService.CleanupExclusive() {
+		release.Exclusive = "expiry"
+		deletion.Exclusive = "expiry"
+	}
+	return []workers.Sweep{release, deletion}, nil
+}
+
+// vaultProvisioner builds the provisioner of pools with a Vault path and its
+// replenish and revoke sweeps
+func vaultProvisioner(vault *datasources.VaultClient, tokenService *services.TokenService, logger *slog.Logger) (*workers.VaultProvisioner, []workers.Sweep, error) {
+	schedule, err := parseSchedule(env.Conf.Vault.Schedule, constants.DefaultVaultSchedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Vault.Schedule: %w", err)
+	}
+
+	pools := make(map[string]workers.VaultPool)
+	for _, p := range env.Conf.Pools {
+		if p.Vault.Path != "" {
+			pools[p.Name] = workers.VaultPool{
+				Path:         p.Vault.Path,
+				Method:       p.Vault.Method,
+				Field:        p.Vault.Field,
+				MinAvailable: p.Vault.MinAvailable,
+			}
+		}
+	}
+	provisioner := workers.NewVaultProvisioner(vault, tokenService, pools, logger)
+	return provisioner, provisioner.Sweeps(schedule), nil
+}
+
+// probingSweep builds the upstream health probe sweep, run for every pool
+func probingSweep(tokenService *services.TokenService, logger *slog.Logger) (workers.Sweep, error) {
+	schedule, err := parseSchedule(env.Conf.Prober.Schedule, constants.DefaultProbeSchedule)
+	if err != nil {
+		return workers.Sweep{}, fmt.Errorf("Prober.Schedule: %w", err)
+	}
+
+	timeout := constants.DefaultProbeTimeout
+	if env.Conf.Prober.TimeoutMs > 0 {
+		timeout = time.Duration(env.Conf.Prober.TimeoutMs) * time.Millisecond
+	}
+	headers := make(map[string]string, len(env.Conf.Prober.Headers))
+	for _, h := range env.Conf.Prober.Headers {
+		headers[h.Name] = h.Value
+	}
+	return workers.NewTokenProber(tokenService, workers.ProbeConfig{
+		URL:     env.Conf.Prober.URL,
+		Method:  env.Conf.Prober.Method,
+		Headers: headers,
+		Timeout: timeout,
+	}, logger).Sweep(schedule), nil
+}
+
+// poolAlerter builds the alerter for the Slack and PagerDuty channels whose
+// env vars are set, or returns nil when neither is
+func poolAlerter(tokenService *services.TokenService, hostname string, logger *slog.Logger) *workers.PoolAlerter {
+	conf := env.Conf.Alerts
+	timeout := durationOr(conf.TimeoutMs, time.Millisecond, constants.DefaultProbeTimeout)
+	var channels []alerts.Channel
+	if url := os.Getenv(conf.SlackWebhookEnv); conf.SlackWebhookEnv != "" && url != "" {
+		channels = append(channels, alerts.NewSlack(url, timeout))
+	}
+	if key := os.Getenv(conf.PagerDutyRoutingKeyEnv); conf.PagerDutyRoutingKeyEnv != "" && key != "" {
+		eventsURL := conf.PagerDutyURL
+		if eventsURL == "" {
+			eventsURL = constants.DefaultPagerDutyURL
+		}
+		channels = append(channels, alerts.NewPagerDuty(eventsURL, key, hostname, timeout))
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+	return workers.NewPoolAlerter(tokenService, channels, workers.AlertConfig{
+		LowPercent:   conf.LowPercent,
+		ErrorPercent: conf.ErrorPercent,
+		ErrorWindow:  durationOr(conf.ErrorWindowMin, time.Minute, constants.DefaultAlertErrorWindow),
+		Throttle:     durationOr(conf.ThrottleMin, time.Minute, constants.DefaultAlertThrottle),
+	}, logger)
+}
+
+// adminActors maps the configured admin keys, read from the environment, to
+// their actors. Keys whose variable is unset are skipped.
+func adminActors() handlers.ActorConfig {
+	c := env.Conf.Server.AdminActors
+	keys := make(map[string]string, len(c.Keys))
+	for _, k := range c.Keys {
+		if key := os.Getenv(k.KeyEnv); k.KeyEnv != "" && key != "" {
+			keys[key] = k.Actor
+		}
+	}
+	return handlers.ActorConfig{Required: c.Required, Header: c.Header, Keys: keys}
+}
+
+// ginMode is Server.GinMode, defaulting to debug locally and release elsewhere
+func ginMode() string {
+	if env.Conf.Server.GinMode != "" {
+		return env.Conf.Server.GinMode
+	}
+	if env.Conf.Server.ENV == "local" {
+		return gin.DebugMode
+	}
+	return gin.ReleaseMode
+}
+
+// ReconcilePools applies the policies declared in Pools, each completed from
+// its Parent chain, generating tokens up to each MinSize, and returns pools
+// that are no longer declared to the global defaults. It runs at startup and
+// again after each config change.
+func (a *App) ReconcilePools(ctx context.Context) error {
+	a.reconcileMu.Lock()
+	defer a.reconcileMu.Unlock()
+
+	var errs []error
+	declared := make(map[string]bool, len(env.Conf.Pools))
+	policies := make(map[string]services.PoolPolicy, len(env.Conf.Pools))
+	for _, p := range env.Conf.Pools {
+		declared[p.Name] = true
+		policy, err := poolPolicy(p.AssignmentTTLSec, p.KeepaliveGraceSec, p.DeletionAfterIdleSec, p.MaxHoldSec, p.Labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Pools[%s]: %w", p.Name, err))
+			continue
+		}
+		policy.MinSize, policy.MaxSize, policy.Generator = p.MinSize, p.MaxSize, p.Generator
+		policy.SoftMaxSize = p.SoftMaxSize
+		policy.Tags, policy.Parent = p.Tags, p.Parent
+		policy.Autoscale = services.Autoscale{
+			FailurePercent: p.Autoscale.FailurePercent,
+			QueueDepth:     p.Autoscale.QueueDepth,
+			IdlePercent:    p.Autoscale.IdlePercent,
+			Step:           p.Autoscale.Step,
+			Window:         durationOr(p.Autoscale.WindowMin, time.Minute, constants.DefaultAutoscaleWindow),
+		}
+		policies[p.Name] = policy
+	}
+	// Resolved only once every pool is read, so parents may be declared after their children
+	for _, p := range env.Conf.Pools {
+		if _, ok := policies[p.Name]; !ok {
+			continue
+		}
+		policy, err := services.ResolvePolicy(p.Name, policies)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Pools[%s]: %w", p.Name, err))
+			continue
+		}
+
+		added, err := a.Service.ReconcilePool(ctx, p.Name, policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Pools[%s]: %w", p.Name, err))
+			continue
+		}
+		if added > 0 {
+			a.Logger.Info("Reconciled pool", slog.String("pool", p.Name), slog.Int("added", added))
+		}
+	}
+	for pool := range a.declared {
+		if !declared[pool] {
+			if _, err := a.Service.ReconcilePool(ctx, pool, services.PoolPolicy{}); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %w", pool, err))
+			}
+		}
+	}
+	a.declared = declared
+	return errors.Join(errs...)
+}
+
+// poolPolicy converts a pool's declared timing and labels
+func poolPolicy(ttlSec, graceSec, idleSec, maxHoldSec int, labels []string) (services.PoolPolicy, error) {
+	policy := services.PoolPolicy{
+		Timing: repositories.TimingConfig{
+			AssignmentTTL:     time.Duration(ttlSec) * time.Second,
+			KeepaliveGrace:    time.Duration(graceSec) * time.Second,
+			DeletionAfterIdle: time.Duration(idleSec) * time.Second,
+			MaxHold:           time.Duration(maxHoldSec) * time.Second,
+		},
+	}
+	if len(labels) > 0 {
+		policy.Labels = make(map[string]string, len(labels))
+	}
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" || value == "" {
+			return services.PoolPolicy{}, fmt.Errorf("invalid label %q, want key=value", label)
+		}
+		policy.Labels[key] = value
+	}
+	return policy, nil
+}
+
+// poolWarmup merges a pool's inline seeds with those in its seed file
+func poolWarmup(size int, seeds []string, seedFile string) (services.Warmup, error) {
+	if size < 0 {
+		return services.Warmup{}, errors.New("Size must not be negative")
+	}
+	warmup := services.Warmup{Size: size, Seeds: seeds}
+	if seedFile == "" {
+		return warmup, nil
+	}
+	data, err := os.ReadFile(seedFile)
+	if err != nil {
+		return services.Warmup{}, fmt.Errorf("failed to read SeedFile: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			warmup.Seeds = append(warmup.Seeds, line)
+		}
+	}
+	return warmup, nil
+}
+
+// durationOr converts a configured count of unit into a duration, using fallback when it isn't positive
+func durationOr(value int, unit, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return time.Duration(value) * unit
+}
+
+func parseSchedule(spec, fallback string) (workers.Schedule, error) {
+	if spec == "" {
+		spec = fallback
+	}
+	return workers.ParseSchedule(spec)
+}
+
+func discoveryInterval() time.Duration {
+	if env.Conf.Cleanup.DiscoveryIntervalSec <= 0 {
+		return constants.DefaultPoolDiscoveryInterval
+	}
+	return time.Duration(env.Conf.Cleanup.DiscoveryIntervalSec) * time.Second
+}
+
+// backupWorker builds the scheduled backup from Backup, nil when no schedule is set
+func backupWorker(redisClient *redis.Client, logger *slog.Logger) (*workers.BackupWorker, error) {
+	if env.Conf.Backup.Schedule == "" {
+		return nil, nil
+	}
+	schedule, err := workers.ParseSchedule(env.Conf.Backup.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup config: Backup.Schedule: %w", err)
+	}
+	store, err := datasources.NewBackupStore()
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup config: %w", err)
+	}
+	if store == nil {
+		return nil, errors.New("invalid backup config: Backup.Schedule needs Backup.Bucket")
+	}
+	cipher, err := BackupCipher()
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+	if cipher == nil {
+		return nil, errors.New("invalid backup config: Backup.Schedule needs Backup.Key or Backup.KeyEnv")
+	}
+	return workers.NewBackupWorker(redisClient, store, cipher, workers.BackupConfig{
+		Schedule:  schedule,
+		Prefix:    env.Conf.Backup.Prefix,
+		Retention: time.Duration(env.Conf.Backup.RetentionDays) * 24 * time.Hour,
+	}, logger), nil
+}
+
+// historyCompactor builds the history compaction worker from History, nil
+// when no stream has a retention set
+func historyCompactor(service *services.TokenService, logger *slog.Logger) (*workers.HistoryCompactor, error) {
+	retention := make(map[string]repositories.Retention)
+	for name, r := range map[string]struct{ MaxAgeHours, MaxEntries int }{
+		repositories.HistoryAudit:      {env.Conf.History.Audit.MaxAgeHours, env.Conf.History.Audit.MaxEntries},
+		repositories.HistoryDeliveries: {env.Conf.History.Deliveries.MaxAgeHours, env.Conf.History.Deliveries.MaxEntries},
+	} {
+		if r.MaxAgeHours < 0 || r.MaxEntries < 0 {
+			return nil, errors.New("invalid history config: History retention must not be negative")
+		}
+		if r.MaxAgeHours > 0 || r.MaxEntries > 0 {
+			retention[name] = repositories.Retention{
+				MaxAge:     time.Duration(r.MaxAgeHours) * time.Hour,
+				MaxEntries: int64(r.MaxEntries),
+			}
+		}
+	}
+	if len(retention) == 0 {
+		return nil, nil
+	}
+	schedule, err := parseSchedule(env.Conf.History.Schedule, constants.DefaultHistoryCompactionSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid history config: History.Schedule: %w", err)
+	}
+	return workers.NewHistoryCompactor(service, workers.HistoryConfig{Schedule: schedule, Retention: retention}, logger), nil
+}
+
+// auditSink builds the export sink from Audit.Export
+func auditSink() (auditsink.Sink, error) {
+	c := env.Conf.Audit.Export
+	headers := make(map[string]string, len(c.Headers))
+	for _, h := range c.Headers {
+		headers[h.Name] = h.Value
+	}
+	maxMB := c.MaxFileMB
+	if maxMB <= 0 {
+		maxMB = constants.DefaultAuditFileMaxMB
+	}
+	maxFiles := c.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = constants.DefaultAuditFileMaxFiles
+	}
+	return auditsink.New(auditsink.Config{
+		Kind:     c.Sink,
+		URL:      c.URL,
+		Topic:    c.Topic,
+		Exchange: c.Exchange,
+		Headers:  headers,
+		Timeout:  durationOr(c.TimeoutMs, time.Millisecond, constants.DefaultAuditExportTimeout),
+		File:     c.File,
+		MaxBytes: int64(maxMB) << 20,
+		MaxFiles: maxFiles,
+	})
+}
+
+// BackupCipher returns the cipher snapshots are sealed with, nil when no
+// Backup key is configured
+func BackupCipher() (*encryption.Cipher, error) {
+	key := env.Conf.Backup.Key
+	if key == "" && env.Conf.Backup.KeyEnv != "" {
+		key = os.Getenv(env.Conf.Backup.KeyEnv)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return encryption.NewCipher(key)
+}
+
+// tokenCipher returns nil when no encryption key is configured
+func tokenCipher() (*encryption.Cipher, error) {
+	key := env.Conf.Encryption.Key
+	if key == "" && env.Conf.Encryption.KeyEnv != "" {
+		key = os.Getenv(env.Conf.Encryption.KeyEnv)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return encryption.NewCipher(key)
+}
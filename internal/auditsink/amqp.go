@@ -0,0 +1,158 @@
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// amqpSink publishes each entry to a RabbitMQ exchange over AMQP 0-9-1, with
+// the entry's action (e.g. "token.force_release") as routing key so consumers
+// bind to the event types they care about. Messages are persistent and carry
+// the entry ID as message_id for deduping redeliveries. Publishing is
+// mandatory and in confirm mode, so a batch only counts as delivered once the
+// broker has acked every entry, and an entry the exchange routes nowhere
+// comes back and fails the batch rather than being dropped.
+type amqpSink struct {
+	url      string
+	exchange string
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+func newAMQPSink(config Config) *amqpSink {
+	return &amqpSink{url: config.URL, exchange: config.Exchange, timeout: config.Timeout}
+}
+
+// connect (re)opens the connection and confirm-mode channel when either has
+// closed; the caller holds mu
+func (s *amqpSink) connect() error {
+	if s.conn != nil && !s.conn.IsClosed() && s.channel != nil && !s.channel.IsClosed() {
+		return nil
+	}
+	s.reset()
+
+	config := amqp.Config{Heartbeat: 10 * time.Second, Locale: "en_US"}
+	if s.timeout > 0 {
+		config.Dial = amqp.DefaultDial(s.timeout)
+	}
+	conn, err := amqp.DialConfig(s.url, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to put RabbitMQ channel in confirm mode: %w", err)
+	}
+	s.conn = conn
+	s.channel = channel
+	s.returns = channel.NotifyReturn(make(chan amqp.Return, 1))
+	return nil
+}
+
+// reset drops the connection so the next Write redials; the caller holds mu
+func (s *amqpSink) reset() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.channel, s.returns = nil, nil, nil
+}
+
+func (s *amqpSink) Write(ctx context.Context, entries []repositories.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	confirms := make([]*amqp.DeferredConfirmation, 0, len(entries))
+	for _, entry := range entries {
+		body, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit entry: %w", err)
+		}
+		confirm, err := s.channel.PublishWithDeferredConfirmWithContext(ctx, s.exchange, entry.Action, true, false, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			MessageId:    entry.ID,
+			Body:         body,
+		})
+		if err != nil {
+			s.reset()
+			return fmt.Errorf("failed to publish audit entry: %w", err)
+		}
+		confirms = append(confirms, confirm)
+	}
+
+	// RabbitMQ sends a mandatory message's return before its ack, so returns
+	// are drained while waiting to keep the channel's reader from blocking
+	// and are all in hand once the last ack arrives. A failure part way
+	// retries the whole batch; message_id lets consumers dedupe.
+	var returned []amqp.Return
+	for _, confirm := range confirms {
+		for waiting := true; waiting; {
+			select {
+			case <-confirm.Done():
+				waiting = false
+			case ret, ok := <-s.returns:
+				if !ok {
+					s.reset()
+					return fmt.Errorf("RabbitMQ channel closed before the audit batch was confirmed")
+				}
+				returned = append(returned, ret)
+			case <-ctx.Done():
+				s.reset()
+				return fmt.Errorf("failed to deliver audit batch: %w", ctx.Err())
+			}
+		}
+		if !confirm.Acked() {
+			s.reset()
+			return fmt.Errorf("RabbitMQ did not accept the audit batch")
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case ret, ok := <-s.returns:
+			if ok {
+				returned = append(returned, ret)
+			} else {
+				drained = true
+			}
+		default:
+			drained = true
+		}
+	}
+	if len(returned) > 0 {
+		return fmt.Errorf("exchange %s could not route %d audit entries, e.g. %s: %s",
+			s.exchange, len(returned), returned[0].MessageId, returned[0].ReplyText)
+	}
+	return nil
+}
+
+func (s *amqpSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
@@ -0,0 +1,104 @@
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// fileSink appends entries as JSON lines, rotating the file to path.1,
+// path.2, ... once it reaches MaxBytes
+type fileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newFileSink(config Config) (*fileSink, error) {
+	s := &fileSink{path: config.File, maxBytes: config.MaxBytes, maxFiles: config.MaxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit file: %w", err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(_ context.Context, entries []repositories.AuditEntry) error {
+	var buf []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit entry: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit file: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N down to path to path.1, dropping the
+// oldest, and starts a new file. The caller holds mu.
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file: %w", err)
+	}
+	os.Remove(s.rotated(s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		os.Rename(s.rotated(i), s.rotated(i+1)) // missing files are fine
+	}
+	if s.maxFiles > 0 {
+		if err := os.Rename(s.path, s.rotated(1)); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+	return s.open()
+}
+
+func (s *fileSink) rotated(n int) string {
+	return s.path + "." + strconv.Itoa(n)
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
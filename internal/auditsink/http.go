@@ -0,0 +1,61 @@
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// httpSink POSTs each batch as a JSON array
+type httpSink struct {
+	url         string
+	contentType string
+	headers     map[string]string
+	client      *http.Client
+}
+
+func newHTTPSink(config Config) *httpSink {
+	return &httpSink{
+		url:         config.URL,
+		contentType: "application/json",
+		headers:     config.Headers,
+		client:      &http.Client{Timeout: config.Timeout},
+	}
+}
+
+func (s *httpSink) Write(ctx context.Context, entries []repositories.AuditEntry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit batch: %w", err)
+	}
+	return s.post(ctx, body)
+}
+
+func (s *httpSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver audit batch: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error { return nil }
@@ -0,0 +1,41 @@
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// kafkaSink produces to a Kafka topic through the Confluent REST Proxy (v2
+// API), keyed by pool so a pool's entries stay ordered within a partition
+type kafkaSink struct {
+	httpSink
+}
+
+func newKafkaSink(config Config) *kafkaSink {
+	sink := newHTTPSink(config)
+	sink.url = strings.TrimRight(config.URL, "/") + "/topics/" + url.PathEscape(config.Topic)
+	sink.contentType = "application/vnd.kafka.json.v2+json"
+	return &kafkaSink{httpSink: *sink}
+}
+
+type kafkaRecord struct {
+	Key   string                  `json:"key"`
+	Value repositories.AuditEntry `json:"value"`
+}
+
+func (s *kafkaSink) Write(ctx context.Context, entries []repositories.AuditEntry) error {
+	records := make([]kafkaRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = kafkaRecord{Key: entry.Pool, Value: entry}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit batch: %w", err)
+	}
+	return s.post(ctx, body)
+}
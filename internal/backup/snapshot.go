@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/internal/encryption"
+	"github.com/redis/go-redis/v9"
+)
+
+// snapshotVersion is bumped when the snapshot layout changes incompatibly
+const snapshotVersion = 1
+
+// Snapshot is the full state of the Redis database the service runs on
+type Snapshot struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Keys      []Entry   `json:"keys"`
+}
+
+// Entry is one key. Strings, hashes, sets, sorted sets and lists are stored as
+// JSON; other types (the job and audit streams) as their DUMP payload.
+type Entry struct {
+	Key   string            `json:"key"`
+	Type  string            `json:"type"`
+	TTLMs int64             `json:"ttl_ms,omitempty"` // remaining at snapshot time; 0 never expires
+	Value string            `json:"value,omitempty"`
+	Hash  map[string]string `json:"hash,omitempty"`
+	Set   []string          `json:"set,omitempty"`
+	ZSet  []Member          `json:"zset,omitempty"`
+	List  []string          `json:"list,omitempty"`
+	Dump  []byte            `json:"dump,omitempty"`
+}
+
+// Member is a sorted set member and its score
+type Member struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// Export reads every key of client into a snapshot, batchSize keys at a time.
+// Keys written while it runs may or may not be included.
+func Export(ctx context.Context, client *redis.Client, batchSize int) (*Snapshot, error) {
+	snap := &Snapshot{Version: snapshotVersion, CreatedAt: time.Now().UTC()}
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "*", int64(batchSize)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan keys: %w", err)
+		}
+		if len(keys) > 0 {
+			entries, err := exportKeys(ctx, client, keys)
+			if err != nil {
+				return nil, err
+			}
+			snap.Keys = append(snap.Keys, entries...)
+		}
+		if next == 0 {
+			return snap, nil
+		}
+		cursor = next
+	}
+}
+
+func exportKeys(ctx context.Context, client *redis.Client, keys []string) ([]Entry, error) {
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			types[i] = pipe.Type(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key types: %w", err)
+	}
+
+	values := make([]redis.Cmder, len(keys))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			switch types[i].Val() {
+			case "string":
+				values[i] = pipe.Get(ctx, key)
+			case "hash":
+				values[i] = pipe.HGetAll(ctx, key)
+			case "set":
+				values[i] = pipe.SMembers(ctx, key)
+			case "zset":
+				values[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+			case "list":
+				values[i] = pipe.LRange(ctx, key, 0, -1)
+			case "none": // expired or deleted since the scan
+			default:
+				values[i] = pipe.Dump(ctx, key)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(keys))
+	for i, key := range keys {
+		if values[i] == nil || values[i].Err() != nil {
+			continue
+		}
+		entry := Entry{Key: key, Type: types[i].Val()}
+		if ttl := ttls[i].Val(); ttl > 0 {
+			entry.TTLMs = ttl.Milliseconds()
+		}
+		switch cmd := values[i].(type) {
+		case *redis.StringCmd:
+			if entry.Type == "string" {
+				entry.Value = cmd.Val()
+			} else {
+				entry.Dump = []byte(cmd.Val())
+			}
+		case *redis.MapStringStringCmd:
+			entry.Hash = cmd.Val()
+		case *redis.StringSliceCmd:
+			if entry.Type == "set" {
+				entry.Set = cmd.Val()
+			} else {
+				entry.List = cmd.Val()
+			}
+		case *redis.ZSliceCmd:
+			for _, z := range cmd.Val() {
+				entry.ZSet = append(entry.ZSet, Member{Member: z.Member.(string), Score: z.Score})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Restore writes a snapshot into client, batchSize keys per pipeline. Keys in
+// the snapshot replace any existing key of the same name; other keys are left
+// alone. It returns the number of keys restored.
+func Restore(ctx context.Context, client *redis.Client, snap *Snapshot, batchSize int) (int, error) {
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	restored := 0
+	for start := 0; start < len(snap.Keys); start += batchSize {
+		batch := snap.Keys[start:min(start+batchSize, len(snap.Keys))]
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, entry := range batch {
+				restoreEntry(ctx, pipe, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return restored, fmt.Errorf("failed to restore keys: %w", err)
+		}
+		restored += len(batch)
+	}
+	return restored, nil
+}
+
+func restoreEntry(ctx context.Context, pipe redis.Pipeliner, entry Entry) {
+	ttl := time.Duration(entry.TTLMs) * time.Millisecond
+	if entry.Dump != nil {
+		pipe.RestoreReplace(ctx, entry.Key, ttl, string(entry.Dump))
+		return
+	}
+
+	pipe.Del(ctx, entry.Key)
+	switch entry.Type {
+	case "string":
+		pipe.Set(ctx, entry.Key, entry.Value, 0)
+	case "hash":
+		fields := make([]interface{}, 0, 2*len(entry.Hash))
+		for field, value := range entry.Hash {
+			fields = append(fields, field, value)
+		}
+		pipe.HSet(ctx, entry.Key, fields...)
+	case "set":
+		members := make([]interface{}, len(entry.Set))
+		for i, m := range entry.Set {
+			members[i] = m
+		}
+		pipe.SAdd(ctx, entry.Key, members...)
+	case "zset":
+		members := make([]redis.Z, len(entry.ZSet))
+		for i, m := range entry.ZSet {
+			members[i] = redis.Z{Member: m.Member, Score: m.Score}
+		}
+		pipe.ZAdd(ctx, entry.Key, members...)
+	case "list":
+		values := make([]interface{}, len(entry.List))
+		for i, v := range entry.List {
+			values[i] = v
+		}
+		pipe.RPush(ctx, entry.Key, values...)
+	}
+	if ttl > 0 {
+		pipe.PExpire(ctx, entry.Key, ttl)
+	}
+}
+
+// Encode serialises a snapshot as gzip compressed JSON sealed with cipher
+func Encode(snap *Snapshot, cipher *encryption.Cipher) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	return cipher.Seal(buf.Bytes(), nil)
+}
+
+// Decode reverses Encode
+func Decode(data []byte, cipher *encryption.Cipher) (*Snapshot, error) {
+	compressed, err := cipher.Open(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	var snap Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return &snap, nil
+}
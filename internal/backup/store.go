@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectSuffix marks snapshot objects: gzip compressed JSON, then encrypted
+const objectSuffix = ".json.gz.enc"
+
+// objectTimeFormat names snapshots so they sort by age
+const objectTimeFormat = "20060102T150405Z"
+
+// Store is the object storage snapshots are kept in
+type Store interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ObjectKey names the snapshot taken at t under prefix
+func ObjectKey(prefix string, t time.Time) string {
+	return prefix + t.UTC().Format(objectTimeFormat) + objectSuffix
+}
+
+// Snapshots lists the snapshots under prefix, oldest first
+func Snapshots(ctx context.Context, store Store, prefix string) ([]string, error) {
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, key := range keys {
+		if _, ok := snapshotTime(prefix, key); ok {
+			snapshots = append(snapshots, key)
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// Prune deletes snapshots under prefix taken before cutoff. The newest
+// snapshot is always kept, however old.
+func Prune(ctx context.Context, store Store, prefix string, cutoff time.Time) (int, error) {
+	snapshots, err := Snapshots(ctx, store, prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, key := range snapshots[:max(len(snapshots)-1, 0)] {
+		taken, _ := snapshotTime(prefix, key)
+		if !taken.Before(cutoff) {
+			break
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			return deleted, fmt.Errorf("failed to prune snapshot: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func snapshotTime(prefix, key string) (time.Time, bool) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(key, prefix), objectSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(objectTimeFormat, name)
+	return t, err == nil
+}
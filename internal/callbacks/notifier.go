@@ -0,0 +1,188 @@
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/internal/tracing"
+)
+
+// Reasons a holder is warned before its token is reclaimed
+const (
+	ReasonExpiring = "expiring" // the assignment is about to lapse without a keepalive
+	ReasonReclaim  = "reclaim"  // another caller asked for the token to be released
+	ReasonReleased = "released" // an operator force-released the token; Code says why
+)
+
+// Headers sent with every callback. With a signing secret the signature is
+// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>"; receivers should
+// check it, reject stale timestamps and drop event IDs they have seen.
+const (
+	HeaderEventID   = "X-Callback-Event-ID"
+	HeaderTimestamp = "X-Callback-Timestamp" // Unix seconds
+	HeaderSignature = "X-Callback-Signature"
+)
+
+// Event is the JSON body POSTed to a holder's callback URL
+type Event struct {
+	ID        string    `json:"id"` // unique per event, set by Notify when empty
+	Token     string    `json:"token"`
+	Pool      string    `json:"pool"`
+	Reason    string    `json:"reason"`
+	Code      string    `json:"code,omitempty"` // release reason code for force releases
+	ReleaseAt time.Time `json:"release_at"`     // when the token is reclaimed unless kept alive
+
+	// The API request that caused the event, set by Notify from its context
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Delivery is the outcome of one attempt to call a holder back or deliver
+// an event to a webhook subscription
+type Delivery struct {
+	EventID    string    `json:"event_id"`
+	Webhook    string    `json:"webhook,omitempty"` // subscription ID; empty for holder callbacks
+	URL        string    `json:"url"`
+	Pool       string    `json:"pool"`
+	Reason     string    `json:"reason"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"` // 0 when no response arrived
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Notifier POSTs reclaim warnings to the callback URLs holders register at
+// assignment time
+type Notifier struct {
+	client       *http.Client
+	allowedHosts []string
+	secret       []byte
+
+	// OnDelivery, when set, is told the outcome of every callback attempt
+	OnDelivery func(ctx context.Context, delivery Delivery)
+}
+
+// NewNotifier creates a notifier. An empty allowedHosts accepts any host; an
+// empty secret sends callbacks unsigned.
+func NewNotifier(timeout time.Duration, allowedHosts []string, secret string) *Notifier {
+	return &Notifier{client: &http.Client{Timeout: timeout}, allowedHosts: allowedHosts, secret: []byte(secret)}
+}
+
+// Validate checks that a callback URL is an absolute http(s) URL to an allowed host
+func (n *Notifier) Validate(rawURL string) error {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback must be an absolute http or https URL")
+	}
+	if len(n.allowedHosts) > 0 && !slices.Contains(n.allowedHosts, u.Hostname()) {
+		return fmt.Errorf("callback host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// Message is one signed POST of an event
+type Message struct {
+	EventID string
+	URL     string
+	Secret  []byte // signs the body; unsigned when empty
+	Payload any    // JSON encoded as the body
+	Webhook string // subscription the message is for, recorded with the delivery
+	Pool    string // recorded with the delivery
+	Reason  string // recorded with the delivery: the callback reason or event type
+}
+
+// Notify POSTs the event to the callback URL; any non-2xx response is an error
+func (n *Notifier) Notify(ctx context.Context, callbackURL string, event Event) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.TraceID == "" && event.RequestID == "" {
+		ids := tracing.FromContext(ctx)
+		event.TraceID, event.RequestID = ids.TraceID, ids.RequestID
+	}
+	return n.Send(ctx, Message{
+		EventID: event.ID,
+		URL:     callbackURL,
+		Secret:  n.secret,
+		Payload: event,
+		Pool:    event.Pool,
+		Reason:  event.Reason,
+	})
+}
+
+// Send POSTs a message and reports the attempt to OnDelivery; any non-2xx
+// response is an error
+func (n *Notifier) Send(ctx context.Context, msg Message) error {
+	start := time.Now()
+	status, err := n.post(ctx, msg, start)
+	if n.OnDelivery != nil {
+		delivery := Delivery{
+			EventID:    msg.EventID,
+			Webhook:    msg.Webhook,
+			URL:        msg.URL,
+			Pool:       msg.Pool,
+			Reason:     msg.Reason,
+			Time:       start,
+			StatusCode: status,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		n.OnDelivery(ctx, delivery)
+	}
+	return err
+}
+
+// post sends one attempt and returns the response status, 0 without a response
+func (n *Notifier) post(ctx context.Context, msg Message, now time.Time) (int, error) {
+	body, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode callback event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build callback request: %w", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, msg.EventID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(msg.Secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(msg.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call back holder: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("holder callback returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the HeaderSignature value for a body sent at timestamp
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Cipher encrypts token values with AES-256-GCM and derives a deterministic
+// HMAC index so tokens can still be looked up without storing the plaintext.
+type Cipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// NewCipher builds a cipher from a base64-encoded 32 byte key
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	// Separate the index key from the encryption key so one never doubles as the other
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("token-index"))
+
+	return &Cipher{aead: aead, indexKey: mac.Sum(nil)}, nil
+}
+
+// Encrypt returns base64(nonce || ciphertext). The ciphertext is bound to aad,
+// typically the ID it is stored under, so it only decrypts with the same aad
+// and can't be moved to another record unnoticed.
+func (c *Cipher) Encrypt(plaintext, aad string) (string, error) {
+	sealed, err := c.Seal([]byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. An empty aad opens values encrypted without one.
+func (c *Cipher) Decrypt(encoded, aad string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+	plaintext, err := c.Open(sealed, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Seal returns nonce || ciphertext of data, authenticating aad with it
+func (c *Cipher) Seal(data, aad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, data, aad), nil
+}
+
+// Open reverses Seal; it fails unless aad is the one data was sealed with
+func (c *Cipher) Open(sealed, aad []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("ciphertext too short")
+	}
+	return c.aead.Open(nil, sealed[:size], sealed[size:], aad)
+}
+
+// Index returns the stable lookup ID stored in Redis in place of the token
+func (c *Cipher) Index(token string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// ActorConfig says how the admin API learns who is calling. An actor comes
+// from the identity header set by the authenticating proxy in front of
+// /admin, or from a bearer key given to automation.
+type ActorConfig struct {
+	Required bool              // reject mutating admin requests without an actor; otherwise they are recorded without one
+	Header   string            // identity header set by the auth proxy, e.g. X-Forwarded-User; empty trusts none
+	Keys     map[string]string // bearer key -> actor name
+}
+
+// actorKey holds the caller's actor in the gin context
+const actorKey = "admin_actor"
+
+// identifyActor resolves the actor of every admin request and the principal
+// it acts for (X-On-Behalf-Of), which audit entries record next to it.
+// Mutating requests without an actor are rejected when one is required. The
+// client's own X-Client-ID never stands in for one, as anybody can set it.
+func identifyActor(config ActorConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := actorOf(c, config)
+		if actor == "" && config.Required && mutating(c.Request.Method) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin changes need an authenticated actor"})
+			return
+		}
+		c.Set(actorKey, actor)
+
+		if principal := c.GetHeader(constants.HeaderOnBehalfOf); principal != "" {
+			if len(principal) > constants.MaxActorLength {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid " + constants.HeaderOnBehalfOf})
+				return
+			}
+			c.Request = c.Request.WithContext(repositories.WithOnBehalfOf(c.Request.Context(), principal))
+		}
+		c.Next()
+	}
+}
+
+// actorOf returns the authenticated actor, empty when the request carries none
+func actorOf(c *gin.Context, config ActorConfig) string {
+	if config.Header != "" {
+		if actor := c.GetHeader(config.Header); actor != "" && len(actor) <= constants.MaxActorLength {
+			return actor
+		}
+	}
+	key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		return ""
+	}
+	actor := ""
+	for k, name := range config.Keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			actor = name
+		}
+	}
+	return actor
+}
+
+// adminActor is who made an admin request, as identifyActor resolved it;
+// empty when the request carries no actor
+func adminActor(c *gin.Context) string {
+	return c.GetString(actorKey)
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
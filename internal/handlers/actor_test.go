@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+)
+
+// actorRouter echoes the resolved actor of GET and POST /admin/pools
+func actorRouter(config ActorConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(identifyActor(config))
+	echo := func(c *gin.Context) { c.String(http.StatusOK, adminActor(c)) }
+	router.GET("/admin/pools", echo)
+	router.POST("/admin/pools", echo)
+	return router
+}
+
+func serveActor(router *gin.Engine, method string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/admin/pools", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdentifyActorResolvesHeaderAndBearerKeys(t *testing.T) {
+	router := actorRouter(ActorConfig{Required: true, Header: "X-Forwarded-User", Keys: map[string]string{"s3cret": "deploy-bot"}})
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		actor   string
+	}{
+		{"proxy header", map[string]string{"X-Forwarded-User": "alice"}, "alice"},
+		{"bearer key", map[string]string{"Authorization": "Bearer s3cret"}, "deploy-bot"},
+		{"header wins over key", map[string]string{"X-Forwarded-User": "alice", "Authorization": "Bearer s3cret"}, "alice"},
+		{"oversized header falls back to key", map[string]string{"X-Forwarded-User": strings.Repeat("a", constants.MaxActorLength+1), "Authorization": "Bearer s3cret"}, "deploy-bot"},
+	} {
+		w := serveActor(router, http.MethodPost, tc.headers)
+		if w.Code != http.StatusOK || w.Body.String() != tc.actor {
+			t.Errorf("%s: got %d %q, want 200 %q", tc.name, w.Code, w.Body.String(), tc.actor)
+		}
+	}
+}
+
+func TestIdentifyActorRejectsMutationsWithoutAnActor(t *testing.T) {
+	router := actorRouter(ActorConfig{Required: true, Header: "X-Forwarded-User", Keys: map[string]string{"s3cret": "deploy-bot"}})
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"no credentials", nil},
+		{"unknown key", map[string]string{"Authorization": "Bearer wrong"}},
+		{"empty key", map[string]string{"Authorization": "Bearer "}},
+		{"client id is not an actor", map[string]string{"X-Client-ID": "alice"}},
+	} {
+		if w := serveActor(router, http.MethodPost, tc.headers); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: POST = %d, want 401", tc.name, w.Code)
+		}
+	}
+	if w := serveActor(router, http.MethodGet, nil); w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("GET without an actor = %d %q, want 200 with no actor", w.Code, w.Body.String())
+	}
+}
+
+func TestIdentifyActorAllowsAnonymousChangesWhenNotRequired(t *testing.T) {
+	router := actorRouter(ActorConfig{Keys: map[string]string{"s3cret": "deploy-bot"}})
+
+	if w := serveActor(router, http.MethodPost, nil); w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("POST without an actor = %d %q, want 200 with no actor", w.Code, w.Body.String())
+	}
+	// No header is trusted when none is configured
+	if w := serveActor(router, http.MethodPost, map[string]string{"X-Forwarded-User": "alice"}); w.Body.String() != "" {
+		t.Errorf("unconfigured header resolved actor %q", w.Body.String())
+	}
+}
+
+func TestIdentifyActorRejectsAnOversizedPrincipal(t *testing.T) {
+	router := actorRouter(ActorConfig{Header: "X-Forwarded-User"})
+
+	headers := map[string]string{"X-Forwarded-User": "alice", constants.HeaderOnBehalfOf: "team-a"}
+	if w := serveActor(router, http.MethodPost, headers); w.Code != http.StatusOK {
+		t.Errorf("with a principal = %d, want 200", w.Code)
+	}
+	headers[constants.HeaderOnBehalfOf] = strings.Repeat("p", constants.MaxActorLength+1)
+	if w := serveActor(router, http.MethodPost, headers); w.Code != http.StatusBadRequest {
+		t.Errorf("with an oversized principal = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/secrets"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+// AdminHandler exposes operational endpoints for tokens, background jobs,
+// secrets and the running configuration
+type AdminHandler struct {
+	Service *services.TokenService
+	Jobs    *jobs.Queue
+	Secrets secrets.Store // resolves hash-only handles; nil disables retrieval
+	Config  any           // effective configuration, already redacted
+}
+
+func NewAdminHandler(service *services.TokenService, queue *jobs.Queue, store secrets.Store, config any) *AdminHandler {
+	return &AdminHandler{Service: service, Jobs: queue, Secrets: store, Config: config}
+}
+
+type ForceReleaseRequest struct {
+	Reason string `json:"reason" binding:"required,oneof=admin rotation incident"`
+	Note   string `json:"note" binding:"max=512"`
+}
+
+// ForceRelease returns a token to the pool right away, recording who did it and why
+func (handler *AdminHandler) ForceRelease(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+	var req ForceReleaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, reason must be admin, rotation or incident"})
+		return
+	}
+
+	err := handler.Service.ForceRelease(c.Request.Context(), uri.Token, req.Reason, req.Note, adminActor(c))
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrTokenNotAssigned):
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenNotAssigned.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to release token", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Token released", "reason": req.Reason})
+	}
+}
+
+// ForceKeepAlive sets a token's expiry to a full TTL from now, shortening it
+// if a keepalive had pushed it further out, but never past its pool's max hold
+func (handler *AdminHandler) ForceKeepAlive(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	err := handler.Service.ForceKeepAlive(c.Request.Context(), uri.Token, adminActor(c))
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrMaxHoldReached):
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrMaxHoldReached.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to keep token alive", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Token expiry reset"})
+	}
+}
+
+// GetQuarantine lists a pool's quarantined tokens with their failure counts
+// and last probe, longest quarantined first
+func (handler *AdminHandler) GetQuarantine(c *gin.Context) {
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := handler.Service.QuarantinedTokens(c.Request.Context(), pool)
+	if err != nil {
+		respondFailed(c, "Failed to list quarantined tokens", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pool": pool, "tokens": tokens})
+}
+
+// ApproveQuarantined puts a quarantined token back in its pool
+func (handler *AdminHandler) ApproveQuarantined(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	err := handler.Service.ApproveQuarantined(c.Request.Context(), uri.Token, adminActor(c))
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrInvalidTransition), errors.Is(err, constants.ErrTokenNotQuarantined):
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenNotQuarantined.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to approve token", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Token returned to pool"})
+	}
+}
+
+// PurgeQuarantined permanently deletes one quarantined token
+func (handler *AdminHandler) PurgeQuarantined(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+	version, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	err := handler.Service.PurgeQuarantined(c.Request.Context(), uri.Token, adminActor(c), version)
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrTokenNotQuarantined):
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenNotQuarantined.Error()})
+	case errors.Is(err, constants.ErrVersionMismatch):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": constants.ErrVersionMismatch.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to purge token", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Token purged"})
+	}
+}
+
+// PurgeQuarantine permanently deletes every quarantined token in a pool
+func (handler *AdminHandler) PurgeQuarantine(c *gin.Context) {
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+
+	purged, err := handler.Service.PurgeQuarantine(c.Request.Context(), pool, adminActor(c))
+	if err != nil {
+		respondFailed(c, "Failed to purge quarantine", gin.H{"purged": purged})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pool": pool, "purged": purged})
+}
+
+// GetAudit lists recent audit entries, filtered by ?token= when given
+func (handler *AdminHandler) GetAudit(c *gin.Context) {
+	limit := constants.DefaultAuditLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > constants.AuditScanLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := handler.Service.AuditHistory(c.Request.Context(), c.Query("token"), limit)
+	if err != nil {
+		respondFailed(c, "Failed to read audit history", nil)
+		return
+	}
+	if formatOf(c) == formatCSV {
+		writeCSV(c, []string{"id", "time", "action", "token", "pool", "actor", "on_behalf_of", "reason", "detail"}, auditRows(entries))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GetDeliveries lists recent callback attempts, newest first, with their
+// HTTP status or error
+func (handler *AdminHandler) GetDeliveries(c *gin.Context) {
+	limit := constants.DefaultAuditLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > constants.CallbackDeliveriesMaxLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := handler.Service.Deliveries(c.Request.Context(), limit)
+	if err != nil {
+		respondFailed(c, "Failed to read deliveries", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// auditRows flattens audit entries for CSV, with detail as sorted key=value pairs
+func auditRows(entries []repositories.AuditEntry) [][]string {
+	rows := make([][]string, len(entries))
+	for i, entry := range entries {
+		detail := make([]string, 0, len(entry.Detail))
+		for key, value := range entry.Detail {
+			detail = append(detail, key+"="+value)
+		}
+		sort.Strings(detail)
+		rows[i] = []string{
+			entry.ID,
+			entry.Time.Format(time.RFC3339),
+			entry.Action,
+			entry.Token,
+			entry.Pool,
+			csvText(entry.Actor),
+			csvText(entry.OnBehalfOf),
+			csvText(entry.Reason),
+			csvText(strings.Join(detail, ",")),
+		}
+	}
+	return rows
+}
+
+// RunCleanup runs a cleanup pass now and returns its report. ?pool= limits it
+// to one pool, every pool otherwise; ?phase= is release, delete or all. A pool
+// a scheduled sweep is cleaning answers 409, unless ?wait=true holds the run
+// until the sweep is done.
+func (handler *AdminHandler) RunCleanup(c *gin.Context) {
+	pool := c.Query("pool")
+	if pool != "" && !poolNamePattern.MatchString(pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return
+	}
+	phase, err := repositories.ParseCleanupPhase(c.Query("phase"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phase, must be release, delete or all"})
+		return
+	}
+
+	report, err := handler.Service.CleanupPool(c.Request.Context(), pool, phase, c.Query("wait") == "true")
+	if errors.Is(err, constants.ErrCleanupInProgress) && !deadlineExceeded(c) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.Error("Cleanup run failed", slog.String("pool", pool), slog.String("error", err.Error()))
+		respondFailed(c, "Cleanup failed", gin.H{"report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// PauseCleanupRequest pauses scheduled cleanup, for DurationSec or until
+// resumed when it is 0
+type PauseCleanupRequest struct {
+	Reason      string `json:"reason" binding:"required,max=512"`
+	DurationSec int    `json:"duration_sec" binding:"min=0"`
+}
+
+// PauseCleanup stops the scheduled release and deletion sweeps on every
+// replica so token state stays frozen while an incident is inspected
+func (handler *AdminHandler) PauseCleanup(c *gin.Context) {
+	var req PauseCleanupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, reason is required and duration_sec must not be negative"})
+		return
+	}
+
+	duration := time.Duration(req.DurationSec) * time.Second
+	pause, err := handler.Service.PauseCleanup(c.Request.Context(), adminActor(c), req.Reason, duration)
+	if err != nil && !pause.Paused {
+		respondFailed(c, "Failed to pause cleanup", nil)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to audit cleanup pause", slog.String("error", err.Error()))
+	}
+	c.JSON(http.StatusOK, pause)
+}
+
+// ResumeCleanup lifts a pause of scheduled cleanup
+func (handler *AdminHandler) ResumeCleanup(c *gin.Context) {
+	resumed, err := handler.Service.ResumeCleanup(c.Request.Context(), adminActor(c))
+	if err != nil && !resumed {
+		respondFailed(c, "Failed to resume cleanup", nil)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to audit cleanup resume", slog.String("error", err.Error()))
+	}
+	c.JSON(http.StatusOK, gin.H{"resumed": resumed})
+}
+
+// GetCleanupStatus reports whether scheduled cleanup is paused and which
+// pools and phases are being cleaned right now
+func (handler *AdminHandler) GetCleanupStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	pause, err := handler.Service.CleanupPaused(ctx)
+	if err != nil {
+		respondFailed(c, "Failed to fetch cleanup status", nil)
+		return
+	}
+	running, err := handler.Service.RunningCleanups(ctx)
+	if err != nil {
+		respondFailed(c, "Failed to fetch cleanup status", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pause": pause, "running": running})
+}
+
+// GetMemory estimates the bytes each pool's keys take in Redis by key family,
+// and those of the keys pools share, for capacity planning. ?pool= limits the
+// report to one pool.
+func (handler *AdminHandler) GetMemory(c *gin.Context) {
+	pool := c.Query("pool")
+	if pool != "" && !poolNamePattern.MatchString(pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return
+	}
+
+	pools, shared, err := handler.Service.MemoryReport(c.Request.Context(), pool)
+	if err != nil {
+		slog.Error("Memory report failed", slog.String("pool", pool), slog.String("error", err.Error()))
+		respondFailed(c, "Failed to measure memory usage", gin.H{"pools": pools})
+		return
+	}
+	var total int64
+	for _, report := range pools {
+		total += report.Total
+	}
+	for _, bytes := range shared {
+		total += bytes
+	}
+	c.JSON(http.StatusOK, gin.H{"pools": pools, "shared": shared, "total": total})
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (handler *AdminHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, handler.Config)
+}
+
+type JobRequest struct {
+	ID string `uri:"job" binding:"required,uuid"`
+}
+
+// RunJob enqueues a registered job; ?pool= is passed to pool scoped jobs
+func (handler *AdminHandler) RunJob(c *gin.Context) {
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("job")
+	id, err := handler.Jobs.Enqueue(c.Request.Context(), name, map[string]string{"pool": pool})
+	if errors.Is(err, constants.ErrUnknownJob) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown job", "jobs": handler.Jobs.Names()})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to enqueue job", nil)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job_id": id})
+}
+
+// GetJob returns the last recorded state of a job
+func (handler *AdminHandler) GetJob(c *gin.Context) {
+	var req JobRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	status, err := handler.Jobs.Status(c.Request.Context(), req.ID)
+	if errors.Is(err, constants.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to fetch job status", nil)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// CancelJob asks a queued or running job to stop. A running job stops only
+// if it checks for cancellation, as imports do between chunks, so the
+// returned status may still be running.
+func (handler *AdminHandler) CancelJob(c *gin.Context) {
+	var req JobRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	status, err := handler.Jobs.Cancel(c.Request.Context(), req.ID)
+	if errors.Is(err, constants.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to cancel job", nil)
+		return
+	}
+	c.JSON(http.StatusAccepted, status)
+}
+
+type SecretRequest struct {
+	Handle string `uri:"handle" binding:"required,sha256"`
+}
+
+// GetSecret resolves a hash-only handle to its secret. Every attempt is
+// written to the audit history, whether or not it succeeds, and a secret is
+// only returned once its retrieval has been recorded. Unlike other reads it
+// needs an authenticated actor, whether or not admin changes require one.
+func (handler *AdminHandler) GetSecret(c *gin.Context) {
+	if handler.Secrets == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Secret store is not configured"})
+		return
+	}
+
+	var req SecretRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid handle"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	audit := func(outcome string) error {
+		err := handler.Service.RecordSecretRetrieval(ctx, req.Handle, adminActor(c), c.ClientIP(), outcome)
+		if err != nil {
+			slog.Error("Failed to audit secret retrieval",
+				slog.String("handle", req.Handle),
+				slog.String("outcome", outcome),
+				slog.String("error", err.Error()))
+		}
+		return err
+	}
+
+	if adminActor(c) == "" {
+		audit("unauthenticated")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Secret reads need an authenticated actor"})
+		return
+	}
+
+	secret, err := handler.Secrets.Lookup(ctx, req.Handle)
+	if errors.Is(err, constants.ErrSecretNotFound) {
+		audit("not_found")
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrSecretNotFound.Error()})
+		return
+	}
+	if err != nil {
+		audit("error")
+		respondFailed(c, "Failed to retrieve secret", nil)
+		return
+	}
+	if err := audit("success"); err != nil {
+		respondFailed(c, "Failed to retrieve secret", nil)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"handle": req.Handle, "token": secret})
+}
+
+type PoolURI struct {
+	Pool string `uri:"pool" binding:"required"`
+}
+
+// PoolPolicyRequest changes a pool's timing at runtime. Omitted fields are
+// left alone; 0 reverts a field to the configured value.
+type PoolPolicyRequest struct {
+	AssignmentTTLSec     *int `json:"assignment_ttl_sec" binding:"omitempty,min=0"`
+	KeepaliveGraceSec    *int `json:"keepalive_grace_sec" binding:"omitempty,min=0"`
+	DeletionAfterIdleSec *int `json:"deletion_after_idle_sec" binding:"omitempty,min=0"`
+	LockTTLSec           *int `json:"lock_ttl_sec" binding:"omitempty,min=0"`
+	MaxHoldSec           *int `json:"max_hold_sec" binding:"omitempty,min=0"`
+}
+
+// GetPoolPolicy returns a pool's runtime timing override and the effective timing
+func (handler *AdminHandler) GetPoolPolicy(c *gin.Context) {
+	var uri PoolURI
+	if err := c.ShouldBindUri(&uri); err != nil || !poolNamePattern.MatchString(uri.Pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return
+	}
+	override, effective, err := handler.Service.PoolTiming(c.Request.Context(), uri.Pool)
+	if err != nil {
+		respondFailed(c, "Failed to fetch pool policy", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pool": uri.Pool, "override": override, "effective": effective})
+}
+
+// PatchPoolPolicy adjusts a pool's auto-release, deletion and lock times. The
+// change is stored in Redis and picked up by every replica.
+func (handler *AdminHandler) PatchPoolPolicy(c *gin.Context) {
+	var uri PoolURI
+	if err := c.ShouldBindUri(&uri); err != nil || !poolNamePattern.MatchString(uri.Pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return
+	}
+	var req PoolPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, times must be non-negative seconds"})
+		return
+	}
+
+	patch := services.TimingPatch{
+		AssignmentTTLSec:     req.AssignmentTTLSec,
+		KeepaliveGraceSec:    req.KeepaliveGraceSec,
+		DeletionAfterIdleSec: req.DeletionAfterIdleSec,
+		LockTTLSec:           req.LockTTLSec,
+		MaxHoldSec:           req.MaxHoldSec,
+	}
+	ctx := c.Request.Context()
+	override, err := handler.Service.UpdatePoolTiming(ctx, uri.Pool, patch, adminActor(c))
+	switch {
+	case errors.Is(err, constants.ErrInvalidTiming):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		slog.Error("Pool policy update failed", slog.String("pool", uri.Pool), slog.String("error", err.Error()))
+		respondFailed(c, "Failed to update pool policy", nil)
+		return
+	}
+	_, effective, err := handler.Service.PoolTiming(ctx, uri.Pool)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"pool": uri.Pool, "override": override})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pool": uri.Pool, "override": override, "effective": effective})
+}
+
+// PoolSimulationRequest proposes a pool policy to simulate: timing read as
+// PoolPolicyRequest is, plus size limits. Omitted fields keep their current value.
+type PoolSimulationRequest struct {
+	PoolPolicyRequest
+	MinSize *int `json:"min_size" binding:"omitempty,min=0"`
+	MaxSize *int `json:"max_size" binding:"omitempty,min=0"`
+}
+
+// SimulatePoolPolicy forecasts how many tokens cleanup would release and
+// delete, and how many would stay available, under a proposed timing and
+// size, next to the same forecast for the current policy. Nothing is changed.
+func (handler *AdminHandler) SimulatePoolPolicy(c *gin.Context) {
+	var uri PoolURI
+	if err := c.ShouldBindUri(&uri); err != nil || !poolNamePattern.MatchString(uri.Pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return
+	}
+	var req PoolSimulationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, times and sizes must be non-negative"})
+		return
+	}
+	if req.MinSize != nil && req.MaxSize != nil && *req.MaxSize > 0 && *req.MinSize > *req.MaxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_size must not exceed max_size"})
+		return
+	}
+
+	proposal := services.PolicyProposal{
+		Timing: services.TimingPatch{
+			AssignmentTTLSec:     req.AssignmentTTLSec,
+			KeepaliveGraceSec:    req.KeepaliveGraceSec,
+			DeletionAfterIdleSec: req.DeletionAfterIdleSec,
+			LockTTLSec:           req.LockTTLSec,
+			MaxHoldSec:           req.MaxHoldSec,
+		},
+		MinSize: req.MinSize,
+		MaxSize: req.MaxSize,
+	}
+	sim, err := handler.Service.SimulatePoolPolicy(c.Request.Context(), uri.Pool, proposal)
+	switch {
+	case errors.Is(err, constants.ErrInvalidTiming):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to simulate pool policy", nil)
+	default:
+		c.JSON(http.StatusOK, sim)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ipAllowlist admits only callers whose client IP, as resolved through the
+// trusted proxies, falls in one of cidrs (CIDRs or single IPs). It guards the
+// operator routes on top of actor authentication, so a leaked admin key or a
+// spoofed identity header is no use from outside the operator network. An
+// empty list admits everyone.
+func ipAllowlist(cidrs []string) (gin.HandlerFunc, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin allowlist entry %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return func(c *gin.Context) {
+		if len(prefixes) == 0 {
+			c.Next()
+			return
+		}
+		ip, err := netip.ParseAddr(c.ClientIP())
+		if err == nil {
+			ip = ip.Unmap()
+			for _, prefix := range prefixes {
+				if prefix.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+		slog.Warn("Admin request from outside the allowlist",
+			slog.String("ip", c.ClientIP()), slog.String("route", c.Request.Method+" "+c.FullPath()))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	}, nil
+}
+
+// parsePrefix reads a CIDR, or a single IP as the prefix holding only it
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allowlistRouter serves GET /admin/pools behind ipAllowlist(cidrs), trusting
+// forwarding headers only from 10.0.0.1
+func allowlistRouter(t *testing.T, cidrs []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	allowlist, err := ipAllowlist(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/admin/pools", allowlist, func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func serveFrom(router *gin.Engine, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodGet, "/admin/pools", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestIPAllowlistAdmitsOnlyListedCallers(t *testing.T) {
+	router := allowlistRouter(t, []string{"192.168.1.0/24", "203.0.113.7", "2001:db8::/32"})
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{"inside a CIDR", "192.168.1.20:5000", http.StatusOK},
+		{"single IP", "203.0.113.7:5000", http.StatusOK},
+		{"IPv6 CIDR", "[2001:db8::1]:5000", http.StatusOK},
+		{"IPv4-mapped IPv6", "[::ffff:192.168.1.20]:5000", http.StatusOK},
+		{"outside", "192.168.2.20:5000", http.StatusForbidden},
+		{"neighbour of a single IP", "203.0.113.8:5000", http.StatusForbidden},
+	} {
+		if code := serveFrom(router, tc.remoteAddr, ""); code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
+
+func TestIPAllowlistBelievesOnlyTrustedProxies(t *testing.T) {
+	router := allowlistRouter(t, []string{"192.168.1.0/24"})
+
+	if code := serveFrom(router, "10.0.0.1:5000", "192.168.1.20"); code != http.StatusOK {
+		t.Errorf("listed client behind a trusted proxy = %d, want 200", code)
+	}
+	if code := serveFrom(router, "10.0.0.1:5000", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("unlisted client behind a trusted proxy = %d, want 403", code)
+	}
+	if code := serveFrom(router, "198.51.100.1:5000", "192.168.1.20"); code != http.StatusForbidden {
+		t.Errorf("spoofed X-Forwarded-For from an untrusted peer = %d, want 403", code)
+	}
+}
+
+func TestIPAllowlistEmptyAdmitsEveryone(t *testing.T) {
+	router := allowlistRouter(t, nil)
+	if code := serveFrom(router, "198.51.100.1:5000", ""); code != http.StatusOK {
+		t.Errorf("got %d, want 200", code)
+	}
+}
+
+func TestIPAllowlistRejectsInvalidEntries(t *testing.T) {
+	for _, cidr := range []string{"192.168.1.0/33", "not-an-ip", ""} {
+		if _, err := ipAllowlist([]string{cidr}); err == nil {
+			t.Errorf("ipAllowlist(%q) accepted an invalid entry", cidr)
+		}
+	}
+}
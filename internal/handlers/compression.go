@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+)
+
+// CompressionConfig compresses response bodies with gzip or deflate, as the
+// caller's Accept-Encoding allows
+type CompressionConfig struct {
+	Enabled        bool
+	MinSize        int      // bodies smaller than this many bytes are sent as they are; DefaultCompressionMinSize when 0
+	ExcludedRoutes []string // "METHOD /path" routes never compressed, e.g. "GET /metrics"
+}
+
+// compress returns middleware that compresses responses once they reach the
+// minimum size. Bodies are held back until then, except when a handler
+// flushes: a streamed export or listing is compressed from its first flush
+// on, since it is expected to grow large.
+func compress(config CompressionConfig) gin.HandlerFunc {
+	minSize := config.MinSize
+	if minSize <= 0 {
+		minSize = constants.DefaultCompressionMinSize
+	}
+	excluded := make(map[string]bool, len(config.ExcludedRoutes))
+	for _, route := range config.ExcludedRoutes {
+		excluded[route] = true
+	}
+
+	return func(c *gin.Context) {
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead || excluded[c.Request.Method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		// Caches must key on Accept-Encoding whether or not this response ends up compressed
+		c.Header("Vary", "Accept-Encoding")
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minSize: minSize}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		w.finish()
+	}
+}
+
+// acceptedEncoding picks gzip, then deflate, from an Accept-Encoding header,
+// skipping codings refused with q=0; "" means send the body as it is
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+	}
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter buffers a response until it is known to be worth
+// compressing, then writes it through the encoder
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+	buf      bytes.Buffer
+	encoder  io.WriteCloser // set once the response is being compressed
+	raw      bool           // set once the response is being sent as it is
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	switch {
+	case w.encoder != nil:
+		return w.encoder.Write(data)
+	case w.raw:
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minSize {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written reports whether the handler wrote anything, even if it is still held back
+func (w *compressWriter) Written() bool {
+	return w.buf.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// Flush starts compressing a held back body, so streamed responses reach the
+// caller as they are produced. With nothing written yet there is nothing to
+// send, and the headers are held back until there is.
+func (w *compressWriter) Flush() {
+	if w.encoder == nil && !w.raw {
+		if w.buf.Len() == 0 {
+			return
+		}
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start settles how the response is sent and writes out what was held back.
+// A body the handler already encoded, an empty or bodiless response, or
+// headers already sent go out as they are.
+func (w *compressWriter) start() error {
+	header := w.Header()
+	status := w.Status()
+	if header.Get("Content-Encoding") != "" || w.ResponseWriter.Written() || w.buf.Len() == 0 ||
+		status == http.StatusNoContent || status == http.StatusNotModified || status < http.StatusOK {
+		w.raw = true
+		_, err := w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+		return err
+	}
+
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.encoder = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.encoder, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+	}
+	_, err := w.encoder.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish sends a body that stayed under the minimum size as it is, or closes
+// the encoder of a compressed one
+func (w *compressWriter) finish() {
+	switch {
+	case w.encoder != nil:
+		w.encoder.Close()
+	case !w.raw && w.buf.Len() > 0:
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/internal/metrics"
+)
+
+var (
+	routeInFlight = metrics.NewGaugeVec(
+		"http_route_in_flight",
+		"Requests in flight on concurrency limited routes.",
+		"route",
+	)
+	routeRejected = metrics.NewCounterVec(
+		"http_route_rejected_total",
+		"Requests rejected with 429 because a route's concurrency limit was reached.",
+		"route",
+	)
+)
+
+// RouteLimit caps concurrent requests on one route, keyed as "METHOD /path"
+// using the registered path, e.g. "GET /tokens/available"
+type RouteLimit struct {
+	Route   string
+	Limit   int
+	MaxWait time.Duration // how long a request may wait for a slot before a 429
+}
+
+// concurrencyLimiter holds a semaphore per limited route
+type concurrencyLimiter struct {
+	routes map[string]*routeSemaphore
+}
+
+type routeSemaphore struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// concurrencyLimit returns middleware that bounds in-flight requests per
+// configured route, so a burst of slow listing or cleanup calls can't starve
+// assign and keepalive. Routes without a limit pass straight through.
+func concurrencyLimit(limits []RouteLimit) gin.HandlerFunc {
+	limiter := &concurrencyLimiter{routes: make(map[string]*routeSemaphore, len(limits))}
+	for _, l := range limits {
+		if l.Limit > 0 {
+			limiter.routes[l.Route] = &routeSemaphore{slots: make(chan struct{}, l.Limit), maxWait: l.MaxWait}
+		}
+	}
+
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		sem, ok := limiter.routes[route]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if !sem.acquire(c) {
+			if deadlineExceeded(c) {
+				c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "Request deadline exceeded", "route": route})
+				return
+			}
+			routeRejected.Inc(route)
+			// One second is a floor: slots free up as soon as any request finishes
+			c.Header("Retry-After", strconv.Itoa(max(int(sem.maxWait.Seconds()), 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":     "Too many concurrent requests",
+				"route":     route,
+				"limit":     cap(sem.slots),
+				"in_flight": len(sem.slots),
+			})
+			return
+		}
+		routeInFlight.Add(1, route)
+		defer func() {
+			<-sem.slots
+			routeInFlight.Add(-1, route)
+		}()
+		c.Next()
+	}
+}
+
+// acquire takes a slot, waiting up to maxWait for one to free up
+func (s *routeSemaphore) acquire(c *gin.Context) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if s.maxWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(s.maxWait)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimitRejectsPastTheLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	entered, release := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(concurrencyLimit([]RouteLimit{{Route: "GET /slow", Limit: 1}}))
+	router.GET("/slow", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	done := make(chan int)
+	go func() { done <- serve("/slow").Code }()
+	<-entered
+
+	w := serve("/slow")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request = %d with Retry-After %q, want 429 with 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve("/fast"); w.Code != http.StatusOK {
+		t.Errorf("unlimited route = %d while the limited one is full", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request = %d", code)
+	}
+	go func() { <-entered }()
+	if w := serve("/slow"); w.Code != http.StatusOK {
+		t.Errorf("request after the slot was freed = %d", w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+)
+
+// deadlineBudget bounds the request by the caller's X-Request-Deadline-Ms, so
+// every Redis call made for it gives up once the budget is spent. Requests
+// without the header run unbounded, as before.
+//
+// Calls that change a token or the wait queue (generate, assign, keepalive,
+// delete, joining or leaving the queue) run on a context detached from the
+// request so a caller going away can't leave a token half-claimed. They
+// carry on past the budget too; the budget only bounds the reads around them.
+func deadlineBudget(c *gin.Context) {
+	raw := c.GetHeader(constants.HeaderRequestDeadline)
+	if raw == "" {
+		c.Next()
+		return
+	}
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ms <= 0 || time.Duration(ms)*time.Millisecond > constants.MaxRequestDeadline {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid " + constants.HeaderRequestDeadline + ", must be between 1 and " +
+				strconv.FormatInt(constants.MaxRequestDeadline.Milliseconds(), 10),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(ms)*time.Millisecond)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// deadlineExceeded reports whether the caller's deadline budget has run out
+func deadlineExceeded(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// respondFailed reports a request that failed as a 500 with message, or as a
+// 504 when the caller's deadline budget ran out first. partial, when set, is
+// added to the body so the caller can see how far the request got before it
+// stopped.
+func respondFailed(c *gin.Context, message string, partial gin.H) {
+	body := gin.H{"error": message}
+	status := http.StatusInternalServerError
+	if deadlineExceeded(c) {
+		status = http.StatusGatewayTimeout
+		body["error"] = "Request deadline exceeded"
+		if len(partial) > 0 {
+			body["partial"] = true
+		}
+	}
+	maps.Copy(body, partial)
+	c.JSON(status, body)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// importCSV queues an import of every token of a multipart CSV upload into
+// pool. The file is stored as it arrives and imported by a background job;
+// the job's status at /admin/jobs/:job shows its progress, and the rejected
+// rows are fetched from /tokens/import/reports/:id.
+func (handler *TokenHandler) importCSV(c *gin.Context, pool string) {
+	if handler.Config.Jobs == nil || !handler.Config.Jobs.Registered(constants.ImportJob) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Bulk imports are not enabled"})
+		return
+	}
+	file, err := importFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	summary := handler.Service.NewImport(pool)
+	err = handler.Service.SpoolImport(ctx, summary, file)
+	if errors.Is(err, constants.ErrInvalidImport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to store import", nil)
+		return
+	}
+	jobID, err := handler.Config.Jobs.Enqueue(ctx, constants.ImportJob, map[string]string{"import": summary.ID, "pool": pool})
+	if err != nil {
+		respondFailed(c, "Failed to enqueue import", nil)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job_id": jobID, "import": summary})
+}
+
+// importFile finds the CSV part of a multipart upload without buffering the
+// parts before it
+func importFile(c *gin.Context) (io.Reader, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", constants.ErrInvalidImport, err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no %q field", constants.ErrInvalidImport, constants.ImportFileField)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", constants.ErrInvalidImport, err)
+		}
+		if part.FormName() == constants.ImportFileField {
+			return part, nil
+		}
+	}
+}
+
+// GetImportReport returns the counts of a bulk import and the rows it
+// rejected: JSON by default, or streamed as NDJSON or CSV
+func (handler *TokenHandler) GetImportReport(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+	ctx := c.Request.Context()
+	summary, err := handler.Service.ImportSummaryOf(ctx, id)
+	if errors.Is(err, constants.ErrImportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrImportNotFound.Error()})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to fetch import report", nil)
+		return
+	}
+
+	// pages hands the report to emit a page at a time, so a large one is
+	// never read whole
+	pages := func(emit func([]repositories.ImportRowError) error, flush func()) error {
+		for offset := int64(0); ; offset += constants.ImportChunkSize {
+			rows, err := handler.Service.ImportReport(ctx, id, offset, constants.ImportChunkSize)
+			if err != nil {
+				return err
+			}
+			if err := emit(rows); err != nil {
+				return err
+			}
+			flush()
+			if len(rows) < constants.ImportChunkSize {
+				return nil
+			}
+		}
+	}
+
+	switch formatOf(c) {
+	case formatNDJSON:
+		streamNDJSON(c, func(emit func(v any) error, flush func()) error {
+			return pages(func(rows []repositories.ImportRowError) error {
+				for _, row := range rows {
+					if err := emit(row); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, flush)
+		})
+	case formatCSV:
+		streamCSV(c, []string{"line", "error"}, func(emit func(row []string) error, flush func()) error {
+			return pages(func(rows []repositories.ImportRowError) error {
+				for _, row := range rows {
+					if err := emit([]string{strconv.Itoa(row.Line), csvText(row.Error)}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, flush)
+		})
+	default:
+		errs := []repositories.ImportRowError{}
+		err := pages(func(rows []repositories.ImportRowError) error {
+			errs = append(errs, rows...)
+			return nil
+		}, func() {})
+		if err != nil {
+			respondFailed(c, "Failed to fetch import report", nil)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"import": summary, "errors": errs})
+	}
+}
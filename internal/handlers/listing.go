@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// listingFormat is how a listing endpoint renders its rows
+type listingFormat int
+
+const (
+	formatJSON   listingFormat = iota // a single JSON document, the default
+	formatNDJSON                      // one JSON object per line, streamed
+	formatCSV                         // a header row then one row per item, streamed where the listing allows
+)
+
+// formatOf picks the listing format from ?format= (json, ndjson or csv),
+// falling back to the Accept header
+func formatOf(c *gin.Context) listingFormat {
+	switch c.Query("format") {
+	case "csv":
+		return formatCSV
+	case "ndjson":
+		return formatNDJSON
+	case "json":
+		return formatJSON
+	}
+
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, constants.MIMECSV):
+		return formatCSV
+	case strings.Contains(accept, constants.MIMENDJSON):
+		return formatNDJSON
+	}
+	return formatJSON
+}
+
+// streamNDJSON writes one JSON value per line as scan produces them, flushing
+// after every batch. Writes block while the client is slow to read, which in
+// turn holds back the next SSCAN round. The status is committed with the first
+// line, so an error part way through is reported as a final {"error": ...}
+// line instead, with "partial": true when the caller's deadline budget ran out.
+func streamNDJSON(c *gin.Context, scan func(emit func(v any) error, flush func()) error) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", constants.MIMENDJSON)
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	encoder := json.NewEncoder(c.Writer)
+	flush := func() { c.Writer.Flush() }
+	if err := scan(encoder.Encode, flush); err != nil {
+		if deadlineExceeded(c) {
+			_ = encoder.Encode(gin.H{"error": "Request deadline exceeded", "partial": true})
+			flush()
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			// The client went away; there is nobody left to tell
+			return
+		}
+		slog.Error("Streamed listing failed", slog.String("path", c.FullPath()), slog.String("error", err.Error()))
+		_ = encoder.Encode(gin.H{"error": "listing interrupted"})
+	}
+	flush()
+}
+
+// streamCSV is streamNDJSON for CSV: a header row, then rows as scan produces
+// them. CSV has no room for an error record, so a listing that fails part way
+// ends with a row whose first cell is "#error", or "#partial" when the
+// caller's deadline budget ran out.
+func streamCSV(c *gin.Context, header []string, scan func(emit func(row []string) error, flush func()) error) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", constants.MIMECSV+"; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	writer := csv.NewWriter(c.Writer)
+	flush := func() {
+		writer.Flush()
+		c.Writer.Flush()
+	}
+	_ = writer.Write(header)
+	if err := scan(writer.Write, flush); err != nil {
+		if deadlineExceeded(c) {
+			_ = writer.Write([]string{"#partial", "request deadline exceeded"})
+			flush()
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		slog.Error("Streamed listing failed", slog.String("path", c.FullPath()), slog.String("error", err.Error()))
+		_ = writer.Write([]string{"#error", "listing interrupted"})
+	}
+	flush()
+}
+
+// writeCSV renders an already fetched listing as CSV
+func writeCSV(c *gin.Context, header []string, rows [][]string) {
+	streamCSV(c, header, func(emit func([]string) error, _ func()) error {
+		for _, row := range rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// expiryColumns head the CSV cells written by expiryCells
+var expiryColumns = []string{"expires_at", "remaining_seconds"}
+
+// expiryCells renders an expiry for CSV, leaving both cells empty when unknown
+func expiryCells(e repositories.Expiry) []string {
+	if e.ExpiresAt == nil {
+		return []string{"", ""}
+	}
+	return []string{e.ExpiresAt.Format(time.RFC3339), strconv.FormatInt(*e.RemainingSeconds, 10)}
+}
+
+// csvText neutralises free text that a spreadsheet would otherwise evaluate
+// as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/internal/metrics"
+)
+
+var (
+	verifyFailures = metrics.NewCounterVec(
+		"verify_failures_total",
+		"Failed receipt verifications and token lookups, which count towards a lockout.",
+		"route",
+	)
+	verifyLockouts = metrics.NewCounterVec(
+		"verify_lockouts_total",
+		"Lockouts started after too many failed verifications.",
+		"route",
+	)
+	verifyLockedOut = metrics.NewCounterVec(
+		"verify_locked_out_total",
+		"Requests rejected with 429 because the caller was locked out.",
+		"route",
+	)
+)
+
+// LockoutConfig locks a caller out of receipt verification and the routes
+// that look a token up by value after too many failures, so token values and
+// receipts can't be enumerated. Zero Failures disables it.
+type LockoutConfig struct {
+	Failures int           // failures within Window that start a lockout
+	Window   time.Duration // counted from the first failure
+	Lockout  time.Duration // how long a locked out caller is turned away
+}
+
+// lockoutSubject is who failures are counted against: the caller's IP. The
+// client ID is not used, as anybody can set X-Client-ID, so rotating it would
+// escape a lockout and sending somebody else's would lock them out.
+func lockoutSubject(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// verifyFailed reports whether a response means the caller presented an
+// invalid receipt or token: malformed, rejected or unknown
+func verifyFailed(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// guardVerify wraps a verification handler with per-IP failure tracking.
+// Locked out callers get a 429 without the handler running. A Redis error
+// fails open, since turning every caller away is worse than letting a few
+// guesses through.
+func (handler *TokenHandler) guardVerify(next gin.HandlerFunc) gin.HandlerFunc {
+	return handler.guard(next, false)
+}
+
+// guardKeepalive is guardVerify for keepalives, which doesn't count a 404 for
+// a token that was issued and has since been deleted: that is its owner
+// keeping alive a token cleanup removed, and only a caller that held the
+// value can name it, so it is no guess
+func (handler *TokenHandler) guardKeepalive(next gin.HandlerFunc) gin.HandlerFunc {
+	return handler.guard(next, true)
+}
+
+func (handler *TokenHandler) guard(next gin.HandlerFunc, forgiveIssued bool) gin.HandlerFunc {
+	config := handler.Config.Lockout
+	if config.Failures <= 0 {
+		return next
+	}
+
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		subject := lockoutSubject(c)
+
+		ctx := c.Request.Context()
+		remaining, err := handler.Service.VerifyLockout(ctx, subject)
+		if err != nil {
+			slog.Warn("Failed to check verification lockout", slog.String("route", route), slog.Any("error", err))
+		}
+		if remaining > 0 {
+			verifyLockedOut.Inc(route)
+			c.Header("Retry-After", strconv.Itoa(max(int(remaining.Seconds()), 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts"})
+			return
+		}
+
+		next(c)
+		if !verifyFailed(c.Writer.Status()) || forgiveIssued && handler.wasIssued(c) {
+			return
+		}
+
+		verifyFailures.Inc(route)
+		locked, err := handler.Service.RecordVerifyFailure(ctx, subject, config.Failures, config.Window, config.Lockout)
+		if err != nil {
+			slog.Warn("Failed to record verification failure", slog.String("route", route), slog.Any("error", err))
+			return
+		}
+		if locked {
+			verifyLockouts.Inc(route)
+			slog.Warn("Caller locked out of verification", slog.String("route", route),
+				slog.String("ip", c.ClientIP()), slog.Duration("lockout", config.Lockout))
+		}
+	}
+}
+
+// wasIssued reports whether a 404 was for a token, named in the path, that
+// was issued and has since been deleted
+func (handler *TokenHandler) wasIssued(c *gin.Context) bool {
+	token := c.Param("token")
+	if c.Writer.Status() != http.StatusNotFound || token == "" {
+		return false
+	}
+	issued, err := handler.Service.TokenWasIssued(c.Request.Context(), token)
+	if err != nil {
+		slog.Warn("Failed to look up a missing token", slog.Any("error", err))
+		return false
+	}
+	return issued
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockoutRouter serves /check/:token through guardVerify and
+// /keepalive/:token through guardKeepalive, each answering with *status
+func lockoutRouter(t *testing.T, config LockoutConfig, status *int) (*gin.Engine, *repositories.TokenRepository, *miniredis.Miniredis) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo := repositories.NewTokenRepository(client, repositories.Config{})
+	handler := NewTokenHandler(services.NewTokenService(repo, services.Config{}), HandlerConfig{Lockout: config})
+
+	respond := func(c *gin.Context) { c.JSON(*status, gin.H{}) }
+	router := gin.New()
+	router.POST("/check/:token", handler.guardVerify(respond))
+	router.POST("/keepalive/:token", handler.guardKeepalive(respond))
+	return router, repo, mr
+}
+
+func serveLockout(router *gin.Engine, path, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGuardVerifyLocksOutAfterRepeatedFailures(t *testing.T) {
+	status := http.StatusNotFound
+	router, _, mr := lockoutRouter(t, LockoutConfig{Failures: 3, Window: time.Minute, Lockout: 30 * time.Second}, &status)
+
+	for i := 0; i < 3; i++ {
+		if w := serveLockout(router, "/check/guess", "198.51.100.1:5000"); w.Code != http.StatusNotFound {
+			t.Fatalf("failure %d = %d, want the handler's 404", i+1, w.Code)
+		}
+	}
+
+	// The handler no longer runs, even for a good token
+	status = http.StatusOK
+	w := serveLockout(router, "/check/real", "198.51.100.1:5000")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("locked out caller = %d with Retry-After %q, want 429 with 30", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Failures count per IP, so another caller is unaffected
+	if w := serveLockout(router, "/check/real", "198.51.100.2:5000"); w.Code != http.StatusOK {
+		t.Errorf("other caller = %d, want 200", w.Code)
+	}
+
+	mr.FastForward(31 * time.Second)
+	if w := serveLockout(router, "/check/real", "198.51.100.1:5000"); w.Code != http.StatusOK {
+		t.Errorf("after the lockout = %d, want 200", w.Code)
+	}
+}
+
+func TestGuardVerifyCountsOnlyFailures(t *testing.T) {
+	status := http.StatusOK
+	router, _, _ := lockoutRouter(t, LockoutConfig{Failures: 2, Window: time.Minute, Lockout: time.Minute}, &status)
+
+	// Successes and other errors, e.g. a conflict, don't count
+	for _, code := range []int{http.StatusOK, http.StatusConflict, http.StatusServiceUnavailable, http.StatusOK} {
+		status = code
+		serveLockout(router, "/check/tok", "198.51.100.1:5000")
+	}
+	status = http.StatusOK
+	if w := serveLockout(router, "/check/tok", "198.51.100.1:5000"); w.Code != http.StatusOK {
+		t.Errorf("after non-failures = %d, want 200", w.Code)
+	}
+
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized} {
+		status = code
+		serveLockout(router, "/check/tok", "198.51.100.1:5000")
+	}
+	status = http.StatusOK
+	if w := serveLockout(router, "/check/tok", "198.51.100.1:5000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("after a 400 and a 401 = %d, want 429", w.Code)
+	}
+}
+
+func TestGuardKeepaliveForgivesIssuedTokens(t *testing.T) {
+	status := http.StatusNotFound
+	router, repo, _ := lockoutRouter(t, LockoutConfig{Failures: 2, Window: time.Minute, Lockout: time.Minute}, &status)
+	if err := repo.SaveToken(context.Background(), constants.DefaultPool, "issued", nil, time.Time{}); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+
+	// A 404 for a token that was issued is its holder, not a guess
+	for i := 0; i < 3; i++ {
+		if w := serveLockout(router, "/keepalive/issued", "198.51.100.1:5000"); w.Code != http.StatusNotFound {
+			t.Fatalf("keepalive %d of an issued token = %d, want 404", i+1, w.Code)
+		}
+	}
+
+	// guardVerify doesn't forgive it
+	serveLockout(router, "/check/issued", "198.51.100.2:5000")
+	serveLockout(router, "/check/issued", "198.51.100.2:5000")
+	if w := serveLockout(router, "/check/issued", "198.51.100.2:5000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("guardVerify after two 404s = %d, want 429", w.Code)
+	}
+
+	// A token never issued still counts on keepalive
+	serveLockout(router, "/keepalive/guess", "198.51.100.3:5000")
+	serveLockout(router, "/keepalive/guess", "198.51.100.3:5000")
+	if w := serveLockout(router, "/keepalive/guess", "198.51.100.3:5000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("keepalive after two guesses = %d, want 429", w.Code)
+	}
+}
+
+func TestGuardVerifyFailsOpenWithoutRedis(t *testing.T) {
+	status := http.StatusNotFound
+	router, _, mr := lockoutRouter(t, LockoutConfig{Failures: 1, Window: time.Minute, Lockout: time.Minute}, &status)
+	mr.Close()
+
+	for i := 0; i < 2; i++ {
+		if w := serveLockout(router, "/check/guess", "198.51.100.1:5000"); w.Code != http.StatusNotFound {
+			t.Errorf("request %d with Redis down = %d, want the handler's 404", i+1, w.Code)
+		}
+	}
+}
+
+func TestGuardDisabledWithoutFailures(t *testing.T) {
+	status := http.StatusNotFound
+	router, _, _ := lockoutRouter(t, LockoutConfig{}, &status)
+
+	for i := 0; i < 10; i++ {
+		if w := serveLockout(router, "/check/guess", "198.51.100.1:5000"); w.Code != http.StatusNotFound {
+			t.Fatalf("request %d = %d, want 404", i+1, w.Code)
+		}
+	}
+}
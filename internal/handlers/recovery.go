@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/tracing"
+)
+
+var panicsRecovered = metrics.NewCounterVec(
+	"http_panics_total",
+	"Handler panics recovered, by route.",
+	"route",
+)
+
+// recovery replaces gin's Recovery: a panicking handler is logged with its
+// stack and request ID, counted, and answered with the usual error body
+// instead of gin's bare 500
+func recovery(c *gin.Context) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		panicsRecovered.Inc(route)
+
+		// A client that hung up mid-response isn't a bug, and can't be answered
+		if brokenPipe(recovered) {
+			slog.WarnContext(c.Request.Context(), "Client connection closed mid-response",
+				slog.String("route", route),
+				slog.String("request_id", tracing.FromContext(c.Request.Context()).RequestID))
+			c.Abort()
+			return
+		}
+
+		slog.ErrorContext(c.Request.Context(), "Recovered from handler panic",
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("request_id", tracing.FromContext(c.Request.Context()).RequestID),
+			slog.Any("panic", recovered),
+			slog.String("stack", string(debug.Stack())))
+		if c.Writer.Written() {
+			c.Abort()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}()
+	c.Next()
+}
+
+// brokenPipe reports whether a panic came from writing to a closed connection
+func brokenPipe(recovered any) bool {
+	err, ok := recovered.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var sysErr *os.SyscallError
+	return errors.As(opErr, &sysErr) && (errors.Is(sysErr.Err, syscall.EPIPE) || errors.Is(sysErr.Err, syscall.ECONNRESET))
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/internal/tracing"
+)
+
+// requestIDPattern bounds caller supplied request IDs to something safe to log and echo
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDs puts the caller's request ID, or a new one, and the W3C trace ID
+// on the request context so audit entries and callbacks can carry them. The
+// request ID is echoed in the response.
+func requestIDs(c *gin.Context) {
+	requestID := c.GetHeader(tracing.HeaderRequestID)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = uuid.New().String()
+	}
+	ids := tracing.IDs{RequestID: requestID, TraceID: tracing.TraceID(c.GetHeader(tracing.HeaderTraceparent))}
+	c.Request = c.Request.WithContext(tracing.WithIDs(c.Request.Context(), ids))
+	c.Header(tracing.HeaderRequestID, requestID)
+	c.Next()
+}
@@ -1,26 +1,296 @@
 package handlers
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/logging"
 )
 
-func SetupRoutes(tc *TokenHandler) *gin.Engine {
-	router := gin.Default()
+// RouteConfig selects the optional route groups to expose and how the client
+// IP is resolved behind proxies
+type RouteConfig struct {
+	Metrics bool
+	Admin   bool
+
+	Profiles       []string // route profiles to register, see ProfilePublic; empty registers all
+	DisabledRoutes []string // "METHOD /path" routes left out of the enabled profiles
+
+	// SeparateAdmin moves /metrics and /admin off the public router onto an
+	// internal one, so the public ingress never exposes them
+	SeparateAdmin bool
+
+	TrustedProxies  []string // proxies whose forwarding headers are believed; nil trusts none
+	RemoteIPHeaders []string // overrides gin's X-Forwarded-For, X-Real-IP default
+
+	ConcurrencyLimits []RouteLimit // per-route in-flight caps, on both routers
+	SLO               *SLOTracker  // per-route latency budgets; nil disables tracking and /admin/slo
+
+	Mode string // gin mode: debug, release or test
+
+	Actors         ActorConfig // who admin requests are made by
+	AdminAllowlist []string    // CIDRs or IPs allowed to reach /admin and /metrics; empty allows any
+
+	Obfuscate logging.Obfuscator // hides :token path segments in the request log; nil logs paths as they are
+
+	Compression CompressionConfig // gzip/deflate response bodies, on both routers
+}
+
+// SetupRoutes builds the public router and, with SeparateAdmin, the internal
+// admin router; otherwise the returned admin router is nil
+func SetupRoutes(tc *TokenHandler, ac *AdminHandler, config RouteConfig) (*gin.Engine, *gin.Engine, error) {
+	switch config.Mode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		gin.SetMode(config.Mode)
+	default:
+		return nil, nil, fmt.Errorf("invalid gin mode %q", config.Mode)
+	}
+
+	router, err := newEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// CORS Middleware
 	router.Use(cors.Default())
 
+	routes, err := newRouteSet(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowlist, err := ipAllowlist(config.AdminAllowlist)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	tokenGroup := router.Group("tokens")
 
-	tokenGroup.POST("/generate", tc.GenerateToken)
-	tokenGroup.POST("/assign", tc.AssignToken)
-	tokenGroup.POST("/keepalive/:token", tc.KeepAlive)
-	tokenGroup.POST("/unblock/:token", tc.UnblockToken)
-	tokenGroup.DELETE("/:token", tc.DeleteToken)
+	routes.add(tokenGroup, ProfileInternal, http.MethodPost, "/generate", tc.GenerateToken)
+	routes.add(tokenGroup, ProfileInternal, http.MethodPost, "/import", tc.ImportToken)
+	routes.add(tokenGroup, ProfileInternal, http.MethodGet, "/import/reports/:id", tc.GetImportReport)
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/assign", tc.AssignToken)
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/assign/batch", tc.AssignTokens)
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/swap", tc.SwapToken)
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/assign/:token", tc.guardVerify(tc.AssignSpecificToken))
+	routes.add(tokenGroup, ProfilePublic, http.MethodGet, "/queue/:ticket", tc.WaitForToken)
+	routes.add(tokenGroup, ProfilePublic, http.MethodDelete, "/queue/:ticket", tc.LeaveQueue)
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/receipts/verify", tc.guardVerify(tc.VerifyReceipt))
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/keepalive/:token", tc.guardKeepalive(tc.KeepAlive))
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/unblock/:token", tc.guardVerify(tc.UnblockToken))
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/usage/:token", tc.guardVerify(tc.ReportUsage))
+	routes.add(tokenGroup, ProfilePublic, http.MethodPost, "/:token/transfer", tc.guardVerify(tc.TransferToken))
+	routes.add(tokenGroup, ProfileInternal, http.MethodPost, "/:token/alias", tc.SetAlias)
+	routes.add(tokenGroup, ProfileInternal, http.MethodDelete, "/:token/alias", tc.RemoveAlias)
+	routes.add(tokenGroup, ProfilePublic, http.MethodGet, "/:token", tc.guardVerify(tc.GetTokenStatus))
+	routes.add(tokenGroup, ProfileInternal, http.MethodDelete, "/:token", tc.DeleteToken)
+
+	routes.add(tokenGroup, ProfileInternal, http.MethodGet, "/available", tc.GetAvailableTokens)
+	routes.add(tokenGroup, ProfileInternal, http.MethodGet, "/assigned", tc.GetAssignedTokens)
+
+	clientGroup := router.Group("clients")
+
+	routes.add(clientGroup, ProfilePublic, http.MethodGet, "/:id/tokens", tc.GetClientTokens)
+	routes.add(clientGroup, ProfilePublic, http.MethodPost, "/:id/release-all", tc.ReleaseClientTokens)
+
+	routes.add(&router.RouterGroup, ProfilePublic, http.MethodGet, "/pools", tc.ListPools)
+
+	if !config.SeparateAdmin {
+		setupAdminRoutes(router, ac, config, routes, allowlist)
+		return router, nil, routes.check()
+	}
+
+	adminRouter, err := newEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	setupAdminRoutes(adminRouter, ac, config, routes, allowlist)
+	return router, adminRouter, routes.check()
+}
+
+// Route profiles group the routes a deployment can choose to register.
+// Public is the API token holders call, internal the one provisioning
+// services and operators call to create, delete and list tokens, and admin
+// the /admin routes.
+const (
+	ProfilePublic   = "public"
+	ProfileInternal = "internal"
+	ProfileAdmin    = "admin"
+)
+
+// routeSet registers the routes of the enabled profiles, leaving out the ones
+// disabled by name
+type routeSet struct {
+	profiles map[string]bool
+	disabled map[string]bool
+	known    map[string]bool // every route offered, registered or not, to catch typos in disabled
+}
+
+func newRouteSet(config RouteConfig) (*routeSet, error) {
+	routes := &routeSet{
+		profiles: make(map[string]bool),
+		disabled: make(map[string]bool),
+		known:    make(map[string]bool),
+	}
+	profiles := config.Profiles
+	if len(profiles) == 0 {
+		profiles = []string{ProfilePublic, ProfileInternal, ProfileAdmin}
+	}
+	for _, profile := range profiles {
+		switch profile {
+		case ProfilePublic, ProfileInternal, ProfileAdmin:
+			routes.profiles[profile] = true
+		default:
+			return nil, fmt.Errorf("unknown route profile %q", profile)
+		}
+	}
+	// Features.Admin predates profiles and still switches /admin off on its own
+	if !config.Admin {
+		delete(routes.profiles, ProfileAdmin)
+	}
+	for _, route := range config.DisabledRoutes {
+		routes.disabled[route] = true
+	}
+	return routes, nil
+}
+
+// add registers handler on group unless its profile is off or the route,
+// named "METHOD /path" as in the other route settings, is disabled
+func (rs *routeSet) add(group *gin.RouterGroup, profile, method, path string, handler gin.HandlerFunc) {
+	route := method + " " + strings.TrimSuffix(group.BasePath(), "/") + path
+	rs.known[route] = true
+	if !rs.profiles[profile] || rs.disabled[route] {
+		return
+	}
+	group.Handle(method, path, handler)
+}
+
+// check rejects disabled routes that don't exist, which would otherwise leave
+// a route exposed that was meant to be hidden
+func (rs *routeSet) check() error {
+	for route := range rs.disabled {
+		if !rs.known[route] {
+			return fmt.Errorf("unknown route %q in disabled routes", route)
+		}
+	}
+	return nil
+}
+
+// newEngine creates a gin engine that resolves client IPs through the trusted proxies
+func newEngine(config RouteConfig) (*gin.Engine, error) {
+	router := gin.New()
+	router.Use(requestLogger(config.Obfuscate), requestIDs)
+	// Ahead of recovery, so the 500 a recovered panic writes is sent too
+	if config.Compression.Enabled {
+		router.Use(compress(config.Compression))
+	}
+	router.Use(recovery, deadlineBudget)
+
+	// c.ClientIP() reports the real caller for logs and audit only when the
+	// request came through a trusted proxy; otherwise it is the peer address
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if len(config.RemoteIPHeaders) > 0 {
+		router.RemoteIPHeaders = config.RemoteIPHeaders
+	}
+	// Timed outside the limiter so 429s and time spent waiting for a slot count too
+	if config.SLO != nil {
+		router.Use(config.SLO.Middleware())
+	}
+	if len(config.ConcurrencyLimits) > 0 {
+		router.Use(concurrencyLimit(config.ConcurrencyLimits))
+	}
+	router.Use(labelPool)
+	return router, nil
+}
+
+// loggedPathKey holds the request path as the request log shows it
+const loggedPathKey = "logged_path"
+
+// requestLogger is gin's request log, with the :token segment of the path
+// obfuscated when o is set
+func requestLogger(o logging.Obfuscator) gin.HandlerFunc {
+	if o == nil {
+		return gin.Logger()
+	}
+	logger := gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		if path, ok := p.Keys[loggedPathKey].(string); ok {
+			p.Path = path
+		}
+		if p.Latency > time.Minute {
+			p.Latency = p.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			p.TimeStamp.Format("2006/01/02 - 15:04:05"), p.StatusCode, p.Latency, p.ClientIP, p.Method, p.Path, p.ErrorMessage)
+	})
+	return func(c *gin.Context) {
+		// Params are set before the first handler runs, so the path can be
+		// rewritten here and picked up by the formatter once the request is done
+		if token := c.Param("token"); token != "" {
+			c.Set(loggedPathKey, strings.Replace(c.Request.URL.Path, "/"+token, "/"+o(token), 1))
+		}
+		logger(c)
+	}
+}
+
+// labelPool tags the request context with the pool named by ?pool= or :pool,
+// so the Redis commands it leads to are labelled by pool in the metrics.
+// Requests naming a token are labelled once the token's pool is looked up.
+func labelPool(c *gin.Context) {
+	pool := c.Param("pool")
+	if pool == "" {
+		pool = c.Query("pool")
+	}
+	if poolNamePattern.MatchString(pool) {
+		c.Request = c.Request.WithContext(datasources.WithPool(c.Request.Context(), pool))
+	}
+	c.Next()
+}
+
+// setupAdminRoutes adds the operator facing /metrics and /admin routes enabled
+// in config, behind allowlist
+func setupAdminRoutes(router *gin.Engine, ac *AdminHandler, config RouteConfig, routes *routeSet, allowlist gin.HandlerFunc) {
+	if config.Metrics {
+		router.GET("/metrics", allowlist, gin.WrapH(metrics.Handler()))
+	}
+
+	adminGroup := router.Group("admin", allowlist, identifyActor(config.Actors))
 
-	tokenGroup.GET("/available", tc.GetAvailableTokens)
-	tokenGroup.GET("/assigned", tc.GetAssignedTokens)
+	// gin needs both routes to share the wildcard name: a job name for run, an ID for status
 
-	return router
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/jobs/:job/run", ac.RunJob)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/jobs/:job", ac.GetJob)
+	routes.add(adminGroup, ProfileAdmin, http.MethodDelete, "/jobs/:job", ac.CancelJob)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/secrets/:handle", ac.GetSecret)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/config", ac.GetConfig)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/tokens/:token/release", ac.ForceRelease)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/tokens/:token/keepalive", ac.ForceKeepAlive)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/quarantine", ac.GetQuarantine)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/quarantine/purge", ac.PurgeQuarantine)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/quarantine/:token/approve", ac.ApproveQuarantined)
+	routes.add(adminGroup, ProfileAdmin, http.MethodDelete, "/quarantine/:token", ac.PurgeQuarantined)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/cleanup", ac.RunCleanup)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/cleanup/pause", ac.PauseCleanup)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/cleanup/resume", ac.ResumeCleanup)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/cleanup/status", ac.GetCleanupStatus)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/memory", ac.GetMemory)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/audit", ac.GetAudit)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/webhooks/deliveries", ac.GetDeliveries)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/webhooks", ac.ListWebhooks)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/webhooks", ac.CreateWebhook)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/webhooks/:id", ac.GetWebhook)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPatch, "/webhooks/:id", ac.UpdateWebhook)
+	routes.add(adminGroup, ProfileAdmin, http.MethodDelete, "/webhooks/:id", ac.DeleteWebhook)
+	routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/pools/:pool/policy", ac.GetPoolPolicy)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPatch, "/pools/:pool/policy", ac.PatchPoolPolicy)
+	routes.add(adminGroup, ProfileAdmin, http.MethodPost, "/pools/:pool/simulate", ac.SimulatePoolPolicy)
+	if config.SLO != nil {
+		routes.add(adminGroup, ProfileAdmin, http.MethodGet, "/slo", config.SLO.GetSummary)
+	}
 }
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/internal/metrics"
+)
+
+// sloExemplars is how many recent violations are kept per route
+const sloExemplars = 10
+
+var (
+	sloRequests = metrics.NewCounterVec(
+		"http_slo_requests_total",
+		"Requests on routes with a latency budget.",
+		"route",
+	)
+	sloViolations = metrics.NewCounterVec(
+		"http_slo_violations_total",
+		"Requests that exceeded their route's latency budget.",
+		"route",
+	)
+)
+
+// SLOBudget is the latency a route should stay within, keyed as "METHOD /path"
+type SLOBudget struct {
+	Route  string
+	Budget time.Duration
+}
+
+// SLOTracker counts requests against per-route latency budgets and keeps the
+// most recent violations as exemplars for GET /admin/slo
+type SLOTracker struct {
+	budgets map[string]time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*sloRoute
+}
+
+type sloRoute struct {
+	requests   int64
+	violations int64
+	exemplars  []SLOExemplar // ring of the latest violations, oldest overwritten first
+	next       int
+}
+
+// SLOExemplar is one request that blew its route's budget
+type SLOExemplar struct {
+	At        time.Time `json:"at"`
+	LatencyMs float64   `json:"latency_ms"`
+	Status    int       `json:"status"`
+	Client    string    `json:"client"`
+}
+
+// SLOSummary reports a route's standing against its budget
+type SLOSummary struct {
+	Route          string        `json:"route"`
+	BudgetMs       float64       `json:"budget_ms"`
+	Requests       int64         `json:"requests"`
+	Violations     int64         `json:"violations"`
+	ViolationRatio float64       `json:"violation_ratio"`
+	Exemplars      []SLOExemplar `json:"exemplars"`
+}
+
+func NewSLOTracker(budgets []SLOBudget) *SLOTracker {
+	t := &SLOTracker{budgets: make(map[string]time.Duration), routes: make(map[string]*sloRoute)}
+	for _, b := range budgets {
+		if b.Budget > 0 {
+			t.budgets[b.Route] = b.Budget
+			t.routes[b.Route] = &sloRoute{}
+		}
+	}
+	return t
+}
+
+// Middleware times every request on a budgeted route
+func (t *SLOTracker) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		budget, ok := t.budgets[route]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		sloRequests.Inc(route)
+		violated := latency > budget
+		if violated {
+			sloViolations.Inc(route)
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		r := t.routes[route]
+		r.requests++
+		if !violated {
+			return
+		}
+		r.violations++
+		exemplar := SLOExemplar{
+			At:        start,
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+			Status:    c.Writer.Status(),
+			Client:    clientID(c),
+		}
+		if len(r.exemplars) < sloExemplars {
+			r.exemplars = append(r.exemplars, exemplar)
+		} else {
+			r.exemplars[r.next] = exemplar
+		}
+		r.next = (r.next + 1) % sloExemplars
+	}
+}
+
+// Summary returns every budgeted route's counts since startup, sorted by route
+func (t *SLOTracker) Summary() []SLOSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summaries := make([]SLOSummary, 0, len(t.routes))
+	for route, r := range t.routes {
+		s := SLOSummary{
+			Route:      route,
+			BudgetMs:   float64(t.budgets[route].Microseconds()) / 1000,
+			Requests:   r.requests,
+			Violations: r.violations,
+			Exemplars:  append([]SLOExemplar{}, r.exemplars...),
+		}
+		if r.requests > 0 {
+			s.ViolationRatio = float64(r.violations) / float64(r.requests)
+		}
+		sort.Slice(s.Exemplars, func(i, j int) bool { return s.Exemplars[i].At.After(s.Exemplars[j].At) })
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Route < summaries[j].Route })
+	return summaries
+}
+
+// GetSummary serves Summary on GET /admin/slo
+func (t *SLOTracker) GetSummary(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"routes": t.Summary()})
+}
@@ -2,47 +2,539 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/repositories"
 	"github.com/manankarani/token-manager/internal/services"
+	"github.com/manankarani/token-manager/logging"
+	"github.com/manankarani/token-manager/receipts"
 )
 
 type TokenHandler struct {
 	Service *services.TokenService
+	Config  HandlerConfig
 }
 
-func NewTokenHandler(service *services.TokenService) *TokenHandler {
-	return &TokenHandler{Service: service}
+// HandlerConfig holds response tuning for the token endpoints
+type HandlerConfig struct {
+	EmptyPoolStatus int              // status returned by assign when the pool is empty
+	LongPollTimeout time.Duration    // how long a queued waiter is held before re-polling
+	Receipts        *receipts.Signer // signs checkout receipts; nil disables them
+	ReceiptTTL      time.Duration    // receipt lifetime, matching the assignment TTL
+
+	// Obfuscate hides token values in the available, assigned and client
+	// listings; nil shows them.
+	Obfuscate logging.Obfuscator
+
+	Lockout LockoutConfig // failure tracking on receipt verification and token lookups
+
+	Jobs *jobs.Queue // runs CSV imports; nil turns multipart imports away
+}
+
+func NewTokenHandler(service *services.TokenService, config HandlerConfig) *TokenHandler {
+	if config.EmptyPoolStatus == 0 {
+		config.EmptyPoolStatus = http.StatusServiceUnavailable
+	}
+	if config.LongPollTimeout <= 0 {
+		config.LongPollTimeout = constants.DefaultQueueLongPollLimit
+	}
+	if config.ReceiptTTL <= 0 {
+		config.ReceiptTTL = constants.TokenAutoReleaseTime * time.Second
+	}
+	if config.Lockout.Window <= 0 {
+		config.Lockout.Window = constants.DefaultLockoutWindow
+	}
+	if config.Lockout.Lockout <= 0 {
+		config.Lockout.Lockout = constants.DefaultLockoutDuration
+	}
+	return &TokenHandler{Service: service, Config: config}
 }
 
 type TokenRequest struct {
-	Token string `uri:"token" binding:"required,uuid"`
+	Token string `uri:"token" binding:"required,printascii,max=512"` // generated UUID, imported token or hash-only handle
+}
+
+type TicketRequest struct {
+	Ticket string `uri:"ticket" binding:"required,uuid"`
+}
+
+// poolNamePattern keeps pool names safe to embed in Redis keys
+var poolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// bindPool reads the optional ?pool= query parameter, writing a 400 if it is invalid
+func bindPool(c *gin.Context) (string, bool) {
+	pool := c.DefaultQuery("pool", constants.DefaultPool)
+	if !poolNamePattern.MatchString(pool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool"})
+		return "", false
+	}
+	return pool, true
+}
+
+// bindLabels reads a label list from the named query parameter, writing a 400 if it is invalid
+func bindLabels(c *gin.Context, param string) (map[string]string, bool) {
+	labels, err := services.ParseLabels(c.Query(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return labels, true
+}
+
+// bindRateLimit reads the optional ?rate_limit= requests-per-minute hint, writing a 400 if it is invalid
+func bindRateLimit(c *gin.Context) (int, bool) {
+	raw := c.Query("rate_limit")
+	if raw == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate_limit"})
+		return 0, false
+	}
+	return limit, true
+}
+
+// bindActivateAt reads ?activate_at= as an RFC3339 time; zero when absent
+func bindActivateAt(c *gin.Context) (time.Time, bool) {
+	raw := c.Query("activate_at")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid activate_at, must be RFC3339"})
+		return time.Time{}, false
+	}
+	return at, true
 }
 
 func (handler *TokenHandler) GenerateToken(c *gin.Context) {
-	token, err := handler.Service.GenerateToken(context.Background())
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+	labels, ok := bindLabels(c, "labels")
+	if !ok {
+		return
+	}
+	rateLimit, ok := bindRateLimit(c)
+	if !ok {
+		return
+	}
+	activateAt, ok := bindActivateAt(c)
+	if !ok {
+		return
+	}
+
+	token, err := handler.Service.GenerateToken(context.WithoutCancel(c.Request.Context()), pool, labels, activateAt)
+	if errors.Is(err, constants.ErrPoolFull) {
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrPoolFull.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrTokenExists) {
+		// Every attempt collided; the pool's generator has too few values left
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to generate a token not issued before"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		respondFailed(c, "Failed to generate token", nil)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	if rateLimit > 0 {
+		if err := handler.Service.SetRateLimit(c.Request.Context(), token.Value, rateLimit); err != nil {
+			respondFailed(c, "Failed to save rate limit", nil)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+type ImportTokenRequest struct {
+	Token     string `json:"token" binding:"required,printascii,max=512"`
+	Labels    string `json:"labels"`                              // same "key=value,..." form as ?labels= on generate
+	RateLimit int    `json:"rate_limit" binding:"omitempty,gt=0"` // upstream requests per minute
+
+	ActivateAt time.Time `json:"activate_at"` // hold the token inactive until then; unset makes it available now
+}
+
+// ImportToken adds an externally issued token to a pool, or with a
+// multipart upload every token of a CSV file, see importCSV
+func (handler *TokenHandler) ImportToken(c *gin.Context) {
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() == binding.MIMEMultipartPOSTForm {
+		handler.importCSV(c, pool)
+		return
+	}
+
+	var req ImportTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	labels, err := services.ParseLabels(req.Labels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := handler.Service.ImportToken(c.Request.Context(), pool, req.Token, labels, req.ActivateAt)
+	if errors.Is(err, constants.ErrTokenPrefixMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constants.ErrTokenPrefixMismatch.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrPoolFull) {
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrPoolFull.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrTokenExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenExists.Error()})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to import token", nil)
+		return
+	}
+	if req.RateLimit > 0 {
+		if err := handler.Service.SetRateLimit(c.Request.Context(), token.Value, req.RateLimit); err != nil {
+			respondFailed(c, "Failed to save rate limit", nil)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, token)
 }
 
 func (handler *TokenHandler) AssignToken(c *gin.Context) {
-	token, err := handler.Service.AssignToken(context.Background())
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+
+	selector, ok := bindLabels(c, "selector")
+	if !ok {
+		return
+	}
+	callback := c.Query("callback")
+	if callback != "" {
+		if err := handler.Service.ValidateCallback(callback); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	token, err := handler.Service.AssignToken(context.WithoutCancel(c.Request.Context()), pool, clientID(c), selector)
+	if err != nil {
+
+		capped := errors.Is(err, constants.ErrAssignmentCapReached)
+		if capped || errors.Is(err, constants.ErrNoAvailableTokens) {
+			// Queued waiters are served any token, so selector requests can't wait
+			if c.Query("wait") == "true" && handler.Service.QueueEnabled() && len(selector) == 0 {
+				handler.enqueue(c, pool)
+				return
+			}
+			handler.setRetryAfter(c, pool)
+			if capped {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": constants.ErrAssignmentCapReached.Error()})
+				return
+			}
+			c.JSON(handler.Config.EmptyPoolStatus, gin.H{"error": constants.ErrNoAvailableTokens.Error()})
+			return
+		}
+
+		respondFailed(c, "Failed to assign token", nil)
+		return
+	}
+	if callback != "" {
+		if err := handler.Service.SetCallback(context.WithoutCancel(c.Request.Context()), token.Pool, token.Value, callback, clientID(c)); err != nil {
+			respondFailed(c, "Failed to register callback", nil)
+			return
+		}
+	}
+	handler.respondAssigned(c, token)
+}
+
+// AssignTokens claims up to ?count= tokens at once for callers that need
+// several, and reports how many short of the count it fell. An empty pool
+// answers as AssignToken does; a partial batch is still a 200.
+func (handler *TokenHandler) AssignTokens(c *gin.Context) {
+	pool, ok := bindPool(c)
+	if !ok {
+		return
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count <= 0 || count > constants.MaxBatchAssignCount {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid count, must be between 1 and %d", constants.MaxBatchAssignCount)})
+		return
+	}
+	selector, ok := bindLabels(c, "selector")
+	if !ok {
+		return
+	}
+
+	tokens, err := handler.Service.AssignTokens(context.WithoutCancel(c.Request.Context()), pool, clientID(c), count, selector)
+	if errors.Is(err, constants.ErrAssignmentCapReached) {
+		handler.setRetryAfter(c, pool)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": constants.ErrAssignmentCapReached.Error(), "shortage": count})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to assign tokens", nil)
+		return
+	}
+
+	shortage := count - len(tokens)
+	if shortage > 0 {
+		handler.setRetryAfter(c, pool)
+	}
+	if len(tokens) == 0 {
+		c.JSON(handler.Config.EmptyPoolStatus, gin.H{"error": constants.ErrNoAvailableTokens.Error(), "shortage": shortage})
+		return
+	}
+	resp, ok := handler.assignedResponses(c, tokens)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": resp, "assigned": len(resp), "shortage": shortage})
+}
+
+// AssignSpecificToken claims a token the caller already knows it needs
+func (handler *TokenHandler) AssignSpecificToken(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	token, err := handler.Service.AssignSpecificToken(c.Request.Context(), req.Token, clientID(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, constants.ErrInvalidTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, constants.ErrTokenAlreadyInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenAlreadyInUse.Error()})
+		case errors.Is(err, constants.ErrTokenNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		case errors.Is(err, constants.ErrAssignmentCapReached):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": constants.ErrAssignmentCapReached.Error()})
+		default:
+			respondFailed(c, "Failed to assign token", nil)
+		}
+		return
+	}
+	handler.respondAssigned(c, token)
+}
+
+// AssignedResponse is an assigned token with what its holder needs to use it
+type AssignedResponse struct {
+	*repositories.Token
+	RateLimit int    `json:"rate_limit,omitempty"` // upstream requests per minute, when a hint was set
+	Receipt   string `json:"receipt,omitempty"`    // signed checkout receipt, when receipts are enabled
+}
+
+// respondAssigned writes the assignment, with the token's rate-limit hint and a
+// signed receipt when receipts are enabled
+func (handler *TokenHandler) respondAssigned(c *gin.Context, token *repositories.Token) {
+	resp, ok := handler.assignedResponses(c, []*repositories.Token{token})
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, resp[0])
+}
+
+// assignedResponses adds the rate-limit hints and receipts to assigned tokens,
+// writing an error and returning false if it can't. The tokens are already
+// claimed, so the lookup isn't cut short by the caller's deadline budget.
+func (handler *TokenHandler) assignedResponses(c *gin.Context, tokens []*repositories.Token) ([]AssignedResponse, bool) {
+	values := make([]string, len(tokens))
+	for i, token := range tokens {
+		values[i] = token.Value
+	}
+	rateLimits, err := handler.Service.RateLimitsOf(context.WithoutCancel(c.Request.Context()), values)
+	if err != nil {
+		respondFailed(c, "Failed to fetch rate limit", nil)
+		return nil, false
+	}
+
+	resp := make([]AssignedResponse, len(tokens))
+	for i, token := range tokens {
+		resp[i] = AssignedResponse{Token: token, RateLimit: rateLimits[i]}
+		if handler.Config.Receipts == nil {
+			continue
+		}
+		resp[i].Receipt, err = handler.Config.Receipts.Issue(token.Value, token.Pool, clientID(c), handler.Config.ReceiptTTL)
+		if err != nil {
+			respondFailed(c, "Failed to sign receipt", nil)
+			return nil, false
+		}
+	}
+	return resp, true
+}
+
+type ReportUsageRequest struct {
+	Used int `json:"used" binding:"required,gt=0"` // upstream requests made with the token
+}
+
+// ReportUsage records quota consumed with a token so assignment can favour idle tokens
+func (handler *TokenHandler) ReportUsage(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+	var req ReportUsageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	utilisation, err := handler.Service.ReportUsage(c.Request.Context(), uri.Token, req.Used)
+	if err != nil {
+		if errors.Is(err, constants.ErrTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+			return
+		}
+		respondFailed(c, "Failed to record usage", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": uri.Token, "utilisation": utilisation})
+}
+
+type VerifyReceiptRequest struct {
+	Receipt string `json:"receipt" binding:"required"`
+}
+
+// VerifyReceipt checks a checkout receipt for services that don't hold the signing key
+func (handler *TokenHandler) VerifyReceipt(c *gin.Context) {
+	if handler.Config.Receipts == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Receipts are not enabled"})
+		return
+	}
+
+	var req VerifyReceiptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := handler.Config.Receipts.Verify(req.Receipt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true, "claims": claims})
+}
+
+// enqueue parks the caller in the wait queue and hands back a ticket to poll with
+func (handler *TokenHandler) enqueue(c *gin.Context, pool string) {
+	ticket, position, err := handler.Service.EnqueueWaiter(context.WithoutCancel(c.Request.Context()), pool, clientID(c))
+	if err != nil {
+		respondFailed(c, "Failed to join wait queue", nil)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"ticket": ticket, "position": position})
+}
+
+// WaitForToken long-polls a queue ticket until a token is handed to it
+func (handler *TokenHandler) WaitForToken(c *gin.Context) {
+	var req TicketRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket"})
+		return
+	}
+
+	token, position, err := handler.Service.WaitForToken(c.Request.Context(), req.Ticket, handler.Config.LongPollTimeout)
 	if err != nil {
+		if errors.Is(err, constants.ErrTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTicketNotFound.Error()})
+			return
+		}
+		if deadlineExceeded(c) {
+			// The ticket keeps its place for the caller's next poll
+			respondFailed(c, "Failed to wait for token", gin.H{"ticket": req.Ticket, "position": position})
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		respondFailed(c, "Failed to wait for token", nil)
+		return
+	}
+
+	if token == nil {
+		c.JSON(http.StatusAccepted, gin.H{"ticket": req.Ticket, "position": position})
+		return
+	}
+	handler.respondAssigned(c, token)
+}
 
-		if err.Error() == constants.ErrNoAvailableTokens.Error() {
-			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrNoAvailableTokens.Error()})
+// LeaveQueue gives up a queue ticket
+func (handler *TokenHandler) LeaveQueue(c *gin.Context) {
+	var req TicketRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket"})
+		return
+	}
+
+	if err := handler.Service.LeaveQueue(context.WithoutCancel(c.Request.Context()), req.Ticket); err != nil {
+		if errors.Is(err, constants.ErrTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTicketNotFound.Error()})
 			return
 		}
+		respondFailed(c, "Failed to leave queue", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Left queue"})
+}
+
+// clientID identifies the caller for queue fairness, falling back to a shared
+// anonymous ID. X-Client-ID is whatever the caller sends, so a client can claim
+// another's turns or weight: fairness is only as good as the clients' honesty.
+func clientID(c *gin.Context) string {
+	if id := c.GetHeader(constants.HeaderClientID); id != "" {
+		return id
+	}
+	return constants.AnonymousClientID
+}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign token"})
+// ifMatch reads the token version from an If-Match header ("3", W/"3" or 3),
+// writing a 400 if it is malformed. A missing header or * gives 0, which
+// makes the change unconditional.
+func ifMatch(c *gin.Context) (int64, bool) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" || raw == "*" {
+		return 0, true
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(raw, "W/"), `"`), 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
+// setRetryAfter tells clients when the next token is expected to free up
+func (handler *TokenHandler) setRetryAfter(c *gin.Context, pool string) {
+	wait, err := handler.Service.NextReleaseIn(c.Request.Context(), pool)
+	if err != nil {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
+
+	// Cleanup only runs periodically, so a release is picked up one interval late at worst
+	seconds := max(int(wait.Seconds())+constants.TokenCleanupInterval, 1)
+	c.Header("Retry-After", strconv.Itoa(seconds))
 }
 
 func (handler *TokenHandler) KeepAlive(c *gin.Context) {
@@ -52,15 +544,70 @@ func (handler *TokenHandler) KeepAlive(c *gin.Context) {
 		return
 	}
 
-	err := handler.Service.KeepTokenAlive(context.Background(), req.Token)
+	// ?assigned_only= overrides the configured default for this call
+	var assignedOnly *bool
+	if raw, ok := c.GetQuery("assigned_only"); ok {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assigned_only"})
+			return
+		}
+		assignedOnly = &v
+	}
+
+	err := handler.Service.KeepTokenAlive(context.WithoutCancel(c.Request.Context()), req.Token, assignedOnly)
+	if errors.Is(err, constants.ErrTokenPrefixMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constants.ErrTokenPrefixMismatch.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrKeepaliveNotAssigned) {
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrKeepaliveNotAssigned.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrMaxHoldReached) {
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrMaxHoldReached.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to keep token alive"})
+		respondFailed(c, "Failed to keep token alive", nil)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Token kept alive"})
 }
 
+// GetTokenStatus reports a token's state, expiry and lock
+func (handler *TokenHandler) GetTokenStatus(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	status, err := handler.Service.GetTokenStatus(c.Request.Context(), req.Token)
+	if errors.Is(err, constants.ErrTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		return
+	}
+	if err != nil {
+		respondFailed(c, "Failed to fetch token status", nil)
+		return
+	}
+	// An alias can be guessed, so looking a token up by it reveals no more of
+	// the value than the listings do
+	if status.Alias == req.Token && status.Value != req.Token {
+		status.Value = handler.listed(status.Value)
+	}
+	if status.Version > 0 {
+		c.Header("ETag", strconv.Quote(strconv.FormatInt(status.Version, 10)))
+	}
+	c.JSON(http.StatusOK, status)
+}
+
 func (handler *TokenHandler) DeleteToken(ctx *gin.Context) {
 	var req struct {
 		Token string `json:"token" binding:"required"`
@@ -71,8 +618,26 @@ func (handler *TokenHandler) DeleteToken(ctx *gin.Context) {
 		return
 	}
 
-	if err := handler.Service.DeleteToken(context.Background(), req.Token); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token"})
+	version, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	err := handler.Service.DeleteToken(context.WithoutCancel(ctx.Request.Context()), req.Token, version)
+	if errors.Is(err, constants.ErrVersionMismatch) {
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": constants.ErrVersionMismatch.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrTokenPrefixMismatch) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": constants.ErrTokenPrefixMismatch.Error()})
+		return
+	}
+	if errors.Is(err, constants.ErrTokenNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		return
+	}
+	if err != nil {
+		respondFailed(ctx, "Failed to delete token", nil)
 		return
 	}
 
@@ -89,37 +654,348 @@ func (c *TokenHandler) UnblockToken(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.Service.UnblockToken(context.Background(), req.Token); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock token"})
+	// Unblocking someone else's token warns them first when they registered a callback
+	version, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	releaseAt, err := c.Service.ReclaimToken(ctx.Request.Context(), req.Token, clientID(ctx), version)
+	switch {
+	case errors.Is(err, constants.ErrVersionMismatch):
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": constants.ErrVersionMismatch.Error()})
+		return
+	case errors.Is(err, constants.ErrTokenNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		return
+	case errors.Is(err, constants.ErrInvalidTransition):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		respondFailed(ctx, "Failed to unblock token", nil)
+		return
+	}
+	if !releaseAt.IsZero() {
+		ctx.JSON(http.StatusAccepted, gin.H{"message": "Holder notified, token will be released", "release_at": releaseAt})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Token unblocked successfully"})
 }
 
+type SwapTokenRequest struct {
+	Token    string `json:"token" binding:"required"` // assigned token to give back
+	Selector string `json:"selector"`                 // same "key=value,..." form as ?selector= on assign
+}
+
+// SwapToken gives back the caller's token and assigns a fresh one from the
+// same pool in its place, e.g. when the token starts failing upstream
+// mid-job. When the pool is empty the caller keeps the token it has.
+func (handler *TokenHandler) SwapToken(c *gin.Context) {
+	var req SwapTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	selector, err := services.ParseLabels(req.Selector)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	token, err := handler.Service.SwapToken(context.WithoutCancel(c.Request.Context()), req.Token, clientID(c), selector, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, constants.ErrVersionMismatch):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": constants.ErrVersionMismatch.Error()})
+		case errors.Is(err, constants.ErrTokenNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		case errors.Is(err, constants.ErrNotTokenOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": constants.ErrNotTokenOwner.Error()})
+		case errors.Is(err, constants.ErrInvalidTransition), errors.Is(err, constants.ErrTokenNotAssigned):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, constants.ErrNoAvailableTokens):
+			c.JSON(handler.Config.EmptyPoolStatus, gin.H{"error": constants.ErrNoAvailableTokens.Error(), "token": req.Token})
+		default:
+			respondFailed(c, "Failed to swap token", nil)
+		}
+		return
+	}
+	handler.respondAssigned(c, token)
+}
+
+type TransferTokenRequest struct {
+	To string `json:"to" binding:"required,printascii,max=256"` // client ID taking over the assignment
+}
+
+// TransferToken hands the caller's assigned token to another client, e.g. an
+// outgoing worker passing its tokens to its replacement during a deploy
+func (handler *TokenHandler) TransferToken(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+	var req TransferTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.To == constants.AnonymousClientID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	version, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	from := clientID(c)
+	if err := handler.Service.TransferToken(c.Request.Context(), uri.Token, from, req.To, version); err != nil {
+		switch {
+		case errors.Is(err, constants.ErrVersionMismatch):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": constants.ErrVersionMismatch.Error()})
+		case errors.Is(err, constants.ErrTokenNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+		case errors.Is(err, constants.ErrNotTokenOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": constants.ErrNotTokenOwner.Error()})
+		case errors.Is(err, constants.ErrTokenNotAssigned):
+			c.JSON(http.StatusConflict, gin.H{"error": constants.ErrTokenNotAssigned.Error()})
+		default:
+			respondFailed(c, "Failed to transfer token", nil)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token transferred", "from": from, "to": req.To})
+}
+
+type SetAliasRequest struct {
+	Alias string `json:"alias" binding:"required"`
+}
+
+// SetAlias names a token so operators can refer to it by alias on the status,
+// keepalive and delete endpoints, replacing any alias it had
+func (handler *TokenHandler) SetAlias(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+	var req SetAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !services.ValidAlias(req.Alias) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alias"})
+		return
+	}
+
+	err := handler.Service.SetAlias(context.WithoutCancel(c.Request.Context()), uri.Token, req.Alias, clientID(c))
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrAliasTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": constants.ErrAliasTaken.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to set alias", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Alias set", "alias": req.Alias})
+	}
+}
+
+// RemoveAlias drops a token's alias
+func (handler *TokenHandler) RemoveAlias(c *gin.Context) {
+	var uri TokenRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	err := handler.Service.RemoveAlias(context.WithoutCancel(c.Request.Context()), uri.Token, clientID(c))
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrTokenNotFound.Error()})
+	case errors.Is(err, constants.ErrAliasNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrAliasNotFound.Error()})
+	case err != nil:
+		respondFailed(c, "Failed to remove alias", nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Alias removed"})
+	}
+}
+
+// listed returns token as the available, assigned and client listings show it
+func (handler *TokenHandler) listed(token string) string {
+	if handler.Config.Obfuscate == nil {
+		return token
+	}
+	return handler.Config.Obfuscate(token)
+}
+
 func (c *TokenHandler) GetAvailableTokens(ctx *gin.Context) {
-	tokens, err := c.Service.GetAvailableTokens(context.Background())
+	pool, ok := bindPool(ctx)
+	if !ok {
+		return
+	}
+
+	// Large pools can be streamed instead of built up in memory
+	switch formatOf(ctx) {
+	case formatNDJSON:
+		streamNDJSON(ctx, func(emit func(any) error, flush func()) error {
+			return c.Service.ScanAvailableTokens(ctx.Request.Context(), pool, func(tokens []string) error {
+				for _, token := range tokens {
+					if err := emit(gin.H{"token": c.listed(token)}); err != nil {
+						return err
+					}
+				}
+				flush()
+				return nil
+			})
+		})
+		return
+	case formatCSV:
+		streamCSV(ctx, []string{"token"}, func(emit func([]string) error, flush func()) error {
+			return c.Service.ScanAvailableTokens(ctx.Request.Context(), pool, func(tokens []string) error {
+				for _, token := range tokens {
+					if err := emit([]string{c.listed(token)}); err != nil {
+						return err
+					}
+				}
+				flush()
+				return nil
+			})
+		})
+		return
+	}
+
+	tokens, err := c.Service.GetAvailableTokens(ctx.Request.Context(), pool)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fehandlerh available tokens"})
+		respondFailed(ctx, "Failed to fehandlerh available tokens", nil)
 		return
 	}
+	for i, token := range tokens {
+		tokens[i] = c.listed(token)
+	}
 	ctx.JSON(http.StatusOK, gin.H{"available_tokens": tokens})
 }
 
 func (c *TokenHandler) GetAssignedTokens(ctx *gin.Context) {
-	tokens, err := c.Service.GetAssignedTokensWithExpiry(context.Background())
+	pool, ok := bindPool(ctx)
+	if !ok {
+		return
+	}
+
+	// Large pools can be streamed instead of built up in memory
+	switch formatOf(ctx) {
+	case formatNDJSON:
+		streamNDJSON(ctx, func(emit func(any) error, flush func()) error {
+			return c.Service.ScanAssignedTokens(ctx.Request.Context(), pool, func(tokens []repositories.AssignedToken) error {
+				for _, token := range tokens {
+					token.Token = c.listed(token.Token)
+					if err := emit(token); err != nil {
+						return err
+					}
+				}
+				flush()
+				return nil
+			})
+		})
+		return
+	case formatCSV:
+		streamCSV(ctx, append([]string{"token"}, expiryColumns...), func(emit func([]string) error, flush func()) error {
+			return c.Service.ScanAssignedTokens(ctx.Request.Context(), pool, func(tokens []repositories.AssignedToken) error {
+				for _, token := range tokens {
+					if err := emit(append([]string{c.listed(token.Token)}, expiryCells(token.Expiry)...)); err != nil {
+						return err
+					}
+				}
+				flush()
+				return nil
+			})
+		})
+		return
+	}
+
+	tokens, err := c.Service.GetAssignedTokensWithExpiry(ctx.Request.Context(), pool)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": ""})
+		respondFailed(ctx, "", nil)
 		return
 	}
+	for i := range tokens {
+		tokens[i].Token = c.listed(tokens[i].Token)
+	}
 	ctx.JSON(http.StatusOK, gin.H{"assigned_tokens": tokens})
 }
 
-func (c *TokenHandler) CleanupExpiredTokens(ctx *gin.Context) {
-	tokens, err := c.Service.CleanupExpiredTokens(context.Background())
+type ClientRequest struct {
+	ID string `uri:"id" binding:"required,printascii,max=256"` // X-Client-ID the tokens were assigned under
+}
+
+// bindClient reads the client ID from the URI. Assignments to anonymous
+// callers aren't tracked, so that ID is rejected, and the caller's X-Client-ID
+// must match. That only stops a client acting on another's tokens by mistake:
+// X-Client-ID is whatever the caller sends, so it is not an authorization check.
+func bindClient(c *gin.Context) (string, bool) {
+	var req ClientRequest
+	if err := c.ShouldBindUri(&req); err != nil || req.ID == constants.AnonymousClientID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return "", false
+	}
+	if req.ID != clientID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "X-Client-ID must match the client in the path; this guards against mistakes and is not authorization"})
+		return "", false
+	}
+	return req.ID, true
+}
+
+// GetClientTokens lists every token assigned to a client with its remaining
+// time, so a restarted worker can find what it still holds
+func (handler *TokenHandler) GetClientTokens(c *gin.Context) {
+	client, ok := bindClient(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := handler.Service.ClientTokens(c.Request.Context(), client)
+	if err != nil {
+		respondFailed(c, "Failed to fetch client tokens", nil)
+		return
+	}
+	if formatOf(c) == formatCSV {
+		rows := make([][]string, len(tokens))
+		for i, token := range tokens {
+			rows[i] = append([]string{handler.listed(token.Token), token.Pool}, expiryCells(token.Expiry)...)
+		}
+		writeCSV(c, append([]string{"token", "pool"}, expiryColumns...), rows)
+		return
+	}
+	for i := range tokens {
+		tokens[i].Token = handler.listed(tokens[i].Token)
+	}
+	c.JSON(http.StatusOK, gin.H{"client": client, "tokens": tokens})
+}
+
+// ReleaseClientTokens returns every token assigned to a client to its pool
+func (handler *TokenHandler) ReleaseClientTokens(c *gin.Context) {
+	client, ok := bindClient(c)
+	if !ok {
+		return
+	}
+
+	released, err := handler.Service.ReleaseClientTokens(c.Request.Context(), client)
+	if err != nil {
+		respondFailed(c, "Failed to release client tokens", gin.H{"released": released})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"client": client, "released": released})
+}
+
+// ListPools describes every pool with its policy, size, tags and health so
+// clients can choose one to draw from. Repeated ?tag= keeps only pools that
+// carry all of the given tags.
+func (handler *TokenHandler) ListPools(c *gin.Context) {
+	pools, err := handler.Service.DescribePools(c.Request.Context(), c.QueryArray("tag"))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": ""})
+		respondFailed(c, "Failed to list pools", nil)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{"cleaned_up": tokens})
+	c.JSON(http.StatusOK, gin.H{"pools": pools})
 }
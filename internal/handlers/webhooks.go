@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+type WebhookURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// WebhookRequest creates or changes a webhook subscription. On update only
+// the fields present are changed.
+type WebhookRequest struct {
+	URL     *string   `json:"url"`
+	Secret  *string   `json:"secret"`
+	Events  *[]string `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+func (req WebhookRequest) change() services.WebhookChange {
+	return services.WebhookChange{URL: req.URL, Secret: req.Secret, Events: req.Events, Enabled: req.Enabled}
+}
+
+// webhookView is a webhook as returned by the API, without its secret
+type webhookView struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func viewOf(webhook repositories.Webhook) webhookView {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+	return webhookView{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    events,
+		Enabled:   webhook.Enabled,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+}
+
+// ListWebhooks returns every webhook subscription
+func (handler *AdminHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := handler.Service.Webhooks(c.Request.Context())
+	if err != nil {
+		respondFailed(c, "Failed to list webhooks", nil)
+		return
+	}
+	views := make([]webhookView, len(webhooks))
+	for i, webhook := range webhooks {
+		views[i] = viewOf(webhook)
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": views})
+}
+
+// CreateWebhook subscribes a URL to audit events
+func (handler *AdminHandler) CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	webhook, err := handler.Service.CreateWebhook(c.Request.Context(), req.change(), adminActor(c))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, viewOf(webhook))
+}
+
+// GetWebhook returns one webhook subscription
+func (handler *AdminHandler) GetWebhook(c *gin.Context) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	webhook, err := handler.Service.Webhook(c.Request.Context(), uri.ID)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, viewOf(webhook))
+}
+
+// UpdateWebhook changes a webhook subscription, e.g. to rotate its secret or disable it
+func (handler *AdminHandler) UpdateWebhook(c *gin.Context) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	webhook, err := handler.Service.UpdateWebhook(c.Request.Context(), uri.ID, req.change(), adminActor(c))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, viewOf(webhook))
+}
+
+// DeleteWebhook removes a webhook subscription
+func (handler *AdminHandler) DeleteWebhook(c *gin.Context) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	if err := handler.Service.DeleteWebhook(c.Request.Context(), uri.ID, adminActor(c)); err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+func webhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, constants.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": constants.ErrWebhookNotFound.Error()})
+	case errors.Is(err, constants.ErrInvalidWebhook):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondFailed(c, "Failed to manage webhook", nil)
+	}
+}
@@ -0,0 +1,548 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// Job is a unit of background work travelling through the queue
+type Job struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Payload  map[string]string `json:"payload"`
+	Attempt  int               `json:"attempt"`
+	UniqueBy string            `json:"unique_by,omitempty"`
+}
+
+// Job states recorded in the status hash
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateRetrying  = "retrying"
+	StateSucceeded = "succeeded"
+	StateDead      = "dead"
+	StateCancelled = "cancelled"
+)
+
+// Status is the last known state of a job, kept for JobStatusTTL
+type Status struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Progress        json.RawMessage `json:"progress,omitempty"` // last reported by the handler, see SetProgress
+	CancelRequested bool            `json:"cancel_requested,omitempty"`
+}
+
+// Handler executes a job; returning an error schedules a retry, unless it
+// wraps ErrJobPermanent or ErrJobCancelled
+type Handler func(ctx context.Context, job Job) error
+
+// Config tunes the queue workers
+type Config struct {
+	Consumer     string        // name of this process within the consumer group
+	Workers      int           // jobs processed concurrently by this process
+	MaxAttempts  int           // attempts before a job is moved to the dead letter stream
+	RetryBackoff time.Duration // base delay, doubled on every attempt
+	ClaimIdle    time.Duration // pending jobs idle this long are taken over from dead consumers
+}
+
+// Queue is a Redis stream backed job queue. Jobs are consumed through a
+// consumer group so several replicas can share the work; failed jobs wait in
+// a delayed set before being retried and end up in a dead letter stream.
+type Queue struct {
+	client   *redis.Client
+	config   Config
+	logger   *slog.Logger
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewQueue creates a queue; register handlers before calling Run
+func NewQueue(client *redis.Client, config Config, logger *slog.Logger) *Queue {
+	if config.Consumer == "" {
+		config.Consumer = uuid.New().String()
+	}
+	if config.Workers <= 0 {
+		config.Workers = constants.DefaultJobWorkers
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = constants.DefaultJobMaxAttempts
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = constants.DefaultJobRetryBackoff
+	}
+	if config.ClaimIdle <= 0 {
+		config.ClaimIdle = constants.DefaultJobClaimIdle
+	}
+	return &Queue{client: client, config: config, logger: logger, handlers: make(map[string]Handler)}
+}
+
+// Register binds a handler to a job name
+func (q *Queue) Register(name string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[name] = handler
+}
+
+// Registered reports whether a handler exists for the job name
+func (q *Queue) Registered(name string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	_, ok := q.handlers[name]
+	return ok
+}
+
+// Names lists the registered job names
+func (q *Queue) Names() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	names := make([]string, 0, len(q.handlers))
+	for name := range q.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enqueue adds a job to the queue and returns its ID
+func (q *Queue) Enqueue(ctx context.Context, name string, payload map[string]string) (string, error) {
+	if !q.Registered(name) {
+		return "", constants.ErrUnknownJob
+	}
+	job := Job{ID: uuid.New().String(), Name: name, Payload: payload, Attempt: 1}
+	if err := q.add(ctx, job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// EnqueueUnique adds a job unless one with the same uniqueBy key is still
+// queued or running. It returns ErrDuplicateJob when the job was skipped.
+func (q *Queue) EnqueueUnique(ctx context.Context, name, uniqueBy string, payload map[string]string) (string, error) {
+	if !q.Registered(name) {
+		return "", constants.ErrUnknownJob
+	}
+	job := Job{ID: uuid.New().String(), Name: name, Payload: payload, Attempt: 1, UniqueBy: uniqueBy}
+
+	// The TTL only matters if a worker dies mid-job; completion clears the key
+	ok, err := q.client.SetNX(ctx, uniqueKey(uniqueBy), job.ID, constants.JobUniqueTTL).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to reserve unique job: %w", err)
+	}
+	if !ok {
+		return "", constants.ErrDuplicateJob
+	}
+
+	if err := q.add(ctx, job); err != nil {
+		q.client.Del(ctx, uniqueKey(uniqueBy))
+		return "", err
+	}
+	return job.ID, nil
+}
+
+func (q *Queue) add(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	err = q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: constants.KeyJobStream,
+		MaxLen: constants.JobStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"job": data},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	q.setStatus(ctx, job, StateQueued, nil)
+	return nil
+}
+
+// Status returns the last recorded state of a job
+func (q *Queue) Status(ctx context.Context, id string) (*Status, error) {
+	fields, err := q.client.HGetAll(ctx, statusKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch job status: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, constants.ErrJobNotFound
+	}
+
+	attempt, _ := strconv.Atoi(fields["attempt"])
+	updated, _ := strconv.ParseInt(fields["updated_at"], 10, 64)
+	status := &Status{
+		ID:              id,
+		Name:            fields["name"],
+		State:           fields["state"],
+		Attempt:         attempt,
+		Error:           fields["error"],
+		UpdatedAt:       time.UnixMilli(updated),
+		CancelRequested: fields["cancel_requested"] != "",
+	}
+	if progress := fields["progress"]; progress != "" {
+		status.Progress = json.RawMessage(progress)
+	}
+	return status, nil
+}
+
+// SetProgress records how far a running job got, shown with its status
+func (q *Queue) SetProgress(ctx context.Context, id string, progress any) error {
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode job progress: %w", err)
+	}
+	if err := q.client.HSet(ctx, statusKey(id), "progress", encoded).Err(); err != nil {
+		return fmt.Errorf("failed to record job progress: %w", err)
+	}
+	return nil
+}
+
+// Cancel asks for a job to stop. A queued job is dropped when a worker picks
+// it up; a running one stops only if its handler checks Cancelled.
+func (q *Queue) Cancel(ctx context.Context, id string) (*Status, error) {
+	status, err := q.Status(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch status.State {
+	case StateSucceeded, StateDead, StateCancelled:
+		return status, nil
+	}
+	if err := q.client.HSet(ctx, statusKey(id), "cancel_requested", 1).Err(); err != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	status.CancelRequested = true
+	return status, nil
+}
+
+// Cancelled reports whether a job was asked to stop. A lookup that fails is
+// taken as no, so a Redis blip doesn't abort the job.
+func (q *Queue) Cancelled(ctx context.Context, id string) bool {
+	requested, _ := q.client.HExists(ctx, statusKey(id), "cancel_requested").Result()
+	return requested
+}
+
+// setStatus records the job state; failures only cost observability so they are logged
+func (q *Queue) setStatus(ctx context.Context, job Job, state string, cause error) {
+	errMsg := ""
+	if cause != nil {
+		errMsg = cause.Error()
+	}
+
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, statusKey(job.ID),
+		"name", job.Name,
+		"state", state,
+		"attempt", job.Attempt,
+		"error", errMsg,
+		"updated_at", time.Now().UnixMilli())
+	pipe.Expire(ctx, statusKey(job.ID), constants.JobStatusTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Warn("Failed to record job status",
+			slog.String("job_id", job.ID),
+			slog.String("state", state),
+			slog.String("error", err.Error()))
+	}
+}
+
+// Run consumes jobs until ctx is cancelled
+func (q *Queue) Run(ctx context.Context) error {
+	err := q.client.XGroupCreateMkStream(ctx, constants.KeyJobStream, constants.JobConsumerGroup, "0").Err()
+	if err != nil && !isBusyGroup(err) {
+		return fmt.Errorf("failed to create job consumer group: %w", err)
+	}
+
+	q.logger.Info("Job queue started", slog.String("consumer", q.config.Consumer), slog.Int("workers", q.config.Workers))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		q.promoteDelayed(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		q.reclaimStale(ctx)
+	}()
+
+	for i := range q.config.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, q.config.Consumer+"-"+strconv.Itoa(i))
+		}()
+	}
+
+	wg.Wait()
+	q.logger.Info("Job queue stopping...")
+	return nil
+}
+
+// work reads and processes jobs for a single consumer
+func (q *Queue) work(ctx context.Context, consumer string) {
+	for ctx.Err() == nil {
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    constants.JobConsumerGroup,
+			Consumer: consumer,
+			Streams:  []string{constants.KeyJobStream, ">"},
+			Count:    1,
+			Block:    constants.JobReadBlock,
+		}).Result()
+		if err == redis.Nil || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			q.logger.Error("Error reading jobs", slog.String("error", err.Error()))
+			sleep(ctx, constants.JobReadBlock)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				q.process(ctx, consumer, msg)
+			}
+		}
+	}
+}
+
+func (q *Queue) process(ctx context.Context, consumer string, msg redis.XMessage) {
+	var job Job
+	raw, _ := msg.Values["job"].(string)
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		q.logger.Error("Dropping undecodable job", slog.String("message_id", msg.ID), slog.String("error", err.Error()))
+		q.ack(ctx, msg.ID)
+		return
+	}
+
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Name]
+	q.mu.RUnlock()
+
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("no handler registered for job %q", job.Name)
+	case q.Cancelled(ctx, job.ID):
+		err = constants.ErrJobCancelled
+	default:
+		q.setStatus(ctx, job, StateRunning, nil)
+		stop := q.hold(ctx, consumer, msg.ID)
+		err = q.safeRun(ctx, handler, job)
+		stop()
+	}
+
+	switch {
+	case err == nil:
+		q.setStatus(ctx, job, StateSucceeded, nil)
+		q.finish(ctx, job)
+	case errors.Is(err, constants.ErrJobCancelled):
+		q.logger.Info("Job cancelled", slog.String("job_id", job.ID), slog.String("job", job.Name))
+		q.setStatus(ctx, job, StateCancelled, nil)
+		q.finish(ctx, job)
+	default:
+		if !q.fail(ctx, job, err) {
+			// Left pending, so reclaimStale delivers it again rather than losing it
+			return
+		}
+	}
+	q.ack(ctx, msg.ID)
+}
+
+// hold keeps claiming a job's message while its handler runs, so a job
+// outlasting ClaimIdle isn't taken over by reclaimStale as if its worker had
+// died. The returned func stops it.
+func (q *Queue) hold(ctx context.Context, consumer, messageID string) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(q.config.ClaimIdle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Claiming resets the message's idle time
+				err := q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+					Stream:   constants.KeyJobStream,
+					Group:    constants.JobConsumerGroup,
+					Consumer: consumer,
+					Messages: []string{messageID},
+				}).Err()
+				if err != nil && ctx.Err() == nil {
+					q.logger.Warn("Failed to hold running job", slog.String("message_id", messageID), slog.String("error", err.Error()))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// safeRun keeps a panicking handler from taking the worker down with it
+func (q *Queue) safeRun(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (q *Queue) finish(ctx context.Context, job Job) {
+	if job.UniqueBy != "" {
+		q.client.Del(ctx, uniqueKey(job.UniqueBy))
+	}
+}
+
+// fail schedules a retry with exponential backoff, or dead-letters the job.
+// It reports false when neither could be stored, and the message must stay
+// unacknowledged.
+func (q *Queue) fail(ctx context.Context, job Job, cause error) bool {
+	logger := q.logger.With(
+		slog.String("job_id", job.ID),
+		slog.String("job", job.Name),
+		slog.Int("attempt", job.Attempt),
+		slog.String("error", cause.Error()))
+
+	if job.Attempt >= q.config.MaxAttempts || errors.Is(cause, constants.ErrJobPermanent) {
+		logger.Error("Job failed permanently, moving to dead letter stream")
+		data, _ := json.Marshal(job)
+		err := q.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: constants.KeyJobDeadLetter,
+			MaxLen: constants.JobStreamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"job": data, "error": cause.Error()},
+		}).Err()
+		if err != nil {
+			logger.Error("Failed to dead-letter job", slog.String("dead_letter_error", err.Error()))
+			return false
+		}
+		q.setStatus(ctx, job, StateDead, cause)
+		q.finish(ctx, job)
+		return true
+	}
+
+	delay := q.config.RetryBackoff << (job.Attempt - 1)
+	job.Attempt++
+	data, _ := json.Marshal(job)
+	err := q.client.ZAdd(ctx, constants.KeyJobDelayed, redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: data,
+	}).Err()
+	if err != nil {
+		logger.Error("Failed to schedule job retry", slog.String("schedule_error", err.Error()))
+		return false
+	}
+	q.setStatus(ctx, job, StateRetrying, cause)
+	logger.Warn("Job failed, retrying", slog.Duration("delay", delay))
+	return true
+}
+
+func (q *Queue) ack(ctx context.Context, id string) {
+	pipe := q.client.TxPipeline()
+	pipe.XAck(ctx, constants.KeyJobStream, constants.JobConsumerGroup, id)
+	pipe.XDel(ctx, constants.KeyJobStream, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Error("Failed to ack job", slog.String("message_id", id), slog.String("error", err.Error()))
+	}
+}
+
+// promoteDelayedScript moves due retries from the delayed set back onto the stream
+var promoteDelayedScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, job in ipairs(due) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], '*', 'job', job)
+end
+return #due
+`)
+
+func (q *Queue) promoteDelayed(ctx context.Context) {
+	ticker := time.NewTicker(constants.JobPromoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := promoteDelayedScript.Run(ctx, q.client,
+				[]string{constants.KeyJobDelayed, constants.KeyJobStream},
+				time.Now().UnixMilli(), constants.JobStreamMaxLen,
+			).Err()
+			if err != nil && ctx.Err() == nil {
+				q.logger.Error("Failed to promote delayed jobs", slog.String("error", err.Error()))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reclaimStale takes over jobs left pending by consumers that died mid-job
+func (q *Queue) reclaimStale(ctx context.Context) {
+	ticker := time.NewTicker(q.config.ClaimIdle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			msgs, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   constants.KeyJobStream,
+				Group:    constants.JobConsumerGroup,
+				Consumer: q.config.Consumer + "-reclaim",
+				MinIdle:  q.config.ClaimIdle,
+				Start:    "0",
+				Count:    100,
+			}).Result()
+			if err != nil {
+				if ctx.Err() == nil {
+					q.logger.Error("Failed to reclaim stale jobs", slog.String("error", err.Error()))
+				}
+				continue
+			}
+			for _, msg := range msgs {
+				q.process(ctx, q.config.Consumer+"-reclaim", msg)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func statusKey(id string) string {
+	return constants.PrefixJobStatusKey + ":" + id
+}
+
+func uniqueKey(uniqueBy string) string {
+	return constants.PrefixJobUniqueKey + ":" + uniqueBy
+}
+
+func isBusyGroup(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && len(redisErr.Error()) >= 9 && redisErr.Error()[:9] == "BUSYGROUP"
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+// Emitter receives every metric update as it happens, for backends that are
+// pushed to rather than scraped. Labels arrive as parallel name and value
+// slices, which emitters must not retain.
+type Emitter interface {
+	Count(name string, delta float64, labels, values []string)
+	Gauge(name string, value float64, labels, values []string)
+	Observe(name string, value float64, labels, values []string)
+}
+
+// AddEmitter forwards every later update of the registry's metrics to e, as
+// well as keeping them for /metrics
+func (r *Registry) AddEmitter(e Emitter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	emitters := append(append([]Emitter(nil), r.loadEmitters()...), e)
+	r.emitters.Store(&emitters)
+}
+
+func (r *Registry) loadEmitters() []Emitter {
+	if emitters := r.emitters.Load(); emitters != nil {
+		return *emitters
+	}
+	return nil
+}
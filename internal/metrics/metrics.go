@@ -0,0 +1,296 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets are upper bounds in seconds suited to Redis and HTTP latencies
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// collector is anything that can render itself in Prometheus text format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds every metric exposed on /metrics
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	emitters   atomic.Pointer[[]Emitter] // read on every update, so swapped rather than locked
+}
+
+// Default is the registry the package-level constructors register into
+var Default = &Registry{}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write renders all registered metrics, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the default registry in Prometheus text exposition format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// vec tracks one series per combination of label values
+type vec[T any] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*T
+	values     map[string][]string
+	newSeries  func() *T
+	mismatch   sync.Once // reports the first update with the wrong number of label values
+}
+
+func (v *vec[T]) name() string { return v.metricName }
+
+// with returns the series for labelValues. An update with the wrong number
+// of label values is a bug at its call site, but one on a request path
+// shouldn't fail the request: the sample is dropped and the first is logged.
+func (v *vec[T]) with(labelValues []string) (*T, bool) {
+	if len(labelValues) != len(v.labels) {
+		v.mismatch.Do(func() {
+			slog.Error("Dropping metric samples with the wrong number of label values",
+				"metric", v.metricName, "want", len(v.labels), "got", len(labelValues))
+		})
+		return nil, false
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newSeries()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+	return s, true
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, v.kind)
+}
+
+// sortedKeys returns series keys in a stable order for rendering
+func (v *vec[T]) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newVec[T any](name, help, kind string, labels []string, newSeries func() *T) *vec[T] {
+	return &vec[T]{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		series:     make(map[string]*T),
+		values:     make(map[string][]string),
+		newSeries:  newSeries,
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels
+type CounterVec struct {
+	*vec[float64]
+}
+
+// NewCounterVec creates a counter and registers it with the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *float64 { return new(float64) })}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the series identified by labelValues
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the series identified by labelValues by delta
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	s, ok := c.with(labelValues)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	*s += delta
+	c.mu.Unlock()
+	for _, e := range Default.loadEmitters() {
+		e.Count(c.metricName, delta, c.labels, labelValues)
+	}
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, c.values[key]), formatFloat(*c.series[key]))
+	}
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels
+type GaugeVec struct {
+	*vec[float64]
+}
+
+// NewGaugeVec creates a gauge and registers it with the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *float64 { return new(float64) })}
+	Default.register(g)
+	return g
+}
+
+// Set replaces the value of the series identified by labelValues
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	s, ok := g.with(labelValues)
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	*s = value
+	g.mu.Unlock()
+	g.emit(value, labelValues)
+}
+
+// Add shifts the value of the series identified by labelValues by delta
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	s, ok := g.with(labelValues)
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	*s += delta
+	value := *s
+	g.mu.Unlock()
+	g.emit(value, labelValues)
+}
+
+// emit sends the new absolute value, since not every backend accepts deltas
+func (g *GaugeVec) emit(value float64, labelValues []string) {
+	for _, e := range Default.loadEmitters() {
+		e.Gauge(g.metricName, value, g.labels, labelValues)
+	}
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, formatLabels(g.labels, g.values[key]), formatFloat(*g.series[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // cumulative counts per bucket
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec samples observations into buckets, partitioned by labels
+type HistogramVec struct {
+	*vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram and registers it with the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() *histogram {
+		return &histogram{counts: make([]uint64, len(buckets))}
+	})
+	Default.register(h)
+	return h
+}
+
+// Observe records value in the series identified by labelValues
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	s, ok := h.with(labelValues)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+	h.mu.Unlock()
+	for _, e := range Default.loadEmitters() {
+		e.Observe(h.metricName, value, h.labels, labelValues)
+	}
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range h.sortedKeys() {
+		s := h.series[key]
+		values := h.values[key]
+		labels := append(append([]string(nil), h.labels...), "le")
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(labels, append(append([]string(nil), values...), formatFloat(bound))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(labels, append(append([]string(nil), values...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, values), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
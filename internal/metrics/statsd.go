@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxPacket keeps a flushed datagram inside a typical 1500 byte MTU
+const maxPacket = 1432
+
+// StatsDConfig describes where and how a StatsD emitter sends metrics
+type StatsDConfig struct {
+	Address       string   // host:port of the agent, reached over UDP
+	Prefix        string   // prepended to every metric name with a dot
+	Datadog       bool     // DogStatsD: labels become tags instead of name segments
+	Tags          []string // extra DogStatsD tags sent with every metric, e.g. env:prod
+	FlushInterval time.Duration
+	QueueSize     int // lines buffered between flushes; updates beyond it are dropped
+}
+
+// StatsD emits metrics to a StatsD or DogStatsD agent. Updates are queued and
+// packed into datagrams on a timer, so recording a metric never blocks on the
+// network; when the queue is full they are dropped and counted instead.
+type StatsD struct {
+	conn    net.Conn
+	config  StatsDConfig
+	lines   chan string
+	dropped atomic.Int64
+}
+
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd agent: %w", err)
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	return &StatsD{conn: conn, config: config, lines: make(chan string, config.QueueSize)}, nil
+}
+
+func (s *StatsD) Count(name string, delta float64, labels, values []string) {
+	s.enqueue(name, delta, "c", labels, values)
+}
+
+func (s *StatsD) Gauge(name string, value float64, labels, values []string) {
+	s.enqueue(name, value, "g", labels, values)
+}
+
+// Observe sends histogram samples, which plain StatsD treats as timers
+func (s *StatsD) Observe(name string, value float64, labels, values []string) {
+	s.enqueue(name, value, "h", labels, values)
+}
+
+func (s *StatsD) enqueue(name string, value float64, kind string, labels, values []string) {
+	select {
+	case s.lines <- s.format(name, value, kind, labels, values):
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// format renders one line: prefix.name:value|kind, with labels appended to the
+// name for StatsD or as |#label:value tags for DogStatsD
+func (s *StatsD) format(name string, value float64, kind string, labels, values []string) string {
+	var b strings.Builder
+	if s.config.Prefix != "" {
+		b.WriteString(s.config.Prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(name)
+	if !s.config.Datadog {
+		for _, v := range values {
+			b.WriteByte('.')
+			b.WriteString(sanitize(v, true))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if s.config.Datadog && len(labels)+len(s.config.Tags) > 0 {
+		b.WriteString("|#")
+		tags := append([]string(nil), s.config.Tags...)
+		for i, label := range labels {
+			tags = append(tags, label+":"+sanitize(values[i], false))
+		}
+		b.WriteString(strings.Join(tags, ","))
+	}
+	return b.String()
+}
+
+// sanitize replaces characters the line protocol gives meaning to, and dots
+// too when the value becomes part of a name
+func sanitize(value string, dots bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ':', r == '|', r == '@', r == '#', r == ',', r == '\n', r == ' ':
+			return '_'
+		case r == '.' && dots:
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// Run sends queued lines every FlushInterval until ctx is cancelled, then
+// flushes what is left and closes the connection
+func (s *StatsD) Run(ctx context.Context) {
+	defer s.conn.Close()
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	var packet bytes.Buffer
+	for {
+		select {
+		case line := <-s.lines:
+			s.add(&packet, line)
+		case <-ticker.C:
+			if n := s.dropped.Swap(0); n > 0 {
+				s.enqueue("statsd_dropped_total", float64(n), "c", nil, nil)
+			}
+			s.send(&packet)
+		case <-ctx.Done():
+			for {
+				select {
+				case line := <-s.lines:
+					s.add(&packet, line)
+				default:
+					s.send(&packet)
+					return
+				}
+			}
+		}
+	}
+}
+
+// add appends a line to the packet, sending the packet first if the line
+// wouldn't fit
+func (s *StatsD) add(packet *bytes.Buffer, line string) {
+	if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacket {
+		s.send(packet)
+	}
+	if packet.Len() > 0 {
+		packet.WriteByte('\n')
+	}
+	packet.WriteString(line)
+}
+
+// send writes the packet as one datagram and resets it. UDP errors (usually
+// no agent listening) are ignored, as StatsD clients do.
+func (s *StatsD) send(packet *bytes.Buffer) {
+	if packet.Len() == 0 {
+		return
+	}
+	s.conn.Write(packet.Bytes())
+	packet.Reset()
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testTiming keeps the thresholds of test pools apart: a token is released a
+// minute past its expiry and deleted an hour past it
+var testTiming = TimingConfig{
+	AssignmentTTL:     time.Minute,
+	KeepaliveGrace:    time.Minute,
+	DeletionAfterIdle: time.Hour,
+	LockTTL:           time.Second,
+}
+
+// newTestRepository returns a repository backed by an in-memory Redis
+func newTestRepository(t *testing.T, timing TimingConfig) (*TokenRepository, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewTokenRepository(client, Config{Timing: timing}), mr
+}
+
+// saveTokens adds available tokens to pool
+func saveTokens(t *testing.T, r *TokenRepository, pool string, tokens ...string) {
+	t.Helper()
+	for _, token := range tokens {
+		if err := r.SaveToken(context.Background(), pool, token, nil, time.Time{}); err != nil {
+			t.Fatalf("SaveToken(%s): %v", token, err)
+		}
+	}
+}
+
+// recordOf returns a token's record, failing the test when it has none
+func recordOf(t *testing.T, r *TokenRepository, token string) *TokenRecord {
+	t.Helper()
+	record, err := r.RecordOf(context.Background(), token)
+	if err != nil {
+		t.Fatalf("RecordOf(%s): %v", token, err)
+	}
+	if record == nil {
+		t.Fatalf("RecordOf(%s): no record", token)
+	}
+	return record
+}
+
+// setRecord overwrites fields of a token's record, as a change made elsewhere would
+func setRecord(t *testing.T, r *TokenRepository, token string, fields ...any) {
+	t.Helper()
+	err := r.RedisClient.Eval(context.Background(), updateRecordScript, []string{recordKey(r.ref(token))}, fields...).Err()
+	if err != nil {
+		t.Fatalf("updating record of %s: %v", token, err)
+	}
+}
+
+// events returns the audit stream oldest first
+func events(t *testing.T, r *TokenRepository) []AuditEntry {
+	t.Helper()
+	entries, err := r.AuditHistory(context.Background(), "", 1000)
+	if err != nil {
+		t.Fatalf("AuditHistory: %v", err)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// eventsOf returns the actions recorded for token, oldest first
+func eventsOf(t *testing.T, r *TokenRepository, token string) []string {
+	t.Helper()
+	var actions []string
+	for _, entry := range events(t, r) {
+		if entry.Token == r.ref(token) {
+			actions = append(actions, entry.Action)
+		}
+	}
+	return actions
+}
+
+// member reports whether token is in the set at key
+func member(t *testing.T, r *TokenRepository, key, token string) bool {
+	t.Helper()
+	ok, err := r.RedisClient.SIsMember(context.Background(), key, r.ref(token)).Result()
+	if err != nil {
+		t.Fatalf("SISMEMBER %s: %v", key, err)
+	}
+	return ok
+}
+
+// setExpiry moves a token's keepalive score to at
+func setExpiry(t *testing.T, r *TokenRepository, pool, token string, at time.Time) {
+	t.Helper()
+	err := r.RedisClient.ZAdd(context.Background(), keysFor(pool).keepalive, redis.Z{Score: float64(at.Unix()), Member: r.ref(token)}).Err()
+	if err != nil {
+		t.Fatalf("setting expiry of %s: %v", token, err)
+	}
+}
+
+// keepaliveScore returns a token's keepalive score
+func keepaliveScore(t *testing.T, r *TokenRepository, pool, token string) int64 {
+	t.Helper()
+	score, err := r.RedisClient.ZScore(context.Background(), keysFor(pool).keepalive, r.ref(token)).Result()
+	if err != nil {
+		t.Fatalf("ZSCORE %s: %v", token, err)
+	}
+	return int64(score)
+}
@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// activateScript moves up to ARGV[2] pending tokens (KEYS[1]) whose activation
+// time is at or before ARGV[1] into the available set (KEYS[2]), starting
+// their idle clock in the keepalive set (KEYS[3]). ARGV[3] is the record key
+// prefix. Returns how many were activated. It records an event for each
+// (outboxLua).
+var activateScript = redis.NewScript(outboxLua + recordLua + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, ref in ipairs(due) do
+	redis.call('ZREM', KEYS[1], ref)
+	redis.call('SADD', KEYS[2], ref)
+	redis.call('ZADD', KEYS[3], ARGV[1], ref)
+	record_update(ARGV[3] .. ref, {state = 'available', updated_at = ARGV[1]})
+	outbox_for(ref)
+end
+return #due
+`)
+
+// ActivateDueTokens moves a pool's pending tokens whose activation time has
+// come into the available set and returns how many it moved. It records a
+// token.activate event for each.
+func (r *TokenRepository) ActivateDueTokens(ctx context.Context, pool string) (int, error) {
+	keys := keysFor(pool)
+	activated := 0
+	for {
+		event, err := r.eventArgs(ctx, pool, AuditEntry{Action: "token.activate"})
+		if err != nil {
+			return activated, err
+		}
+		n, err := activateScript.Run(ctx, r.RedisClient,
+			[]string{keys.pending, keys.available, keys.keepalive},
+			append([]any{r.Now().Unix(), constants.ActivationBatchSize, recordKey("")}, event...)...,
+		).Int()
+		if err != nil {
+			return activated, fmt.Errorf("failed to activate pending tokens: %w", err)
+		}
+		activated += n
+		if n < constants.ActivationBatchSize {
+			return activated, nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+func sweepOutcomesKey(pool string, minute int64) string {
+	return constants.PrefixSweepOutcomesKey + ":" + pool + ":" + strconv.FormatInt(minute, 10)
+}
+
+func alertKey(prefix, pool, kind string) string {
+	return prefix + ":" + pool + ":" + kind
+}
+
+// RecordSweepOutcome counts a cleanup run for a pool in the current minute,
+// shared by every replica
+func (r *TokenRepository) RecordSweepOutcome(ctx context.Context, pool string, failed bool) error {
+	key := sweepOutcomesKey(pool, r.Now().Unix()/60)
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HIncrBy(ctx, key, "runs", 1)
+	if failed {
+		pipe.HIncrBy(ctx, key, "errors", 1)
+	}
+	pipe.Expire(ctx, key, constants.SweepOutcomesTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record sweep outcome: %w", err)
+	}
+	return nil
+}
+
+// SweepOutcomes returns how many cleanup runs a pool had in the last window,
+// and how many of them failed
+func (r *TokenRepository) SweepOutcomes(ctx context.Context, pool string, window time.Duration) (int64, int64, error) {
+	now := r.Now().Unix() / 60
+	minutes := int64(window / time.Minute)
+	pipe := r.RedisClient.Pipeline()
+	cmds := make([]*redis.SliceCmd, 0, minutes)
+	for m := now - minutes + 1; m <= now; m++ {
+		cmds = append(cmds, pipe.HMGet(ctx, sweepOutcomesKey(pool, m), "runs", "errors"))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to read sweep outcomes: %w", err)
+	}
+
+	var runs, errors int64
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		runs += parseCount(values[0])
+		errors += parseCount(values[1])
+	}
+	return runs, errors, nil
+}
+
+func parseCount(value any) int64 {
+	s, _ := value.(string)
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// RaiseAlert marks an alert as raised and reports whether it should be sent,
+// which it is at most once per throttle across replicas
+func (r *TokenRepository) RaiseAlert(ctx context.Context, pool, kind string, throttle time.Duration) (bool, error) {
+	pipe := r.RedisClient.TxPipeline()
+	pipe.Set(ctx, alertKey(constants.PrefixAlertActiveKey, pool, kind), 1, constants.AlertActiveTTL)
+	send := pipe.SetNX(ctx, alertKey(constants.PrefixAlertThrottleKey, pool, kind), 1, throttle)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to raise alert: %w", err)
+	}
+	return send.Val(), nil
+}
+
+// ResolveAlert clears a raised alert and reports whether one was raised
+func (r *TokenRepository) ResolveAlert(ctx context.Context, pool, kind string) (bool, error) {
+	cleared, err := r.RedisClient.Del(ctx, alertKey(constants.PrefixAlertActiveKey, pool, kind)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve alert: %w", err)
+	}
+	return cleared > 0, nil
+}
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// The reverse hash (token -> alias) is authoritative: a forward entry its
+// token doesn't point back to is stale and treated as free. Deleting a token,
+// by hand or through cleanup, drops both entries.
+
+// setAliasScript points alias ARGV[1] at token ref ARGV[2] in the alias hash
+// KEYS[1] and its reverse KEYS[2], dropping the token's previous alias. It
+// returns 'taken' when the alias belongs to another token, or is itself a
+// token value (ref ARGV[3]) in the issued set KEYS[3] or pool index KEYS[4],
+// since lookups try the value as a token first. It takes an event (outboxLua).
+var setAliasScript = redis.NewScript(outboxLua + `
+local alias, ref = ARGV[1], ARGV[2]
+local owner = redis.call('HGET', KEYS[1], alias)
+if owner and owner ~= ref and redis.call('HGET', KEYS[2], owner) == alias then
+	return 'taken'
+end
+if redis.call('SISMEMBER', KEYS[3], ARGV[3]) == 1 or redis.call('HEXISTS', KEYS[4], ARGV[3]) == 1 then
+	return 'taken'
+end
+local previous = redis.call('HGET', KEYS[2], ref)
+if previous then
+	redis.call('HDEL', KEYS[1], previous)
+end
+redis.call('HSET', KEYS[1], alias, ref)
+redis.call('HSET', KEYS[2], ref, alias)
+outbox()
+return 'ok'
+`)
+
+// SetAlias gives a token a human-friendly alias it can be looked up by,
+// replacing any alias it had. ErrAliasTaken means another token, or a token
+// value, already goes by that name. It takes an event (WithEvent).
+func (r *TokenRepository) SetAlias(ctx context.Context, token, alias string) error {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.alias", ref))
+	if err != nil {
+		return err
+	}
+
+	res, err := setAliasScript.Run(ctx, r.RedisClient,
+		[]string{constants.KeyTokenAliases, constants.KeyAliasOfToken, constants.KeyIssuedTokens, constants.KeyTokenPoolIndex},
+		append([]any{alias, ref, r.ref(alias)}, event...)...,
+	).Text()
+	if err != nil {
+		return fmt.Errorf("failed to set alias: %w", err)
+	}
+	if res == "taken" {
+		return constants.ErrAliasTaken
+	}
+	return nil
+}
+
+// removeAliasScript drops the alias of token ref ARGV[1] from the alias hash
+// KEYS[1] and its reverse KEYS[2], returning false when it has none. It takes
+// an event (outboxLua).
+var removeAliasScript = redis.NewScript(outboxLua + `
+local alias = redis.call('HGET', KEYS[2], ARGV[1])
+if not alias then
+	return false
+end
+redis.call('HDEL', KEYS[1], alias)
+redis.call('HDEL', KEYS[2], ARGV[1])
+outbox()
+return alias
+`)
+
+// RemoveAlias drops a token's alias and returns it, or ErrAliasNotFound when
+// the token has none. It takes an event (WithEvent).
+func (r *TokenRepository) RemoveAlias(ctx context.Context, token string) (string, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.unalias", ref))
+	if err != nil {
+		return "", err
+	}
+
+	alias, err := removeAliasScript.Run(ctx, r.RedisClient,
+		[]string{constants.KeyTokenAliases, constants.KeyAliasOfToken},
+		append([]any{ref}, event...)...,
+	).Text()
+	if err == redis.Nil {
+		return "", constants.ErrAliasNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to remove alias: %w", err)
+	}
+	return alias, nil
+}
+
+// ResolveAlias returns the token going by alias, or ErrTokenNotFound
+func (r *TokenRepository) ResolveAlias(ctx context.Context, alias string) (string, error) {
+	ref, err := r.RedisClient.HGet(ctx, constants.KeyTokenAliases, alias).Result()
+	if err == redis.Nil {
+		return "", constants.ErrTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up alias: %w", err)
+	}
+	current, err := r.aliasOf(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if current != alias {
+		return "", constants.ErrTokenNotFound
+	}
+	return r.revealOne(ctx, ref)
+}
+
+// aliasOf returns the alias of the token stored under ref, or "" if it has none
+func (r *TokenRepository) aliasOf(ctx context.Context, ref string) (string, error) {
+	alias, err := r.RedisClient.HGet(ctx, constants.KeyAliasOfToken, ref).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up token alias: %w", err)
+	}
+	return alias, nil
+}
+
+// unindexAlias queues the writes that drop the alias of the token stored under ref
+func unindexAlias(ctx context.Context, pipe redis.Pipeliner, ref, alias string) {
+	if alias == "" {
+		return
+	}
+	pipe.HDel(ctx, constants.KeyTokenAliases, alias)
+	pipe.HDel(ctx, constants.KeyAliasOfToken, ref)
+}
@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestAliasLifecycle(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1", "tok-2")
+
+	if err := r.SetAlias(ctx, "tok-1", "first"); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+	if token, err := r.ResolveAlias(ctx, "first"); err != nil || token != "tok-1" {
+		t.Fatalf("ResolveAlias(first) = %q, %v", token, err)
+	}
+	if err := r.SetAlias(ctx, "tok-2", "first"); !errors.Is(err, constants.ErrAliasTaken) {
+		t.Errorf("SetAlias to another token's alias = %v, want ErrAliasTaken", err)
+	}
+	if err := r.SetAlias(ctx, "tok-2", "tok-1"); !errors.Is(err, constants.ErrAliasTaken) {
+		t.Errorf("SetAlias to a token value = %v, want ErrAliasTaken", err)
+	}
+
+	// Renaming frees the old alias
+	if err := r.SetAlias(ctx, "tok-1", "second"); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+	if _, err := r.ResolveAlias(ctx, "first"); !errors.Is(err, constants.ErrTokenNotFound) {
+		t.Errorf("ResolveAlias of the replaced alias = %v, want ErrTokenNotFound", err)
+	}
+	if err := r.SetAlias(ctx, "tok-2", "first"); err != nil {
+		t.Errorf("SetAlias to a freed alias: %v", err)
+	}
+
+	alias, err := r.RemoveAlias(ctx, "tok-1")
+	if err != nil || alias != "second" {
+		t.Fatalf("RemoveAlias = %q, %v", alias, err)
+	}
+	if _, err := r.RemoveAlias(ctx, "tok-1"); !errors.Is(err, constants.ErrAliasNotFound) {
+		t.Errorf("RemoveAlias without an alias = %v, want ErrAliasNotFound", err)
+	}
+	want := []string{"token.create", "token.alias", "token.alias", "token.unalias"}
+	if got := eventsOf(t, r, "tok-1"); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteTokenDropsAlias(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	if err := r.SetAlias(ctx, "tok-1", "nick"); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+
+	if err := r.DeleteToken(ctx, "tok-1", 0); err != nil {
+		t.Fatalf("DeleteToken: %v", err)
+	}
+	if _, err := r.ResolveAlias(ctx, "nick"); !errors.Is(err, constants.ErrTokenNotFound) {
+		t.Errorf("ResolveAlias of a deleted token = %v, want ErrTokenNotFound", err)
+	}
+	for _, key := range []string{constants.KeyTokenAliases, constants.KeyAliasOfToken} {
+		if n, _ := r.RedisClient.HLen(ctx, key).Result(); n != 0 {
+			t.Errorf("%s still has %d entries", key, n)
+		}
+	}
+}
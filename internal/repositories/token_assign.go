@@ -0,0 +1,238 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// AssignOptions narrows which available token an assignment may take
+type AssignOptions struct {
+	Selector       map[string]string // only tokens carrying all of these labels
+	ReservePercent int               // share of the pool this caller may not dip into
+	Client         string            // recorded as the owner of the assigned token
+}
+
+// popTokenScript takes an available token (KEYS[1]) out of the pool. With
+// label index sets (KEYS[4..]) it picks from their intersection with the
+// available set. A reserve (ARGV[1], percent of available + assigned in
+// KEYS[2]) is left untouched, so low-priority callers find the pool empty
+// once only the reserve remains. When clients have reported usage this
+// minute (KEYS[3]), the least utilised of ARGV[2] random candidates is
+// preferred, so the cost of an assign doesn't grow with the pool.
+var popTokenScript = redis.NewScript(`
+local reserve = tonumber(ARGV[1])
+if reserve > 0 then
+	local available = redis.call('SCARD', KEYS[1])
+	local total = available + redis.call('SCARD', KEYS[2])
+	if available <= math.ceil(total * reserve / 100) then
+		return false
+	end
+end
+
+local tracked = redis.call('EXISTS', KEYS[3]) == 1
+if #KEYS == 3 and not tracked then
+	return redis.call('SPOP', KEYS[1])
+end
+
+local sample = tonumber(ARGV[2])
+local candidates
+if #KEYS == 3 then
+	candidates = redis.call('SRANDMEMBER', KEYS[1], sample)
+else
+	local sets = {KEYS[1]}
+	for i = 4, #KEYS do
+		sets[#sets + 1] = KEYS[i]
+	end
+	candidates = redis.call('SINTER', unpack(sets))
+end
+if #candidates == 0 then
+	return false
+end
+
+local start = math.random(#candidates)
+local pick = candidates[start]
+if tracked then
+	local best
+	for i = 0, math.min(sample, #candidates) - 1 do
+		local token = candidates[(start + i - 1) % #candidates + 1]
+		local used = tonumber(redis.call('ZSCORE', KEYS[3], token) or '0')
+		if best == nil or used < best then
+			best = used
+			pick = token
+		end
+		if used == 0 then
+			break
+		end
+	end
+end
+redis.call('SREM', KEYS[1], pick)
+return pick
+`)
+
+// popToken takes an available token that satisfies the options
+func (r *TokenRepository) popToken(ctx context.Context, pool string, opts AssignOptions) (string, error) {
+	keys := keysFor(pool)
+	setKeys := []string{keys.available, keys.assigned, keys.usageKey(r.Now())}
+	for key, value := range opts.Selector {
+		setKeys = append(setKeys, keys.labelKey(key, value))
+	}
+
+	token, err := popTokenScript.Run(ctx, r.RedisClient, setKeys, opts.ReservePercent, constants.TokenUsageSample).Text()
+	if err == redis.Nil {
+		return "", constants.ErrNoAvailableTokens
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to pop token: %w", err)
+	}
+	return token, nil
+}
+
+// assignBatchScript claims up to ARGV[1] available tokens (KEYS[1]) in one
+// go, doing for each what claimToken does across several round trips: lock
+// (ARGV[4] prefix, ARGV[5] value, ARGV[6] TTL in ms), move to the assigned set
+// (KEYS[2]) with its keepalive (KEYS[3], score ARGV[7]), take an assignment
+// slot (KEYS[4], ARGV[3] of them; 0 for no cap), update the token record
+// (ARGV[8] prefix, ARGV[9] now) and index the owner (ARGV[10], "" for
+// untracked callers, in KEYS[5] and KEYS[6]).
+//
+// The reserve (ARGV[2]) and the slot cap lower how many may be taken rather
+// than failing the batch. With label index sets (KEYS[7..]) only tokens in
+// their intersection are candidates. Tokens still locked by a stale
+// assignment are put back and skipped. Returns a flat list of claimed tokens
+// and their new record revisions. It records an event for each (outboxLua).
+var assignBatchScript = redis.NewScript(outboxLua + recordLua + `
+local want = tonumber(ARGV[1])
+local reserve = tonumber(ARGV[2])
+if reserve > 0 then
+	local available = redis.call('SCARD', KEYS[1])
+	local total = available + redis.call('SCARD', KEYS[2])
+	want = math.min(want, available - math.ceil(total * reserve / 100))
+end
+local cap = tonumber(ARGV[3])
+if cap > 0 then
+	want = math.min(want, cap - redis.call('SCARD', KEYS[4]))
+end
+if want <= 0 then
+	return {}
+end
+
+local candidates
+if #KEYS > 6 then
+	local sets = {KEYS[1]}
+	for i = 7, #KEYS do
+		sets[#sets + 1] = KEYS[i]
+	end
+	candidates = redis.call('SINTER', unpack(sets))
+end
+
+local claimed, skipped = {}, {}
+local taken, cursor = 0, 1
+while taken < want do
+	local token
+	if candidates then
+		token = candidates[cursor]
+		cursor = cursor + 1
+		if token then
+			redis.call('SREM', KEYS[1], token)
+		end
+	else
+		token = redis.call('SPOP', KEYS[1])
+	end
+	if not token then
+		break
+	end
+
+	if redis.call('SET', ARGV[4] .. ':' .. token, ARGV[5], 'NX', 'PX', ARGV[6]) then
+		redis.call('SADD', KEYS[2], token)
+		redis.call('ZADD', KEYS[3], ARGV[7], token)
+		if cap > 0 then
+			redis.call('SADD', KEYS[4], token)
+		end
+		if ARGV[10] ~= '' then
+			redis.call('HSET', KEYS[5], token, ARGV[10])
+			redis.call('SADD', KEYS[6], token)
+		end
+		claimed[#claimed + 1] = token
+		claimed[#claimed + 1] = record_update(ARGV[8] .. ':' .. token, {state = 'assigned', owner = ARGV[10], assigned_at = ARGV[9], updated_at = ARGV[9]})
+		outbox_for(token)
+		taken = taken + 1
+	else
+		skipped[#skipped + 1] = token
+	end
+end
+
+if #skipped > 0 then
+	redis.call('SADD', KEYS[1], unpack(skipped))
+end
+return claimed
+`)
+
+// AssignTokens claims up to count available tokens from pool for opts.Client
+// in a single script, so a batch is never left half-claimed. It returns as
+// many as the pool, the caller's reserve and the assignment cap allow, which
+// may be none; ErrAssignmentCapReached means every slot was already taken.
+// Unlike AssignToken it takes tokens at random rather than preferring the
+// least utilised. It records a token.assign event for each token.
+func (r *TokenRepository) AssignTokens(ctx context.Context, pool string, count int, opts AssignOptions) ([]*Token, error) {
+	ctx = datasources.WithPool(ctx, pool)
+	full, err := r.atAssignmentCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		return nil, constants.ErrAssignmentCapReached
+	}
+
+	keys := keysFor(pool)
+	owner := opts.Client
+	if !tracksOwner(owner) {
+		owner = ""
+	}
+
+	setKeys := []string{
+		keys.available, keys.assigned, keys.keepalive, constants.KeyAssignmentSlots,
+		constants.KeyTokenOwners, clientTokensKey(owner),
+	}
+	for key, value := range opts.Selector {
+		setKeys = append(setKeys, keys.labelKey(key, value))
+	}
+
+	event, err := r.eventArgs(ctx, pool, AuditEntry{Action: "token.assign", Actor: opts.Client})
+	if err != nil {
+		return nil, err
+	}
+	now := r.Now()
+	timing := r.timingFor(pool)
+	res, err := assignBatchScript.Run(ctx, r.RedisClient, setKeys, append([]any{
+		count, opts.ReservePercent, r.AssignmentCap,
+		constants.PrefixLockKey, constants.LockValue, timing.LockTTL.Milliseconds(), timing.expiresAt(now),
+		constants.PrefixTokenRecordKey, now.Unix(), owner,
+	}, event...)...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign tokens: %w", err)
+	}
+
+	refs := make([]string, 0, len(res)/2)
+	revs := make([]int64, 0, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		ref, _ := res[i].(string)
+		rev, _ := res[i+1].(int64)
+		refs = append(refs, ref)
+		revs = append(revs, rev)
+	}
+	values, err := r.reveal(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*Token, len(refs))
+	for i := range refs {
+		tokens[i] = ClaimedToken(pool, values[i], owner, now)
+		tokens[i].Version = revs[i]
+	}
+	return tokens, nil
+}
@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestAssignTokenRecordsOwnerAndEvent(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+
+	token, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"})
+	if err != nil {
+		t.Fatalf("AssignToken: %v", err)
+	}
+	if token.Value != "tok-1" || token.Owner != "client-a" {
+		t.Fatalf("assigned %+v, want tok-1 owned by client-a", token)
+	}
+
+	record := recordOf(t, r, "tok-1")
+	if record.State != TokenStateAssigned || record.Owner != "client-a" || record.AssignedAt.IsZero() {
+		t.Errorf("record = %+v, want assigned to client-a with an assignment time", record)
+	}
+	if token.Version != record.Revision {
+		t.Errorf("returned version %d, record is at %d", token.Version, record.Revision)
+	}
+	if got := eventsOf(t, r, "tok-1"); !slices.Equal(got, []string{"token.create", "token.assign"}) {
+		t.Errorf("events = %v", got)
+	}
+	if entry := events(t, r)[1]; entry.Actor != "client-a" || entry.Pool != "default" {
+		t.Errorf("assign event = %+v, want actor client-a in pool default", entry)
+	}
+
+	if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-b"}); !errors.Is(err, constants.ErrNoAvailableTokens) {
+		t.Errorf("AssignToken on an empty pool = %v, want ErrNoAvailableTokens", err)
+	}
+}
+
+func TestAssignTokensClaimsWhatThePoolHas(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1", "tok-2", "tok-3")
+
+	tokens, err := r.AssignTokens(ctx, "default", 5, AssignOptions{Client: "client-a"})
+	if err != nil {
+		t.Fatalf("AssignTokens: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("assigned %d tokens, want the 3 the pool has", len(tokens))
+	}
+
+	keys := keysFor("default")
+	for _, token := range tokens {
+		if !member(t, r, keys.assigned, token.Value) || member(t, r, keys.available, token.Value) {
+			t.Errorf("%s is not moved to the assigned set", token.Value)
+		}
+		record := recordOf(t, r, token.Value)
+		if record.State != TokenStateAssigned || record.Owner != "client-a" || token.Version != record.Revision {
+			t.Errorf("record of %s = %+v, token version %d", token.Value, record, token.Version)
+		}
+		// The batch's single event is recorded once per token, naming it
+		if got := eventsOf(t, r, token.Value); !slices.Equal(got, []string{"token.create", "token.assign"}) {
+			t.Errorf("events of %s = %v", token.Value, got)
+		}
+	}
+
+	tokens, err = r.AssignTokens(ctx, "default", 2, AssignOptions{Client: "client-b"})
+	if err != nil || len(tokens) != 0 {
+		t.Errorf("AssignTokens on an empty pool = %v, %v; want none", tokens, err)
+	}
+}
+
+func TestReleasedTokensCanBeReassignedWithinTheLockTTL(t *testing.T) {
+	timing := testTiming
+	timing.LockTTL = time.Hour
+	r, _ := newTestRepository(t, timing)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+
+	assign := func() {
+		t.Helper()
+		if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"}); err != nil {
+			t.Fatalf("AssignToken: %v", err)
+		}
+	}
+	assign()
+	if err := r.UnblockToken(ctx, "tok-1", 0); err != nil {
+		t.Fatalf("UnblockToken: %v", err)
+	}
+	assign()
+
+	setExpiry(t, r, "default", "tok-1", time.Now().Add(-5*time.Minute))
+	if report, err := r.CleanupPool(ctx, "default", PhaseRelease); err != nil || report.Released != 1 {
+		t.Fatalf("CleanupPool = %+v, %v; want tok-1 released", report, err)
+	}
+	assign()
+
+	if _, err := r.SwapToken(ctx, "tok-1", "client-a", 0, AssignOptions{}); !errors.Is(err, constants.ErrNoAvailableTokens) {
+		t.Fatalf("SwapToken on an empty pool = %v, want ErrNoAvailableTokens", err)
+	}
+	saveTokens(t, r, "default", "tok-2")
+	if _, err := r.SwapToken(ctx, "tok-1", "client-a", 0, AssignOptions{}); err != nil {
+		t.Fatalf("SwapToken: %v", err)
+	}
+	if _, err := r.AssignSpecificToken(ctx, "tok-1", "client-b"); err != nil {
+		t.Errorf("AssignSpecificToken of the swapped out token: %v", err)
+	}
+}
+
+func TestAssignPutsBackATokenItCannotLock(t *testing.T) {
+	r, mr := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+
+	// Left behind by an assignment that crashed midway
+	mr.Set(lockKey("tok-1"), constants.LockValue)
+	if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"}); !errors.Is(err, constants.ErrTokenAlreadyInUse) {
+		t.Fatalf("AssignToken of a locked token = %v, want ErrTokenAlreadyInUse", err)
+	}
+	if !member(t, r, keysFor("default").available, "tok-1") {
+		t.Error("token that couldn't be locked was lost from the pool")
+	}
+}
@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditEntry is one record in the audit history. Tokens are recorded by ref,
+// so the history holds no token values when encryption is on.
+type AuditEntry struct {
+	ID         string            `json:"id,omitempty"`
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action"` // e.g. token.force_release
+	Token      string            `json:"token,omitempty"`
+	Pool       string            `json:"pool,omitempty"`
+	Actor      string            `json:"actor,omitempty"`
+	OnBehalfOf string            `json:"on_behalf_of,omitempty"` // who Actor acted for, when Actor is automation
+	Reason     string            `json:"reason,omitempty"`
+	Detail     map[string]string `json:"detail,omitempty"`
+
+	// The API request that caused the entry, when there was one
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type onBehalfOfContextKey struct{}
+
+// WithOnBehalfOf returns a context whose audit entries record principal as
+// who their actor acted for, unless they name someone themselves
+func WithOnBehalfOf(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, onBehalfOfContextKey{}, principal)
+}
+
+// AppendAudit adds an entry to the audit history stream on its own. Entries
+// recording a state change are better passed to the mutation with WithEvent.
+func (r *TokenRepository) AppendAudit(ctx context.Context, entry AuditEntry) error {
+	encoded, err := r.encodeAudit(ctx, entry)
+	if err != nil {
+		return err
+	}
+	err = r.RedisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: constants.KeyAuditStream,
+		MaxLen: constants.AuditStreamMaxLen,
+		Approx: true,
+		Values: map[string]any{"entry": encoded},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return nil
+}
+
+// AuditHistory returns up to limit entries, newest first. With a token only
+// that token's entries among the most recent AuditScanLimit are returned.
+func (r *TokenRepository) AuditHistory(ctx context.Context, token string, limit int) ([]AuditEntry, error) {
+	scan := int64(limit)
+	ref := ""
+	if token != "" {
+		scan = constants.AuditScanLimit
+		ref = r.ref(token)
+	}
+
+	messages, err := r.RedisClient.XRevRangeN(ctx, constants.KeyAuditStream, "+", "-", scan).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit history: %w", err)
+	}
+
+	entries := make([]AuditEntry, 0, min(len(messages), limit))
+	for _, msg := range messages {
+		raw, _ := msg.Values["entry"].(string)
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry %s: %w", msg.ID, err)
+		}
+		if ref != "" && entry.Token != ref {
+			continue
+		}
+		if ref != "" {
+			entry.Token = token
+		}
+		entry.ID = msg.ID
+		entries = append(entries, entry)
+		if len(entries) == limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// CreateAuditGroup creates a consumer group reading the audit history from
+// the oldest retained entry, for the export or the webhook dispatcher. It is a
+// no-op when the group exists.
+func (r *TokenRepository) CreateAuditGroup(ctx context.Context, group string) error {
+	err := r.RedisClient.XGroupCreateMkStream(ctx, constants.KeyAuditStream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create audit group %s: %w", group, err)
+	}
+	return nil
+}
+
+// ReadAuditGroup returns up to count entries group has not acknowledged:
+// first those another consumer left pending for longer than AuditExportClaimIdle,
+// then new ones, waiting up to AuditExportBlock for them. Returned entries stay
+// pending under consumer until AckAuditGroup.
+func (r *TokenRepository) ReadAuditGroup(ctx context.Context, group, consumer string, count int) ([]AuditEntry, error) {
+	messages, _, err := r.RedisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   constants.KeyAuditStream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  constants.AuditExportClaimIdle,
+		Start:    "0",
+		Count:    int64(count),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim stale audit entries: %w", err)
+	}
+
+	if len(messages) == 0 {
+		streams, err := r.RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{constants.KeyAuditStream, ">"},
+			Count:    int64(count),
+			Block:    constants.AuditExportBlock,
+		}).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit entries: %w", err)
+		}
+		messages = streams[0].Messages
+	}
+
+	entries := make([]AuditEntry, 0, len(messages))
+	var trimmed []string
+	for _, msg := range messages {
+		raw, ok := msg.Values["entry"].(string)
+		if !ok { // trimmed from the stream while pending
+			trimmed = append(trimmed, msg.ID)
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry %s: %w", msg.ID, err)
+		}
+		entry.ID = msg.ID
+		entries = append(entries, entry)
+	}
+	return entries, r.AckAuditGroup(ctx, group, trimmed...)
+}
+
+// AckAuditGroup marks entries as handled by group
+func (r *TokenRepository) AckAuditGroup(ctx context.Context, group string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.RedisClient.XAck(ctx, constants.KeyAuditStream, group, ids...).Err(); err != nil {
+		return fmt.Errorf("failed to acknowledge audit entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/secrets"
+)
+
+// NewToken is one token to add with SaveTokens
+type NewToken struct {
+	Pool       string
+	Token      string
+	Labels     map[string]string
+	ActivateAt time.Time
+	Hashed     bool // keep only the SHA-256 handle of Token, as hash-only mode does
+}
+
+// SaveTokens adds many tokens in one round trip and returns an error per
+// token, nil for those saved. The writes go out as a single MULTI so a
+// failing command only fails the token it belongs to. A token whose value was
+// ever issued before fails with ErrTokenExists, as with SaveToken.
+func (r *TokenRepository) SaveTokens(ctx context.Context, tokens []NewToken) []error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	errs := make([]error, len(tokens))
+	spans := make([][2]int, len(tokens))
+	refs := make([]string, len(tokens))
+	ciphertexts := make([]string, len(tokens))
+	now := r.Now()
+
+	for i, t := range tokens {
+		if t.Hashed {
+			refs[i] = secrets.Handle(t.Token)
+			continue
+		}
+		refs[i] = r.ref(t.Token)
+		ciphertexts[i], errs[i] = r.storeCiphertext(t.Token)
+	}
+	reserved, err := r.reserveRefs(ctx, refs)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	var release []string
+	for i := range tokens {
+		if !reserved[i] {
+			errs[i] = cmp.Or(errs[i], constants.ErrTokenExists)
+		} else if errs[i] != nil {
+			release = append(release, refs[i])
+		}
+	}
+
+	pipe := r.RedisClient.TxPipeline()
+	for i, t := range tokens {
+		if errs[i] != nil {
+			continue
+		}
+		start := pipe.Len()
+		if errs[i] = r.queueSave(ctx, pipe, t.Pool, refs[i], ciphertexts[i], t.Labels, t.ActivateAt, now); errs[i] != nil {
+			release = append(release, refs[i])
+			continue
+		}
+		spans[i] = [2]int{start, pipe.Len()}
+	}
+	if pipe.Len() == 0 {
+		r.releaseRefs(ctx, release)
+		return errs
+	}
+
+	cmds, err := pipe.Exec(ctx)
+	for i, span := range spans {
+		if errs[i] != nil {
+			continue
+		}
+		if span[1] > len(cmds) {
+			errs[i] = fmt.Errorf("failed to save token: %w", err)
+			release = append(release, refs[i])
+			continue
+		}
+		for _, cmd := range cmds[span[0]:span[1]] {
+			if cmd.Err() != nil {
+				errs[i] = fmt.Errorf("failed to save token: %w", cmd.Err())
+				release = append(release, refs[i])
+				break
+			}
+		}
+	}
+	r.releaseRefs(ctx, release)
+	return errs
+}
@@ -0,0 +1,187 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// Callback is the URL a holder registered to be warned before its token is reclaimed
+type Callback struct {
+	URL       string
+	Pool      string
+	Client    string    // client that registered it; its own unblock releases immediately
+	Notified  bool      // warned of expiry since its last keepalive, with release deferred once
+	ReclaimAt time.Time // when a requested reclaim releases the token; zero if none is pending
+}
+
+// DueCallback is an assigned token whose release is imminent and whose holder
+// hasn't been warned yet
+type DueCallback struct {
+	Ref       string
+	Token     string
+	Callback  Callback
+	ReleaseAt time.Time
+}
+
+func callbackKey(ref string) string {
+	return constants.PrefixTokenCallbackKey + ":" + ref
+}
+
+// DueReclaim is a token whose reclaim grace period has run out
+type DueReclaim struct {
+	Ref   string
+	Token string
+}
+
+// SetCallback registers the holder's callback URL for an assigned token
+func (r *TokenRepository) SetCallback(ctx context.Context, pool, token, url, client string) error {
+	err := r.RedisClient.HSet(ctx, callbackKey(r.ref(token)), "url", url, "pool", pool, "client", client).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save callback: %w", err)
+	}
+	return nil
+}
+
+// CallbackOf returns a token's callback, nil if its holder didn't register one
+func (r *TokenRepository) CallbackOf(ctx context.Context, token string) (*Callback, error) {
+	fields, err := r.RedisClient.HGetAll(ctx, callbackKey(r.ref(token))).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch callback: %w", err)
+	}
+	return parseCallback(fields), nil
+}
+
+func parseCallback(fields map[string]string) *Callback {
+	if fields["url"] == "" {
+		return nil
+	}
+	callback := &Callback{
+		URL:      fields["url"],
+		Pool:     fields["pool"],
+		Client:   fields["client"],
+		Notified: fields["notified"] != "",
+	}
+	if at, err := strconv.ParseInt(fields["reclaim_at"], 10, 64); err == nil {
+		callback.ReclaimAt = time.Unix(at, 0)
+	}
+	return callback
+}
+
+// releaseAt is when cleanup reclaims a token of pool with the given keepalive score
+func (r *TokenRepository) releaseAt(pool string, score float64) time.Time {
+	return time.Unix(int64(score), 0).Add(r.timingFor(pool).KeepaliveGrace)
+}
+
+// CallbacksDue returns assigned tokens in a pool that cleanup will reclaim
+// within lead and whose holders haven't been warned
+func (r *TokenRepository) CallbacksDue(ctx context.Context, pool string, lead time.Duration) ([]DueCallback, error) {
+	keys := keysFor(pool)
+	horizon := r.Now().Add(lead - r.timingFor(pool).KeepaliveGrace).Unix()
+
+	expiring, err := r.RedisClient.ZRangeByScoreWithScores(ctx, keys.keepalive, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(horizon, 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expiring tokens: %w", err)
+	}
+	if len(expiring) == 0 {
+		return nil, nil
+	}
+
+	// The keepalive set also scores available tokens, so check assignment too
+	pipe := r.RedisClient.Pipeline()
+	assigned := make([]*redis.BoolCmd, len(expiring))
+	callbacks := make([]*redis.MapStringStringCmd, len(expiring))
+	for i, z := range expiring {
+		ref := z.Member.(string)
+		assigned[i] = pipe.SIsMember(ctx, keys.assigned, ref)
+		callbacks[i] = pipe.HGetAll(ctx, callbackKey(ref))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch callbacks: %w", err)
+	}
+
+	var due []DueCallback
+	for i, z := range expiring {
+		callback := parseCallback(callbacks[i].Val())
+		if !assigned[i].Val() || callback == nil || callback.Notified {
+			continue
+		}
+		ref := z.Member.(string)
+		token, err := r.revealOne(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, DueCallback{Ref: ref, Token: token, Callback: *callback, ReleaseAt: r.releaseAt(pool, z.Score)})
+	}
+	return due, nil
+}
+
+// DeferRelease marks the holder as warned and pushes the token's release out
+// to grace from now, unless a keepalive already put it later. A keepalive
+// clears the mark, so the next time the token nears expiry it is warned again.
+func (r *TokenRepository) DeferRelease(ctx context.Context, pool, token string, grace time.Duration) (time.Time, error) {
+	ref := r.ref(token)
+	releaseAt := r.Now().Add(grace)
+	score := float64(releaseAt.Add(-r.timingFor(pool).KeepaliveGrace).Unix())
+
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HSet(ctx, callbackKey(ref), "notified", "1")
+	pipe.ZAddArgs(ctx, keysFor(pool).keepalive, redis.ZAddArgs{
+		XX:      true,
+		GT:      true,
+		Members: []redis.Z{{Score: score, Member: ref}},
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return time.Time{}, fmt.Errorf("failed to defer release: %w", err)
+	}
+	return releaseAt, nil
+}
+
+// ScheduleReclaim records that the token is to be released at the given time
+// regardless of keepalives, giving its warned holder until then to finish
+func (r *TokenRepository) ScheduleReclaim(ctx context.Context, pool, token string, at time.Time) error {
+	ref := r.ref(token)
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HSet(ctx, callbackKey(ref), "reclaim_at", at.Unix())
+	pipe.ZAdd(ctx, keysFor(pool).reclaims, redis.Z{Score: float64(at.Unix()), Member: ref})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to schedule reclaim: %w", err)
+	}
+	return nil
+}
+
+// ReclaimsDue returns the tokens in a pool whose scheduled reclaim time has passed
+func (r *TokenRepository) ReclaimsDue(ctx context.Context, pool string) ([]DueReclaim, error) {
+	refs, err := r.RedisClient.ZRangeByScore(ctx, keysFor(pool).reclaims, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(r.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due reclaims: %w", err)
+	}
+
+	tokens, err := r.reveal(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	due := make([]DueReclaim, len(refs))
+	for i, ref := range refs {
+		due[i] = DueReclaim{Ref: ref, Token: tokens[i]}
+	}
+	return due, nil
+}
+
+// ForgetReclaim drops a reclaim once the token has been released or is gone
+func (r *TokenRepository) ForgetReclaim(ctx context.Context, pool, ref string) error {
+	if err := r.RedisClient.ZRem(ctx, keysFor(pool).reclaims, ref).Err(); err != nil {
+		return fmt.Errorf("failed to forget reclaim: %w", err)
+	}
+	return nil
+}
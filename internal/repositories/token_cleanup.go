@@ -0,0 +1,746 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/logging"
+	"github.com/redis/go-redis/v9"
+)
+
+// CleanupConfig controls how cleanup work is spread across workers and pipelines
+type CleanupConfig struct {
+	Workers      int // number of batches processed concurrently
+	BatchSize    int // release/delete decisions applied by a single batch
+	PipelineSize int // max commands sent in a single Redis pipeline
+	ChunkRetries int // retries for a pipeline chunk that failed to execute
+
+	Order CleanupOrder // how the deletion sweep sequences itself against releases
+
+	OperationTimeout time.Duration // deadline for each Redis call or pipeline chunk
+	CycleTimeout     time.Duration // wall-clock budget for a whole run; the watchdog aborts it past this
+}
+
+// CleanupOrder decides how the release and deletion sweeps of a pool interact
+type CleanupOrder string
+
+const (
+	// CleanupReleaseFirst makes the deletion sweep run a release pass first and
+	// keeps it from overlapping the release sweep, so a token whose expiry
+	// straddles both thresholds is only ever handled by one of them
+	CleanupReleaseFirst CleanupOrder = "release_first"
+	// CleanupDeleteFirst is CleanupReleaseFirst with the passes swapped: idle
+	// tokens are removed before lapsed ones are returned to the pool
+	CleanupDeleteFirst CleanupOrder = "delete_first"
+	// CleanupParallel runs the two sweeps independently, as separate passes that
+	// may overlap. Cheapest, but the passes can disagree about the same token.
+	CleanupParallel CleanupOrder = "parallel"
+)
+
+// ParseCleanupOrder validates a configured order; empty means release first
+func ParseCleanupOrder(raw string) (CleanupOrder, error) {
+	switch order := CleanupOrder(raw); order {
+	case "":
+		return CleanupReleaseFirst, nil
+	case CleanupReleaseFirst, CleanupDeleteFirst, CleanupParallel:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown cleanup order %q", raw)
+	}
+}
+
+// withDefaults fills unset cleanup options with the package defaults
+func (c CleanupConfig) withDefaults() CleanupConfig {
+	if c.Workers <= 0 {
+		c.Workers = constants.DefaultCleanupWorkers
+	}
+	if c.PipelineSize <= 0 {
+		c.PipelineSize = constants.DefaultCleanupPipelineSize
+	}
+	// Zero means unset; a negative value disables retries entirely
+	if c.ChunkRetries == 0 {
+		c.ChunkRetries = constants.DefaultCleanupChunkRetries
+	} else if c.ChunkRetries < 0 {
+		c.ChunkRetries = 0
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = constants.DefaultCleanupBatchSize
+	}
+	if c.Order == "" {
+		c.Order = CleanupReleaseFirst
+	}
+	if c.OperationTimeout <= 0 {
+		c.OperationTimeout = constants.DefaultCleanupOperationTimeout
+	}
+	if c.CycleTimeout <= 0 {
+		c.CycleTimeout = constants.DefaultCleanupCycleTimeout
+	}
+	return c
+}
+
+// opContext bounds a single cleanup call to Redis, so a stuck connection
+// fails the call instead of hanging the run
+func (r *TokenRepository) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.Cleanup.OperationTimeout)
+}
+
+// CleanupResult holds statistics about token cleanup
+type CleanupResult struct {
+	TokensScanned   int
+	TokensReleased  int
+	TokensDeleted   int
+	ChunksFailed    int
+	ProcessingError error
+
+	// Refs of the tokens touched, kept up to CleanupReportTokenLimit
+	releasedTokens []string
+	deletedTokens  []string
+}
+
+// track keeps a touched token for the report while the list is short enough to be shown
+func track(list []string, tokens ...string) []string {
+	room := constants.CleanupReportTokenLimit - len(list)
+	if room <= 0 {
+		return list
+	}
+	return append(list, tokens[:min(room, len(tokens))]...)
+}
+
+// CleanupPhase selects which kinds of cleanup work a run performs
+type CleanupPhase int
+
+const (
+	// PhaseRelease returns assigned tokens whose keepalive lapsed to the pool
+	PhaseRelease CleanupPhase = 1 << iota
+	// PhaseDelete removes tokens idle past the deletion threshold, assigned or not
+	PhaseDelete
+
+	PhaseAll = PhaseRelease | PhaseDelete
+)
+
+func (p CleanupPhase) String() string {
+	switch p {
+	case PhaseRelease:
+		return "release"
+	case PhaseDelete:
+		return "delete"
+	default:
+		return "all"
+	}
+}
+
+// ParseCleanupPhase reads a phase name as produced by String; empty means all
+func ParseCleanupPhase(raw string) (CleanupPhase, error) {
+	switch raw {
+	case "", "all":
+		return PhaseAll, nil
+	case "release":
+		return PhaseRelease, nil
+	case "delete":
+		return PhaseDelete, nil
+	default:
+		return 0, fmt.Errorf("unknown cleanup phase %q", raw)
+	}
+}
+
+// poolSnapshot is a pool's sets and keepalive scores, read in one MULTI so
+// every token is seen in exactly one state
+type poolSnapshot struct {
+	keys      poolKeys
+	assigned  []string
+	available []string
+	expiries  map[string]int64  // keepalive scores; complete when deleting, lapsed tokens only when just releasing
+	aliases   map[string]string // alias of each token that has one, read only when deleting
+	heldSince map[string]int64  // assigned_at of assigned tokens, read only when asked for, see snapshotPool
+}
+
+// cleanupDecision is what a run does to one token, decided from its snapshot
+type cleanupDecision struct {
+	token        string
+	action       cleanupAction
+	assigned     bool   // whether the snapshot had it assigned rather than available
+	hasKeepalive bool   // whether the snapshot had a keepalive score for it
+	before       int64  // threshold its keepalive score, or its assignment start when held, was past
+	held         bool   // released for having been held for its MaxHold, whatever its keepalive
+	alias        string // alias the snapshot had for it, dropped along with a deleted token
+}
+
+// cleanupBatch is a slice of decisions for one pool, applied by a single worker
+type cleanupBatch struct {
+	pool      string
+	keys      poolKeys
+	decisions []cleanupDecision
+}
+
+// CleanupExpiredTokens checks for and handles expired tokens in every pool
+func (r *TokenRepository) CleanupExpiredTokens(ctx context.Context) (CleanupReport, error) {
+	pools, err := r.ListPools(ctx)
+	if err != nil {
+		return CleanupReport{}, err
+	}
+	return r.runCleanup(ctx, pools, PhaseAll)
+}
+
+// CleanupPool runs the given cleanup phases against a single pool
+func (r *TokenRepository) CleanupPool(ctx context.Context, pool string, phase CleanupPhase) (CleanupReport, error) {
+	return r.runCleanup(ctx, []string{pool}, phase)
+}
+
+// CleanupPools runs the given cleanup phases against each of pools
+func (r *TokenRepository) CleanupPools(ctx context.Context, pools []string, phase CleanupPhase) (CleanupReport, error) {
+	return r.runCleanup(ctx, pools, phase)
+}
+
+// runCleanup runs one pass and reports it; the error is the pass's first
+// failure, or ErrCleanupInProgress when another run holds one of the pools
+func (r *TokenRepository) runCleanup(ctx context.Context, pools []string, phase CleanupPhase) (CleanupReport, error) {
+	var report CleanupReport
+	unlock, err := r.lockCleanup(ctx, pools, phase)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	start := time.Now()
+	result := r.cleanupExpiredTokens(ctx, pools, phase)
+	report.add(phase, len(pools), result, time.Since(start))
+	return report, result.ProcessingError
+}
+
+// DeletionPass runs the deletion sweep of a pool. Unless the order is
+// parallel it runs a release pass too, before or after deleting, each from a
+// fresh snapshot so the second pass sees what the first one changed.
+func (r *TokenRepository) DeletionPass(ctx context.Context, pool string) (CleanupReport, error) {
+	phases := []CleanupPhase{PhaseRelease, PhaseDelete}
+	switch r.Cleanup.Order {
+	case CleanupParallel:
+		phases = []CleanupPhase{PhaseDelete}
+	case CleanupDeleteFirst:
+		phases = []CleanupPhase{PhaseDelete, PhaseRelease}
+	}
+
+	var report CleanupReport
+	var locked CleanupPhase
+	for _, phase := range phases {
+		locked |= phase
+	}
+	unlock, err := r.lockCleanup(ctx, []string{pool}, locked)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	for _, phase := range phases {
+		start := time.Now()
+		result := r.cleanupExpiredTokens(ctx, []string{pool}, phase)
+		report.add(phase, 1, result, time.Since(start))
+		if result.ProcessingError != nil {
+			return report, result.ProcessingError
+		}
+	}
+	return report, nil
+}
+
+// CleanupExclusive reports whether a pool's release and deletion sweeps must
+// not run at the same time
+func (r *TokenRepository) CleanupExclusive() bool {
+	return r.Cleanup.Order != CleanupParallel
+}
+
+// cleanupExpiredTokens performs the actual cleanup work and returns statistics.
+// Each pool is snapshotted once and every release or delete is decided from
+// that snapshot before any write is issued, so a token moving between sets
+// mid-run can't be handled twice with conflicting writes.
+func (r *TokenRepository) cleanupExpiredTokens(ctx context.Context, pools []string, phase CleanupPhase) CleanupResult {
+	result := CleanupResult{}
+	now := r.Now().Unix()
+
+	slog.Debug("Starting token cleanup",
+		slog.Int64("now", now),
+		slog.Int("pools", len(pools)),
+		slog.String("phase", phase.String()))
+
+	// The watchdog cancels a run that outlives its budget; every call below
+	// uses the run's context, so in-flight work fails fast and no new work starts
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	started := time.Now()
+	watchdog := time.AfterFunc(r.Cleanup.CycleTimeout, func() {
+		slog.Error("Token cleanup exceeded its time budget, aborting",
+			slog.String("phase", phase.String()),
+			slog.Int("pools", len(pools)),
+			slog.Duration("budget", r.Cleanup.CycleTimeout))
+		cancel(constants.ErrCleanupBudgetExceeded)
+	})
+	defer watchdog.Stop()
+
+	// Decide everything up front so batches can be spread across workers
+	var batches []cleanupBatch
+	pending := 0
+	for _, pool := range pools {
+		timing := r.timingFor(pool)
+		releaseBefore := now - int64(timing.KeepaliveGrace.Seconds())
+		deleteBefore := now - int64(timing.DeletionAfterIdle.Seconds())
+		holdBefore := timing.heldBefore(now)
+
+		snapshot, err := r.snapshotPool(ctx, pool, phase, releaseBefore, timing.MaxHold > 0 && phase&PhaseRelease != 0)
+		if err != nil {
+			result.ProcessingError = err
+			return result
+		}
+		result.TokensScanned += len(snapshot.assigned) + len(snapshot.available)
+
+		decisions := decideCleanup(snapshot, phase, releaseBefore, deleteBefore, holdBefore)
+		slog.Debug("Planned pool cleanup",
+			slog.String("pool", pool),
+			slog.Int("assigned", len(snapshot.assigned)),
+			slog.Int("available", len(snapshot.available)),
+			slog.Int("writes", len(decisions)))
+
+		for start := 0; start < len(decisions); start += r.Cleanup.BatchSize {
+			end := min(start+r.Cleanup.BatchSize, len(decisions))
+			batches = append(batches, cleanupBatch{pool: pool, keys: snapshot.keys, decisions: decisions[start:end]})
+		}
+		pending += len(decisions)
+	}
+
+	queue := make(chan cleanupBatch)
+	results := make(chan CleanupResult)
+
+	// Bounded worker pool draining batches from every pool
+	var wg sync.WaitGroup
+	for range r.Cleanup.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				results <- r.applyCleanup(ctx, batch)
+			}
+		}()
+	}
+
+	go func() {
+		for _, batch := range batches {
+			queue <- batch
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collect results, reporting progress as each batch lands
+	for res := range results {
+		result.TokensReleased += res.TokensReleased
+		result.TokensDeleted += res.TokensDeleted
+		result.ChunksFailed += res.ChunksFailed
+		result.releasedTokens = track(result.releasedTokens, res.releasedTokens...)
+		result.deletedTokens = track(result.deletedTokens, res.deletedTokens...)
+		if res.ProcessingError != nil && result.ProcessingError == nil {
+			result.ProcessingError = res.ProcessingError
+		}
+		slog.Debug("Token cleanup progress",
+			slog.Int("planned", pending),
+			slog.Int("released", result.TokensReleased),
+			slog.Int("deleted", result.TokensDeleted))
+	}
+
+	if cause := context.Cause(ctx); errors.Is(cause, constants.ErrCleanupBudgetExceeded) {
+		result.ProcessingError = fmt.Errorf("aborted after %s: %w", time.Since(started).Round(time.Millisecond), cause)
+	}
+	if result.ProcessingError != nil {
+		slog.Error("Token cleanup encountered errors",
+			slog.String("phase", phase.String()),
+			slog.Int("chunks_failed", result.ChunksFailed),
+			slog.String("error", result.ProcessingError.Error()))
+	} else {
+		slog.Info("Token cleanup completed",
+			slog.String("phase", phase.String()),
+			slog.Int("released", result.TokensReleased),
+			slog.Int("deleted", result.TokensDeleted))
+	}
+
+	return result
+}
+
+// snapshotPool reads a pool's sets and keepalive scores in one transaction.
+// Releasing only needs lapsed scores, so without the delete phase just those
+// are fetched; deleting also needs to know which tokens have no score at all,
+// and their aliases to drop with them. Aliases are set by hand and few, so
+// the whole alias hash is read. With held, when each assigned token's
+// assignment began is read too, for releasing those held for their MaxHold.
+func (r *TokenRepository) snapshotPool(ctx context.Context, pool string, phase CleanupPhase, releaseBefore int64, held bool) (poolSnapshot, error) {
+	keys := keysFor(pool)
+	ctx, cancel := r.opContext(ctx)
+	defer cancel()
+
+	pipe := r.RedisClient.TxPipeline()
+	assigned := pipe.SMembers(ctx, keys.assigned)
+	var available *redis.StringSliceCmd
+	var scores *redis.ZSliceCmd
+	var aliases *redis.MapStringStringCmd
+	if phase&PhaseDelete != 0 {
+		// Available tokens are only ever deleted, so the release phase can skip them
+		available = pipe.SMembers(ctx, keys.available)
+		scores = pipe.ZRangeWithScores(ctx, keys.keepalive, 0, -1)
+		aliases = pipe.HGetAll(ctx, constants.KeyAliasOfToken)
+	} else {
+		scores = pipe.ZRangeByScoreWithScores(ctx, keys.keepalive, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(releaseBefore, 10),
+		})
+	}
+	var since *redis.Cmd
+	if held {
+		since = heldSinceScript.Eval(ctx, pipe, []string{keys.assigned}, constants.PrefixTokenRecordKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return poolSnapshot{}, fmt.Errorf("failed to snapshot pool %s: %w", pool, err)
+	}
+
+	snapshot := poolSnapshot{
+		keys:     keys,
+		assigned: assigned.Val(),
+		expiries: make(map[string]int64, len(scores.Val())),
+	}
+	if available != nil {
+		snapshot.available = available.Val()
+		snapshot.aliases = aliases.Val()
+	}
+	for _, z := range scores.Val() {
+		snapshot.expiries[z.Member.(string)] = int64(z.Score)
+	}
+	if since != nil {
+		pairs, err := since.StringSlice()
+		if err != nil {
+			return poolSnapshot{}, fmt.Errorf("failed to read assignment start times of pool %s: %w", pool, err)
+		}
+		snapshot.heldSince = make(map[string]int64, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			snapshot.heldSince[pairs[i]], _ = strconv.ParseInt(pairs[i+1], 10, 64)
+		}
+	}
+	return snapshot, nil
+}
+
+// heldSinceScript returns, as token and Unix time pairs, when each token of
+// the assigned set KEYS[1] began its current assignment, from the records
+// under key prefix ARGV[1]. Tokens whose record doesn't say are left out. It
+// runs in the snapshot's MULTI, so it sees the same assigned set.
+var heldSinceScript = redis.NewScript(recordLua + `
+local held = {}
+for _, token in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	local record = record_load(ARGV[1] .. ':' .. token)
+	local since = record and tonumber(record.assigned_at) or 0
+	if since > 0 then
+		held[#held + 1] = token
+		held[#held + 1] = tostring(since)
+	end
+end
+return held
+`)
+
+// decideCleanup works out, in memory, which tokens of a snapshot to release
+// and which to delete. Deletion wins when a token is past both thresholds.
+// Assigned tokens whose assignment began at or before holdBefore are released
+// whatever their keepalive, as they have been held for their MaxHold; a
+// holdBefore of 0 releases none that way.
+func decideCleanup(snapshot poolSnapshot, phase CleanupPhase, releaseBefore, deleteBefore, holdBefore int64) []cleanupDecision {
+	var decisions []cleanupDecision
+	deleting := phase&PhaseDelete != 0
+	releasing := phase&PhaseRelease != 0
+
+	for _, token := range snapshot.assigned {
+		expiry, ok := snapshot.expiries[token]
+		since, tracked := snapshot.heldSince[token]
+		overHeld := tracked && since <= holdBefore && releasing
+		switch {
+		case !ok && deleting:
+			// Token with no keepalive record should be deleted
+			decisions = append(decisions, cleanupDecision{token: token, action: actionDelete, assigned: true, alias: snapshot.aliases[token]})
+		case ok && expiry <= deleteBefore && deleting:
+			// Delete tokens idle past DeletionAfterIdle
+			decisions = append(decisions, cleanupDecision{token: token, action: actionDelete, assigned: true, hasKeepalive: true, before: deleteBefore, alias: snapshot.aliases[token]})
+		case ok && expiry <= deleteBefore:
+		case overHeld:
+			// Release tokens held for their MaxHold, however recently kept alive
+			decisions = append(decisions, cleanupDecision{token: token, action: actionRelease, assigned: true, hasKeepalive: ok, before: holdBefore, held: true})
+		case !ok:
+			// Not lapsed, or no keepalive record; either way not ours to release
+		case expiry <= releaseBefore && releasing:
+			// Release tokens past their keepalive grace but not yet due for deletion
+			decisions = append(decisions, cleanupDecision{token: token, action: actionRelease, assigned: true, hasKeepalive: true, before: releaseBefore})
+		}
+	}
+
+	for _, token := range snapshot.available {
+		// Delete tokens with no keepalive or one older than the deletion threshold
+		expiry, ok := snapshot.expiries[token]
+		if !ok || expiry <= deleteBefore {
+			decisions = append(decisions, cleanupDecision{token: token, action: actionDelete, hasKeepalive: ok, before: deleteBefore, alias: snapshot.aliases[token]})
+		}
+	}
+	return decisions
+}
+
+// cleanupTokenScript applies one cleanup decision to a token (ARGV[1]),
+// first rechecking what the decision was made from, since the token may have
+// been kept alive, released or reassigned after the snapshot. The token must
+// still be in the set it was seen in (KEYS[1]) and its keepalive score
+// (KEYS[3]) still at or before ARGV[3]; "none" requires it to still have no
+// score and "held" instead requires its record (KEYS[7]) to still date its
+// assignment at or before ARGV[6], so a token released and reassigned since
+// is left alone. Otherwise the token is skipped.
+//
+// ARGV[2] "release" moves it to the available set (KEYS[2]), dropping its
+// owner (KEYS[4]), assignment slot (KEYS[5]), callback (KEYS[6]) and
+// assignment lock (KEYS[15]) and marking its record (KEYS[7]) available as
+// of ARGV[4]. "delete" removes it and everything kept about it: those, its
+// keepalive, and its pool index, ciphertext, labels, rate limit, probe and
+// alias entries (KEYS[8..13]). Its alias is also dropped from the alias hash
+// KEYS[14]: the one the snapshot had (ARGV[5]) and the one it has now, should
+// it have been renamed since, each unless it already names another token.
+// Returns 1 when the decision was applied, 0 when the token was skipped. It
+// records an event when applied (outboxLua).
+var cleanupTokenScript = redis.NewScript(outboxLua + recordLua + `
+local token = ARGV[1]
+if redis.call('SISMEMBER', KEYS[1], token) == 0 then
+	return 0
+end
+local score = redis.call('ZSCORE', KEYS[3], token)
+if ARGV[3] == 'none' then
+	if score then
+		return 0
+	end
+elseif ARGV[3] == 'held' then
+	local record = record_load(KEYS[7])
+	local since = record and tonumber(record.assigned_at) or 0
+	if since == 0 or since > tonumber(ARGV[6]) then
+		return 0
+	end
+else
+	if not score or tonumber(score) > tonumber(ARGV[3]) then
+		return 0
+	end
+end
+
+redis.call('SREM', KEYS[1], token)
+redis.call('HDEL', KEYS[4], token)
+redis.call('SREM', KEYS[5], token)
+redis.call('DEL', KEYS[6])
+redis.call('DEL', KEYS[15])
+if ARGV[2] == 'release' then
+	redis.call('SADD', KEYS[2], token)
+	record_update(KEYS[7], {state = 'available', owner = '', updated_at = ARGV[4]})
+	outbox()
+	return 1
+end
+
+redis.call('ZREM', KEYS[3], token)
+redis.call('DEL', KEYS[7])
+for _, alias in ipairs({ARGV[5], redis.call('HGET', KEYS[13], token) or ''}) do
+	if alias ~= '' and redis.call('HGET', KEYS[14], alias) == token then
+		redis.call('HDEL', KEYS[14], alias)
+	end
+end
+for i = 8, 13 do
+	redis.call('HDEL', KEYS[i], token)
+end
+outbox()
+return 1
+`)
+
+// applyCleanup issues the writes for a batch of decisions, each through
+// cleanupTokenScript so a token that changed since its snapshot is left alone
+func (r *TokenRepository) applyCleanup(ctx context.Context, batch cleanupBatch) CleanupResult {
+	keys := batch.keys
+	writer := newPipelineWriter(r.RedisClient, r.Cleanup)
+
+	// Chunks run the script by SHA, as a pipeline can't fall back to EVAL
+	loadCtx, cancel := r.opContext(ctx)
+	err := cleanupTokenScript.Load(loadCtx, r.RedisClient).Err()
+	cancel()
+	if err != nil {
+		return CleanupResult{ProcessingError: fmt.Errorf("failed to load cleanup script: %w", err)}
+	}
+
+	now := r.Now().Unix()
+	var failed error
+	for _, d := range batch.decisions {
+		token, alias := d.token, d.alias
+		set, action := keys.available, "delete"
+		if d.assigned {
+			set = keys.assigned
+		}
+		if d.action == actionRelease {
+			action = "release"
+		}
+		event, err := r.eventArgs(ctx, batch.pool, tokenEvent("token.cleanup_"+action, token))
+		if err != nil {
+			// Stop here; what was queued so far is still applied and counted
+			failed = err
+			break
+		}
+		cutoff := strconv.FormatInt(d.before, 10)
+		switch {
+		case d.held:
+			cutoff = "held"
+		case !d.hasKeepalive:
+			cutoff = "none"
+		}
+
+		writer.Queue(ctx, token, d.action, func(pipe redis.Pipeliner) *redis.Cmd {
+			return cleanupTokenScript.EvalSha(ctx, pipe, []string{
+				set, keys.available, keys.keepalive, constants.KeyTokenOwners, constants.KeyAssignmentSlots,
+				callbackKey(token), recordKey(token),
+				constants.KeyTokenPoolIndex, constants.KeyTokenCiphertext, constants.KeyTokenLabels,
+				constants.KeyTokenRateLimits, constants.KeyTokenProbes, constants.KeyAliasOfToken,
+				constants.KeyTokenAliases, lockKey(token),
+			}, append([]any{token, action, cutoff, now, alias, d.before}, event...)...)
+		})
+		switch {
+		case d.action == actionRelease:
+			slog.Debug("Returning token to pool (keepalive grace elapsed)", slog.String("token", logging.Token(token)))
+		case d.assigned:
+			slog.Debug("Deleting assigned token (idle past deletion threshold or no keepalive)", slog.String("token", logging.Token(token)))
+		}
+	}
+
+	err = writer.Flush(ctx)
+	result := writer.Result()
+	if err != nil {
+		result.ProcessingError = fmt.Errorf("failed to execute cleanup writes: %w", err)
+	} else if failed != nil {
+		result.ProcessingError = failed
+	}
+	return result
+}
+
+// cleanupAction records what a queued write does to a token, for accounting
+type cleanupAction int
+
+const (
+	actionRelease cleanupAction = iota
+	actionDelete
+)
+
+// queuedWrite is the guarded write applied to a single token. Its command
+// returns 1 when the write was made and 0 when the token was skipped.
+type queuedWrite struct {
+	token  string
+	action cleanupAction
+	apply  func(redis.Pipeliner) *redis.Cmd
+}
+
+// pipelineWriter buffers writes and executes them in chunks of capped size.
+// A chunk that fails is retried on its own, so earlier chunks are never replayed.
+// Only writes that were made, rather than skipped, are counted.
+type pipelineWriter struct {
+	client       *redis.Client
+	limit        int
+	retries      int
+	timeout      time.Duration
+	chunk        []queuedWrite
+	pending      int
+	released     int
+	deleted      int
+	failedChunks int
+	err          error
+
+	releasedTokens []string
+	deletedTokens  []string
+}
+
+func newPipelineWriter(client *redis.Client, cfg CleanupConfig) *pipelineWriter {
+	return &pipelineWriter{client: client, limit: cfg.PipelineSize, retries: cfg.ChunkRetries, timeout: cfg.OperationTimeout}
+}
+
+// Queue adds a token's write, flushing first if it would overflow the cap
+func (w *pipelineWriter) Queue(ctx context.Context, token string, action cleanupAction, apply func(redis.Pipeliner) *redis.Cmd) {
+	if w.pending > 0 && w.pending+1 > w.limit {
+		w.exec(ctx)
+	}
+	w.chunk = append(w.chunk, queuedWrite{token: token, action: action, apply: apply})
+	w.pending++
+}
+
+// Flush executes any remaining writes and returns the first chunk error seen
+func (w *pipelineWriter) Flush(ctx context.Context) error {
+	if w.pending > 0 {
+		w.exec(ctx)
+	}
+	return w.err
+}
+
+// Result reports counts for the chunks that were actually committed
+func (w *pipelineWriter) Result() CleanupResult {
+	return CleanupResult{
+		TokensReleased: w.released,
+		TokensDeleted:  w.deleted,
+		ChunksFailed:   w.failedChunks,
+		releasedTokens: w.releasedTokens,
+		deletedTokens:  w.deletedTokens,
+	}
+}
+
+func (w *pipelineWriter) exec(ctx context.Context) {
+	defer func() {
+		w.chunk = w.chunk[:0]
+		w.pending = 0
+	}()
+
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			slog.Warn("Retrying cleanup chunk",
+				slog.Int("commands", w.pending),
+				slog.Int("attempt", attempt),
+				slog.Int("max_attempts", w.retries),
+				slog.String("error", err.Error()))
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				attempt = w.retries
+				continue
+			case <-time.After(time.Duration(attempt) * constants.CleanupChunkRetryBackoff):
+			}
+		}
+
+		// Writes recheck the token before changing it, so replaying a chunk is
+		// safe; a write that already landed is skipped the second time
+		pipe := w.client.TxPipeline()
+		cmds := make([]*redis.Cmd, len(w.chunk))
+		for i, write := range w.chunk {
+			cmds[i] = write.apply(pipe)
+		}
+		opCtx, cancel := context.WithTimeout(ctx, w.timeout)
+		_, err = pipe.Exec(opCtx)
+		cancel()
+		if err == nil {
+			for i, write := range w.chunk {
+				if applied, _ := cmds[i].Int(); applied == 0 {
+					slog.Debug("Skipped cleanup of token changed since its snapshot", slog.String("token", logging.Token(write.token)))
+					continue
+				}
+				if write.action == actionRelease {
+					w.released++
+					w.releasedTokens = track(w.releasedTokens, write.token)
+				} else {
+					w.deleted++
+					w.deletedTokens = track(w.deletedTokens, write.token)
+				}
+			}
+			return
+		}
+	}
+
+	w.failedChunks++
+	if w.err == nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireCleanupScript takes every cleanup lock in KEYS for run ARGV[1], with
+// a TTL of ARGV[2] ms, or none of them: if one is already held it returns
+// that key and nothing changes.
+var acquireCleanupScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		return key
+	end
+end
+for _, key in ipairs(KEYS) do
+	redis.call('SET', key, ARGV[1], 'PX', ARGV[2])
+end
+return false
+`)
+
+// releaseCleanupScript drops the cleanup locks in KEYS that run ARGV[1] still
+// holds, leaving any that expired and were taken by another run
+var releaseCleanupScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('GET', key) == ARGV[1] then
+		redis.call('DEL', key)
+	end
+end
+return 0
+`)
+
+// cleanupLockKeys are the locks a run of phase over pools holds: one per pool
+// and phase, so with the parallel order a pool's release and deletion sweeps
+// still overlap while two runs of the same phase never do
+func cleanupLockKeys(pools []string, phase CleanupPhase) []string {
+	var keys []string
+	for _, pool := range pools {
+		for _, p := range []CleanupPhase{PhaseRelease, PhaseDelete} {
+			if phase&p != 0 {
+				keys = append(keys, constants.PrefixCleanupLockKey+":"+pool+":"+p.String())
+			}
+		}
+	}
+	return keys
+}
+
+// lockCleanup takes the cleanup locks of pools for phase, shared by every
+// replica, so a manual run and a scheduled sweep can't work on the same
+// tokens at once. ErrCleanupInProgress names the pool another run holds. The
+// locks outlive the run's time budget only by the operation timeout, in case
+// a crashed replica never releases them.
+func (r *TokenRepository) lockCleanup(ctx context.Context, pools []string, phase CleanupPhase) (func(), error) {
+	keys := cleanupLockKeys(pools, phase)
+	run := uuid.New().String()
+	ttl := r.Cleanup.CycleTimeout + r.Cleanup.OperationTimeout
+	held, err := acquireCleanupScript.Run(ctx, r.RedisClient, keys, run, ttl.Milliseconds()).Text()
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		return nil, fmt.Errorf("failed to take cleanup lock: %w", err)
+	default:
+		pool := strings.TrimPrefix(held, constants.PrefixCleanupLockKey+":")
+		pool = pool[:strings.LastIndex(pool, ":")]
+		return nil, fmt.Errorf("%w: %s", constants.ErrCleanupInProgress, pool)
+	}
+
+	return func() {
+		// Released even if the run's own context was cancelled
+		ctx, cancel := r.opContext(context.WithoutCancel(ctx))
+		defer cancel()
+		releaseCleanupScript.Run(ctx, r.RedisClient, keys, run)
+	}, nil
+}
+
+// RunningCleanups lists the pools and phases cleanup runs currently hold, as
+// pool:phase
+func (r *TokenRepository) RunningCleanups(ctx context.Context) ([]string, error) {
+	prefix := constants.PrefixCleanupLockKey + ":"
+	running := []string{}
+	iter := r.RedisClient.Scan(ctx, 0, prefix+"*", constants.StreamScanCount).Iterator()
+	for iter.Next(ctx) {
+		running = append(running, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list running cleanups: %w", err)
+	}
+	return running, nil
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// CleanupPause describes a pause of scheduled cleanup
+type CleanupPause struct {
+	Paused bool       `json:"paused"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"` // when it lifts by itself; unset holds until resumed
+	Actor  string     `json:"actor,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// PauseCleanup pauses scheduled cleanup on every replica, for duration when
+// it is above 0 and until ResumeCleanup otherwise. Pausing again replaces the
+// previous pause. It takes an event (WithEvent).
+func (r *TokenRepository) PauseCleanup(ctx context.Context, actor, reason string, duration time.Duration) (CleanupPause, error) {
+	now := r.Now()
+	pause := CleanupPause{Paused: true, Since: &now, Actor: actor, Reason: reason}
+
+	pipe := r.RedisClient.TxPipeline()
+	pipe.Del(ctx, constants.KeyCleanupPause)
+	pipe.HSet(ctx, constants.KeyCleanupPause, "since", now.Unix(), "actor", actor, "reason", reason)
+	if duration > 0 {
+		until := now.Add(duration)
+		pause.Until = &until
+		pipe.HSet(ctx, constants.KeyCleanupPause, "until", until.Unix())
+		pipe.PExpire(ctx, constants.KeyCleanupPause, duration)
+	}
+	if err := r.queueEvent(ctx, pipe, "", AuditEntry{Action: "cleanup.pause"}); err != nil {
+		return CleanupPause{}, err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return CleanupPause{}, fmt.Errorf("failed to pause cleanup: %w", err)
+	}
+	return pause, nil
+}
+
+// resumeCleanupScript deletes the pause KEYS[1], returning 0 when there was
+// none. It takes an event (outboxLua), recorded only when a pause was lifted.
+var resumeCleanupScript = redis.NewScript(outboxLua + `
+if redis.call('DEL', KEYS[1]) == 0 then
+	return 0
+end
+outbox()
+return 1
+`)
+
+// ResumeCleanup lifts a pause and reports whether cleanup was paused. It
+// takes an event (WithEvent).
+func (r *TokenRepository) ResumeCleanup(ctx context.Context) (bool, error) {
+	event, err := r.eventArgs(ctx, "", AuditEntry{Action: "cleanup.resume"})
+	if err != nil {
+		return false, err
+	}
+	n, err := resumeCleanupScript.Run(ctx, r.RedisClient, []string{constants.KeyCleanupPause}, event...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to resume cleanup: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CleanupPaused returns the current pause of scheduled cleanup, if any
+func (r *TokenRepository) CleanupPaused(ctx context.Context) (CleanupPause, error) {
+	fields, err := r.RedisClient.HGetAll(ctx, constants.KeyCleanupPause).Result()
+	if err != nil && err != redis.Nil {
+		return CleanupPause{}, fmt.Errorf("failed to read cleanup pause: %w", err)
+	}
+	if len(fields) == 0 {
+		return CleanupPause{}, nil
+	}
+
+	pause := CleanupPause{Paused: true, Actor: fields["actor"], Reason: fields["reason"]}
+	if since, err := strconv.ParseInt(fields["since"], 10, 64); err == nil {
+		pause.Since = timeOrNil(time.Unix(since, 0))
+	}
+	if until, err := strconv.ParseInt(fields["until"], 10, 64); err == nil {
+		pause.Until = timeOrNil(time.Unix(until, 0))
+	}
+	return pause, nil
+}
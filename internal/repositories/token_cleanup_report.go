@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// CleanupReport describes what a cleanup run did, phase by phase. Durations
+// are whole milliseconds and phase names are fixed strings, so the report
+// reads the same to any client regardless of locale.
+type CleanupReport struct {
+	Phases     []CleanupPhaseReport `json:"phases"`
+	Scanned    int                  `json:"scanned"`
+	Released   int                  `json:"released"`
+	Deleted    int                  `json:"deleted"`
+	DurationMs int64                `json:"duration_ms"`
+	Errors     []string             `json:"errors,omitempty"`
+}
+
+// CleanupPhaseReport is one pass of a cleanup run
+type CleanupPhaseReport struct {
+	Phase        string `json:"phase"` // release, delete or all
+	Pools        int    `json:"pools"`
+	Scanned      int    `json:"scanned"`
+	Released     int    `json:"released"`
+	Deleted      int    `json:"deleted"`
+	ChunksFailed int    `json:"chunks_failed"`
+	DurationMs   int64  `json:"duration_ms"`
+	Error        string `json:"error,omitempty"`
+
+	// The refs of the tokens touched, listed only when there are at most
+	// CleanupReportTokenLimit of them
+	ReleasedTokens []string `json:"released_tokens,omitempty"`
+	DeletedTokens  []string `json:"deleted_tokens,omitempty"`
+}
+
+// add appends a pass to the report and rolls its counts into the totals
+func (report *CleanupReport) add(phase CleanupPhase, pools int, result CleanupResult, took time.Duration) {
+	pass := CleanupPhaseReport{
+		Phase:        phase.String(),
+		Pools:        pools,
+		Scanned:      result.TokensScanned,
+		Released:     result.TokensReleased,
+		Deleted:      result.TokensDeleted,
+		ChunksFailed: result.ChunksFailed,
+		DurationMs:   took.Milliseconds(),
+	}
+	if result.TokensReleased <= constants.CleanupReportTokenLimit {
+		pass.ReleasedTokens = result.releasedTokens
+	}
+	if result.TokensDeleted <= constants.CleanupReportTokenLimit {
+		pass.DeletedTokens = result.deletedTokens
+	}
+	if result.ProcessingError != nil {
+		pass.Error = result.ProcessingError.Error()
+		report.Errors = append(report.Errors, phase.String()+": "+pass.Error)
+	}
+
+	report.Phases = append(report.Phases, pass)
+	report.Scanned += pass.Scanned
+	report.Released += pass.Released
+	report.Deleted += pass.Deleted
+	report.DurationMs += pass.DurationMs
+}
+
+// Counts summarises the report for sweep metrics
+func (report CleanupReport) Counts() map[string]int64 {
+	return map[string]int64{"released": int64(report.Released), "deleted": int64(report.Deleted)}
+}
@@ -0,0 +1,223 @@
+package repositories
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// assignTokens assigns each of tokens to client
+func assignTokens(t *testing.T, r *TokenRepository, client string, tokens ...string) {
+	t.Helper()
+	for _, token := range tokens {
+		if _, err := r.AssignSpecificToken(context.Background(), token, client); err != nil {
+			t.Fatalf("AssignSpecificToken(%s): %v", token, err)
+		}
+	}
+}
+
+func TestCleanupReleasesLapsedTokens(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "lapsed", "live")
+	assignTokens(t, r, "client-a", "lapsed", "live")
+
+	// Past the minute's grace but nowhere near the hour's deletion
+	setExpiry(t, r, "default", "lapsed", time.Now().Add(-5*time.Minute))
+
+	report, err := r.CleanupPool(ctx, "default", PhaseRelease)
+	if err != nil {
+		t.Fatalf("CleanupPool: %v", err)
+	}
+	if n := report.Released; n != 1 {
+		t.Errorf("released %d tokens, want 1", n)
+	}
+
+	keys := keysFor("default")
+	if !member(t, r, keys.available, "lapsed") || member(t, r, keys.assigned, "lapsed") {
+		t.Error("lapsed token is not back in the pool")
+	}
+	if !member(t, r, keys.assigned, "live") {
+		t.Error("live token was released")
+	}
+	if owner, _ := r.RedisClient.HGet(ctx, constants.KeyTokenOwners, "lapsed").Result(); owner != "" {
+		t.Errorf("released token still owned by %q", owner)
+	}
+	if record := recordOf(t, r, "lapsed"); record.State != TokenStateAvailable || record.Owner != "" {
+		t.Errorf("record = %+v, want available with no owner", record)
+	}
+	if got := eventsOf(t, r, "lapsed"); got[len(got)-1] != "token.cleanup_release" {
+		t.Errorf("events = %v, want them to end with token.cleanup_release", got)
+	}
+}
+
+func TestCleanupDeletesIdleTokens(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "held", "idle", "fresh")
+	assignTokens(t, r, "client-a", "held")
+	held, idle := "held", "idle"
+
+	// An assigned token without a keepalive score is deleted, like one idle past the hour
+	if err := r.RedisClient.ZRem(ctx, keysFor("default").keepalive, held).Err(); err != nil {
+		t.Fatal(err)
+	}
+	setExpiry(t, r, "default", idle, time.Now().Add(-2*time.Hour))
+
+	report, err := r.CleanupPool(ctx, "default", PhaseDelete)
+	if err != nil {
+		t.Fatalf("CleanupPool: %v", err)
+	}
+	if n := report.Deleted; n != 2 {
+		t.Errorf("deleted %d tokens, want 2", n)
+	}
+
+	for _, token := range []string{held, idle} {
+		if state, err := r.StateOf(ctx, token); err != constants.ErrTokenNotFound {
+			t.Errorf("StateOf(%s) = %q, %v; want it deleted", token, state, err)
+		}
+		if indexed, _ := r.RedisClient.HExists(ctx, constants.KeyTokenPoolIndex, token).Result(); indexed {
+			t.Errorf("pool index entry of %s survived", token)
+		}
+		if exists, _ := r.RedisClient.Exists(ctx, recordKey(token)).Result(); exists != 0 {
+			t.Errorf("record of %s survived", token)
+		}
+		if got := eventsOf(t, r, token); got[len(got)-1] != "token.cleanup_delete" {
+			t.Errorf("events of %s = %v, want them to end with token.cleanup_delete", token, got)
+		}
+	}
+	remaining, _ := r.GetAvailableTokens(ctx, "default")
+	if len(remaining) != 1 {
+		t.Errorf("available = %v, want only the fresh token", remaining)
+	}
+}
+
+func TestCleanupDeleteDropsAlias(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	if err := r.SetAlias(ctx, "tok-1", "nick"); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+	setExpiry(t, r, "default", "tok-1", time.Now().Add(-2*time.Hour))
+
+	if _, err := r.CleanupPool(ctx, "default", PhaseDelete); err != nil {
+		t.Fatalf("CleanupPool: %v", err)
+	}
+	if n, _ := r.RedisClient.HLen(ctx, constants.KeyTokenAliases).Result(); n != 0 {
+		t.Error("alias -> token entry survived the delete")
+	}
+	if n, _ := r.RedisClient.HLen(ctx, constants.KeyAliasOfToken).Result(); n != 0 {
+		t.Error("token -> alias entry survived the delete")
+	}
+}
+
+func TestCleanupSkipsTokensChangedAfterTheSnapshot(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "kept", "released", "lapsed")
+	assignTokens(t, r, "client-a", "kept", "released", "lapsed")
+	for _, token := range []string{"kept", "released", "lapsed"} {
+		setExpiry(t, r, "default", token, time.Now().Add(-5*time.Minute))
+	}
+
+	now := r.Now().Unix()
+	releaseBefore, deleteBefore := now-60, now-3600
+	snapshot, err := r.snapshotPool(ctx, "default", PhaseRelease, releaseBefore, false)
+	if err != nil {
+		t.Fatalf("snapshotPool: %v", err)
+	}
+	decisions := decideCleanup(snapshot, PhaseRelease, releaseBefore, deleteBefore, 0)
+	if len(decisions) != 3 {
+		t.Fatalf("decided %d releases, want 3", len(decisions))
+	}
+
+	// Between deciding and applying, one token is kept alive and one released by its owner
+	if err := r.KeepAlive(ctx, "kept", KeepaliveOptions{}); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	if err := r.UnblockToken(ctx, "released", 0); err != nil {
+		t.Fatalf("UnblockToken: %v", err)
+	}
+
+	result := r.applyCleanup(ctx, cleanupBatch{pool: "default", keys: snapshot.keys, decisions: decisions})
+	if result.ProcessingError != nil {
+		t.Fatalf("applyCleanup: %v", result.ProcessingError)
+	}
+	if result.TokensReleased != 1 || !slices.Equal(result.releasedTokens, []string{"lapsed"}) {
+		t.Errorf("released %v, want only the lapsed token", result.releasedTokens)
+	}
+	if !member(t, r, keysFor("default").assigned, "kept") {
+		t.Error("token kept alive after the snapshot was released")
+	}
+	if got := eventsOf(t, r, "released"); slices.Contains(got, "token.cleanup_release") {
+		t.Errorf("events of the token released by its owner = %v, want no cleanup release", got)
+	}
+}
+
+func TestCleanupReleasesTokensHeldForMaxHold(t *testing.T) {
+	timing := testTiming
+	timing.MaxHold = time.Hour
+	r, _ := newTestRepository(t, timing)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1", "tok-2")
+	assignTokens(t, r, "client-a", "tok-1", "tok-2")
+
+	// Both kept alive, both assigned over an hour ago
+	longAgo := time.Now().Add(-2 * time.Hour).Unix()
+	for _, token := range []string{"tok-1", "tok-2"} {
+		setRecord(t, r, token, "assigned_at", longAgo)
+	}
+
+	now := r.Now().Unix()
+	releaseBefore, deleteBefore, holdBefore := now-60, now-3600, timing.heldBefore(now)
+	snapshot, err := r.snapshotPool(ctx, "default", PhaseRelease, releaseBefore, true)
+	if err != nil {
+		t.Fatalf("snapshotPool: %v", err)
+	}
+	if snapshot.heldSince["tok-1"] != longAgo {
+		t.Fatalf("snapshot has tok-1 held since %d, want %d", snapshot.heldSince["tok-1"], longAgo)
+	}
+	decisions := decideCleanup(snapshot, PhaseRelease, releaseBefore, deleteBefore, holdBefore)
+	if len(decisions) != 2 {
+		t.Fatalf("decided %d releases, want both tokens", len(decisions))
+	}
+
+	// tok-2 is released and assigned again after the snapshot: its new
+	// assignment hasn't been held for long
+	setRecord(t, r, "tok-2", "assigned_at", now)
+
+	result := r.applyCleanup(ctx, cleanupBatch{pool: "default", keys: snapshot.keys, decisions: decisions})
+	if result.ProcessingError != nil {
+		t.Fatalf("applyCleanup: %v", result.ProcessingError)
+	}
+	if !slices.Equal(result.releasedTokens, []string{"tok-1"}) {
+		t.Errorf("released %v, want only tok-1", result.releasedTokens)
+	}
+	if !member(t, r, keysFor("default").assigned, "tok-2") {
+		t.Error("reassigned token was released")
+	}
+}
+
+func TestCleanupDeletionPassRunsBothPhases(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1", "tok-2")
+	assignTokens(t, r, "client-a", "tok-1", "tok-2")
+	setExpiry(t, r, "default", "tok-1", time.Now().Add(-5*time.Minute))
+	setExpiry(t, r, "default", "tok-2", time.Now().Add(-2*time.Hour))
+
+	report, err := r.DeletionPass(ctx, "default")
+	if err != nil {
+		t.Fatalf("DeletionPass: %v", err)
+	}
+	if len(report.Phases) != 2 || report.Released != 1 || report.Deleted != 1 {
+		t.Errorf("report = %+v, want a release and a delete phase, one token each", report)
+	}
+	if !member(t, r, keysFor("default").available, "tok-1") {
+		t.Error("lapsed token is not back in the pool")
+	}
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// Now is the time keepalive scores, cleanup and the other shared timestamps
+// are measured against; callers scheduling against those use it too. With RedisClock it is Redis TIME, tracked as an
+// offset from the local clock that SyncClock keeps current, so replicas agree
+// on expiry however far their own clocks drift; otherwise it is the local
+// clock.
+func (r *TokenRepository) Now() time.Time {
+	if !r.RedisClock {
+		return time.Now()
+	}
+	return time.Now().Add(time.Duration(r.clockOffset.Load()))
+}
+
+// SyncClock reads Redis TIME and records how far it is ahead of the local
+// clock, taking the midpoint of the round trip as the moment it was read. The
+// offset is returned whether or not RedisClock uses it, so skew can be
+// reported either way.
+func (r *TokenRepository) SyncClock(ctx context.Context) (time.Duration, error) {
+	sent := time.Now()
+	redisNow, err := r.RedisClient.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	offset := redisNow.Sub(sent.Add(received.Sub(sent) / 2))
+	r.clockOffset.Store(int64(offset))
+	return offset, nil
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// recentAssignmentKey identifies one client's assign request: same pool, same selector
+func recentAssignmentKey(client, pool string, selector map[string]string) string {
+	labels := make([]string, 0, len(selector))
+	for key, value := range selector {
+		labels = append(labels, key+"="+value)
+	}
+	slices.Sort(labels)
+	return constants.PrefixRecentAssignKey + ":" + pool + ":" + strings.Join(labels, ",") + ":" + client
+}
+
+// RecentAssignment returns the token a client was given for the same request
+// within the duplicate-suppression window, as long as it is still assigned to
+// that client: one released and reassigned, or transferred, to someone else
+// is never handed back. A nil token means there is nothing to reuse.
+func (r *TokenRepository) RecentAssignment(ctx context.Context, client, pool string, selector map[string]string) (*Token, error) {
+	value, err := r.RedisClient.Get(ctx, recentAssignmentKey(client, pool, selector)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent assignment: %w", err)
+	}
+
+	// Pool names can't contain ':', so the ref is everything after the first one
+	servedPool, ref, _ := strings.Cut(value, ":")
+	pipe := r.RedisClient.Pipeline()
+	assigned := pipe.SIsMember(ctx, keysFor(servedPool).assigned, ref)
+	record := pipe.HGetAll(ctx, recordKey(ref))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check recent assignment: %w", err)
+	}
+	// Without a record the holder can't be told, so the retry takes a fresh token
+	held := parseRecord(record.Val())
+	if !assigned.Val() || held == nil || held.Owner != client {
+		return nil, nil
+	}
+
+	revealed, err := r.revealOne(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{Value: revealed, Pool: servedPool, State: TokenStateAssigned}
+	token.applyRecord(held)
+	return token, nil
+}
+
+// RememberAssignment records the token handed to a client so a retry within
+// window gets the same token back
+func (r *TokenRepository) RememberAssignment(ctx context.Context, client, pool string, selector map[string]string, token *Token, window time.Duration) error {
+	value := token.Pool + ":" + r.ref(token.Value)
+	if err := r.RedisClient.Set(ctx, recentAssignmentKey(client, pool, selector), value, window).Err(); err != nil {
+		return fmt.Errorf("failed to remember assignment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/callbacks"
+	"github.com/redis/go-redis/v9"
+)
+
+// RecordDelivery adds a callback attempt to the delivery log
+func (r *TokenRepository) RecordDelivery(ctx context.Context, delivery callbacks.Delivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery: %w", err)
+	}
+	err = r.RedisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: constants.KeyCallbackDeliveries,
+		MaxLen: constants.CallbackDeliveriesMaxLen,
+		Approx: true,
+		Values: map[string]any{"delivery": encoded},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to record delivery: %w", err)
+	}
+	return nil
+}
+
+// Deliveries returns up to limit callback attempts, newest first
+func (r *TokenRepository) Deliveries(ctx context.Context, limit int) ([]callbacks.Delivery, error) {
+	messages, err := r.RedisClient.XRevRangeN(ctx, constants.KeyCallbackDeliveries, "+", "-", int64(limit)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deliveries: %w", err)
+	}
+	deliveries := make([]callbacks.Delivery, 0, len(messages))
+	for _, msg := range messages {
+		raw, _ := msg.Values["delivery"].(string)
+		var delivery callbacks.Delivery
+		if err := json.Unmarshal([]byte(raw), &delivery); err != nil {
+			return nil, fmt.Errorf("failed to decode delivery %s: %w", msg.ID, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
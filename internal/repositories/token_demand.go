@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+func assignOutcomesKey(pool string, minute int64) string {
+	return constants.PrefixAssignOutcomesKey + ":" + pool + ":" + strconv.FormatInt(minute, 10)
+}
+
+// RecordAssignOutcome counts an assign attempt on a pool in the current
+// minute, and whether it found the pool empty, shared by every replica
+func (r *TokenRepository) RecordAssignOutcome(ctx context.Context, pool string, empty bool) error {
+	key := assignOutcomesKey(pool, r.Now().Unix()/60)
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HIncrBy(ctx, key, "attempts", 1)
+	if empty {
+		pipe.HIncrBy(ctx, key, "failures", 1)
+	}
+	pipe.Expire(ctx, key, constants.AssignOutcomesTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record assign outcome: %w", err)
+	}
+	return nil
+}
+
+// AssignOutcomes returns how many assign attempts a pool had in the last
+// window, and how many of them found it empty
+func (r *TokenRepository) AssignOutcomes(ctx context.Context, pool string, window time.Duration) (int64, int64, error) {
+	now := r.Now().Unix() / 60
+	minutes := max(int64(window/time.Minute), 1)
+	pipe := r.RedisClient.Pipeline()
+	cmds := make([]*redis.SliceCmd, 0, minutes)
+	for m := now - minutes + 1; m <= now; m++ {
+		cmds = append(cmds, pipe.HMGet(ctx, assignOutcomesKey(pool, m), "attempts", "failures"))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to read assign outcomes: %w", err)
+	}
+
+	var attempts, failures int64
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		attempts += parseCount(values[0])
+		failures += parseCount(values[1])
+	}
+	return attempts, failures, nil
+}
+
+// RetireAvailable deletes up to n tokens sitting in a pool's available set
+// and returns how many went. They are popped first, so none can be assigned
+// while being deleted.
+func (r *TokenRepository) RetireAvailable(ctx context.Context, pool string, n int) (int, error) {
+	refs, err := r.RedisClient.SPopN(ctx, keysFor(pool).available, int64(n)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pop surplus tokens: %w", err)
+	}
+	for i, ref := range refs {
+		if err := r.deleteRef(ctx, pool, ref, 0); err != nil {
+			// Put back what wasn't deleted rather than leave it out of the pool
+			r.RedisClient.SAdd(ctx, keysFor(pool).available, refs[i:])
+			return i, err
+		}
+	}
+	return len(refs), nil
+}
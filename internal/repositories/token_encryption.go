@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/secrets"
+)
+
+// ref returns the ID a token is stored under. With encryption enabled every
+// set, hash and lock uses the HMAC index; otherwise it is the token itself.
+// Hash-only handles are already stored as-is.
+func (r *TokenRepository) ref(token string) string {
+	if r.Cipher == nil || secrets.IsHandle(token) {
+		return token
+	}
+	return r.Cipher.Index(token)
+}
+
+// storeCiphertext returns the encrypted value to keep alongside the ref, or
+// "" when encryption is disabled. It is bound to the ref, so it can't be
+// copied to another token's entry.
+func (r *TokenRepository) storeCiphertext(token string) (string, error) {
+	if r.Cipher == nil {
+		return "", nil
+	}
+	ciphertext, err := r.Cipher.Encrypt(token, r.ref(token))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// reveal maps stored refs back to token values. Refs without ciphertext are
+// returned unchanged, which covers tokens saved before encryption was enabled.
+func (r *TokenRepository) reveal(ctx context.Context, refs []string) ([]string, error) {
+	if r.Cipher == nil || len(refs) == 0 {
+		return refs, nil
+	}
+
+	ciphertexts, err := r.RedisClient.HMGet(ctx, constants.KeyTokenCiphertext, refs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token ciphertext: %w", err)
+	}
+
+	tokens := make([]string, len(refs))
+	for i, ref := range refs {
+		ciphertext, ok := ciphertexts[i].(string)
+		if !ok {
+			tokens[i] = ref
+			continue
+		}
+		if tokens[i], err = r.decrypt(ref, ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// decrypt opens the ciphertext stored under ref. Ciphertexts stored before
+// they were bound to their ref are accepted only when they hold the token
+// the ref was derived from.
+func (r *TokenRepository) decrypt(ref, ciphertext string) (string, error) {
+	token, err := r.Cipher.Decrypt(ciphertext, ref)
+	if err == nil {
+		return token, nil
+	}
+	if legacy, legacyErr := r.Cipher.Decrypt(ciphertext, ""); legacyErr == nil && r.ref(legacy) == ref {
+		return legacy, nil
+	}
+	return "", fmt.Errorf("token %s: %w", ref, err)
+}
+
+func (r *TokenRepository) revealOne(ctx context.Context, ref string) (string, error) {
+	tokens, err := r.reveal(ctx, []string{ref})
+	if err != nil {
+		return "", err
+	}
+	return tokens[0], nil
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/encryption"
+)
+
+func TestCiphertextIsBoundToItsRef(t *testing.T) {
+	cipher, err := encryption.NewCipher("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, mr := newTestRepository(t, testTiming)
+	r.Cipher = cipher
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1", "tok-2")
+
+	tokens, err := r.reveal(ctx, []string{r.ref("tok-1"), r.ref("tok-2")})
+	if err != nil || tokens[0] != "tok-1" || tokens[1] != "tok-2" {
+		t.Fatalf("reveal = %v, %v", tokens, err)
+	}
+
+	// A ciphertext copied to another token's entry doesn't open there
+	mr.HSet(constants.KeyTokenCiphertext, r.ref("tok-2"), mr.HGet(constants.KeyTokenCiphertext, r.ref("tok-1")))
+	if token, err := r.revealOne(ctx, r.ref("tok-2")); err == nil {
+		t.Errorf("moved ciphertext revealed %q", token)
+	}
+
+	// One stored before ciphertexts were bound opens only under its own ref
+	legacy, err := cipher.Encrypt("tok-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.HSet(constants.KeyTokenCiphertext, r.ref("tok-1"), legacy)
+	mr.HSet(constants.KeyTokenCiphertext, r.ref("tok-2"), legacy)
+	if token, err := r.revealOne(ctx, r.ref("tok-1")); err != nil || token != "tok-1" {
+		t.Errorf("legacy ciphertext = %q, %v; want tok-1", token, err)
+	}
+	if token, err := r.revealOne(ctx, r.ref("tok-2")); err == nil {
+		t.Errorf("legacy ciphertext under another ref revealed %q", token)
+	}
+}
+
+func TestWebhookSecretsAreBoundToTheirWebhook(t *testing.T) {
+	cipher, err := encryption.NewCipher("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, mr := newTestRepository(t, testTiming)
+	r.Cipher = cipher
+	ctx := context.Background()
+
+	// Two webhooks sealed before secrets were bound, and a bound one
+	legacy, err := cipher.Encrypt("legacy-secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.HSet(constants.KeyWebhooks, "hook-1", `{"id":"hook-1","secret":"`+legacy+`"}`)
+	mr.HSet(constants.KeyWebhooks, "hook-2", `{"id":"hook-2","secret":"`+legacy+`"}`)
+	if err := r.SaveWebhook(ctx, Webhook{ID: "hook-3", Secret: "bound-secret"}); err != nil {
+		t.Fatalf("SaveWebhook: %v", err)
+	}
+	if _, err := r.Webhook(ctx, "hook-1"); err == nil {
+		t.Error("unbound secret opened")
+	}
+
+	bound, err := r.BindWebhookSecrets(ctx)
+	if err != nil || bound != 2 {
+		t.Fatalf("BindWebhookSecrets = %d, %v; want both legacy secrets bound", bound, err)
+	}
+	for _, id := range []string{"hook-1", "hook-2"} {
+		if webhook, err := r.Webhook(ctx, id); err != nil || webhook.Secret != "legacy-secret" {
+			t.Errorf("Webhook(%s) = %+v, %v", id, webhook, err)
+		}
+	}
+	if webhook, err := r.Webhook(ctx, "hook-3"); err != nil || webhook.Secret != "bound-secret" {
+		t.Errorf("Webhook(hook-3) = %+v, %v", webhook, err)
+	}
+
+	// A bound secret copied to another webhook doesn't open there
+	mr.HSet(constants.KeyWebhooks, "hook-2", strings.Replace(mr.HGet(constants.KeyWebhooks, "hook-1"), "hook-1", "hook-2", 1))
+	if webhook, err := r.Webhook(ctx, "hook-2"); err == nil {
+		t.Errorf("secret moved from hook-1 revealed %q", webhook.Secret)
+	}
+}
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PublishEvents appends entries to the event stream at key for downstream
+// consumers, trimming it to about maxLen. Each message carries the entry's
+// action as "type", its pool and token ref for filtering without decoding,
+// the audit entry ID as "id" for deduping redeliveries, and the whole entry
+// as JSON under "entry".
+func (r *TokenRepository) PublishEvents(ctx context.Context, key string, maxLen int64, entries []AuditEntry) error {
+	pipe := r.RedisClient.Pipeline()
+	for _, entry := range entries {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode event %s: %w", entry.ID, err)
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: map[string]any{
+				"id":    entry.ID,
+				"type":  entry.Action,
+				"pool":  entry.Pool,
+				"token": entry.Token,
+				"entry": encoded,
+			},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to publish events: %w", err)
+	}
+	return nil
+}
+
+// CreateEventGroups creates the consumer groups downstream services read the
+// event stream at key through, each starting with events published after it
+// was created. Existing groups keep their position.
+func (r *TokenRepository) CreateEventGroups(ctx context.Context, key string, groups []string) error {
+	for _, group := range groups {
+		err := r.RedisClient.XGroupCreateMkStream(ctx, key, group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("failed to create event group %s: %w", group, err)
+		}
+	}
+	return nil
+}
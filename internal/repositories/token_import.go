@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/secrets"
+	"github.com/redis/go-redis/v9"
+)
+
+// ImportSummary counts the rows of a bulk import
+type ImportSummary struct {
+	ID       string `json:"id"`
+	Pool     string `json:"pool"`
+	Rows     int    `json:"rows"` // data rows read, not counting the header
+	Imported int    `json:"imported"`
+	Failed   int    `json:"failed"`
+
+	ReportTruncated bool       `json:"report_truncated,omitempty"` // more rows failed than the report keeps
+	Error           string     `json:"error,omitempty"`            // why the import stopped early, if it did
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"` // nil while the import is queued or running
+
+	BytesTotal int64  `json:"bytes_total"`           // size of the uploaded file
+	BytesRead  int64  `json:"bytes_read"`            // how much of it the import has got through
+	ETASeconds *int64 `json:"eta_seconds,omitempty"` // estimated from the rate so far, while running
+}
+
+// ImportRowError is one row a bulk import rejected, by its line in the file
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func importSummaryKey(id string) string {
+	return constants.PrefixImportSummaryKey + ":" + id
+}
+
+func importReportKey(id string) string {
+	return constants.PrefixImportReportKey + ":" + id
+}
+
+func importUploadKey(id string) string {
+	return constants.PrefixImportUploadKey + ":" + id
+}
+
+// AppendImportUpload stores the next chunk of an import's uploaded file, for
+// whichever replica runs its job to read back with ImportUploadChunk
+func (r *TokenRepository) AppendImportUpload(ctx context.Context, id string, chunk []byte) error {
+	pipe := r.RedisClient.TxPipeline()
+	pipe.RPush(ctx, importUploadKey(id), chunk)
+	pipe.Expire(ctx, importUploadKey(id), constants.ImportReportTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store import upload: %w", err)
+	}
+	return nil
+}
+
+// ImportUploadChunk returns chunk index of an import's uploaded file, or nil
+// past its end
+func (r *TokenRepository) ImportUploadChunk(ctx context.Context, id string, index int64) ([]byte, error) {
+	chunk, err := r.RedisClient.LIndex(ctx, importUploadKey(id), index).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import upload: %w", err)
+	}
+	return chunk, nil
+}
+
+// DropImportUpload removes an import's uploaded file once its job is done with it
+func (r *TokenRepository) DropImportUpload(ctx context.Context, id string) error {
+	if err := r.RedisClient.Del(ctx, importUploadKey(id)).Err(); err != nil {
+		return fmt.Errorf("failed to drop import upload: %w", err)
+	}
+	return nil
+}
+
+// TokensExist reports which of tokens were ever issued, in any pool and
+// state, deleted ones included. Hashed tokens are looked up by their handle.
+func (r *TokenRepository) TokensExist(ctx context.Context, tokens []string, hashed bool) ([]bool, error) {
+	refs := make([]string, len(tokens))
+	for i, token := range tokens {
+		if hashed {
+			refs[i] = secrets.Handle(token)
+		} else {
+			refs[i] = r.ref(token)
+		}
+	}
+	return r.issued(ctx, refs)
+}
+
+// SaveImportSummary stores an import's counts, kept as long as its report
+func (r *TokenRepository) SaveImportSummary(ctx context.Context, summary ImportSummary) error {
+	encoded, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode import summary: %w", err)
+	}
+	if err := r.RedisClient.Set(ctx, importSummaryKey(summary.ID), encoded, constants.ImportReportTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save import summary: %w", err)
+	}
+	return nil
+}
+
+// ImportSummaryOf returns an import's counts, ErrImportNotFound once its
+// report expired
+func (r *TokenRepository) ImportSummaryOf(ctx context.Context, id string) (*ImportSummary, error) {
+	encoded, err := r.RedisClient.Get(ctx, importSummaryKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, constants.ErrImportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import summary: %w", err)
+	}
+	var summary ImportSummary
+	if err := json.Unmarshal(encoded, &summary); err != nil {
+		return nil, fmt.Errorf("failed to decode import summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// AppendImportReport adds rejected rows to an import's report
+func (r *TokenRepository) AppendImportReport(ctx context.Context, id string, rows []ImportRowError) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	values := make([]any, len(rows))
+	for i, row := range rows {
+		encoded, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("failed to encode import row error: %w", err)
+		}
+		values[i] = encoded
+	}
+	pipe := r.RedisClient.TxPipeline()
+	pipe.RPush(ctx, importReportKey(id), values...)
+	pipe.Expire(ctx, importReportKey(id), constants.ImportReportTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to append import report: %w", err)
+	}
+	return nil
+}
+
+// ImportReport returns up to count rejected rows of an import from offset on,
+// in file order
+func (r *TokenRepository) ImportReport(ctx context.Context, id string, offset, count int64) ([]ImportRowError, error) {
+	encoded, err := r.RedisClient.LRange(ctx, importReportKey(id), offset, offset+count-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import report: %w", err)
+	}
+	rows := make([]ImportRowError, len(encoded))
+	for i, e := range encoded {
+		if err := json.Unmarshal([]byte(e), &rows[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode import row error: %w", err)
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// reserveScript claims each token in ARGV for a save, returning 1 for those
+// claimed and 0 for those already issued: in the issued set KEYS[1], which
+// deletion leaves alone, or in the pool index KEYS[2], which covers tokens
+// saved before the issued set existed.
+var reserveScript = redis.NewScript(`
+local claimed = {}
+for i, token in ipairs(ARGV) do
+	if redis.call('HEXISTS', KEYS[2], token) == 1 then
+		claimed[i] = 0
+	else
+		claimed[i] = redis.call('SADD', KEYS[1], token)
+	end
+end
+return claimed
+`)
+
+// reserveRefs claims refs ahead of saving them, so two saves of the same
+// value can't both go through and a deleted or quarantined token's value is
+// never handed out again. It reports which were claimed; the rest are taken.
+func (r *TokenRepository) reserveRefs(ctx context.Context, refs []string) ([]bool, error) {
+	claimed, err := reserveScript.Run(ctx, r.RedisClient, []string{constants.KeyIssuedTokens, constants.KeyTokenPoolIndex}, toAny(refs)...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve tokens: %w", err)
+	}
+	reserved := make([]bool, len(refs))
+	for i, c := range claimed {
+		reserved[i] = c == 1
+	}
+	return reserved, nil
+}
+
+// releaseRefs gives back refs reserved for a save that failed, so it can be
+// retried with the same values
+func (r *TokenRepository) releaseRefs(ctx context.Context, refs []string) {
+	if len(refs) == 0 {
+		return
+	}
+	// Released even if the save's own context was cancelled
+	ctx, cancel := r.opContext(context.WithoutCancel(ctx))
+	defer cancel()
+	r.RedisClient.SRem(ctx, constants.KeyIssuedTokens, toAny(refs)...)
+}
+
+// issued reports which refs were ever saved, including deleted ones
+func (r *TokenRepository) issued(ctx context.Context, refs []string) ([]bool, error) {
+	pipe := r.RedisClient.Pipeline()
+	members := pipe.SMIsMember(ctx, constants.KeyIssuedTokens, toAny(refs)...)
+	pools := pipe.HMGet(ctx, constants.KeyTokenPoolIndex, refs...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to look up tokens: %w", err)
+	}
+	found := members.Val()
+	for i, pool := range pools.Val() {
+		found[i] = found[i] || pool != nil
+	}
+	return found, nil
+}
+
+// WasIssued reports whether a token value was ever saved, including when it
+// has since been deleted
+func (r *TokenRepository) WasIssued(ctx context.Context, token string) (bool, error) {
+	found, err := r.issued(ctx, []string{r.ref(token)})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+func toAny(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
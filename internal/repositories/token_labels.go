@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// labelKey is the index set of tokens in a pool carrying key=value
+func (k poolKeys) labelKey(key, value string) string {
+	return k.labelPrefix + ":" + key + "=" + value
+}
+
+// indexLabels queues the writes that attach labels to a stored token
+func indexLabels(ctx context.Context, pipe redis.Pipeliner, keys poolKeys, ref string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("failed to encode labels: %w", err)
+	}
+	pipe.HSet(ctx, constants.KeyTokenLabels, ref, encoded)
+	for key, value := range labels {
+		pipe.SAdd(ctx, keys.labelKey(key, value), ref)
+	}
+	return nil
+}
+
+// labelsOf returns the labels attached to a stored token
+func (r *TokenRepository) labelsOf(ctx context.Context, ref string) (map[string]string, error) {
+	encoded, err := r.RedisClient.HGet(ctx, constants.KeyTokenLabels, ref).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token labels: %w", err)
+	}
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(encoded), &labels); err != nil {
+		return nil, fmt.Errorf("failed to decode token labels: %w", err)
+	}
+	return labels, nil
+}
+
+// unindexLabels queues the writes that detach a token from its label index sets.
+// Cleanup only drops the labels hash entry; index sets are always intersected
+// with the available set, so leftover members never match.
+func unindexLabels(ctx context.Context, pipe redis.Pipeliner, keys poolKeys, ref string, labels map[string]string) {
+	pipe.HDel(ctx, constants.KeyTokenLabels, ref)
+	for key, value := range labels {
+		pipe.SRem(ctx, keys.labelKey(key, value), ref)
+	}
+}
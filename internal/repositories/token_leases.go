@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// SaveLease records the upstream lease a token was minted under
+func (r *TokenRepository) SaveLease(ctx context.Context, pool, token, leaseID string) error {
+	ref := r.ref(token)
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HSet(ctx, keysFor(pool).leases, ref, leaseID)
+	updateRecord(ctx, pipe, ref, "lease", leaseID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save token lease: %w", err)
+	}
+	return nil
+}
+
+// RetiredLeases returns the leases of tokens that no longer exist, keyed by
+// token ref. Deletion and cleanup drop the token but leave its lease behind
+// so it can be revoked upstream.
+func (r *TokenRepository) RetiredLeases(ctx context.Context, pool string) (map[string]string, error) {
+	leases, err := r.RedisClient.HGetAll(ctx, keysFor(pool).leases).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list token leases: %w", err)
+	}
+	if len(leases) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]string, 0, len(leases))
+	for ref := range leases {
+		refs = append(refs, ref)
+	}
+	pools, err := r.RedisClient.HMGet(ctx, constants.KeyTokenPoolIndex, refs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check leased tokens: %w", err)
+	}
+
+	retired := make(map[string]string)
+	for i, ref := range refs {
+		if pools[i] == nil {
+			retired[ref] = leases[ref]
+		}
+	}
+	return retired, nil
+}
+
+// ForgetLease drops a lease once it has been revoked
+func (r *TokenRepository) ForgetLease(ctx context.Context, pool, ref string) error {
+	if err := r.RedisClient.HDel(ctx, keysFor(pool).leases, ref).Err(); err != nil {
+		return fmt.Errorf("failed to forget token lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// TimingSeconds is a pool's timing in whole seconds, as set through the admin
+// API and stored in Redis. Zero fields inherit the pool's configured timing.
+type TimingSeconds struct {
+	AssignmentTTLSec     int `json:"assignment_ttl_sec"`
+	KeepaliveGraceSec    int `json:"keepalive_grace_sec"` // auto-release: time past expiry before cleanup returns the token
+	DeletionAfterIdleSec int `json:"deletion_after_idle_sec"`
+	LockTTLSec           int `json:"lock_ttl_sec"`
+	MaxHoldSec           int `json:"max_hold_sec"` // longest an assignment may last across keepalives
+}
+
+// SecondsOf expresses a timing in whole seconds
+func SecondsOf(t TimingConfig) TimingSeconds {
+	return TimingSeconds{
+		AssignmentTTLSec:     int(t.AssignmentTTL.Seconds()),
+		KeepaliveGraceSec:    int(t.KeepaliveGrace.Seconds()),
+		DeletionAfterIdleSec: int(t.DeletionAfterIdle.Seconds()),
+		LockTTLSec:           int(t.LockTTL.Seconds()),
+		MaxHoldSec:           int(t.MaxHold.Seconds()),
+	}
+}
+
+func (s TimingSeconds) timing() TimingConfig {
+	return TimingConfig{
+		AssignmentTTL:     time.Duration(s.AssignmentTTLSec) * time.Second,
+		KeepaliveGrace:    time.Duration(s.KeepaliveGraceSec) * time.Second,
+		DeletionAfterIdle: time.Duration(s.DeletionAfterIdleSec) * time.Second,
+		LockTTL:           time.Duration(s.LockTTLSec) * time.Second,
+		MaxHold:           time.Duration(s.MaxHoldSec) * time.Second,
+	}
+}
+
+// TimingOverrideOf returns the runtime timing override stored for a pool
+func (r *TokenRepository) TimingOverrideOf(ctx context.Context, pool string) (TimingSeconds, error) {
+	var override TimingSeconds
+	encoded, err := r.RedisClient.HGet(ctx, constants.KeyPoolTiming, pool).Result()
+	if err == redis.Nil {
+		return override, nil
+	}
+	if err != nil {
+		return override, fmt.Errorf("failed to fetch pool timing: %w", err)
+	}
+	if err := json.Unmarshal([]byte(encoded), &override); err != nil {
+		return override, fmt.Errorf("failed to decode pool timing: %w", err)
+	}
+	return override, nil
+}
+
+// SaveTimingOverride validates and stores a pool's runtime timing and applies
+// it to this replica at once; others pick it up on their next
+// LoadTimingOverrides. An all-zero override removes it. It takes an event
+// (WithEvent).
+func (r *TokenRepository) SaveTimingOverride(ctx context.Context, pool string, override TimingSeconds) error {
+	if _, err := r.TimingWith(pool, override); err != nil {
+		return err
+	}
+
+	pipe := r.RedisClient.TxPipeline()
+	if override == (TimingSeconds{}) {
+		pipe.HDel(ctx, constants.KeyPoolTiming, pool)
+	} else {
+		encoded, err := json.Marshal(override)
+		if err != nil {
+			return fmt.Errorf("failed to encode pool timing: %w", err)
+		}
+		pipe.HSet(ctx, constants.KeyPoolTiming, pool, encoded)
+	}
+	if err := r.queueEvent(ctx, pipe, pool, AuditEntry{Action: "pool.timing"}); err != nil {
+		return err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save pool timing: %w", err)
+	}
+
+	r.timingMu.Lock()
+	defer r.timingMu.Unlock()
+	if r.liveTiming == nil {
+		r.liveTiming = make(map[string]TimingConfig)
+	}
+	if override == (TimingSeconds{}) {
+		delete(r.liveTiming, pool)
+	} else {
+		r.liveTiming[pool] = override.timing()
+	}
+	return nil
+}
+
+// TimingWith returns the timing a pool would run with were override its
+// runtime override, or ErrInvalidTiming if that timing isn't sane
+func (r *TokenRepository) TimingWith(pool string, override TimingSeconds) (TimingConfig, error) {
+	r.timingMu.RLock()
+	base, ok := r.poolTiming[pool]
+	if !ok {
+		base = r.Timing
+	}
+	r.timingMu.RUnlock()
+	timing := override.timing().inherit(base)
+	if err := timing.Validate(); err != nil {
+		return timing, fmt.Errorf("%w: %v", constants.ErrInvalidTiming, err)
+	}
+	return timing.withDefaults(), nil
+}
+
+// LoadTimingOverrides replaces this replica's runtime overrides with those
+// stored in Redis, so every replica converges on the same timing
+func (r *TokenRepository) LoadTimingOverrides(ctx context.Context) error {
+	stored, err := r.RedisClient.HGetAll(ctx, constants.KeyPoolTiming).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load pool timing: %w", err)
+	}
+
+	live := make(map[string]TimingConfig, len(stored))
+	for pool, encoded := range stored {
+		var override TimingSeconds
+		if err := json.Unmarshal([]byte(encoded), &override); err != nil {
+			return fmt.Errorf("failed to decode timing of pool %s: %w", pool, err)
+		}
+		live[pool] = override.timing()
+	}
+
+	r.timingMu.Lock()
+	r.liveTiming = live
+	r.timingMu.Unlock()
+	return nil
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// recordFailureScript counts a failed verification in KEYS[1], whose window
+// of ARGV[1] ms starts at the first failure. Reaching ARGV[2] failures sets
+// the lockout KEYS[2] for ARGV[3] ms and starts the count over; it returns 1
+// when this failure locked the subject out.
+var recordFailureScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if failures >= tonumber(ARGV[2]) then
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[3])
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+return 0
+`)
+
+// RecordVerifyFailure counts a failed verification by subject, shared by
+// every replica, and locks it out for lockout once it fails threshold times
+// within window. It reports whether this failure started the lockout.
+func (r *TokenRepository) RecordVerifyFailure(ctx context.Context, subject string, threshold int, window, lockout time.Duration) (bool, error) {
+	keys := []string{
+		constants.PrefixLockoutFailuresKey + ":" + subject,
+		constants.PrefixLockoutKey + ":" + subject,
+	}
+	locked, err := recordFailureScript.Run(ctx, r.RedisClient, keys, window.Milliseconds(), threshold, lockout.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to record verification failure: %w", err)
+	}
+	return locked == 1, nil
+}
+
+// VerifyLockout returns how long the longest lockout among subjects has left,
+// or 0 if none of them is locked out
+func (r *TokenRepository) VerifyLockout(ctx context.Context, subjects ...string) (time.Duration, error) {
+	pipe := r.RedisClient.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(subjects))
+	for i, subject := range subjects {
+		cmds[i] = pipe.PTTL(ctx, constants.PrefixLockoutKey+":"+subject)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to read verification lockouts: %w", err)
+	}
+
+	var longest time.Duration
+	for _, cmd := range cmds {
+		// Missing keys report a negative TTL
+		longest = max(longest, cmd.Val())
+	}
+	return longest, nil
+}
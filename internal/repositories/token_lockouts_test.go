@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifyFailuresLockOutASubject(t *testing.T) {
+	r, mr := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	const threshold, window, lockout = 3, time.Minute, 15 * time.Minute
+
+	for i := 1; i < threshold; i++ {
+		locked, err := r.RecordVerifyFailure(ctx, "ip:10.0.0.1", threshold, window, lockout)
+		if err != nil || locked {
+			t.Fatalf("failure %d: locked = %v, %v; want not yet", i, locked, err)
+		}
+	}
+	if remaining, _ := r.VerifyLockout(ctx, "ip:10.0.0.1"); remaining != 0 {
+		t.Fatalf("locked out for %s before the threshold", remaining)
+	}
+
+	locked, err := r.RecordVerifyFailure(ctx, "ip:10.0.0.1", threshold, window, lockout)
+	if err != nil || !locked {
+		t.Fatalf("failure %d: locked = %v, %v; want locked", threshold, locked, err)
+	}
+	remaining, err := r.VerifyLockout(ctx, "ip:10.0.0.2", "ip:10.0.0.1")
+	if err != nil || remaining <= 0 || remaining > lockout {
+		t.Errorf("VerifyLockout = %s, %v; want up to %s", remaining, err, lockout)
+	}
+	if other, _ := r.VerifyLockout(ctx, "ip:10.0.0.2"); other != 0 {
+		t.Errorf("another subject is locked out for %s", other)
+	}
+
+	mr.FastForward(lockout)
+	if remaining, _ := r.VerifyLockout(ctx, "ip:10.0.0.1"); remaining != 0 {
+		t.Errorf("still locked out for %s after the lockout ran out", remaining)
+	}
+}
+
+func TestVerifyFailuresOutsideTheWindowAreForgotten(t *testing.T) {
+	r, mr := newTestRepository(t, testTiming)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := r.RecordVerifyFailure(ctx, "ip:10.0.0.1", 3, time.Minute, time.Hour); err != nil {
+			t.Fatalf("RecordVerifyFailure: %v", err)
+		}
+	}
+	mr.FastForward(time.Minute)
+	locked, err := r.RecordVerifyFailure(ctx, "ip:10.0.0.1", 3, time.Minute, time.Hour)
+	if err != nil || locked {
+		t.Errorf("third failure after the window: locked = %v, %v; want a fresh count", locked, err)
+	}
+}
@@ -0,0 +1,179 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// Key families MemoryUsage reports on
+const (
+	MemoryPoolSets    = "pool_sets" // available, assigned, quarantined, pending and reclaim sets
+	MemoryKeepalive   = "keepalive" // keepalive scores and Vault leases
+	MemoryRecords     = "records"   // per token records, estimated from a sample
+	MemoryLabels      = "labels"    // label index sets, estimated from a sample
+	MemoryUtilisation = "usage"     // current utilisation buckets
+	MemoryQueue       = "queue"     // assign wait queue
+	MemoryIndexes     = "indexes"   // hashes and sets shared by every pool
+	MemoryHistory     = "history"   // audit, delivery and job streams
+)
+
+// PoolMemory is an estimate of the bytes a pool's keys take in Redis, by family
+type PoolMemory struct {
+	Pool     string           `json:"pool"`
+	Families map[string]int64 `json:"families"`
+	Total    int64            `json:"total"`
+}
+
+// PoolMemory measures a pool's keys with MEMORY USAGE. Families made of a key
+// per token or label are measured on up to MemorySampleKeys of them and
+// scaled up to the rest, so the figures are estimates.
+func (r *TokenRepository) PoolMemory(ctx context.Context, pool string) (PoolMemory, error) {
+	keys := keysFor(pool)
+	report := PoolMemory{Pool: pool, Families: make(map[string]int64)}
+
+	now := r.Now()
+	fixed := map[string][]string{
+		MemoryPoolSets:    {keys.available, keys.assigned, keys.quarantine, keys.quarantinedAt, keys.pending, keys.reclaims},
+		MemoryKeepalive:   {keys.keepalive, keys.leases},
+		MemoryUtilisation: {keys.usageKey(now), keys.usageKey(now.Add(-time.Minute))},
+		MemoryQueue:       {keys.queue, keys.queueRotation, keys.queueClients, keys.queueCredits},
+	}
+	for family, familyKeys := range fixed {
+		bytes, err := r.memoryOf(ctx, familyKeys)
+		if err != nil {
+			return report, err
+		}
+		report.Families[family] = bytes
+	}
+
+	records, err := r.recordMemory(ctx, pool)
+	if err != nil {
+		return report, err
+	}
+	report.Families[MemoryRecords] = records
+
+	labels, err := r.labelMemory(ctx, pool)
+	if err != nil {
+		return report, err
+	}
+	report.Families[MemoryLabels] = labels
+
+	for _, bytes := range report.Families {
+		report.Total += bytes
+	}
+	return report, nil
+}
+
+// SharedMemory measures the keys no single pool owns: the indexes shared by
+// every pool and the history streams
+func (r *TokenRepository) SharedMemory(ctx context.Context) (map[string]int64, error) {
+	families := map[string][]string{
+		MemoryIndexes: {
+			constants.KeyPools, constants.KeyTokenPoolIndex, constants.KeyIssuedTokens, constants.KeyTokenCiphertext, constants.KeyTokenLabels,
+			constants.KeyTokenAliases, constants.KeyAliasOfToken,
+			constants.KeyTokenRateLimits, constants.KeyTokenProbes, constants.KeyTokenProbeFailures,
+			constants.KeyTokenOwners, constants.KeyAssignmentSlots, constants.KeyPoolTiming,
+		},
+		MemoryHistory: {
+			constants.KeyAuditStream, constants.KeyCallbackDeliveries,
+			constants.KeyJobStream, constants.KeyJobDelayed, constants.KeyJobDeadLetter,
+		},
+	}
+	report := make(map[string]int64, len(families))
+	for family, keys := range families {
+		bytes, err := r.memoryOf(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		report[family] = bytes
+	}
+	return report, nil
+}
+
+// recordMemory estimates the records of a pool's tokens from a random sample
+// of its available and assigned ones
+func (r *TokenRepository) recordMemory(ctx context.Context, pool string) (int64, error) {
+	keys := keysFor(pool)
+	size, err := r.PoolSize(ctx, pool)
+	if err != nil || size == 0 {
+		return 0, err
+	}
+
+	pipe := r.RedisClient.Pipeline()
+	available := pipe.SRandMemberN(ctx, keys.available, constants.MemorySampleKeys)
+	assigned := pipe.SRandMemberN(ctx, keys.assigned, constants.MemorySampleKeys)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to sample pool tokens: %w", err)
+	}
+
+	refs := append(available.Val(), assigned.Val()...)
+	refs = refs[:min(len(refs), constants.MemorySampleKeys)]
+	sample := make([]string, len(refs))
+	for i, ref := range refs {
+		sample[i] = recordKey(ref)
+	}
+	return r.extrapolate(ctx, sample, size)
+}
+
+// labelMemory estimates a pool's label index sets from those a scan finds
+// first. The default pool's unsuffixed prefix also matches other pools' keys,
+// which are told apart by the pool segment before the key=value.
+func (r *TokenRepository) labelMemory(ctx context.Context, pool string) (int64, error) {
+	prefix := keysFor(pool).labelPrefix + ":"
+	var sample []string
+	var total int64
+	iter := r.RedisClient.Scan(ctx, 0, prefix+"*", constants.StreamScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if pool == constants.DefaultPool {
+			rest := strings.TrimPrefix(key, prefix)
+			if segment, _, found := strings.Cut(rest, ":"); found && !strings.Contains(segment, "=") {
+				continue
+			}
+		}
+		total++
+		if len(sample) < constants.MemorySampleKeys {
+			sample = append(sample, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to scan label indexes: %w", err)
+	}
+	return r.extrapolate(ctx, sample, total)
+}
+
+// extrapolate scales the memory of a sample of keys up to total keys like them
+func (r *TokenRepository) extrapolate(ctx context.Context, sample []string, total int64) (int64, error) {
+	if len(sample) == 0 {
+		return 0, nil
+	}
+	bytes, err := r.memoryOf(ctx, sample)
+	if err != nil {
+		return 0, err
+	}
+	return bytes * total / int64(len(sample)), nil
+}
+
+// memoryOf sums MEMORY USAGE over keys; missing keys take none
+func (r *TokenRepository) memoryOf(ctx context.Context, keys []string) (int64, error) {
+	pipe := r.RedisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.MemoryUsage(ctx, key, constants.MemoryUsageSamples)
+	}
+	pipe.Exec(ctx) // errors are checked per command, redis.Nil marking a missing key
+
+	var total int64
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return 0, fmt.Errorf("failed to measure memory usage: %w", err)
+		}
+		total += cmd.Val()
+	}
+	return total, nil
+}
@@ -0,0 +1,79 @@
+package repositories
+
+import "time"
+
+// Token is a token as handed back to callers: its value and pool plus what is
+// known about it at that point. Which fields are filled depends on where it
+// came from; a freshly assigned token has no labels loaded, for instance, and
+// a token read back from a record that predates records has no timestamps.
+//
+// It marshals to the {"token": ..., "pool": ...} shape responses have always
+// used, with the other fields added when set.
+type Token struct {
+	Value      string            `json:"token"`
+	Pool       string            `json:"pool"`
+	State      string            `json:"state,omitempty"`
+	Owner      string            `json:"owner,omitempty"` // client the token is assigned to
+	Lease      string            `json:"lease,omitempty"` // lease the holder renews, when leases are in use
+	Labels     map[string]string `json:"labels,omitempty"`
+	Version    int64             `json:"version,omitempty"` // revision, bumped by every change; send it as If-Match to make a change conditional
+	CreatedAt  *time.Time        `json:"created_at,omitempty"`
+	AssignedAt *time.Time        `json:"assigned_at,omitempty"` // when the current assignment began
+	UpdatedAt  *time.Time        `json:"updated_at,omitempty"`
+}
+
+// applyRecord fills in what a token record knows. Ownership and the
+// assignment time are only kept while the token is assigned.
+func (t *Token) applyRecord(rec *TokenRecord) {
+	if rec == nil {
+		return
+	}
+	t.Version = rec.Revision
+	t.Lease = rec.Lease
+	t.CreatedAt = timeOrNil(rec.CreatedAt)
+	t.UpdatedAt = timeOrNil(rec.UpdatedAt)
+	if t.State == TokenStateAssigned {
+		t.Owner = rec.Owner
+		t.AssignedAt = timeOrNil(rec.AssignedAt)
+	}
+}
+
+// CreatedToken describes a token just saved to pool. It is held pending when
+// activateAt is still ahead of now.
+func CreatedToken(pool, value string, labels map[string]string, activateAt, now time.Time) *Token {
+	state := TokenStateAvailable
+	if activateAt.After(now) {
+		state = TokenStatePending
+	}
+	return &Token{
+		Value:     value,
+		Pool:      pool,
+		State:     state,
+		Labels:    labels,
+		Version:   1,
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+}
+
+// ClaimedToken describes a token just claimed by owner
+func ClaimedToken(pool, value, owner string, now time.Time) *Token {
+	if !tracksOwner(owner) {
+		owner = ""
+	}
+	return &Token{
+		Value:      value,
+		Pool:       pool,
+		State:      TokenStateAssigned,
+		Owner:      owner,
+		AssignedAt: &now,
+		UpdatedAt:  &now,
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
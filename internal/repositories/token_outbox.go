@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// The audit stream doubles as an outbox: every mutation that changes token
+// state appends an event in the same Lua script or MULTI as its change, so
+// the change and its event are stored together or not at all. The relay
+// workers (audit export, webhooks, event stream) then publish it at least
+// once through their consumer groups, and no change is missed because the
+// process died between storing it and recording it.
+//
+// Each mutation describes its own change (token.assign, token.keepalive, ...)
+// and callers that know more, such as the actor and reason behind an admin
+// action, pass their own entry with WithEvent instead.
+
+type eventContextKey struct{}
+
+// WithEvent returns a context whose next mutation records entry in the audit
+// stream atomically with its state change, in place of the event it records
+// by default. A token mutation fills in the entry's Pool and Token when they
+// are empty.
+func WithEvent(ctx context.Context, entry AuditEntry) context.Context {
+	return context.WithValue(ctx, eventContextKey{}, entry)
+}
+
+// encodeAudit fills in an entry's time, token ref, request IDs and on-behalf-of
+// principal and encodes it as stored in the audit stream
+func (r *TokenRepository) encodeAudit(ctx context.Context, entry AuditEntry) ([]byte, error) {
+	if entry.Token != "" {
+		entry.Token = r.ref(entry.Token)
+	}
+	return r.encodeEvent(ctx, entry)
+}
+
+// encodeEvent is encodeAudit for an entry whose Token is a ref already
+func (r *TokenRepository) encodeEvent(ctx context.Context, entry AuditEntry) ([]byte, error) {
+	if entry.Time.IsZero() {
+		entry.Time = r.Now()
+	}
+	if entry.TraceID == "" && entry.RequestID == "" {
+		ids := tracing.FromContext(ctx)
+		entry.TraceID, entry.RequestID = ids.TraceID, ids.RequestID
+	}
+	if entry.OnBehalfOf == "" {
+		entry.OnBehalfOf, _ = ctx.Value(onBehalfOfContextKey{}).(string)
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	return encoded, nil
+}
+
+// tokenEvent is the event a mutation of a token records by default
+func tokenEvent(action, ref string) AuditEntry {
+	return AuditEntry{Action: action, Token: ref}
+}
+
+// event encodes the event a mutation of pool records: the one ctx carries,
+// or else fallback, the mutation's own description whose Token is a ref
+func (r *TokenRepository) event(ctx context.Context, pool string, fallback AuditEntry) ([]byte, error) {
+	entry, ok := ctx.Value(eventContextKey{}).(AuditEntry)
+	if !ok {
+		entry = fallback
+	} else if entry.Token != "" {
+		entry.Token = r.ref(entry.Token)
+	} else {
+		entry.Token = fallback.Token
+	}
+	if entry.Pool == "" {
+		entry.Pool = pool
+	}
+	return r.encodeEvent(ctx, entry)
+}
+
+// queueEvent adds the event of a mutation of pool to its transaction, see event
+func (r *TokenRepository) queueEvent(ctx context.Context, pipe redis.Pipeliner, pool string, fallback AuditEntry) error {
+	encoded, err := r.event(ctx, pool, fallback)
+	if err != nil {
+		return err
+	}
+	appendEvent(ctx, pipe, encoded)
+	return nil
+}
+
+// appendEvent adds an encoded event to a transaction
+func appendEvent(ctx context.Context, pipe redis.Pipeliner, encoded []byte) {
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: constants.KeyAuditStream,
+		MaxLen: constants.AuditStreamMaxLen,
+		Approx: true,
+		Values: map[string]any{"entry": encoded},
+	})
+}
+
+// outboxLua is prepended to scripts that record an event. Their last three
+// ARGV, as returned by eventArgs, are the audit stream, its cap and the
+// encoded entry; outbox() appends it and is called only once the state change
+// is made. Scripts changing several tokens call outbox_for(token) for each
+// instead, which records the entry with its token set to that one.
+const outboxLua = `
+local function outbox()
+	redis.call('XADD', ARGV[#ARGV - 2], 'MAXLEN', '~', ARGV[#ARGV - 1], '*', 'entry', ARGV[#ARGV])
+end
+
+local outbox_entry
+local function outbox_for(token)
+	outbox_entry = outbox_entry or cjson.decode(ARGV[#ARGV])
+	outbox_entry.token = token
+	redis.call('XADD', ARGV[#ARGV - 2], 'MAXLEN', '~', ARGV[#ARGV - 1], '*', 'entry', cjson.encode(outbox_entry))
+end
+`
+
+// eventArgs returns the trailing ARGV of a script built with outboxLua that
+// mutates pool, see event
+func (r *TokenRepository) eventArgs(ctx context.Context, pool string, fallback AuditEntry) ([]any, error) {
+	encoded, err := r.event(ctx, pool, fallback)
+	if err != nil {
+		return nil, err
+	}
+	return []any{constants.KeyAuditStream, constants.AuditStreamMaxLen, string(encoded)}, nil
+}
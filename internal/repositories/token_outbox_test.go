@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/manankarani/token-manager/internal/encryption"
+)
+
+func TestMutationsRecordTheirOwnEvent(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	saveTokens(t, r, "default", "tok-1")
+
+	entries := events(t, r)
+	if len(entries) != 1 {
+		t.Fatalf("recorded %d events, want 1", len(entries))
+	}
+	if e := entries[0]; e.Action != "token.create" || e.Token != "tok-1" || e.Pool != "default" || e.Time.IsZero() {
+		t.Errorf("event = %+v, want token.create of tok-1 in default", e)
+	}
+}
+
+func TestWithEventReplacesTheDefaultEvent(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"}); err != nil {
+		t.Fatalf("AssignToken: %v", err)
+	}
+
+	// No Token or Pool: the mutation fills them in
+	ctx = WithEvent(ctx, AuditEntry{Action: "token.force_release", Actor: "ops", Reason: "stuck"})
+	if err := r.UnblockToken(ctx, "tok-1", 0); err != nil {
+		t.Fatalf("UnblockToken: %v", err)
+	}
+
+	entries := events(t, r)
+	e := entries[len(entries)-1]
+	if e.Action != "token.force_release" || e.Actor != "ops" || e.Reason != "stuck" {
+		t.Errorf("event = %+v, want the one passed with WithEvent", e)
+	}
+	if e.Token != "tok-1" || e.Pool != "default" {
+		t.Errorf("event names token %q in pool %q, want tok-1 in default", e.Token, e.Pool)
+	}
+	for _, entry := range entries {
+		if entry.Action == "token.release" {
+			t.Error("the default event was recorded as well")
+		}
+	}
+}
+
+func TestEventsNameTokensByRef(t *testing.T) {
+	cipher, err := encryption.NewCipher("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newTestRepository(t, testTiming)
+	r.Cipher = cipher
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+
+	ctx = WithEvent(ctx, AuditEntry{Action: "token.force_keepalive", Token: "tok-1"})
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{Force: true}); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	for _, e := range events(t, r) {
+		if e.Token != r.ref("tok-1") {
+			t.Errorf("%s event names %q, want the token's ref", e.Action, e.Token)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// ClientToken is a token currently assigned to a client
+type ClientToken struct {
+	Token string `json:"token"`
+	Pool  string `json:"pool"`
+	Expiry
+}
+
+func clientTokensKey(client string) string {
+	return constants.PrefixClientTokensKey + ":" + client
+}
+
+// tracksOwner reports whether assignments to client are indexed. Anonymous
+// callers share an ID, so listing or releasing "their" tokens would be meaningless.
+func tracksOwner(client string) bool {
+	return client != "" && client != constants.AnonymousClientID
+}
+
+// recordOwner queues the writes that index token under client
+func recordOwner(ctx context.Context, pipe redis.Pipeliner, token, client string) {
+	if !tracksOwner(client) {
+		return
+	}
+	pipe.HSet(ctx, constants.KeyTokenOwners, token, client)
+	pipe.SAdd(ctx, clientTokensKey(client), token)
+}
+
+// OwnerOf returns the client a token is assigned to, or "" if it isn't tracked
+func (r *TokenRepository) OwnerOf(ctx context.Context, token string) (string, error) {
+	owner, err := r.RedisClient.HGet(ctx, constants.KeyTokenOwners, r.ref(token)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up token owner: %w", err)
+	}
+	return owner, nil
+}
+
+// ClientTokens lists the tokens assigned to client with their remaining time.
+// Releases only drop the owner entry, not the client's set (the releasing
+// pipelines don't know the client), so members whose owner no longer matches
+// are pruned here.
+func (r *TokenRepository) ClientTokens(ctx context.Context, client string) ([]ClientToken, error) {
+	key := clientTokensKey(client)
+	refs, err := r.RedisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list client tokens: %w", err)
+	}
+	if len(refs) == 0 {
+		return []ClientToken{}, nil
+	}
+
+	pipe := r.RedisClient.Pipeline()
+	owners := pipe.HMGet(ctx, constants.KeyTokenOwners, refs...)
+	pools := pipe.HMGet(ctx, constants.KeyTokenPoolIndex, refs...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to look up client tokens: %w", err)
+	}
+
+	var live, stale []string
+	poolOf := make(map[string]string)
+	for i, ref := range refs {
+		if owner, _ := owners.Val()[i].(string); owner != client {
+			stale = append(stale, ref)
+			continue
+		}
+		pool, ok := pools.Val()[i].(string)
+		if !ok {
+			pool = constants.DefaultPool
+		}
+		live = append(live, ref)
+		poolOf[ref] = pool
+	}
+	if len(stale) > 0 {
+		if err := r.RedisClient.SRem(ctx, key, stale).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune client tokens: %w", err)
+		}
+	}
+	if len(live) == 0 {
+		return []ClientToken{}, nil
+	}
+
+	pipe = r.RedisClient.Pipeline()
+	expiries := make([]*redis.FloatCmd, len(live))
+	for i, ref := range live {
+		expiries[i] = pipe.ZScore(ctx, keysFor(poolOf[ref]).keepalive, ref)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to fetch client token expiries: %w", err)
+	}
+
+	values, err := r.reveal(ctx, live)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.Now()
+	tokens := make([]ClientToken, len(live))
+	for i, ref := range live {
+		tokens[i] = ClientToken{Token: values[i], Pool: poolOf[ref], Expiry: expiryOf(expiries[i], now)}
+	}
+	return tokens, nil
+}
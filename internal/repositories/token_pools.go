@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// poolKeys are the Redis keys that make up a single token pool
+type poolKeys struct {
+	available     string
+	assigned      string
+	keepalive     string
+	leases        string
+	quarantine    string
+	quarantinedAt string
+	reclaims      string
+	pending       string
+
+	labelPrefix string
+	usagePrefix string
+
+	queue             string
+	queueRotation     string
+	queueClients      string
+	queueCredits      string
+	clientQueuePrefix string
+}
+
+// keysFor returns the keys of a pool. The default pool keeps the original
+// unsuffixed key names so existing deployments carry on without a migration.
+func keysFor(pool string) poolKeys {
+	suffix := ""
+	if pool != constants.DefaultPool {
+		suffix = ":" + pool
+	}
+	return poolKeys{
+		available:     constants.KeyTokenPool + suffix,
+		assigned:      constants.KeyAssignedTokens + suffix,
+		keepalive:     constants.KeyKeepaliveTokens + suffix,
+		leases:        constants.KeyTokenLeases + suffix,
+		quarantine:    constants.KeyTokenQuarantine + suffix,
+		quarantinedAt: constants.KeyQuarantinedAt + suffix,
+		reclaims:      constants.KeyTokenReclaims + suffix,
+		pending:       constants.KeyPendingTokens + suffix,
+
+		labelPrefix: constants.PrefixTokenLabelKey + suffix,
+		usagePrefix: constants.PrefixTokenUsageKey + suffix,
+
+		queue:             constants.KeyAssignQueue + suffix,
+		queueRotation:     constants.KeyQueueRotation + suffix,
+		queueClients:      constants.KeyQueueClients + suffix,
+		queueCredits:      constants.KeyQueueCredits + suffix,
+		clientQueuePrefix: constants.PrefixClientQueueKey + suffix,
+	}
+}
+
+// PoolOf returns the pool a token was created in. Tokens that predate pools
+// have no index entry and belong to the default pool.
+func (r *TokenRepository) PoolOf(ctx context.Context, token string) (string, error) {
+	pool, err := r.RedisClient.HGet(ctx, constants.KeyTokenPoolIndex, token).Result()
+	if err == redis.Nil {
+		return constants.DefaultPool, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up token pool: %w", err)
+	}
+	return pool, nil
+}
+
+// PoolOfToken is PoolOf for a token value rather than its stored ref
+func (r *TokenRepository) PoolOfToken(ctx context.Context, token string) (string, error) {
+	return r.PoolOf(ctx, r.ref(token))
+}
+
+// RegisterPool makes a pool known before it holds any tokens, so sweeps and
+// listings include it
+func (r *TokenRepository) RegisterPool(ctx context.Context, pool string) error {
+	if err := r.RedisClient.SAdd(ctx, constants.KeyPools, pool).Err(); err != nil {
+		return fmt.Errorf("failed to register pool: %w", err)
+	}
+	return nil
+}
+
+// ListPools returns every pool that has ever held a token, plus the default pool
+func (r *TokenRepository) ListPools(ctx context.Context) ([]string, error) {
+	pools, err := r.RedisClient.SMembers(ctx, constants.KeyPools).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools: %w", err)
+	}
+
+	seen := map[string]bool{constants.DefaultPool: true}
+	names := []string{constants.DefaultPool}
+	for _, pool := range pools {
+		if !seen[pool] {
+			seen[pool] = true
+			names = append(names, pool)
+		}
+	}
+	sort.Strings(names[1:])
+	return names, nil
+}
@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// ProbeResult is the outcome of the last upstream health probe of a token
+type ProbeResult struct {
+	Healthy    bool      `json:"healthy"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// ProbeTarget is a token due for probing, with the ref it is stored under
+type ProbeTarget struct {
+	Ref         string
+	Token       string
+	Quarantined bool
+}
+
+// ProbeTargets returns the available and quarantined tokens of a pool.
+// Assigned tokens are left alone while a client is using them.
+func (r *TokenRepository) ProbeTargets(ctx context.Context, pool string) ([]ProbeTarget, error) {
+	keys := keysFor(pool)
+
+	pipe := r.RedisClient.Pipeline()
+	available := pipe.SMembers(ctx, keys.available)
+	quarantined := pipe.SMembers(ctx, keys.quarantine)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list tokens to probe: %w", err)
+	}
+
+	refs := append(available.Val(), quarantined.Val()...)
+	tokens, err := r.reveal(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]ProbeTarget, len(refs))
+	for i, ref := range refs {
+		targets[i] = ProbeTarget{Ref: ref, Token: tokens[i], Quarantined: i >= len(available.Val())}
+	}
+	return targets, nil
+}
+
+// recordProbeScript stores the probe result ARGV[2] of token ARGV[1] in
+// KEYS[1] and moves the token from KEYS[2] to KEYS[3] to match it: into
+// quarantine when ARGV[3] is "0", back to the pool when "1". Failed probes are
+// counted in KEYS[4] until one passes. A token that moved is timed into or out
+// of quarantine (KEYS[5]) and its record (KEYS[6]) updated as of ARGV[4].
+// Returns 1 when the token moved. It records an event when it did
+// (outboxLua).
+var recordProbeScript = redis.NewScript(outboxLua + recordLua + `
+local ref = ARGV[1]
+local healthy = ARGV[3] == '1'
+redis.call('HSET', KEYS[1], ref, ARGV[2])
+if healthy then
+	redis.call('HDEL', KEYS[4], ref)
+else
+	redis.call('HINCRBY', KEYS[4], ref, 1)
+end
+if redis.call('SMOVE', KEYS[2], KEYS[3], ref) == 0 then
+	return 0
+end
+
+local state = 'quarantined'
+if healthy then
+	state = 'available'
+	redis.call('ZREM', KEYS[5], ref)
+else
+	redis.call('ZADD', KEYS[5], ARGV[4], ref)
+end
+record_update(KEYS[6], {state = state, updated_at = ARGV[4]})
+outbox()
+return 1
+`)
+
+// RecordProbe stores a probe result and moves the token between the pool and
+// quarantine to match it. SMOVE only acts on tokens still where the probe
+// found them, so a token assigned in the meantime is not touched. Failed
+// probes are counted until one passes, for the quarantine review. A move
+// records a token.quarantine or token.recover event.
+func (r *TokenRepository) RecordProbe(ctx context.Context, pool, ref string, result ProbeResult) (moved bool, err error) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode probe result: %w", err)
+	}
+	keys := keysFor(pool)
+
+	from, to, action := keys.available, keys.quarantine, "token.quarantine"
+	if result.Healthy {
+		from, to, action = keys.quarantine, keys.available, "token.recover"
+	}
+	event, err := r.eventArgs(ctx, pool, tokenEvent(action, ref))
+	if err != nil {
+		return false, err
+	}
+	n, err := recordProbeScript.Run(ctx, r.RedisClient,
+		[]string{constants.KeyTokenProbes, from, to, constants.KeyTokenProbeFailures, keys.quarantinedAt, recordKey(ref)},
+		append([]any{ref, encoded, result.Healthy, r.Now().Unix()}, event...)...,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to record probe result: %w", err)
+	}
+	return n > 0, nil
+}
+
+// probeOf returns the last probe result of a token, nil if it was never probed
+func (r *TokenRepository) probeOf(ctx context.Context, ref string) (*ProbeResult, error) {
+	raw, err := r.RedisClient.HGet(ctx, constants.KeyTokenProbes, ref).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch probe result: %w", err)
+	}
+	return decodeProbe(raw)
+}
+
+func decodeProbe(raw string) (*ProbeResult, error) {
+	var result ProbeResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode probe result: %w", err)
+	}
+	return &result, nil
+}
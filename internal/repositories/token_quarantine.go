@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// QuarantinedToken is a token pulled from its pool after failing a health
+// probe, with what an operator needs to decide whether to put it back
+type QuarantinedToken struct {
+	Token         string       `json:"token"`
+	Pool          string       `json:"pool"`
+	QuarantinedAt *time.Time   `json:"quarantined_at,omitempty"` // unset for tokens quarantined before this was tracked
+	Failures      int64        `json:"failures"`                 // consecutive failed probes
+	LastProbe     *ProbeResult `json:"last_probe,omitempty"`
+	Version       int64        `json:"version,omitempty"` // revision, for a conditional purge
+}
+
+// QuarantinedTokens lists a pool's quarantined tokens, longest quarantined first
+func (r *TokenRepository) QuarantinedTokens(ctx context.Context, pool string) ([]QuarantinedToken, error) {
+	keys := keysFor(pool)
+	refs, err := r.RedisClient.SMembers(ctx, keys.quarantine).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined tokens: %w", err)
+	}
+	if len(refs) == 0 {
+		return []QuarantinedToken{}, nil
+	}
+
+	pipe := r.RedisClient.Pipeline()
+	since := pipe.ZMScore(ctx, keys.quarantinedAt, refs...)
+	failures := pipe.HMGet(ctx, constants.KeyTokenProbeFailures, refs...)
+	probes := pipe.HMGet(ctx, constants.KeyTokenProbes, refs...)
+	revs := make([]*redis.StringCmd, len(refs))
+	for i, ref := range refs {
+		revs[i] = pipe.HGet(ctx, recordKey(ref), "rev")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to fetch quarantine details: %w", err)
+	}
+
+	values, err := r.reveal(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]QuarantinedToken, len(refs))
+	for i := range refs {
+		tokens[i] = QuarantinedToken{Token: values[i], Pool: pool}
+		if score := since.Val()[i]; score > 0 {
+			at := time.Unix(int64(score), 0)
+			tokens[i].QuarantinedAt = &at
+		}
+		if raw, ok := failures.Val()[i].(string); ok {
+			tokens[i].Failures, _ = strconv.ParseInt(raw, 10, 64)
+		}
+		if raw, ok := probes.Val()[i].(string); ok {
+			tokens[i].LastProbe, err = decodeProbe(raw)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tokens[i].Version, _ = revs[i].Int64()
+	}
+
+	// Oldest first; tokens with no recorded time sort ahead, being older still
+	slices.SortStableFunc(tokens, func(a, b QuarantinedToken) int {
+		return cmp.Compare(unixOrZero(a.QuarantinedAt), unixOrZero(b.QuarantinedAt))
+	})
+	return tokens, nil
+}
+
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// approveQuarantinedScript moves ARGV[1] from the quarantine set KEYS[1] to
+// the available set KEYS[2], dropping its quarantine time (KEYS[3]) and probe
+// failures (KEYS[4]) and marking its record (KEYS[5]) available as of
+// ARGV[2]. It returns 0 when the token isn't quarantined. It takes an event
+// (outboxLua).
+var approveQuarantinedScript = redis.NewScript(outboxLua + recordLua + `
+local ref = ARGV[1]
+if redis.call('SMOVE', KEYS[1], KEYS[2], ref) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[3], ref)
+redis.call('HDEL', KEYS[4], ref)
+record_update(KEYS[5], {state = 'available', owner = '', updated_at = ARGV[2]})
+outbox()
+return 1
+`)
+
+// ApproveQuarantined puts a quarantined token back in its pool ahead of its
+// next passing probe and returns the pool. ErrTokenNotQuarantined means it
+// left quarantine in the meantime. It takes an event (WithEvent).
+func (r *TokenRepository) ApproveQuarantined(ctx context.Context, token string) (string, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.quarantine_approve", ref))
+	if err != nil {
+		return "", err
+	}
+
+	moved, err := approveQuarantinedScript.Run(ctx, r.RedisClient,
+		[]string{keys.quarantine, keys.available, keys.quarantinedAt, constants.KeyTokenProbeFailures, recordKey(ref)},
+		append([]any{ref, r.Now().Unix()}, event...)...,
+	).Int()
+	if err != nil {
+		return "", fmt.Errorf("failed to approve quarantined token: %w", err)
+	}
+	if moved == 0 {
+		return "", constants.ErrTokenNotQuarantined
+	}
+	return pool, nil
+}
+
+// QuarantineStats returns how many tokens a pool has in quarantine and when
+// the longest quarantined of them was pulled, zero if none is tracked
+func (r *TokenRepository) QuarantineStats(ctx context.Context, pool string) (int64, time.Time, error) {
+	keys := keysFor(pool)
+	pipe := r.RedisClient.Pipeline()
+	size := pipe.SCard(ctx, keys.quarantine)
+	oldest := pipe.ZRangeWithScores(ctx, keys.quarantinedAt, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to fetch quarantine stats: %w", err)
+	}
+	if len(oldest.Val()) == 0 {
+		return size.Val(), time.Time{}, nil
+	}
+	return size.Val(), time.Unix(int64(oldest.Val()[0].Score), 0), nil
+}
@@ -0,0 +1,310 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// Queue scheduling policies
+const (
+	QueuePolicyFIFO       = "fifo"        // strict arrival order across all clients
+	QueuePolicyRoundRobin = "round_robin" // one assignment per waiting client in turn
+	QueuePolicyWeighted   = "weighted"    // round robin, but a client gets Weights[client] turns in a row
+)
+
+// QueueConfig controls how waiting assign requests are scheduled
+type QueueConfig struct {
+	Policy  string
+	Weights map[string]int // consecutive turns per client under the weighted policy
+}
+
+func (c QueueConfig) withDefaults() QueueConfig {
+	if c.Policy == "" {
+		c.Policy = QueuePolicyFIFO
+	}
+	return c
+}
+
+// popForWaiterScript hands a pool token to the ticket at the head of the
+// arrival-ordered queue. Abandoned tickets (whose heartbeat key expired) are
+// dropped first so they can't block everyone behind them.
+//
+// Returns the token, or an empty string with a reason: "not_head" or "empty".
+var popForWaiterScript = redis.NewScript(`
+local queue = KEYS[1]
+local pool = KEYS[2]
+local ticket = ARGV[1]
+local prefix = ARGV[2]
+
+local head = redis.call('LINDEX', queue, 0)
+while head and head ~= ticket and redis.call('EXISTS', prefix .. ':' .. head) == 0 do
+	redis.call('LPOP', queue)
+	head = redis.call('LINDEX', queue, 0)
+end
+
+if head ~= ticket then
+	return {'', 'not_head'}
+end
+
+local token = redis.call('SPOP', pool)
+if not token then
+	return {'', 'empty'}
+end
+
+redis.call('LPOP', queue)
+redis.call('DEL', prefix .. ':' .. ticket)
+return {token, ''}
+`)
+
+// popForFairWaiterScript serves the client at the front of the rotation ring
+// instead of the oldest ticket overall. Each client has its own ticket list;
+// after a client has been served its weight worth of tokens it moves to the
+// back of the ring. ARGV[4..] holds client/weight pairs, missing clients weigh 1.
+var popForFairWaiterScript = redis.NewScript(`
+local queue = KEYS[1]
+local pool = KEYS[2]
+local rotation = KEYS[3]
+local members = KEYS[4]
+local credits = KEYS[5]
+local ticket = ARGV[1]
+local prefix = ARGV[2]
+local clientPrefix = ARGV[3]
+
+local weights = {}
+for i = 4, #ARGV, 2 do
+	weights[ARGV[i]] = tonumber(ARGV[i + 1])
+end
+
+local client, clientQueue, head
+while true do
+	client = redis.call('LINDEX', rotation, 0)
+	if not client then
+		return {'', 'not_head'}
+	end
+	clientQueue = clientPrefix .. ':' .. client
+	head = redis.call('LINDEX', clientQueue, 0)
+	while head and head ~= ticket and redis.call('EXISTS', prefix .. ':' .. head) == 0 do
+		redis.call('LPOP', clientQueue)
+		redis.call('LREM', queue, 1, head)
+		head = redis.call('LINDEX', clientQueue, 0)
+	end
+	if head then
+		break
+	end
+	redis.call('LPOP', rotation)
+	redis.call('SREM', members, client)
+	redis.call('HDEL', credits, client)
+end
+
+if head ~= ticket then
+	return {'', 'not_head'}
+end
+
+local token = redis.call('SPOP', pool)
+if not token then
+	return {'', 'empty'}
+end
+
+redis.call('LPOP', clientQueue)
+redis.call('LREM', queue, 1, ticket)
+redis.call('DEL', prefix .. ':' .. ticket)
+
+local used = redis.call('HINCRBY', credits, client, 1)
+if used >= (weights[client] or 1) or redis.call('LLEN', clientQueue) == 0 then
+	redis.call('LPOP', rotation)
+	redis.call('HDEL', credits, client)
+	if redis.call('LLEN', clientQueue) > 0 then
+		redis.call('RPUSH', rotation, client)
+	else
+		redis.call('SREM', members, client)
+	end
+end
+return {token, ''}
+`)
+
+// EnqueueWaiter appends a client's ticket to a pool's wait queue and returns its 1-based position
+func (r *TokenRepository) EnqueueWaiter(ctx context.Context, pool, ticket, client string) (int64, error) {
+	keys := keysFor(pool)
+
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HSet(ctx, queueTicketKey(ticket), "pool", pool, "client", client)
+	pipe.Expire(ctx, queueTicketKey(ticket), constants.QueueTicketTTL)
+	length := pipe.RPush(ctx, keys.queue, ticket)
+	if r.Queue.Policy != QueuePolicyFIFO {
+		pipe.RPush(ctx, keys.clientQueuePrefix+":"+client, ticket)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to enqueue waiter: %w", err)
+	}
+
+	if r.Queue.Policy != QueuePolicyFIFO {
+		// Put the client into the rotation ring unless it is already waiting its turn
+		added, err := r.RedisClient.SAdd(ctx, keys.queueClients, client).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to register waiting client: %w", err)
+		}
+		if added > 0 {
+			if err := r.RedisClient.RPush(ctx, keys.queueRotation, client).Err(); err != nil {
+				return 0, fmt.Errorf("failed to add client to rotation: %w", err)
+			}
+		}
+	}
+
+	return length.Val(), nil
+}
+
+// queueTicket is what a ticket's heartbeat hash records about its waiter
+type queueTicket struct {
+	pool   string
+	client string
+}
+
+// lookupTicket returns the waiter behind a ticket, or ErrTicketNotFound once it has expired
+func (r *TokenRepository) lookupTicket(ctx context.Context, ticket string) (queueTicket, error) {
+	fields, err := r.RedisClient.HGetAll(ctx, queueTicketKey(ticket)).Result()
+	if err != nil {
+		return queueTicket{}, fmt.Errorf("failed to look up queue ticket: %w", err)
+	}
+	if len(fields) == 0 {
+		return queueTicket{}, constants.ErrTicketNotFound
+	}
+	return queueTicket{pool: fields["pool"], client: fields["client"]}, nil
+}
+
+// QueuePosition returns the 1-based arrival position of a ticket, refreshing its heartbeat.
+// Under the fair policies this is only an upper bound on the wait.
+func (r *TokenRepository) QueuePosition(ctx context.Context, ticket string) (int64, error) {
+	alive, err := r.RedisClient.Expire(ctx, queueTicketKey(ticket), constants.QueueTicketTTL).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh queue ticket: %w", err)
+	}
+	if !alive {
+		return 0, constants.ErrTicketNotFound
+	}
+
+	waiter, err := r.lookupTicket(ctx, ticket)
+	if err != nil {
+		return 0, err
+	}
+
+	pos, err := r.RedisClient.LPos(ctx, keysFor(waiter.pool).queue, ticket, redis.LPosArgs{}).Result()
+	if err == redis.Nil {
+		return 0, constants.ErrTicketNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find queue position: %w", err)
+	}
+	return pos + 1, nil
+}
+
+// queueLengthScript drops abandoned tickets (whose heartbeat key expired)
+// from a pool's queue (KEYS[1]) and returns how many are left. Under the fair
+// policies an abandoned ticket stays in its client's list until the next pop
+// prunes it there.
+var queueLengthScript = redis.NewScript(`
+local prefix = ARGV[1]
+local live = 0
+for _, ticket in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if redis.call('EXISTS', prefix .. ':' .. ticket) == 1 then
+		live = live + 1
+	else
+		redis.call('LREM', KEYS[1], 1, ticket)
+	end
+end
+return live
+`)
+
+// QueueLength returns how many live tickets are waiting for a token from a
+// pool. Abandoned tickets are dropped rather than counted, so they don't hold
+// back direct assigns until a waiter's pop reaches them.
+func (r *TokenRepository) QueueLength(ctx context.Context, pool string) (int64, error) {
+	n, err := queueLengthScript.Run(ctx, r.RedisClient, []string{keysFor(pool).queue}, constants.PrefixQueueTicketKey).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get queue length: %w", err)
+	}
+	return n, nil
+}
+
+// AssignToWaiter assigns a token to the ticket if the scheduling policy says it is next.
+// ErrNotQueueHead means someone else goes first; ErrNoAvailableTokens means the pool is empty.
+// It records a token.assign event (WithEvent).
+func (r *TokenRepository) AssignToWaiter(ctx context.Context, ticket string) (*Token, error) {
+	waiter, err := r.lookupTicket(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	keys := keysFor(waiter.pool)
+
+	// The scripts below dequeue the waiter along with the token, so don't
+	// pop one that couldn't be claimed
+	full, err := r.atAssignmentCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		return nil, constants.ErrAssignmentCapReached
+	}
+
+	var cmd *redis.Cmd
+	if r.Queue.Policy == QueuePolicyFIFO {
+		cmd = popForWaiterScript.Run(ctx, r.RedisClient,
+			[]string{keys.queue, keys.available},
+			ticket, constants.PrefixQueueTicketKey,
+		)
+	} else {
+		args := []interface{}{ticket, constants.PrefixQueueTicketKey, keys.clientQueuePrefix}
+		if r.Queue.Policy == QueuePolicyWeighted {
+			for client, weight := range r.Queue.Weights {
+				args = append(args, client, strconv.Itoa(weight))
+			}
+		}
+		cmd = popForFairWaiterScript.Run(ctx, r.RedisClient,
+			[]string{keys.queue, keys.available, keys.queueRotation, keys.queueClients, keys.queueCredits},
+			args...,
+		)
+	}
+
+	res, err := cmd.StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pop token for waiter: %w", err)
+	}
+
+	switch res[1] {
+	case "not_head":
+		return nil, constants.ErrNotQueueHead
+	case "empty":
+		return nil, constants.ErrNoAvailableTokens
+	}
+
+	return r.claimToken(ctx, waiter.pool, res[0], waiter.client)
+}
+
+// LeaveQueue removes a ticket from its wait queue
+func (r *TokenRepository) LeaveQueue(ctx context.Context, ticket string) error {
+	waiter, err := r.lookupTicket(ctx, ticket)
+	if err != nil {
+		return err
+	}
+	keys := keysFor(waiter.pool)
+
+	pipe := r.RedisClient.TxPipeline()
+	removed := pipe.LRem(ctx, keys.queue, 1, ticket)
+	// Empty client lists are pruned from the rotation by the next pop
+	pipe.LRem(ctx, keys.clientQueuePrefix+":"+waiter.client, 1, ticket)
+	pipe.Del(ctx, queueTicketKey(ticket))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to leave queue: %w", err)
+	}
+	if removed.Val() == 0 {
+		return constants.ErrTicketNotFound
+	}
+	return nil
+}
+
+func queueTicketKey(ticket string) string {
+	return constants.PrefixQueueTicketKey + ":" + ticket
+}
@@ -0,0 +1,399 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// TokenRecord is the per-token record kept alongside the pool sets, so a
+// token's state, owner, lease and history can be read with one HGETALL.
+// The sets stay authoritative for assignment; the record follows every write
+// that changes them.
+//
+// The record hash holds the schema version ("v"), the revision ("rev") and
+// the rest of the record msgpack-encoded under "data". Scripts decode and
+// re-encode it with recordLua; writes queued in a pipeline go through
+// updateRecordScript, so neither reads the record back to the client first.
+// Version 1 records kept every field as its own hash field; they are still
+// read, and rewritten in the current encoding by their next update or by
+// MigrateRecords.
+//
+// Revision counts the writes to a token, for conditional (If-Match) mutations.
+// Every write that changes the record bumps it with HINCRBY.
+type TokenRecord struct {
+	Version    int // 0 for tokens written before records existed
+	Revision   int64
+	Pool       string
+	State      string
+	Owner      string
+	Lease      string
+	CreatedAt  time.Time
+	AssignedAt time.Time
+	UpdatedAt  time.Time
+}
+
+// recordData is the encoded part of a record, timestamps in Unix seconds
+type recordData struct {
+	Pool       string `msgpack:"pool"`
+	State      string `msgpack:"state"`
+	Owner      string `msgpack:"owner"`
+	Lease      string `msgpack:"lease"`
+	CreatedAt  int64  `msgpack:"created_at"`
+	AssignedAt int64  `msgpack:"assigned_at"`
+	UpdatedAt  int64  `msgpack:"updated_at"`
+}
+
+func recordKey(ref string) string {
+	return constants.PrefixTokenRecordKey + ":" + ref
+}
+
+// recordCreated queues the write of a new token's record
+func recordCreated(ctx context.Context, pipe redis.Pipeliner, ref, pool, state string, now time.Time) {
+	data, _ := msgpack.Marshal(recordData{
+		Pool:      pool,
+		State:     state,
+		CreatedAt: now.Unix(),
+		UpdatedAt: now.Unix(),
+	})
+	pipe.HSet(ctx, recordKey(ref),
+		"v", constants.TokenRecordVersion,
+		"rev", 1,
+		"data", data,
+	)
+}
+
+// recordAssigned queues the record update for a token claimed by owner. The
+// returned command holds the new revision once the pipeline has run.
+func recordAssigned(ctx context.Context, pipe redis.Pipeliner, ref, owner string, now time.Time) *redis.Cmd {
+	if !tracksOwner(owner) {
+		owner = ""
+	}
+	return updateRecord(ctx, pipe, ref,
+		"state", TokenStateAssigned,
+		"owner", owner,
+		"assigned_at", now.Unix(),
+		"updated_at", now.Unix(),
+	)
+}
+
+// recordState queues the record update for a token moving to an unassigned state
+func recordState(ctx context.Context, pipe redis.Pipeliner, ref, state string, now time.Time) {
+	updateRecord(ctx, pipe, ref, "state", state, "owner", "", "updated_at", now.Unix())
+}
+
+// recordLua is prepended to scripts that update token records. record_load
+// returns the record at a key as a table of its encoded fields, nil when
+// there is none; record_save encodes it back under the current schema version
+// and returns the new revision. record_update changes some fields of a record
+// and returns its new revision, or 0 when the token has no record yet; that is
+// left for MigrateRecords to build from the pool sets. The codec covers what
+// records hold: a map of strings and non-negative integers.
+var recordLua = `
+local record_version = ` + strconv.Itoa(constants.TokenRecordVersion) + `
+local record_fields = {'pool', 'state', 'owner', 'lease', 'created_at', 'assigned_at', 'updated_at'}
+local record_numbers = {created_at = true, assigned_at = true, updated_at = true}
+
+local function record_decode(data)
+	local pos = 1
+	local function uint(n)
+		local v = 0
+		for i = pos, pos + n - 1 do
+			v = v * 256 + string.byte(data, i)
+		end
+		pos = pos + n
+		return v
+	end
+	local function int(n)
+		local v = uint(n)
+		local top = 2 ^ (8 * n)
+		if v >= top / 2 then
+			v = v - top
+		end
+		return v
+	end
+	local function str(n)
+		local s = string.sub(data, pos, pos + n - 1)
+		pos = pos + n
+		return s
+	end
+	local value
+	local function map(n)
+		local t = {}
+		for _ = 1, n do
+			local k = value()
+			t[k] = value()
+		end
+		return t
+	end
+	value = function()
+		local b = uint(1)
+		if b < 0x80 then return b end
+		if b >= 0xe0 then return b - 0x100 end
+		if b < 0x90 then return map(b - 0x80) end
+		if b >= 0xa0 and b < 0xc0 then return str(b - 0xa0) end
+		if b == 0xc0 then return nil end
+		if b == 0xc2 then return false end
+		if b == 0xc3 then return true end
+		if b >= 0xcc and b <= 0xcf then return uint(2 ^ (b - 0xcc)) end
+		if b >= 0xd0 and b <= 0xd3 then return int(2 ^ (b - 0xd0)) end
+		if b >= 0xd9 and b <= 0xdb then return str(uint(2 ^ (b - 0xd9))) end
+		if b == 0xde then return map(uint(2)) end
+		if b == 0xdf then return map(uint(4)) end
+		error('unsupported msgpack type ' .. b)
+	end
+	return value()
+end
+
+local function record_encode(record)
+	local out = {string.char(0x80 + #record_fields)}
+	local function str(s)
+		local n = #s
+		if n < 32 then
+			out[#out + 1] = string.char(0xa0 + n)
+		elseif n < 256 then
+			out[#out + 1] = string.char(0xd9, n)
+		else
+			out[#out + 1] = string.char(0xda, math.floor(n / 256) % 256, n % 256)
+		end
+		out[#out + 1] = s
+	end
+	for _, field in ipairs(record_fields) do
+		str(field)
+		if record_numbers[field] then
+			local n = math.max(math.floor(tonumber(record[field]) or 0), 0)
+			if n < 128 then
+				out[#out + 1] = string.char(n)
+			else
+				local bytes = {}
+				for i = 8, 1, -1 do
+					bytes[i] = n % 256
+					n = math.floor(n / 256)
+				end
+				out[#out + 1] = string.char(0xcf, unpack(bytes))
+			end
+		else
+			str(tostring(record[field] or ''))
+		end
+	end
+	return table.concat(out)
+end
+
+local function record_load(key)
+	local data = redis.call('HGET', key, 'data')
+	if data then
+		return record_decode(data)
+	end
+	local values = redis.call('HMGET', key, unpack(record_fields))
+	local record, found = {}, false
+	for i, field in ipairs(record_fields) do
+		if values[i] then
+			record[field] = values[i]
+			found = true
+		end
+	end
+	if found then
+		return record
+	end
+	return nil
+end
+
+local function record_save(key, record)
+	redis.call('HSET', key, 'v', record_version, 'data', record_encode(record))
+	redis.call('HDEL', key, unpack(record_fields))
+	return redis.call('HINCRBY', key, 'rev', 1)
+end
+
+local function record_update(key, changes)
+	local record = record_load(key)
+	if not record then
+		return 0
+	end
+	for field, value in pairs(changes) do
+		record[field] = value
+	end
+	return record_save(key, record)
+end
+`
+
+// updateRecordScript sets the ARGV field/value pairs on the record at
+// KEYS[1] with record_update
+var updateRecordScript = recordLua + `
+local changes = {}
+for i = 1, #ARGV, 2 do
+	changes[ARGV[i]] = ARGV[i + 1]
+end
+return record_update(KEYS[1], changes)
+`
+
+// updateRecord queues an update of a token's record. It is sent with EVAL
+// rather than EVALSHA, since a pipeline can't fall back when the script
+// isn't cached yet.
+func updateRecord(ctx context.Context, pipe redis.Pipeliner, ref string, fields ...any) *redis.Cmd {
+	return pipe.Eval(ctx, updateRecordScript, []string{recordKey(ref)}, fields...)
+}
+
+func parseRecord(fields map[string]string) *TokenRecord {
+	if len(fields) == 0 {
+		return nil
+	}
+	version, _ := strconv.Atoi(fields["v"])
+	revision, _ := strconv.ParseInt(fields["rev"], 10, 64)
+
+	var data recordData
+	if encoded, ok := fields["data"]; ok {
+		if err := msgpack.Unmarshal([]byte(encoded), &data); err != nil {
+			return nil
+		}
+	} else {
+		data = recordData{
+			Pool:       fields["pool"],
+			State:      fields["state"],
+			Owner:      fields["owner"],
+			Lease:      fields["lease"],
+			CreatedAt:  parseInt(fields["created_at"]),
+			AssignedAt: parseInt(fields["assigned_at"]),
+			UpdatedAt:  parseInt(fields["updated_at"]),
+		}
+	}
+	return &TokenRecord{
+		Version:    version,
+		Revision:   revision,
+		Pool:       data.Pool,
+		State:      data.State,
+		Owner:      data.Owner,
+		Lease:      data.Lease,
+		CreatedAt:  unixTime(data.CreatedAt),
+		AssignedAt: unixTime(data.AssignedAt),
+		UpdatedAt:  unixTime(data.UpdatedAt),
+	}
+}
+
+func parseInt(raw string) int64 {
+	n, _ := strconv.ParseInt(raw, 10, 64)
+	return n
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// RecordOf returns a token's record, nil for tokens written before records
+// existed that haven't been migrated yet
+func (r *TokenRepository) RecordOf(ctx context.Context, token string) (*TokenRecord, error) {
+	fields, err := r.RedisClient.HGetAll(ctx, recordKey(r.ref(token))).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token record: %w", err)
+	}
+	return parseRecord(fields), nil
+}
+
+// VersionOf returns a token's revision, 0 for tokens whose record predates revisions
+func (r *TokenRepository) VersionOf(ctx context.Context, token string) (int64, error) {
+	rev, err := r.RedisClient.HGet(ctx, recordKey(r.ref(token)), "rev").Int64()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to fetch token version: %w", err)
+	}
+	return rev, nil
+}
+
+// guarded runs fn as a transaction. With ifVersion above 0 the transaction
+// only commits while the token's revision is still ifVersion, and fails with
+// ErrVersionMismatch otherwise, including when the token changes between the
+// check and the commit.
+func (r *TokenRepository) guarded(ctx context.Context, ref string, ifVersion int64, fn func(pipe redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if ifVersion <= 0 {
+		return r.RedisClient.TxPipelined(ctx, fn)
+	}
+
+	var cmds []redis.Cmder
+	err := r.RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+		rev, err := tx.HGet(ctx, recordKey(ref), "rev").Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if rev != ifVersion {
+			return constants.ErrVersionMismatch
+		}
+		cmds, err = tx.TxPipelined(ctx, fn)
+		return err
+	}, recordKey(ref))
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, constants.ErrVersionMismatch
+	}
+	return cmds, err
+}
+
+// migrateRecordScript (re)builds the record of ARGV[1] from the pool sets
+// when it is missing or older than ARGV[2]. Running inside Redis keeps it
+// consistent with assignments happening while a migration is in progress.
+// Creation and assignment times can't be told from the sets, so they are
+// kept from an older record or left unset rather than guessed.
+var migrateRecordScript = redis.NewScript(recordLua + `
+local ref = ARGV[1]
+if tonumber(redis.call('HGET', KEYS[1], 'v') or '0') >= tonumber(ARGV[2]) then
+	return 0
+end
+
+local state
+if redis.call('SISMEMBER', KEYS[3], ref) == 1 then
+	state = 'assigned'
+elseif redis.call('SISMEMBER', KEYS[2], ref) == 1 then
+	state = 'available'
+elseif redis.call('SISMEMBER', KEYS[4], ref) == 1 then
+	state = 'quarantined'
+else
+	return 0
+end
+
+local record = record_load(KEYS[1]) or {}
+record.pool = ARGV[3]
+record.state = state
+record.owner = ''
+if state == 'assigned' then
+	record.owner = redis.call('HGET', KEYS[5], ref) or ''
+end
+record.lease = redis.call('HGET', KEYS[6], ref) or ''
+record.updated_at = ARGV[4]
+record_save(KEYS[1], record)
+return 1
+`)
+
+// MigrateRecords brings the records of every token in a pool up to
+// TokenRecordVersion and returns how many were written. It is safe to run
+// while the pool is in use and to run repeatedly.
+func (r *TokenRepository) MigrateRecords(ctx context.Context, pool string) (int, error) {
+	keys := keysFor(pool)
+	now := r.Now().Unix()
+
+	migrated := 0
+	migrate := func(refs []string) error {
+		for _, ref := range refs {
+			n, err := migrateRecordScript.Run(ctx, r.RedisClient,
+				[]string{recordKey(ref), keys.available, keys.assigned, keys.quarantine, constants.KeyTokenOwners, keys.leases},
+				ref, constants.TokenRecordVersion, pool, now,
+			).Int()
+			if err != nil {
+				return fmt.Errorf("failed to migrate token record: %w", err)
+			}
+			migrated += n
+		}
+		return nil
+	}
+
+	for _, set := range []string{keys.available, keys.assigned, keys.quarantine} {
+		if err := r.scanSet(ctx, set, migrate); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestRecordsRoundTripBetweenGoAndLua(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	created := time.Unix(1_700_000_000, 0)
+
+	// Written by Go, updated by the Lua codec, read back by Go
+	pipe := r.RedisClient.TxPipeline()
+	recordCreated(ctx, pipe, "tok-1", "default", TokenStateAvailable, created)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	owner := strings.Repeat("o", 40)        // past a fixstr
+	assigned := time.Unix(4_000_000_000, 0) // past a uint32
+	setRecord(t, r, "tok-1", "state", TokenStateAssigned, "owner", owner, "assigned_at", assigned.Unix())
+
+	record := recordOf(t, r, "tok-1")
+	want := TokenRecord{
+		Version: constants.TokenRecordVersion, Revision: 2, Pool: "default", State: TokenStateAssigned,
+		Owner: owner, CreatedAt: created, AssignedAt: assigned, UpdatedAt: created,
+	}
+	if *record != want {
+		t.Errorf("record = %+v, want %+v", *record, want)
+	}
+}
+
+func TestLegacyRecordsAreRewrittenOnUpdate(t *testing.T) {
+	r, mr := newTestRepository(t, testTiming)
+	key := recordKey("tok-1")
+	mr.HSet(key, "v", "1", "rev", "5", "pool", "default", "state", TokenStateAvailable, "created_at", "1700000000")
+
+	setRecord(t, r, "tok-1", "state", TokenStateQuarantined)
+
+	if mr.HGet(key, "state") != "" || mr.HGet(key, "data") == "" {
+		t.Error("record still has its legacy fields")
+	}
+	record := recordOf(t, r, "tok-1")
+	if record.Version != constants.TokenRecordVersion || record.Revision != 6 || record.State != TokenStateQuarantined ||
+		record.Pool != "default" || record.CreatedAt.Unix() != 1_700_000_000 {
+		t.Errorf("record = %+v", *record)
+	}
+}
@@ -2,295 +2,415 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/encryption"
+	"github.com/manankarani/token-manager/internal/secrets"
 	"github.com/redis/go-redis/v9"
 )
 
 // TokenRepository manages token lifecycle
 type TokenRepository struct {
 	RedisClient *redis.Client
+	Cleanup     CleanupConfig
+	Queue       QueueConfig
+	Timing      TimingConfig
+	Cipher      *encryption.Cipher // encrypts token values at rest; nil stores them in plaintext
+
+	AssignmentCap     int  // max tokens assigned at once across every pool; 0 is unlimited
+	AssignmentSoftCap int  // assigned tokens past which a warning is sent ahead of AssignmentCap; 0 never warns
+	RedisClock        bool // take the time from Redis rather than this node, see Now
+
+	clockOffset atomic.Int64 // Redis TIME minus the local clock in nanoseconds, see SyncClock
+
+	timingMu   sync.RWMutex
+	poolTiming map[string]TimingConfig // per-pool overrides of Timing, see SetPoolTiming
+	liveTiming map[string]TimingConfig // runtime overrides on top of those, see SaveTimingOverride
 }
 
-// NewTokenRepository creates a new token repository instance
-func NewTokenRepository(RedisClient *redis.Client) *TokenRepository {
-	return &TokenRepository{RedisClient: RedisClient}
+// Config groups the tunables of the repository subsystems
+type Config struct {
+	Cleanup CleanupConfig
+	Queue   QueueConfig
+	Timing  TimingConfig
+	Cipher  *encryption.Cipher
+
+	AssignmentCap     int
+	AssignmentSoftCap int
+	RedisClock        bool
 }
 
-// SaveToken adds a new token to the available pool
-func (r *TokenRepository) SaveToken(ctx context.Context, token string) error {
-	if err := r.RedisClient.SAdd(ctx, constants.KeyTokenPool, token).Err(); err != nil {
-		return fmt.Errorf("failed to save token: %w", err)
+// NewTokenRepository creates a new token repository instance
+func NewTokenRepository(RedisClient *redis.Client, config Config) *TokenRepository {
+	return &TokenRepository{
+		RedisClient: RedisClient,
+		Cleanup:     config.Cleanup.withDefaults(),
+		Queue:       config.Queue.withDefaults(),
+		Timing:      config.Timing.withDefaults(),
+		Cipher:      config.Cipher,
+
+		AssignmentCap:     config.AssignmentCap,
+		AssignmentSoftCap: config.AssignmentSoftCap,
+		RedisClock:        config.RedisClock,
 	}
+}
 
-	// Initialize token in keepalive with current time
-	err := r.RedisClient.ZAdd(ctx, constants.KeyKeepaliveTokens, redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: token,
-	}).Err()
-
+// SaveToken adds a new token to the available set of a pool, indexed by its
+// labels. A token with an activateAt in the future is held inactive until the
+// activation sweep moves it into the pool; a zero activateAt means now. It
+// records a token.create event (WithEvent).
+func (r *TokenRepository) SaveToken(ctx context.Context, pool, token string, labels map[string]string, activateAt time.Time) error {
+	ciphertext, err := r.storeCiphertext(token)
 	if err != nil {
-		return fmt.Errorf("failed to initialize token keepalive: %w", err)
+		return err
 	}
-
-	return nil
+	return r.saveRef(ctx, pool, r.ref(token), ciphertext, labels, activateAt)
 }
 
-func (r *TokenRepository) AssignToken(ctx context.Context) (string, error) {
-	// Fetch a token from the pool
-	token, err := r.RedisClient.SPop(ctx, "token_pool").Result()
-	if err == redis.Nil {
-		return "", constants.ErrNoAvailableTokens
-	}
-	if err != nil {
+// SaveHashedToken adds a token by the SHA-256 handle of its value only. The
+// value itself never reaches Redis; it is resolved from the secret store.
+func (r *TokenRepository) SaveHashedToken(ctx context.Context, pool, secret string, labels map[string]string, activateAt time.Time) (string, error) {
+	handle := secrets.Handle(secret)
+	if err := r.saveRef(ctx, pool, handle, "", labels, activateAt); err != nil {
 		return "", err
 	}
+	return handle, nil
+}
 
-	// Try acquiring a lock on the token
-	lockKey := constants.PrefixLockKey + ":" + token
-	success, err := r.RedisClient.SetNX(ctx, lockKey, constants.LockValue, constants.TokenLockTime*time.Second).Result()
+// saveRef stores a new token, failing with ErrTokenExists if its value was
+// ever issued before, whatever state it is in or even once deleted
+func (r *TokenRepository) saveRef(ctx context.Context, pool, token, ciphertext string, labels map[string]string, activateAt time.Time) error {
+	reserved, err := r.reserveRefs(ctx, []string{token})
 	if err != nil {
-		return "", err
+		return err
 	}
-	if !success {
-		return "", constants.ErrTokenAlreadyInUse
+	if !reserved[0] {
+		return constants.ErrTokenExists
 	}
 
-	// Move token to assigned state
 	pipe := r.RedisClient.TxPipeline()
-	pipe.SAdd(ctx, "assigned_tokens", token)
-	pipe.ZAdd(ctx, "keepalive_tokens", redis.Z{
-		Score:  float64(time.Now().Add(60 * time.Second).Unix()), // 60s expiry timer
-		Member: token,
-	})
-	_, err = pipe.Exec(ctx)
-	if err != nil {
-		// Rollback the lock if the transaction fails
-		r.RedisClient.Del(ctx, lockKey)
-		return "", err
+	if err := r.queueSave(ctx, pipe, pool, token, ciphertext, labels, activateAt, r.Now()); err != nil {
+		r.releaseRefs(ctx, []string{token})
+		return err
 	}
-
-	return token, nil
+	if _, err := pipe.Exec(ctx); err != nil {
+		r.releaseRefs(ctx, []string{token})
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return nil
 }
 
-// KeepAlive extends the lifetime of a token
-func (r *TokenRepository) KeepAlive(ctx context.Context, token string) error {
-	// Check if token exists
-	inPool, err := r.RedisClient.SIsMember(ctx, constants.KeyTokenPool, token).Result()
+// queueSave queues the writes that add a new token to a pool, and its event
+func (r *TokenRepository) queueSave(ctx context.Context, pipe redis.Pipeliner, pool, token, ciphertext string, labels map[string]string, activateAt, now time.Time) error {
+	event, err := r.event(ctx, pool, tokenEvent("token.create", token))
 	if err != nil {
-		return fmt.Errorf("failed to check token in pool: %w", err)
+		return err
 	}
-
-	inAssigned, err := r.RedisClient.SIsMember(ctx, constants.KeyAssignedTokens, token).Result()
-	if err != nil {
-		return fmt.Errorf("failed to check token in assigned: %w", err)
+	keys := keysFor(pool)
+	if err := indexLabels(ctx, pipe, keys, token, labels); err != nil {
+		return err
 	}
 
-	if !inPool && !inAssigned {
-		return constants.ErrTokenNotFound
+	if activateAt.After(now) {
+		// The idle clock starts when the activation sweep makes it available
+		pipe.ZAdd(ctx, keys.pending, redis.Z{Score: float64(activateAt.Unix()), Member: token})
+		recordCreated(ctx, pipe, token, pool, TokenStatePending, now)
+	} else {
+		pipe.SAdd(ctx, keys.available, token)
+		pipe.ZAdd(ctx, keys.keepalive, redis.Z{Score: float64(now.Unix()), Member: token})
+		recordCreated(ctx, pipe, token, pool, TokenStateAvailable, now)
 	}
-
-	// Update keepalive timestamp
-	err = r.RedisClient.ZAdd(ctx, constants.KeyKeepaliveTokens, redis.Z{
-		Score:  float64(time.Now().Unix() + constants.TokenAutoReleaseTime),
-		Member: token,
-	}).Err()
-
-	if err != nil {
-		return constants.ErrFailedKeepAlive
+	pipe.HSet(ctx, constants.KeyTokenPoolIndex, token, pool)
+	pipe.SAdd(ctx, constants.KeyPools, pool)
+	if ciphertext != "" {
+		pipe.HSet(ctx, constants.KeyTokenCiphertext, token, ciphertext)
 	}
-
+	appendEvent(ctx, pipe, event)
 	return nil
 }
 
-// CleanupResult holds statistics about token cleanup
-type CleanupResult struct {
-	TokensReleased  int
-	TokensDeleted   int
-	ProcessingError error
-}
-
-// CleanupExpiredTokens checks for and handles expired tokens
-func (r *TokenRepository) CleanupExpiredTokens(ctx context.Context) (map[string]int64, error) {
-	result := r.cleanupExpiredTokens(ctx)
-	if result.ProcessingError != nil {
-		return nil, result.ProcessingError
+// AssignToken assigns a random available token within the limits of opts. It
+// records a token.assign event (WithEvent).
+func (r *TokenRepository) AssignToken(ctx context.Context, pool string, opts AssignOptions) (*Token, error) {
+	// Fallback assignment calls this once per pool in the chain
+	ctx = datasources.WithPool(ctx, pool)
+	full, err := r.atAssignmentCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		return nil, constants.ErrAssignmentCapReached
 	}
 
-	res := make(map[string]int64)
-
-	res[constants.KeyAssignedTokens] = int64(result.TokensReleased)
-	res[constants.KeyTokenPool] = int64(result.TokensDeleted)
+	// Fetch a token from the pool
+	token, err := r.popToken(ctx, pool, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	return res, nil
+	return r.claimToken(ctx, pool, token, opts.Client)
 }
 
-// cleanupExpiredTokens performs the actual cleanup work and returns statistics
-func (r *TokenRepository) cleanupExpiredTokens(ctx context.Context) CleanupResult {
-	result := CleanupResult{}
-	now := time.Now().Unix()
-	releaseBefore := now - constants.TokenAutoReleaseTime
-	deleteBefore := now - constants.TokenDeletionTime
-
-	log.Printf("[Cleanup] Starting token cleanup at %d", now)
-
-	// Process tokens concurrently
-	var wg sync.WaitGroup
-	resultChan := make(chan CleanupResult, 2)
-
-	// Handle assigned tokens
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		localResult := r.cleanupAssignedTokens(ctx, releaseBefore, deleteBefore)
-		resultChan <- localResult
-	}()
-
-	// Handle pool tokens
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		localResult := r.cleanupPoolTokens(ctx, deleteBefore)
-		resultChan <- localResult
-	}()
-
-	// Wait for all goroutines to complete
-	wg.Wait()
-	close(resultChan)
-
-	// Collect results
-	for res := range resultChan {
-		result.TokensReleased += res.TokensReleased
-		result.TokensDeleted += res.TokensDeleted
-		if res.ProcessingError != nil && result.ProcessingError == nil {
-			result.ProcessingError = res.ProcessingError
-		}
+// AssignSpecificToken claims a named token for client if it is available.
+// ErrTokenAlreadyInUse means it exists but is held by someone else. It
+// records a token.assign event (WithEvent).
+func (r *TokenRepository) AssignSpecificToken(ctx context.Context, token, client string) (*Token, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return nil, err
 	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
 
-	if result.ProcessingError != nil {
-		log.Printf("[Cleanup] Token cleanup encountered errors: %v", result.ProcessingError)
-	} else {
-		log.Printf("[Cleanup] Token cleanup completed: released %d, deleted %d",
-			result.TokensReleased, result.TokensDeleted)
+	// SREM is the atomic take: only one caller can remove the member
+	removed, err := r.RedisClient.SRem(ctx, keys.available, ref).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token from pool: %w", err)
+	}
+	if removed == 0 {
+		assigned, err := r.RedisClient.SIsMember(ctx, keys.assigned, ref).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if token is assigned: %w", err)
+		}
+		if assigned {
+			return nil, constants.ErrTokenAlreadyInUse
+		}
+		return nil, constants.ErrTokenNotFound
 	}
 
-	return result
+	claimed, err := r.claimToken(ctx, pool, ref, client)
+	if err != nil {
+		return nil, err
+	}
+	// Hand back the token as the caller named it, not its stored handle
+	claimed.Value = token
+	return claimed, nil
 }
 
-// cleanupAssignedTokens handles cleanup of assigned tokens
-func (r *TokenRepository) cleanupAssignedTokens(ctx context.Context, releaseBefore, deleteBefore int64) CleanupResult {
-	result := CleanupResult{}
+// claimToken locks a token already popped from the pool, marks it assigned
+// to client and returns it with its value revealed. A token it can't lock
+// goes back to the pool.
+func (r *TokenRepository) claimToken(ctx context.Context, pool, token, client string) (*Token, error) {
+	keys := keysFor(pool)
 
-	// Get all assigned tokens
-	assignedTokens, err := r.RedisClient.SMembers(ctx, constants.KeyAssignedTokens).Result()
-	if err != nil {
-		result.ProcessingError = fmt.Errorf("failed to fetch assigned tokens: %w", err)
-		return result
+	// The cap is only checked before popping, so the slot is what enforces it
+	if err := r.acquireSlot(ctx, token); err != nil {
+		if errors.Is(err, constants.ErrAssignmentCapReached) {
+			r.RedisClient.SAdd(ctx, keys.available, token)
+		}
+		return nil, err
 	}
 
-	log.Printf("[Cleanup] Found %d assigned tokens", len(assignedTokens))
-
-	if len(assignedTokens) == 0 {
-		return result
+	// Try acquiring a lock on the token
+	lock := lockKey(token)
+	success, err := r.RedisClient.SetNX(ctx, lock, constants.LockValue, r.timingFor(pool).LockTTL).Result()
+	if err != nil {
+		r.RedisClient.SRem(ctx, constants.KeyAssignmentSlots, token)
+		return nil, err
+	}
+	if !success {
+		// A stale lock is still held; put the token back rather than losing it
+		r.RedisClient.SRem(ctx, constants.KeyAssignmentSlots, token)
+		r.RedisClient.SAdd(ctx, keys.available, token)
+		return nil, constants.ErrTokenAlreadyInUse
 	}
 
+	// Move token to assigned state
+	now := r.Now()
 	pipe := r.RedisClient.TxPipeline()
-
-	for _, token := range assignedTokens {
-		expiry, err := r.RedisClient.ZScore(ctx, constants.KeyKeepaliveTokens, token).Result()
-
-		if err == redis.Nil {
-			// Token with no keepalive record should be deleted
-			pipe.SRem(ctx, constants.KeyAssignedTokens, token)
-			pipe.ZRem(ctx, constants.KeyKeepaliveTokens, token)
-			result.TokensDeleted++
-			log.Printf("[Cleanup] Token %s had no keepalive record - removing", token)
-		} else if err != nil {
-			log.Printf("[Cleanup] Failed to fetch expiry for token %s: %v", token, err)
-			continue
-		} else {
-			expiryTime := int64(expiry)
-
-			if expiryTime <= deleteBefore {
-				// Delete tokens inactive for 5+ minutes
-				pipe.SRem(ctx, constants.KeyAssignedTokens, token)
-				pipe.ZRem(ctx, constants.KeyKeepaliveTokens, token)
-				result.TokensDeleted++
-				log.Printf("[Cleanup] Deleting expired token %s (no keepalive for >5min)", token)
-			} else if expiryTime <= releaseBefore {
-				// Release tokens inactive for 60+ seconds but less than 5 minutes
-				pipe.SRem(ctx, constants.KeyAssignedTokens, token)
-				pipe.SAdd(ctx, constants.KeyTokenPool, token)
-				result.TokensReleased++
-				log.Printf("[Cleanup] Returning token %s to pool (expired after 60s)", token)
-			}
-		}
+	pipe.SAdd(ctx, keys.assigned, token)
+	pipe.ZAdd(ctx, keys.keepalive, redis.Z{
+		Score:  r.timingFor(pool).expiresAt(now),
+		Member: token,
+	})
+	recordOwner(ctx, pipe, token, client)
+	rev := recordAssigned(ctx, pipe, token, client, now)
+	event := tokenEvent("token.assign", token)
+	event.Actor = client
+	err = r.queueEvent(ctx, pipe, pool, event)
+	if err == nil {
+		_, err = pipe.Exec(ctx)
 	}
-
-	// Execute Redis transaction
-	_, err = pipe.Exec(ctx)
 	if err != nil {
-		result.ProcessingError = fmt.Errorf("failed to execute cleanup for assigned tokens: %w", err)
+		// Rollback the lock and slot if the transaction fails
+		r.RedisClient.Del(ctx, lock)
+		r.RedisClient.SRem(ctx, constants.KeyAssignmentSlots, token)
+		return nil, err
 	}
 
-	return result
+	value, err := r.revealOne(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	claimed := ClaimedToken(pool, value, client, now)
+	claimed.Version, _ = rev.Int64()
+	return claimed, nil
 }
 
-// cleanupPoolTokens handles cleanup of tokens in the pool
-func (r *TokenRepository) cleanupPoolTokens(ctx context.Context, deleteBefore int64) CleanupResult {
-	result := CleanupResult{}
+// lockKey is the assignment lock of the token stored under ref. Releases
+// and deletes drop it, so it only outlives an assignment that crashed midway.
+func lockKey(ref string) string {
+	return constants.PrefixLockKey + ":" + ref
+}
 
-	// Get tokens in the pool
-	poolTokens, err := r.RedisClient.SMembers(ctx, constants.KeyTokenPool).Result()
+// NextReleaseIn estimates how long until the next assigned token is released
+// back to the pool, based on the oldest keepalive score.
+func (r *TokenRepository) NextReleaseIn(ctx context.Context, pool string) (time.Duration, error) {
+	oldest, err := r.RedisClient.ZRangeWithScores(ctx, keysFor(pool).keepalive, 0, 0).Result()
 	if err != nil {
-		result.ProcessingError = fmt.Errorf("failed to fetch pool tokens: %w", err)
-		return result
+		return 0, fmt.Errorf("failed to fetch oldest keepalive: %w", err)
 	}
-
-	if len(poolTokens) == 0 {
-		return result
+	if len(oldest) == 0 {
+		return 0, nil
 	}
 
-	pipe := r.RedisClient.TxPipeline()
+	releaseAt := time.Unix(int64(oldest[0].Score), 0).Add(r.timingFor(pool).KeepaliveGrace)
+	return max(time.Until(releaseAt), 0), nil
+}
 
-	for _, token := range poolTokens {
-		// Check if token has received a keepalive in the last 5 minutes
-		expiry, err := r.RedisClient.ZScore(ctx, constants.KeyKeepaliveTokens, token).Result()
+// KeepaliveOptions changes how KeepAlive treats the token's current state
+type KeepaliveOptions struct {
+	Force        bool // set the expiry even if it is earlier than the current one
+	AssignedOnly bool // reject tokens sitting in the pool with ErrKeepaliveNotAssigned
+}
 
-		if err == redis.Nil || (err == nil && int64(expiry) <= deleteBefore) {
-			// Delete tokens with no keepalive or keepalive older than 5 minutes
-			pipe.SRem(ctx, constants.KeyTokenPool, token)
-			if err == nil {
-				pipe.ZRem(ctx, constants.KeyKeepaliveTokens, token)
-			}
-			result.TokensDeleted++
-		} else if err != nil {
-			result.ProcessingError = fmt.Errorf("failed to fetch expiry for token %s: %w", token, err)
-			return result
-		}
+// keepaliveScript sets the expiry of a token in the available set KEYS[1] or
+// assigned set KEYS[2] in the keepalive set KEYS[3] to ARGV[1], and clears the
+// reclaim warning in the callback hash KEYS[4]. The expiry only ever moves
+// later unless ARGV[2] is "1". An assigned token's expiry never passes
+// ARGV[5] seconds after the assigned_at of its record KEYS[5] when ARGV[5] is
+// above 0; once ARGV[6] (now) has reached that point it returns "max_hold"
+// instead. It returns the expiry in force, false when the token is in neither
+// set, or "unassigned" when ARGV[3] is "1" and the token is only available.
+// It takes an event (outboxLua).
+var keepaliveScript = redis.NewScript(outboxLua + recordLua + `
+local token = ARGV[4]
+local assigned = redis.call('SISMEMBER', KEYS[2], token) == 1
+if not assigned then
+	if redis.call('SISMEMBER', KEYS[1], token) == 0 then
+		return false
+	end
+	if ARGV[3] == '1' then
+		return 'unassigned'
+	end
+end
+local expiry = tonumber(ARGV[1])
+local current = tonumber(redis.call('ZSCORE', KEYS[3], token))
+if ARGV[2] ~= '1' and current and current > expiry then
+	expiry = current
+end
+local maxHold = tonumber(ARGV[5])
+local record = record_load(KEYS[5])
+local since = record and tonumber(record.assigned_at) or 0
+if assigned and maxHold > 0 and since > 0 then
+	local limit = since + maxHold
+	if limit <= tonumber(ARGV[6]) then
+		return 'max_hold'
+	end
+	if expiry > limit then
+		expiry = limit
+	end
+end
+redis.call('ZADD', KEYS[3], expiry, token)
+redis.call('HDEL', KEYS[4], 'notified')
+outbox()
+return tostring(expiry)
+`)
+
+// KeepAlive extends the lifetime of a token. It never shortens it: a call
+// racing with a later keepalive, or made with stale state, leaves the later
+// expiry in place. With opts.Force the expiry is set regardless, so an admin
+// can bring it forward. Neither extends an assignment past its pool's
+// MaxHold: the expiry stops there, and once it is reached KeepAlive fails
+// with ErrMaxHoldReached. It takes an event (WithEvent).
+func (r *TokenRepository) KeepAlive(ctx context.Context, token string, opts KeepaliveOptions) error {
+	token = r.ref(token)
+	pool, err := r.PoolOf(ctx, token)
+	if err != nil {
+		return err
 	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
 
-	// Execute Redis transaction
-	_, err = pipe.Exec(ctx)
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.keepalive", token))
+	if err != nil {
+		return err
+	}
+	timing, now := r.timingFor(pool), r.Now()
+	expiry := strconv.FormatFloat(timing.expiresAt(now), 'f', -1, 64)
+	res, err := keepaliveScript.Run(ctx, r.RedisClient,
+		[]string{keys.available, keys.assigned, keys.keepalive, callbackKey(token), recordKey(token)},
+		append([]any{expiry, opts.Force, opts.AssignedOnly, token, int64(timing.MaxHold.Seconds()), now.Unix()}, event...)...,
+	).Text()
+	if errors.Is(err, redis.Nil) {
+		return constants.ErrTokenNotFound
+	}
 	if err != nil {
-		result.ProcessingError = fmt.Errorf("failed to execute cleanup for pool tokens: %w", err)
+		return constants.ErrFailedKeepAlive
+	}
+	switch res {
+	case "unassigned":
+		return constants.ErrKeepaliveNotAssigned
+	case "max_hold":
+		return constants.ErrMaxHoldReached
 	}
+	return nil
+}
 
-	return result
+// DeleteToken permanently removes a token from all pools. With ifVersion above
+// 0 it fails with ErrVersionMismatch unless the token is at that revision. It
+// takes an event (WithEvent).
+func (r *TokenRepository) DeleteToken(ctx context.Context, token string, ifVersion int64) error {
+	token = r.ref(token)
+	pool, err := r.PoolOf(ctx, token)
+	if err != nil {
+		return err
+	}
+	return r.deleteRef(datasources.WithPool(ctx, pool), pool, token, ifVersion)
 }
 
-// DeleteToken permanently removes a token from all pools
-func (r *TokenRepository) DeleteToken(ctx context.Context, token string) error {
-	pipe := r.RedisClient.TxPipeline()
-	pipe.SRem(ctx, constants.KeyTokenPool, token)
-	pipe.SRem(ctx, constants.KeyAssignedTokens, token)
-	pipe.ZRem(ctx, constants.KeyKeepaliveTokens, token)
+// deleteRef removes the token stored under ref from pool and every index
+func (r *TokenRepository) deleteRef(ctx context.Context, pool, token string, ifVersion int64) error {
+	keys := keysFor(pool)
 
-	result, err := pipe.Exec(ctx)
+	labels, err := r.labelsOf(ctx, token)
+	if err != nil {
+		return err
+	}
+	alias, err := r.aliasOf(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.guarded(ctx, token, ifVersion, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, keys.available, token)
+		pipe.SRem(ctx, keys.assigned, token)
+		pipe.SRem(ctx, keys.quarantine, token)
+		pipe.ZRem(ctx, keys.pending, token)
+		pipe.ZRem(ctx, keys.keepalive, token)
+		pipe.HDel(ctx, constants.KeyTokenPoolIndex, token)
+		pipe.HDel(ctx, constants.KeyTokenCiphertext, token)
+		unindexLabels(ctx, pipe, keys, token, labels)
+		unindexAlias(ctx, pipe, token, alias)
+		pipe.HDel(ctx, constants.KeyTokenRateLimits, token)
+		pipe.HDel(ctx, constants.KeyTokenProbes, token)
+		pipe.HDel(ctx, constants.KeyTokenProbeFailures, token)
+		pipe.ZRem(ctx, keys.quarantinedAt, token)
+		pipe.HDel(ctx, constants.KeyTokenOwners, token)
+		pipe.SRem(ctx, constants.KeyAssignmentSlots, token)
+		pipe.Del(ctx, callbackKey(token))
+		pipe.Del(ctx, lockKey(token))
+		pipe.Del(ctx, recordKey(token))
+		return r.queueEvent(ctx, pipe, pool, tokenEvent("token.delete", token))
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete token: %w", err)
 	}
@@ -298,7 +418,7 @@ func (r *TokenRepository) DeleteToken(ctx context.Context, token string) error {
 	// Check if any key was actually removed
 	affected := false
 	for _, res := range result {
-		if res.(*redis.IntCmd).Val() > 0 {
+		if removed, ok := res.(*redis.IntCmd); ok && removed.Val() > 0 {
 			affected = true
 			break
 		}
@@ -311,9 +431,19 @@ func (r *TokenRepository) DeleteToken(ctx context.Context, token string) error {
 	return nil
 }
 
-// UnblockToken moves a token from assigned back to the available pool
-func (r *TokenRepository) UnblockToken(ctx context.Context, token string) error {
-	exists, err := r.RedisClient.SIsMember(ctx, constants.KeyAssignedTokens, token).Result()
+// UnblockToken moves a token from assigned back to the available pool. With
+// ifVersion above 0 it fails with ErrVersionMismatch unless the token is at
+// that revision. It takes an event (WithEvent).
+func (r *TokenRepository) UnblockToken(ctx context.Context, token string, ifVersion int64) error {
+	token = r.ref(token)
+	pool, err := r.PoolOf(ctx, token)
+	if err != nil {
+		return err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+
+	exists, err := r.RedisClient.SIsMember(ctx, keys.assigned, token).Result()
 	if err != nil {
 		return fmt.Errorf("failed to check if token is assigned: %w", err)
 	}
@@ -322,17 +452,22 @@ func (r *TokenRepository) UnblockToken(ctx context.Context, token string) error
 		return constants.ErrTokenNotAssigned
 	}
 
-	pipe := r.RedisClient.TxPipeline()
-	pipe.SRem(ctx, constants.KeyAssignedTokens, token)
-	pipe.SAdd(ctx, constants.KeyTokenPool, token) // Move back to pool
-
-	// Reset keepalive timestamp to current time
-	pipe.ZAdd(ctx, constants.KeyKeepaliveTokens, redis.Z{
-		Score:  float64(time.Now().Unix() + constants.TokenAutoReleaseTime),
-		Member: token,
+	_, err = r.guarded(ctx, token, ifVersion, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, keys.assigned, token)
+		pipe.SAdd(ctx, keys.available, token) // Move back to pool
+		pipe.HDel(ctx, constants.KeyTokenOwners, token)
+		pipe.SRem(ctx, constants.KeyAssignmentSlots, token)
+		pipe.Del(ctx, callbackKey(token))
+		pipe.Del(ctx, lockKey(token)) // so the next assignment can lock it
+		recordState(ctx, pipe, token, TokenStateAvailable, r.Now())
+
+		// Reset keepalive timestamp to current time
+		pipe.ZAdd(ctx, keys.keepalive, redis.Z{
+			Score:  r.timingFor(pool).expiresAt(r.Now()),
+			Member: token,
+		})
+		return r.queueEvent(ctx, pipe, pool, tokenEvent("token.release", token))
 	})
-
-	_, err = pipe.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to unblock token: %w", err)
 	}
@@ -340,36 +475,242 @@ func (r *TokenRepository) UnblockToken(ctx context.Context, token string) error
 	return nil
 }
 
+// TokenStatus describes where a token is in its lifecycle. State is one of
+// available, assigned, quarantined or pending; the version, owner and
+// timestamps come from the token record and are unset for tokens not yet
+// migrated to records.
+type TokenStatus struct {
+	Token
+	ExpiresIn *int64       `json:"expires_in,omitempty"` // seconds until the assignment expires, negative once lapsed
+	Locked    bool         `json:"locked"`               // whether an assignment lock key exists
+	LockTTL   *int64       `json:"lock_ttl,omitempty"`   // seconds left on the lock, -1 if it has no expiry
+	Probe     *ProbeResult `json:"probe,omitempty"`      // last upstream health probe, when probing is enabled
+	Alias     string       `json:"alias,omitempty"`      // human-friendly name the token can be looked up by
+
+	ActivateAt *time.Time `json:"activate_at,omitempty"` // when a pending token joins the pool
+}
+
+// Token states reported by GetTokenStatus
+const (
+	TokenStateAvailable   = "available"
+	TokenStateAssigned    = "assigned"
+	TokenStateQuarantined = "quarantined"
+	TokenStatePending     = "pending" // created with a future activation time
+	TokenStateDeleted     = "deleted" // only ever a transition target; deleted tokens leave no trace
+)
+
+// StateOf returns just the state of a token, or ErrTokenNotFound
+func (r *TokenRepository) StateOf(ctx context.Context, token string) (string, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+
+	pipe := r.RedisClient.Pipeline()
+	inPool := pipe.SIsMember(ctx, keys.available, ref)
+	inAssigned := pipe.SIsMember(ctx, keys.assigned, ref)
+	inQuarantine := pipe.SIsMember(ctx, keys.quarantine, ref)
+	activation := pipe.ZScore(ctx, keys.pending, ref)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to fetch token state: %w", err)
+	}
+
+	switch {
+	case inAssigned.Val():
+		return TokenStateAssigned, nil
+	case inPool.Val():
+		return TokenStateAvailable, nil
+	case inQuarantine.Val():
+		return TokenStateQuarantined, nil
+	case activation.Err() == nil:
+		return TokenStatePending, nil
+	}
+	return "", constants.ErrTokenNotFound
+}
+
+// GetTokenStatus reports the state, expiry and lock of a token
+func (r *TokenRepository) GetTokenStatus(ctx context.Context, token string) (*TokenStatus, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+
+	pipe := r.RedisClient.Pipeline()
+	inPool := pipe.SIsMember(ctx, keys.available, ref)
+	inAssigned := pipe.SIsMember(ctx, keys.assigned, ref)
+	inQuarantine := pipe.SIsMember(ctx, keys.quarantine, ref)
+	expiry := pipe.ZScore(ctx, keys.keepalive, ref)
+	activation := pipe.ZScore(ctx, keys.pending, ref)
+	lockTTL := pipe.TTL(ctx, lockKey(ref))
+	record := pipe.HGetAll(ctx, recordKey(ref))
+	alias := pipe.HGet(ctx, constants.KeyAliasOfToken, ref)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to fetch token status: %w", err)
+	}
+
+	labels, err := r.labelsOf(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := r.probeOf(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TokenStatus{Token: Token{Value: token, Pool: pool, Labels: labels}, Probe: probe, Alias: alias.Val()}
+	switch {
+	case inAssigned.Val():
+		status.State = TokenStateAssigned
+	case inPool.Val():
+		status.State = TokenStateAvailable
+	case inQuarantine.Val():
+		status.State = TokenStateQuarantined
+	case activation.Err() == nil:
+		status.State = TokenStatePending
+		activateAt := time.Unix(int64(activation.Val()), 0)
+		status.ActivateAt = &activateAt
+	default:
+		return nil, constants.ErrTokenNotFound
+	}
+
+	status.applyRecord(parseRecord(record.Val()))
+
+	if status.State == TokenStateAssigned && expiry.Err() == nil {
+		remaining := int64(expiry.Val()) - r.Now().Unix()
+		status.ExpiresIn = &remaining
+	}
+
+	// TTL is -2 when the key doesn't exist and -1 when it has no expiry
+	if ttl := lockTTL.Val(); ttl != -2 {
+		status.Locked = true
+		seconds := int64(-1)
+		if ttl >= 0 {
+			seconds = int64(ttl.Seconds())
+		}
+		status.LockTTL = &seconds
+	}
+	return status, nil
+}
+
+// AvailableCount returns how many tokens a pool has ready for assignment
+func (r *TokenRepository) AvailableCount(ctx context.Context, pool string) (int64, error) {
+	n, err := r.RedisClient.SCard(ctx, keysFor(pool).available).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count available tokens: %w", err)
+	}
+	return n, nil
+}
+
 // GetAvailableTokens returns all tokens in the pool
-func (r *TokenRepository) GetAvailableTokens(ctx context.Context) ([]string, error) {
-	tokens, err := r.RedisClient.SMembers(ctx, constants.KeyTokenPool).Result()
+func (r *TokenRepository) GetAvailableTokens(ctx context.Context, pool string) ([]string, error) {
+	tokens, err := r.RedisClient.SMembers(ctx, keysFor(pool).available).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get available tokens: %w", err)
 	}
-	return tokens, nil
+	return r.reveal(ctx, tokens)
+}
+
+// Expiry is when an assignment runs out. Both fields are nil for a token
+// without a keepalive record, so "unknown" is never confused with "lapsed".
+type Expiry struct {
+	ExpiresAt        *time.Time `json:"expires_at"`
+	RemainingSeconds *int64     `json:"remaining_seconds"` // 0 once the assignment has lapsed
 }
 
-// GetAssignedTokensWithExpiry returns assigned tokens with their remaining time
-func (r *TokenRepository) GetAssignedTokensWithExpiry(ctx context.Context) (map[string]int64, error) {
-	tokens, err := r.RedisClient.SMembers(ctx, constants.KeyAssignedTokens).Result()
+// expiryOf reads an assignment's expiry from its keepalive score
+func expiryOf(score *redis.FloatCmd, now time.Time) Expiry {
+	expiry, err := score.Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get assigned tokens: %w", err)
+		return Expiry{}
 	}
+	return expiryAt(expiry, now)
+}
 
-	now := time.Now().Unix() // Current timestamp
-	expiryMap := make(map[string]int64)
+func expiryAt(score float64, now time.Time) Expiry {
+	at := time.Unix(int64(score), 0).UTC()
+	remaining := max(int64(at.Sub(now).Seconds()), 0)
+	return Expiry{ExpiresAt: &at, RemainingSeconds: &remaining}
+}
 
-	for _, token := range tokens {
-		expiry, err := r.RedisClient.ZScore(ctx, constants.KeyKeepaliveTokens, token).Result()
-		if err == redis.Nil {
-			expiryMap[token] = -1 // No expiry info available
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to get expiry for token %s: %w", token, err)
-		} else {
-			remaining := max(int64(expiry)-now, -1)
-			expiryMap[token] = remaining
+// expiriesOf fetches the keepalive expiry of each ref with pipelined ZMSCORE
+// calls of up to ExpiryBatchSize members, instead of a ZSCORE per token.
+// Refs without a keepalive score get an empty Expiry.
+func (r *TokenRepository) expiriesOf(ctx context.Context, keepalive string, refs []string, now time.Time) ([]Expiry, error) {
+	pipe := r.RedisClient.Pipeline()
+	cmds := make([]*redis.Cmd, 0, len(refs)/constants.ExpiryBatchSize+1)
+	for start := 0; start < len(refs); start += constants.ExpiryBatchSize {
+		chunk := refs[start:min(start+constants.ExpiryBatchSize, len(refs))]
+		args := make([]interface{}, 0, len(chunk)+2)
+		args = append(args, "ZMSCORE", keepalive)
+		for _, ref := range chunk {
+			args = append(args, ref)
 		}
+		cmds = append(cmds, pipe.Do(ctx, args...))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch token expiries: %w", err)
 	}
 
-	return expiryMap, nil
+	// ZMSCORE answers nil for missing members, which FloatSliceCmd would turn into 0
+	expiries := make([]Expiry, 0, len(refs))
+	for _, cmd := range cmds {
+		scores, err := cmd.Slice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch token expiries: %w", err)
+		}
+		for _, raw := range scores {
+			var expiry Expiry
+			switch score := raw.(type) {
+			case float64: // RESP3
+				expiry = expiryAt(score, now)
+			case string: // RESP2 bulk string
+				if parsed, err := strconv.ParseFloat(score, 64); err == nil {
+					expiry = expiryAt(parsed, now)
+				}
+			}
+			expiries = append(expiries, expiry)
+		}
+	}
+	return expiries, nil
+}
+
+// AssignedToken is an assigned token and when its assignment runs out
+type AssignedToken struct {
+	Token string `json:"token"`
+	Expiry
+}
+
+// GetAssignedTokensWithExpiry returns assigned tokens with their expiry
+func (r *TokenRepository) GetAssignedTokensWithExpiry(ctx context.Context, pool string) ([]AssignedToken, error) {
+	keys := keysFor(pool)
+
+	tokens, err := r.RedisClient.SMembers(ctx, keys.assigned).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assigned tokens: %w", err)
+	}
+
+	values, err := r.reveal(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	expiries, err := r.expiriesOf(ctx, keys.keepalive, tokens, r.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := make([]AssignedToken, len(tokens))
+	for i := range tokens {
+		assigned[i] = AssignedToken{Token: values[i], Expiry: expiries[i]}
+	}
+	return assigned, nil
 }
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestKeepAliveNeverShortensAnExpiry(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"}); err != nil {
+		t.Fatalf("AssignToken: %v", err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	setExpiry(t, r, "default", "tok-1", later)
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{}); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	if got := keepaliveScore(t, r, "default", "tok-1"); got != later.Unix() {
+		t.Errorf("expiry moved to %d, want the later %d kept", got, later.Unix())
+	}
+
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{Force: true}); err != nil {
+		t.Fatalf("forced KeepAlive: %v", err)
+	}
+	if got := keepaliveScore(t, r, "default", "tok-1"); got >= later.Unix() {
+		t.Errorf("forced keepalive left the expiry at %d", got)
+	}
+	if got := eventsOf(t, r, "tok-1"); len(got) != 4 || got[3] != "token.keepalive" {
+		t.Errorf("events = %v, want one token.keepalive per keepalive", got)
+	}
+}
+
+func TestKeepAliveStopsAtMaxHold(t *testing.T) {
+	timing := testTiming
+	timing.MaxHold = time.Hour
+	r, _ := newTestRepository(t, timing)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	if _, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"}); err != nil {
+		t.Fatalf("AssignToken: %v", err)
+	}
+
+	// Assigned 59m30s ago: a keepalive's full minute would pass the hour
+	since := time.Now().Add(-time.Hour + 30*time.Second).Unix()
+	setRecord(t, r, "tok-1", "assigned_at", since)
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{}); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	if got, limit := keepaliveScore(t, r, "default", "tok-1"), since+3600; got != limit {
+		t.Errorf("expiry = %d, want it capped at the max hold %d", got, limit)
+	}
+
+	setRecord(t, r, "tok-1", "assigned_at", time.Now().Add(-2*time.Hour).Unix())
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{}); !errors.Is(err, constants.ErrMaxHoldReached) {
+		t.Errorf("KeepAlive past the max hold = %v, want ErrMaxHoldReached", err)
+	}
+	if got := eventsOf(t, r, "tok-1"); len(got) != 3 {
+		t.Errorf("events = %v, want none for the rejected keepalive", got)
+	}
+}
+
+func TestKeepAliveAssignedOnly(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+
+	if err := r.KeepAlive(ctx, "tok-1", KeepaliveOptions{AssignedOnly: true}); !errors.Is(err, constants.ErrKeepaliveNotAssigned) {
+		t.Errorf("KeepAlive of an available token = %v, want ErrKeepaliveNotAssigned", err)
+	}
+	if err := r.KeepAlive(ctx, "tok-9", KeepaliveOptions{}); !errors.Is(err, constants.ErrTokenNotFound) {
+		t.Errorf("KeepAlive of an unknown token = %v, want ErrTokenNotFound", err)
+	}
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// History streams that CompactHistory can trim, by name
+const (
+	HistoryAudit      = "audit"      // the audit history
+	HistoryDeliveries = "deliveries" // callback and webhook delivery attempts
+)
+
+var historyStreams = map[string]string{
+	HistoryAudit:      constants.KeyAuditStream,
+	HistoryDeliveries: constants.KeyCallbackDeliveries,
+}
+
+// Retention bounds a history stream by the age of its entries and by their
+// number. Zero leaves that bound to the stream's built-in length cap.
+type Retention struct {
+	MaxAge     time.Duration
+	MaxEntries int64
+}
+
+// CompactHistory trims a history stream to its retention, oldest entries
+// first, and returns how many it removed. Trimming is exact, unlike the
+// approximate cap applied on every append. Entries a consumer group has yet
+// to read are trimmed like any other.
+func (r *TokenRepository) CompactHistory(ctx context.Context, history string, retention Retention) (int64, error) {
+	stream, ok := historyStreams[history]
+	if !ok {
+		return 0, fmt.Errorf("unknown history %q", history)
+	}
+
+	var removed int64
+	if retention.MaxAge > 0 {
+		// Stream IDs start with the entry's time in milliseconds
+		minID := strconv.FormatInt(r.Now().Add(-retention.MaxAge).UnixMilli(), 10)
+		n, err := r.RedisClient.XTrimMinID(ctx, stream, minID).Result()
+		if err != nil {
+			return removed, fmt.Errorf("failed to trim %s history by age: %w", history, err)
+		}
+		removed += n
+	}
+	if retention.MaxEntries > 0 {
+		n, err := r.RedisClient.XTrimMaxLen(ctx, stream, retention.MaxEntries).Result()
+		if err != nil {
+			return removed, fmt.Errorf("failed to trim %s history by length: %w", history, err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// scanSet walks a set with SSCAN, handing each batch of members to fn.
+// Unlike SMEMBERS it never holds the whole set in memory, at the cost of
+// SSCAN's guarantees: members added or removed mid-scan may be missed, and a
+// member can be returned more than once.
+func (r *TokenRepository) scanSet(ctx context.Context, key string, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		members, next, err := r.RedisClient.SScan(ctx, key, cursor, "", constants.StreamScanCount).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", key, err)
+		}
+		if len(members) > 0 {
+			if err := fn(members); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// ScanAvailableTokens streams a pool's available tokens to fn in batches
+func (r *TokenRepository) ScanAvailableTokens(ctx context.Context, pool string, fn func([]string) error) error {
+	return r.scanSet(ctx, keysFor(pool).available, func(refs []string) error {
+		tokens, err := r.reveal(ctx, refs)
+		if err != nil {
+			return err
+		}
+		return fn(tokens)
+	})
+}
+
+// ScanAssignedTokens streams a pool's assigned tokens and their remaining time to fn in batches
+func (r *TokenRepository) ScanAssignedTokens(ctx context.Context, pool string, fn func([]AssignedToken) error) error {
+	keys := keysFor(pool)
+	return r.scanSet(ctx, keys.assigned, func(refs []string) error {
+		expiries, err := r.expiriesOf(ctx, keys.keepalive, refs, r.Now())
+		if err != nil {
+			return err
+		}
+
+		values, err := r.reveal(ctx, refs)
+		if err != nil {
+			return err
+		}
+
+		tokens := make([]AssignedToken, len(refs))
+		for i := range refs {
+			tokens[i] = AssignedToken{Token: values[i], Expiry: expiries[i]}
+		}
+		return fn(tokens)
+	})
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// forecastHorizons are the points ahead of now at which SimulateCleanup
+// forecasts a pool, the first being a sweep run straight away
+var forecastHorizons = []time.Duration{0, time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// CleanupForecast is what cleanup would do to a pool under one timing
+type CleanupForecast struct {
+	Timing    TimingSeconds   `json:"timing"`
+	Forecasts []ForecastPoint `json:"forecasts"`
+}
+
+// ForecastPoint is the outcome of a full cleanup sweep run some time from
+// now, assuming no token is kept alive, assigned or added in the meantime
+type ForecastPoint struct {
+	AfterSec  int   `json:"after_sec"`
+	Released  int   `json:"released"`  // assigned tokens returned to the pool
+	Deleted   int   `json:"deleted"`   // tokens removed, assigned or not
+	Available int64 `json:"available"` // tokens ready for assignment after the sweep
+}
+
+// SimulateCleanup forecasts cleanup of a pool under its current timing and
+// under proposed, from one snapshot of its keepalive scores, without changing
+// anything. Scores were set under the current assignment TTL, so for the
+// proposed forecast they are moved by the difference, as if the last
+// keepalive of every token had been made under the proposed TTL.
+func (r *TokenRepository) SimulateCleanup(ctx context.Context, pool string, proposed TimingConfig) (CleanupForecast, CleanupForecast, error) {
+	current := r.timingFor(pool)
+	snapshot, err := r.snapshotPool(ctx, pool, PhaseAll, 0, current.MaxHold > 0 || proposed.MaxHold > 0)
+	if err != nil {
+		return CleanupForecast{}, CleanupForecast{}, err
+	}
+
+	shift := int64((proposed.AssignmentTTL - current.AssignmentTTL).Seconds())
+	rebased := snapshot
+	rebased.expiries = make(map[string]int64, len(snapshot.expiries))
+	for token, expiry := range snapshot.expiries {
+		rebased.expiries[token] = expiry + shift
+	}
+
+	now := r.Now()
+	return forecastCleanup(snapshot, current, now), forecastCleanup(rebased, proposed, now), nil
+}
+
+// forecastCleanup runs decideCleanup over a snapshot at each forecast horizon
+func forecastCleanup(snapshot poolSnapshot, timing TimingConfig, now time.Time) CleanupForecast {
+	forecast := CleanupForecast{Timing: SecondsOf(timing), Forecasts: make([]ForecastPoint, len(forecastHorizons))}
+	for i, horizon := range forecastHorizons {
+		at := now.Add(horizon).Unix()
+		releaseBefore := at - int64(timing.KeepaliveGrace.Seconds())
+		deleteBefore := at - int64(timing.DeletionAfterIdle.Seconds())
+
+		point := ForecastPoint{AfterSec: int(horizon.Seconds()), Available: int64(len(snapshot.available))}
+		for _, d := range decideCleanup(snapshot, PhaseAll, releaseBefore, deleteBefore, timing.heldBefore(at)) {
+			switch {
+			case d.action == actionRelease:
+				point.Released++
+				point.Available++
+			case !d.assigned:
+				point.Deleted++
+				point.Available--
+			default:
+				point.Deleted++
+			}
+		}
+		forecast.Forecasts[i] = point
+	}
+	return forecast
+}
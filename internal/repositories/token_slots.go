@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireSlotScript adds ARGV[2] to the slot set (KEYS[1]) unless it already
+// holds ARGV[1] members. Holding a slot already counts as success, so a
+// retried claim doesn't take two.
+var acquireSlotScript = redis.NewScript(`
+if redis.call('SISMEMBER', KEYS[1], ARGV[2]) == 1 then
+	return 1
+end
+if redis.call('SCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SADD', KEYS[1], ARGV[2])
+return 1
+`)
+
+// acquireSlot takes one of the AssignmentCap slots shared by every pool for
+// token. Slots are given back wherever a token leaves the assigned set.
+func (r *TokenRepository) acquireSlot(ctx context.Context, token string) error {
+	if r.AssignmentCap <= 0 {
+		return nil
+	}
+	ok, err := acquireSlotScript.Run(ctx, r.RedisClient, []string{constants.KeyAssignmentSlots}, r.AssignmentCap, token).Int()
+	if err != nil {
+		return fmt.Errorf("failed to acquire assignment slot: %w", err)
+	}
+	if ok == 0 {
+		return constants.ErrAssignmentCapReached
+	}
+	return nil
+}
+
+// atAssignmentCap reports whether every slot is taken, so callers that can't
+// put a token back once popped can check before popping. Past
+// AssignmentSoftCap it warns that the cap is near, see WarnSoftLimit.
+func (r *TokenRepository) atAssignmentCap(ctx context.Context) (bool, error) {
+	if r.AssignmentCap <= 0 {
+		return false, nil
+	}
+	held, err := r.RedisClient.SCard(ctx, constants.KeyAssignmentSlots).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to count assignment slots: %w", err)
+	}
+	if r.AssignmentSoftCap > 0 && held >= int64(r.AssignmentSoftCap) {
+		r.WarnSoftLimit(ctx, SoftLimitAssignments, "", held, int64(r.AssignmentSoftCap), int64(r.AssignmentCap))
+	}
+	return held >= int64(r.AssignmentCap), nil
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// Soft limits name the hard limit a quota.soft_limit event warns about
+const (
+	SoftLimitAssignments = "assignments" // Tokens.MaxConcurrentAssignments, across pools
+	SoftLimitPoolSize    = "pool_size"   // a pool's MaxSize
+)
+
+func softLimitKey(limit, pool string) string {
+	if pool == "" {
+		return constants.PrefixSoftLimitKey + ":" + limit
+	}
+	return constants.PrefixSoftLimitKey + ":" + limit + ":" + pool
+}
+
+// WarnSoftLimit records that usage of limit has reached its soft threshold
+// as a quota.soft_limit audit event, which webhooks and the event stream
+// deliver like any other, so teams hear about it before requests are refused.
+// pool is "" for limits across pools; hard is 0 when there is no hard limit.
+// The warning is recorded at most once per SoftLimitWarnInterval for each
+// limit and pool across replicas, so usage hovering at the threshold doesn't
+// flood subscribers. Failures are only logged: a warning must never fail the
+// request that reached the threshold.
+func (r *TokenRepository) WarnSoftLimit(ctx context.Context, limit, pool string, used, soft, hard int64) {
+	ctx = context.WithoutCancel(ctx)
+	send, err := r.RedisClient.SetNX(ctx, softLimitKey(limit, pool), used, constants.SoftLimitWarnInterval).Result()
+	if err != nil {
+		slog.Warn("Failed to throttle soft limit warning", slog.String("limit", limit), slog.String("pool", pool), slog.String("error", err.Error()))
+		return
+	}
+	if !send {
+		return
+	}
+	err = r.AppendAudit(ctx, AuditEntry{
+		Action: "quota.soft_limit",
+		Pool:   pool,
+		Detail: map[string]string{
+			"limit":      limit,
+			"used":       strconv.FormatInt(used, 10),
+			"soft_limit": strconv.FormatInt(soft, 10),
+			"hard_limit": strconv.FormatInt(hard, 10),
+		},
+	})
+	if err != nil {
+		// Let the next request try again rather than losing the warning for a whole interval
+		r.RedisClient.Del(ctx, softLimitKey(limit, pool))
+		slog.Warn("Failed to record soft limit warning", slog.String("limit", limit), slog.String("pool", pool), slog.String("error", err.Error()))
+	}
+}
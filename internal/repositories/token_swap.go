@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// swapTokenScript releases an assigned token (ARGV[1]) and claims another
+// from the same pool in one step, so the holder never has none or two.
+//
+// The old token must be in the assigned set (KEYS[1]) and, for tracked
+// callers (ARGV[2] non-empty), be owned by ARGV[2] in KEYS[4]. ARGV[3] above 0
+// requires its record (KEYS[7]) to be at that revision. A replacement is
+// drawn from the available set (KEYS[2]), or from its intersection with label
+// index sets (KEYS[10..]), leaving ARGV[4] percent of the pool as a reserve;
+// tokens still locked by a stale assignment are skipped. With none to be had
+// nothing changes.
+//
+// The old token then goes back to the pool as UnblockToken does, dropping its
+// callback (KEYS[8]), pending reclaim (KEYS[9]) and lock, and the replacement takes
+// its place: lock (ARGV[5] prefix, ARGV[6] value, ARGV[7] TTL in ms),
+// keepalive (KEYS[3], score ARGV[8]), assignment slot (KEYS[5]) and owner
+// index (KEYS[6]), with both records updated as of ARGV[9] (ARGV[10] is the
+// record key prefix). Returns the replacement and its new revision. It takes
+// an event (outboxLua).
+var swapTokenScript = redis.NewScript(outboxLua + recordLua + `
+local old = ARGV[1]
+if redis.call('SISMEMBER', KEYS[1], old) == 0 then
+	return {'not_assigned'}
+end
+if ARGV[2] ~= '' and redis.call('HGET', KEYS[4], old) ~= ARGV[2] then
+	return {'not_owner'}
+end
+if ARGV[3] ~= '0' and (redis.call('HGET', KEYS[7], 'rev') or '0') ~= ARGV[3] then
+	return {'version_mismatch'}
+end
+
+local reserve = tonumber(ARGV[4])
+if reserve > 0 then
+	local available = redis.call('SCARD', KEYS[2])
+	local total = available + redis.call('SCARD', KEYS[1])
+	if available <= math.ceil(total * reserve / 100) then
+		return {'empty'}
+	end
+end
+
+local candidates
+if #KEYS > 9 then
+	local sets = {KEYS[2]}
+	for i = 10, #KEYS do
+		sets[#sets + 1] = KEYS[i]
+	end
+	candidates = redis.call('SINTER', unpack(sets))
+end
+
+local new
+local skipped = {}
+local cursor = 1
+while not new do
+	local token
+	if candidates then
+		token = candidates[cursor]
+		cursor = cursor + 1
+		if token then
+			redis.call('SREM', KEYS[2], token)
+		end
+	else
+		token = redis.call('SPOP', KEYS[2])
+	end
+	if not token then
+		break
+	end
+	if redis.call('SET', ARGV[5] .. ':' .. token, ARGV[6], 'NX', 'PX', ARGV[7]) then
+		new = token
+	else
+		skipped[#skipped + 1] = token
+	end
+end
+if #skipped > 0 then
+	redis.call('SADD', KEYS[2], unpack(skipped))
+end
+if not new then
+	return {'empty'}
+end
+
+redis.call('SREM', KEYS[1], old)
+redis.call('SADD', KEYS[2], old)
+redis.call('ZADD', KEYS[3], ARGV[8], old)
+redis.call('HDEL', KEYS[4], old)
+redis.call('DEL', KEYS[8])
+redis.call('ZREM', KEYS[9], old)
+redis.call('DEL', ARGV[5] .. ':' .. old)
+record_update(KEYS[7], {state = 'available', owner = '', updated_at = ARGV[9]})
+
+redis.call('SADD', KEYS[1], new)
+redis.call('ZADD', KEYS[3], ARGV[8], new)
+if redis.call('SREM', KEYS[5], old) == 1 then
+	redis.call('SADD', KEYS[5], new)
+end
+if ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[4], new, ARGV[2])
+	redis.call('SADD', KEYS[6], new)
+end
+local rev = record_update(ARGV[10] .. ':' .. new, {state = 'assigned', owner = ARGV[2], assigned_at = ARGV[9], updated_at = ARGV[9]})
+outbox()
+return {'ok', new, rev}
+`)
+
+// SwapToken releases token, which client holds, and assigns client another
+// from the same pool in its place. The old token is only released once a
+// replacement is claimed: ErrNoAvailableTokens leaves it assigned as before.
+// ErrNotTokenOwner means client doesn't hold it, ErrTokenNotAssigned that
+// nobody does, and ErrVersionMismatch that ifVersion is above 0 and not the
+// old token's revision.
+//
+// The replacement takes over the old token's assignment slot, so the
+// assignment cap doesn't stop a swap. It takes an event (WithEvent).
+func (r *TokenRepository) SwapToken(ctx context.Context, token, client string, ifVersion int64, opts AssignOptions) (*Token, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+	owner := client
+	if !tracksOwner(owner) {
+		owner = ""
+	}
+
+	setKeys := []string{
+		keys.assigned, keys.available, keys.keepalive, constants.KeyTokenOwners, constants.KeyAssignmentSlots,
+		clientTokensKey(owner), recordKey(ref), callbackKey(ref), keys.reclaims,
+	}
+	for key, value := range opts.Selector {
+		setKeys = append(setKeys, keys.labelKey(key, value))
+	}
+
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.swap", ref))
+	if err != nil {
+		return nil, err
+	}
+	now := r.Now()
+	timing := r.timingFor(pool)
+	res, err := swapTokenScript.Run(ctx, r.RedisClient, setKeys, append([]any{
+		ref, owner, max(ifVersion, 0), opts.ReservePercent,
+		constants.PrefixLockKey, constants.LockValue, timing.LockTTL.Milliseconds(), timing.expiresAt(now),
+		now.Unix(), constants.PrefixTokenRecordKey,
+	}, event...)...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to swap token: %w", err)
+	}
+
+	switch res[0] {
+	case "not_assigned":
+		return nil, constants.ErrTokenNotAssigned
+	case "not_owner":
+		return nil, constants.ErrNotTokenOwner
+	case "version_mismatch":
+		return nil, constants.ErrVersionMismatch
+	case "empty":
+		return nil, constants.ErrNoAvailableTokens
+	}
+
+	newRef, _ := res[1].(string)
+	value, err := r.revealOne(ctx, newRef)
+	if err != nil {
+		return nil, err
+	}
+	swapped := ClaimedToken(pool, value, owner, now)
+	swapped.Version, _ = res[2].(int64)
+	return swapped, nil
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestSwapTokenReplacesTheHeldToken(t *testing.T) {
+	r, _ := newTestRepository(t, testTiming)
+	r.AssignmentCap = 1 // the replacement must take over the slot to get past it
+	ctx := context.Background()
+	saveTokens(t, r, "default", "tok-1")
+	held, err := r.AssignToken(ctx, "default", AssignOptions{Client: "client-a"})
+	if err != nil {
+		t.Fatalf("AssignToken: %v", err)
+	}
+
+	// Nothing to swap to: the held token stays assigned
+	if _, err := r.SwapToken(ctx, held.Value, "client-a", 0, AssignOptions{}); !errors.Is(err, constants.ErrNoAvailableTokens) {
+		t.Fatalf("SwapToken on an empty pool = %v, want ErrNoAvailableTokens", err)
+	}
+	keys := keysFor("default")
+	if !member(t, r, keys.assigned, held.Value) {
+		t.Fatal("failed swap released the held token")
+	}
+
+	saveTokens(t, r, "default", "tok-2")
+	if _, err := r.SwapToken(ctx, held.Value, "client-b", 0, AssignOptions{}); !errors.Is(err, constants.ErrNotTokenOwner) {
+		t.Errorf("SwapToken by another client = %v, want ErrNotTokenOwner", err)
+	}
+
+	fresh, err := r.SwapToken(ctx, held.Value, "client-a", 0, AssignOptions{})
+	if err != nil {
+		t.Fatalf("SwapToken: %v", err)
+	}
+	if fresh.Value != "tok-2" {
+		t.Fatalf("swapped for %s, want tok-2", fresh.Value)
+	}
+	if !member(t, r, keys.available, "tok-1") || member(t, r, keys.assigned, "tok-1") {
+		t.Error("old token is not back in the pool")
+	}
+	if record := recordOf(t, r, "tok-1"); record.State != TokenStateAvailable || record.Owner != "" {
+		t.Errorf("old record = %+v, want available with no owner", record)
+	}
+	if record := recordOf(t, r, "tok-2"); record.State != TokenStateAssigned || record.Owner != "client-a" {
+		t.Errorf("new record = %+v, want assigned to client-a", record)
+	}
+	if !member(t, r, constants.KeyAssignmentSlots, "tok-2") || member(t, r, constants.KeyAssignmentSlots, "tok-1") {
+		t.Error("the old token's assignment slot is not handed over")
+	}
+	if got := eventsOf(t, r, "tok-1"); got[len(got)-1] != "token.swap" {
+		t.Errorf("events of the old token = %v, want it to end with token.swap", got)
+	}
+}
@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// TimingConfig holds the token lifetime parameters. A token's keepalive score
+// is the time its assignment expires; cleanup measures the grace and idle
+// periods from that score. MaxHold is measured from the assignment's start
+// instead, so keepalives can extend an assignment but never past it.
+type TimingConfig struct {
+	AssignmentTTL     time.Duration // how long an assignment or keepalive is valid for
+	KeepaliveGrace    time.Duration // extra time past expiry before cleanup releases the token
+	DeletionAfterIdle time.Duration // time past expiry before cleanup deletes the token
+	LockTTL           time.Duration // lifetime of the per-token assignment lock
+	MaxHold           time.Duration // longest an assignment may last however often it is kept alive; 0 is unlimited
+}
+
+// withDefaults fills unset timing options with the package defaults
+func (c TimingConfig) withDefaults() TimingConfig {
+	if c.AssignmentTTL <= 0 {
+		c.AssignmentTTL = constants.TokenAutoReleaseTime * time.Second
+	}
+	if c.KeepaliveGrace <= 0 {
+		c.KeepaliveGrace = constants.TokenAutoReleaseTime * time.Second
+	}
+	if c.DeletionAfterIdle <= 0 {
+		c.DeletionAfterIdle = constants.TokenDeletionTime * time.Second
+	}
+	if c.LockTTL <= 0 {
+		c.LockTTL = constants.TokenLockTime * time.Second
+	}
+	return c
+}
+
+// Validate checks the parameters describe a sane lifecycle once defaults are applied
+func (c TimingConfig) Validate() error {
+	c = c.withDefaults()
+	if c.DeletionAfterIdle <= c.KeepaliveGrace {
+		return fmt.Errorf("DeletionAfterIdle (%s) must be longer than KeepaliveGrace (%s), or tokens are deleted before they can be released", c.DeletionAfterIdle, c.KeepaliveGrace)
+	}
+	// A lock that outlives the release point would block reassigning the released token
+	if c.LockTTL > c.AssignmentTTL+c.KeepaliveGrace {
+		return fmt.Errorf("LockTTL (%s) must not exceed AssignmentTTL + KeepaliveGrace (%s)", c.LockTTL, c.AssignmentTTL+c.KeepaliveGrace)
+	}
+	if c.MaxHold > 0 && c.MaxHold < c.AssignmentTTL {
+		return fmt.Errorf("MaxHold (%s) must not be shorter than AssignmentTTL (%s)", c.MaxHold, c.AssignmentTTL)
+	}
+	return nil
+}
+
+// inherit fills the fields c leaves unset from base
+func (c TimingConfig) inherit(base TimingConfig) TimingConfig {
+	if c.AssignmentTTL <= 0 {
+		c.AssignmentTTL = base.AssignmentTTL
+	}
+	if c.KeepaliveGrace <= 0 {
+		c.KeepaliveGrace = base.KeepaliveGrace
+	}
+	if c.DeletionAfterIdle <= 0 {
+		c.DeletionAfterIdle = base.DeletionAfterIdle
+	}
+	if c.LockTTL <= 0 {
+		c.LockTTL = base.LockTTL
+	}
+	if c.MaxHold <= 0 {
+		c.MaxHold = base.MaxHold
+	}
+	return c
+}
+
+// timingFor returns a pool's lifecycle parameters: its own where it declares
+// them, the repository-wide Timing otherwise
+func (r *TokenRepository) timingFor(pool string) TimingConfig {
+	r.timingMu.RLock()
+	defer r.timingMu.RUnlock()
+	return r.timingLocked(pool)
+}
+
+// timingLocked layers a pool's runtime override over its declared timing.
+// The caller holds timingMu.
+func (r *TokenRepository) timingLocked(pool string) TimingConfig {
+	base, ok := r.poolTiming[pool]
+	if !ok {
+		base = r.Timing
+	}
+	if live, ok := r.liveTiming[pool]; ok {
+		return live.inherit(base)
+	}
+	return base
+}
+
+// EffectiveTiming returns the timing a pool currently runs with
+func (r *TokenRepository) EffectiveTiming(pool string) TimingConfig {
+	return r.timingFor(pool)
+}
+
+// SetPoolTiming gives one pool its own lifecycle parameters. Unset fields
+// inherit the repository-wide Timing, so a zero config drops the override.
+// It may be called while the repository is in use.
+func (r *TokenRepository) SetPoolTiming(pool string, timing TimingConfig) error {
+	timing = timing.inherit(r.Timing)
+	if err := timing.Validate(); err != nil {
+		return err
+	}
+	r.timingMu.Lock()
+	defer r.timingMu.Unlock()
+	if r.poolTiming == nil {
+		r.poolTiming = make(map[string]TimingConfig)
+	}
+	r.poolTiming[pool] = timing
+	return nil
+}
+
+// expiresAt is the keepalive score for an assignment made or refreshed now
+func (c TimingConfig) expiresAt(now time.Time) float64 {
+	return float64(now.Add(c.AssignmentTTL).Unix())
+}
+
+// heldBefore is the latest assignment start, in Unix seconds, that has been
+// held for MaxHold by now; 0 when holds are unlimited
+func (c TimingConfig) heldBefore(now int64) int64 {
+	if c.MaxHold <= 0 {
+		return 0
+	}
+	return now - int64(c.MaxHold.Seconds())
+}
@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// transferTokenScript hands an assigned token (ARGV[1]) from its owner
+// (ARGV[2]) to another client (ARGV[3]) and restarts its keepalive at
+// ARGV[4], updating its record as of ARGV[5]. ARGV[6] above 0 requires the
+// record to be at that revision. The old holder's callback and any reclaim
+// pending against it are dropped; the new holder registers its own. It takes
+// an event (outboxLua).
+var transferTokenScript = redis.NewScript(outboxLua + recordLua + `
+local ref = ARGV[1]
+if redis.call('SISMEMBER', KEYS[1], ref) == 0 then
+	return 'not_assigned'
+end
+if redis.call('HGET', KEYS[3], ref) ~= ARGV[2] then
+	return 'not_owner'
+end
+if ARGV[6] ~= '0' and (redis.call('HGET', KEYS[8], 'rev') or '0') ~= ARGV[6] then
+	return 'version_mismatch'
+end
+
+redis.call('SREM', KEYS[4], ref)
+redis.call('HSET', KEYS[3], ref, ARGV[3])
+redis.call('SADD', KEYS[5], ref)
+redis.call('ZADD', KEYS[2], ARGV[4], ref)
+redis.call('DEL', KEYS[6])
+redis.call('ZREM', KEYS[7], ref)
+record_update(KEYS[8], {owner = ARGV[3], updated_at = ARGV[5]})
+outbox()
+return 'ok'
+`)
+
+// TransferToken moves an assigned token from client from to client to and
+// returns its pool. ErrNotTokenOwner means from doesn't hold it, and
+// ErrVersionMismatch that ifVersion is above 0 and not the token's revision.
+// It takes an event (WithEvent).
+func (r *TokenRepository) TransferToken(ctx context.Context, token, from, to string, ifVersion int64) (string, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+	now := r.Now()
+	event, err := r.eventArgs(ctx, pool, tokenEvent("token.transfer", ref))
+	if err != nil {
+		return "", err
+	}
+
+	res, err := transferTokenScript.Run(ctx, r.RedisClient,
+		[]string{keys.assigned, keys.keepalive, constants.KeyTokenOwners, clientTokensKey(from), clientTokensKey(to), callbackKey(ref), keys.reclaims, recordKey(ref)},
+		append([]any{ref, from, to, r.timingFor(pool).expiresAt(now), now.Unix(), max(ifVersion, 0)}, event...)...,
+	).Text()
+	if err != nil {
+		return "", fmt.Errorf("failed to transfer token: %w", err)
+	}
+
+	switch res {
+	case "not_assigned":
+		return "", constants.ErrTokenNotAssigned
+	case "not_owner":
+		return "", constants.ErrNotTokenOwner
+	case "version_mismatch":
+		return "", constants.ErrVersionMismatch
+	}
+	return pool, nil
+}
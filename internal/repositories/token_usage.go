@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/redis/go-redis/v9"
+)
+
+// usageKey is the sorted set of token utilisation for the minute containing t
+func (k poolKeys) usageKey(t time.Time) string {
+	return k.usagePrefix + ":" + strconv.FormatInt(t.Unix()/60, 10)
+}
+
+// SetRateLimit records how many requests per minute a token allows upstream
+func (r *TokenRepository) SetRateLimit(ctx context.Context, token string, perMinute int) error {
+	if err := r.RedisClient.HSet(ctx, constants.KeyTokenRateLimits, r.ref(token), perMinute).Err(); err != nil {
+		return fmt.Errorf("failed to save rate limit: %w", err)
+	}
+	return nil
+}
+
+// RateLimitOf returns a token's requests-per-minute hint, 0 if it has none
+func (r *TokenRepository) RateLimitOf(ctx context.Context, token string) (int, error) {
+	limit, err := r.RedisClient.HGet(ctx, constants.KeyTokenRateLimits, r.ref(token)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch rate limit: %w", err)
+	}
+	return limit, nil
+}
+
+// RateLimitsOf returns the requests-per-minute hints of tokens, in order
+func (r *TokenRepository) RateLimitsOf(ctx context.Context, tokens []string) ([]int, error) {
+	limits := make([]int, len(tokens))
+	if len(tokens) == 0 {
+		return limits, nil
+	}
+	refs := make([]string, len(tokens))
+	for i, token := range tokens {
+		refs[i] = r.ref(token)
+	}
+	values, err := r.RedisClient.HMGet(ctx, constants.KeyTokenRateLimits, refs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rate limits: %w", err)
+	}
+	for i, value := range values {
+		if raw, ok := value.(string); ok {
+			limits[i], _ = strconv.Atoi(raw)
+		}
+	}
+	return limits, nil
+}
+
+// ReportUsage adds consumed upstream requests to the token's utilisation for
+// the current minute. Utilisation is used/limit, or the raw count for tokens
+// without a limit, and is what assignment uses to prefer idle tokens.
+func (r *TokenRepository) ReportUsage(ctx context.Context, token string, used int) (float64, error) {
+	ref := r.ref(token)
+	pool, err := r.PoolOf(ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+	ctx = datasources.WithPool(ctx, pool)
+	keys := keysFor(pool)
+
+	pipe := r.RedisClient.Pipeline()
+	assigned := pipe.SIsMember(ctx, keys.assigned, ref)
+	available := pipe.SIsMember(ctx, keys.available, ref)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to look up token: %w", err)
+	}
+	if !assigned.Val() && !available.Val() {
+		return 0, constants.ErrTokenNotFound
+	}
+
+	limit, err := r.RateLimitOf(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+
+	increment := float64(used)
+	if limit > 0 {
+		increment /= float64(limit)
+	}
+
+	key := keys.usageKey(r.Now())
+	pipe = r.RedisClient.TxPipeline()
+	total := pipe.ZIncrBy(ctx, key, increment, ref)
+	pipe.Expire(ctx, key, constants.TokenUsageWindowTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to record usage: %w", err)
+	}
+	return total.Val(), nil
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// PoolCounts is how many of a pool's tokens are in each state
+type PoolCounts struct {
+	Available   int64 `json:"available"`
+	Assigned    int64 `json:"assigned"`
+	Quarantined int64 `json:"quarantined"`
+	Pending     int64 `json:"pending"`
+}
+
+// Total is every token the pool holds, whatever its state
+func (c PoolCounts) Total() int64 {
+	return c.Available + c.Assigned + c.Quarantined + c.Pending
+}
+
+// CountPool counts a pool's tokens by state
+func (r *TokenRepository) CountPool(ctx context.Context, pool string) (PoolCounts, error) {
+	keys := keysFor(pool)
+	pipe := r.RedisClient.Pipeline()
+	available := pipe.SCard(ctx, keys.available)
+	assigned := pipe.SCard(ctx, keys.assigned)
+	quarantined := pipe.SCard(ctx, keys.quarantine)
+	pending := pipe.ZCard(ctx, keys.pending)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return PoolCounts{}, fmt.Errorf("failed to count pool tokens: %w", err)
+	}
+	return PoolCounts{
+		Available:   available.Val(),
+		Assigned:    assigned.Val(),
+		Quarantined: quarantined.Val(),
+		Pending:     pending.Val(),
+	}, nil
+}
+
+// PoolSize counts every token a pool holds: available, assigned, quarantined and pending
+func (r *TokenRepository) PoolSize(ctx context.Context, pool string) (int64, error) {
+	counts, err := r.CountPool(ctx, pool)
+	return counts.Total(), err
+}
+
+// HasToken reports whether a token has been saved in any pool, even if it was
+// deleted since, so a deleted seed isn't brought back. hashed looks it up by
+// its handle, as stored in hash-only mode.
+func (r *TokenRepository) HasToken(ctx context.Context, token string, hashed bool) (bool, error) {
+	exists, err := r.TokensExist(ctx, []string{token}, hashed)
+	if err != nil {
+		return false, err
+	}
+	return exists[0], nil
+}
+
+// LockWarmup claims the right to seed a pool, so replicas starting together
+// don't each generate its initial tokens. False means another replica has it.
+func (r *TokenRepository) LockWarmup(ctx context.Context, pool string) (bool, error) {
+	ok, err := r.RedisClient.SetNX(ctx, constants.PrefixWarmupLockKey+":"+pool, constants.LockValue, constants.WarmupLockTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to lock pool warm-up: %w", err)
+	}
+	return ok, nil
+}
+
+// UnlockWarmup releases the warm-up lock of a pool
+func (r *TokenRepository) UnlockWarmup(ctx context.Context, pool string) error {
+	if err := r.RedisClient.Del(ctx, constants.PrefixWarmupLockKey+":"+pool).Err(); err != nil {
+		return fmt.Errorf("failed to unlock pool warm-up: %w", err)
+	}
+	return nil
+}
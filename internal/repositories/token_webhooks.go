@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/redis/go-redis/v9"
+)
+
+// Webhook is a subscription to audit events, managed through the admin API
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // signs deliveries; encrypted at rest when encryption is on
+	Events    []string  `json:"events"`           // actions such as token.transfer, or "token.*"; empty matches all
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Matches reports whether the webhook wants events of the given action
+func (w Webhook) Matches(action string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == action || event == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(event, "*"); ok && strings.HasPrefix(action, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveWebhook creates or replaces a webhook. It takes an event (WithEvent).
+func (r *TokenRepository) SaveWebhook(ctx context.Context, webhook Webhook) error {
+	if r.Cipher != nil && webhook.Secret != "" {
+		sealed, err := r.Cipher.Encrypt(webhook.Secret, webhook.ID)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		webhook.Secret = sealed
+	}
+	encoded, err := json.Marshal(webhook)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook: %w", err)
+	}
+	pipe := r.RedisClient.TxPipeline()
+	pipe.HSet(ctx, constants.KeyWebhooks, webhook.ID, encoded)
+	if err := r.queueEvent(ctx, pipe, "", AuditEntry{Action: "webhook.save", Detail: map[string]string{"webhook": webhook.ID}}); err != nil {
+		return err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return nil
+}
+
+// Webhook returns one webhook, ErrWebhookNotFound if there is none with the ID
+func (r *TokenRepository) Webhook(ctx context.Context, id string) (Webhook, error) {
+	encoded, err := r.RedisClient.HGet(ctx, constants.KeyWebhooks, id).Result()
+	if err == redis.Nil {
+		return Webhook{}, constants.ErrWebhookNotFound
+	}
+	if err != nil {
+		return Webhook{}, fmt.Errorf("failed to fetch webhook: %w", err)
+	}
+	return r.decodeWebhook(encoded)
+}
+
+// Webhooks returns every webhook, oldest first
+func (r *TokenRepository) Webhooks(ctx context.Context) ([]Webhook, error) {
+	stored, err := r.RedisClient.HGetAll(ctx, constants.KeyWebhooks).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	webhooks := make([]Webhook, 0, len(stored))
+	for _, encoded := range stored {
+		webhook, err := r.decodeWebhook(encoded)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks, nil
+}
+
+// deleteWebhookScript removes field ARGV[1] from the webhook hash KEYS[1],
+// returning 0 when there is none. It takes an event (outboxLua), recorded
+// only when a webhook was removed.
+var deleteWebhookScript = redis.NewScript(outboxLua + `
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+outbox()
+return 1
+`)
+
+// DeleteWebhook removes a webhook, ErrWebhookNotFound if there is none with
+// the ID. It takes an event (WithEvent).
+func (r *TokenRepository) DeleteWebhook(ctx context.Context, id string) error {
+	event, err := r.eventArgs(ctx, "", AuditEntry{Action: "webhook.delete", Detail: map[string]string{"webhook": id}})
+	if err != nil {
+		return err
+	}
+	removed, err := deleteWebhookScript.Run(ctx, r.RedisClient, []string{constants.KeyWebhooks}, append([]any{id}, event...)...).Int()
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if removed == 0 {
+		return constants.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *TokenRepository) decodeWebhook(encoded string) (Webhook, error) {
+	var webhook Webhook
+	if err := json.Unmarshal([]byte(encoded), &webhook); err != nil {
+		return webhook, fmt.Errorf("failed to decode webhook: %w", err)
+	}
+	if r.Cipher != nil && webhook.Secret != "" {
+		// Only secrets bound to this webhook open: one copied from another
+		// webhook doesn't. BindWebhookSecrets binds those sealed before.
+		secret, err := r.Cipher.Decrypt(webhook.Secret, webhook.ID)
+		if err != nil {
+			return webhook, fmt.Errorf("failed to decrypt secret of webhook %s: %w", webhook.ID, err)
+		}
+		webhook.Secret = secret
+	}
+	return webhook, nil
+}
+
+// bindWebhookSecretScript replaces webhook ARGV[1] in KEYS[1] with ARGV[3]
+// unless it has changed from ARGV[2] since it was read
+var bindWebhookSecretScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+return 1
+`)
+
+// BindWebhookSecrets reseals secrets sealed before they were bound to their
+// webhook, which decodeWebhook no longer opens, and returns how many it
+// rebound. It trusts each legacy secret to belong where it is stored, so run
+// it at startup rather than on every read.
+func (r *TokenRepository) BindWebhookSecrets(ctx context.Context) (int, error) {
+	if r.Cipher == nil {
+		return 0, nil
+	}
+	stored, err := r.RedisClient.HGetAll(ctx, constants.KeyWebhooks).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	bound := 0
+	for id, encoded := range stored {
+		var webhook Webhook
+		if err := json.Unmarshal([]byte(encoded), &webhook); err != nil {
+			return bound, fmt.Errorf("failed to decode webhook: %w", err)
+		}
+		if webhook.Secret == "" {
+			continue
+		}
+		if _, err := r.Cipher.Decrypt(webhook.Secret, webhook.ID); err == nil {
+			continue
+		}
+		secret, err := r.Cipher.Decrypt(webhook.Secret, "")
+		if err != nil {
+			return bound, fmt.Errorf("failed to decrypt secret of webhook %s: %w", id, err)
+		}
+		if webhook.Secret, err = r.Cipher.Encrypt(secret, webhook.ID); err != nil {
+			return bound, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		rebound, err := json.Marshal(webhook)
+		if err != nil {
+			return bound, fmt.Errorf("failed to encode webhook: %w", err)
+		}
+		n, err := bindWebhookSecretScript.Run(ctx, r.RedisClient, []string{constants.KeyWebhooks}, id, encoded, rebound).Int()
+		if err != nil {
+			return bound, fmt.Errorf("failed to save webhook: %w", err)
+		}
+		bound += n
+	}
+	return bound, nil
+}
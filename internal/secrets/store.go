@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// Handle is the SHA-256 of a secret, which is all that is stored in Redis for
+// tokens imported in hash-only mode
+func Handle(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsHandle reports whether s has the shape of a handle
+func IsHandle(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Store resolves handles back to secrets held outside Redis
+type Store interface {
+	Lookup(ctx context.Context, handle string) (string, error)
+}
+
+// rescanInterval is the least time between rescans of a FileStore's
+// directory, so lookups of unknown handles don't each read every file
+const rescanInterval = 10 * time.Second
+
+// FileStore reads secrets from a directory with one secret per file, as
+// rendered by a secret manager agent or a mounted Kubernetes secret. Files are
+// indexed by the hash of their contents, so file names don't matter.
+type FileStore struct {
+	dir string
+
+	mu      sync.Mutex
+	index   map[string]string // handle -> path
+	scanned time.Time         // when the last rescan started
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir, index: make(map[string]string)}
+}
+
+// Lookup returns the secret for a handle. A miss rescans the directory so
+// secrets added after startup are found, but at most once per rescanInterval:
+// until then a handle that wasn't found stays not found. Files are read
+// without holding the lock.
+func (s *FileStore) Lookup(ctx context.Context, handle string) (string, error) {
+	if secret, ok := s.read(handle); ok {
+		return secret, nil
+	}
+
+	s.mu.Lock()
+	rescan := time.Since(s.scanned) >= rescanInterval
+	if rescan {
+		// Claimed before scanning so concurrent misses don't rescan too
+		s.scanned = time.Now()
+	}
+	s.mu.Unlock()
+	if !rescan {
+		return "", constants.ErrSecretNotFound
+	}
+
+	index, err := s.scan()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.index = index
+	s.mu.Unlock()
+
+	if secret, ok := s.read(handle); ok {
+		return secret, nil
+	}
+	return "", constants.ErrSecretNotFound
+}
+
+// read loads a secret from its indexed file, re-checking the hash in case the file changed
+func (s *FileStore) read(handle string) (string, bool) {
+	s.mu.Lock()
+	path, ok := s.index[handle]
+	s.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if secret := strings.TrimSpace(string(data)); Handle(secret) == handle {
+			return secret, true
+		}
+	}
+	s.mu.Lock()
+	if s.index[handle] == path {
+		delete(s.index, handle)
+	}
+	s.mu.Unlock()
+	return "", false
+}
+
+// scan hashes every file in the directory into a new index
+func (s *FileStore) scan() (map[string]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret store: %w", err)
+	}
+
+	index := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		index[Handle(strings.TrimSpace(string(data)))] = path
+	}
+	return index, nil
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+func TestFileStoreRescansAtMostOncePerInterval(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "one"), []byte("secret-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStore(dir)
+	ctx := context.Background()
+
+	if secret, err := store.Lookup(ctx, Handle("secret-1")); err != nil || secret != "secret-1" {
+		t.Fatalf("Lookup = %q, %v; want secret-1", secret, err)
+	}
+
+	// Added right after a rescan: not found until the interval has passed
+	if err := os.WriteFile(filepath.Join(dir, "two"), []byte("secret-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, Handle("secret-2")); !errors.Is(err, constants.ErrSecretNotFound) {
+		t.Fatalf("Lookup before the next rescan = %v, want ErrSecretNotFound", err)
+	}
+	store.scanned = time.Now().Add(-rescanInterval)
+	if secret, err := store.Lookup(ctx, Handle("secret-2")); err != nil || secret != "secret-2" {
+		t.Errorf("Lookup after the interval = %q, %v; want secret-2", secret, err)
+	}
+
+	// A file rewritten with another secret no longer resolves the old handle
+	if err := os.WriteFile(filepath.Join(dir, "one"), []byte("secret-3"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, Handle("secret-1")); !errors.Is(err, constants.ErrSecretNotFound) {
+		t.Errorf("Lookup of a rewritten secret = %v, want ErrSecretNotFound", err)
+	}
+}
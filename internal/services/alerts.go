@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// PoolSize counts every token a pool holds, whatever its state
+func (s *TokenService) PoolSize(ctx context.Context, pool string) (int64, error) {
+	return s.repo.PoolSize(ctx, pool)
+}
+
+// RecordSweepOutcome counts a cleanup run and whether it failed
+func (s *TokenService) RecordSweepOutcome(ctx context.Context, pool string, failed bool) error {
+	return s.repo.RecordSweepOutcome(ctx, pool, failed)
+}
+
+// SweepOutcomes returns the cleanup runs and failures of a pool over window
+func (s *TokenService) SweepOutcomes(ctx context.Context, pool string, window time.Duration) (int64, int64, error) {
+	return s.repo.SweepOutcomes(ctx, pool, window)
+}
+
+// RaiseAlert marks an alert raised and reports whether to send it
+func (s *TokenService) RaiseAlert(ctx context.Context, pool, kind string, throttle time.Duration) (bool, error) {
+	return s.repo.RaiseAlert(ctx, pool, kind, throttle)
+}
+
+// ResolveAlert clears an alert and reports whether it had been raised
+func (s *TokenService) ResolveAlert(ctx context.Context, pool, kind string) (bool, error) {
+	return s.repo.ResolveAlert(ctx, pool, kind)
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// aliasPattern keeps aliases readable and safe in URL paths
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidAlias reports whether alias can be given to a token
+func ValidAlias(alias string) bool {
+	return aliasPattern.MatchString(alias)
+}
+
+// SetAlias names a token so operators can refer to it by alias rather than
+// its value, replacing any alias it had, and records actor in the audit
+// history. token may itself be the current alias. ErrAliasTaken means another
+// token, or a token value, already goes by that name.
+func (s *TokenService) SetAlias(ctx context.Context, token, alias, actor string) error {
+	return s.orAlias(ctx, token, func(token string) error {
+		if _, err := s.repo.StateOf(ctx, token); err != nil {
+			return err
+		}
+		ctx := repositories.WithEvent(ctx, repositories.AuditEntry{
+			Action: "token.alias",
+			Token:  token,
+			Actor:  actor,
+			Detail: map[string]string{"alias": alias},
+		})
+		return s.repo.SetAlias(ctx, token, alias)
+	})
+}
+
+// RemoveAlias drops a token's alias, recording actor in the audit history.
+// ErrAliasNotFound means the token has none.
+func (s *TokenService) RemoveAlias(ctx context.Context, token, actor string) error {
+	return s.orAlias(ctx, token, func(token string) error {
+		if _, err := s.repo.StateOf(ctx, token); err != nil {
+			return err
+		}
+		ctx := repositories.WithEvent(ctx, repositories.AuditEntry{
+			Action: "token.unalias",
+			Token:  token,
+			Actor:  actor,
+		})
+		_, err := s.repo.RemoveAlias(ctx, token)
+		return err
+	})
+}
+
+// orAlias runs fn on token and, when no token goes by that value, again on
+// the token aliased as it. A value is tried as a token first, so lookups by
+// token cost nothing extra; an alias is never a token value, see SetAlias.
+// An alias carries no pool prefix, so a prefix mismatch is retried too.
+func (s *TokenService) orAlias(ctx context.Context, token string, fn func(token string) error) error {
+	err := fn(token)
+	if !errors.Is(err, constants.ErrTokenNotFound) && !errors.Is(err, constants.ErrTokenPrefixMismatch) {
+		return err
+	}
+	if !ValidAlias(token) {
+		return err
+	}
+	aliased, aliasErr := s.repo.ResolveAlias(ctx, token)
+	if errors.Is(aliasErr, constants.ErrTokenNotFound) {
+		return err
+	}
+	if aliasErr != nil {
+		return aliasErr
+	}
+	return fn(aliased)
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// PauseCleanup stops the scheduled release, deletion, activation and
+// autoscale sweeps and the cleanup job on every replica, for duration or
+// until ResumeCleanup when it is 0, so an incident can be inspected with token
+// state frozen. Manual cleanup runs still go ahead. The pause is recorded in the audit history under actor.
+func (s *TokenService) PauseCleanup(ctx context.Context, actor, reason string, duration time.Duration) (repositories.CleanupPause, error) {
+	now := s.repo.Now()
+	detail := map[string]string{}
+	if duration > 0 {
+		detail["until"] = now.Add(duration).UTC().Format(time.RFC3339)
+	}
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Time:   now,
+		Action: "cleanup.pause",
+		Actor:  actor,
+		Reason: reason,
+		Detail: detail,
+	})
+	return s.repo.PauseCleanup(ctx, actor, reason, duration)
+}
+
+// ResumeCleanup lifts a pause of scheduled cleanup, reporting whether there
+// was one, and records it in the audit history under actor
+func (s *TokenService) ResumeCleanup(ctx context.Context, actor string) (bool, error) {
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{Action: "cleanup.resume", Actor: actor})
+	return s.repo.ResumeCleanup(ctx)
+}
+
+// CleanupPaused returns the current pause of scheduled cleanup, if any
+func (s *TokenService) CleanupPaused(ctx context.Context) (repositories.CleanupPause, error) {
+	return s.repo.CleanupPaused(ctx)
+}
+
+// RunningCleanups lists the pools and phases being cleaned right now, as pool:phase
+func (s *TokenService) RunningCleanups(ctx context.Context) ([]string, error) {
+	return s.repo.RunningCleanups(ctx)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// GenerateBatch coalesces concurrent generate requests into one Redis write
+type GenerateBatch struct {
+	Size int           // most tokens written per round trip; 1 or less disables batching
+	Wait time.Duration // how long the first request of a batch waits for others to join
+}
+
+type saveRequest struct {
+	token repositories.NewToken
+	done  chan error
+}
+
+// generateBatcher funnels generate requests through a single writer that
+// saves whatever arrived together in one pipelined MULTI. Under a burst this
+// turns many small round trips into a few large ones; a lone request pays at
+// most Wait in extra latency.
+type generateBatcher struct {
+	repo     *repositories.TokenRepository
+	config   GenerateBatch
+	requests chan saveRequest
+}
+
+func newGenerateBatcher(repo *repositories.TokenRepository, config GenerateBatch) *generateBatcher {
+	b := &generateBatcher{repo: repo, config: config, requests: make(chan saveRequest, config.Size)}
+	go b.run()
+	return b
+}
+
+// Save queues a token for the next batch and waits for its result. When ctx
+// ends first the token may still be saved with the batch.
+func (b *generateBatcher) Save(ctx context.Context, token repositories.NewToken) error {
+	req := saveRequest{token: token, done: make(chan error, 1)}
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *generateBatcher) run() {
+	for first := range b.requests {
+		batch := []saveRequest{first}
+		timer := time.NewTimer(b.config.Wait)
+	collect:
+		for len(batch) < b.config.Size {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		tokens := make([]repositories.NewToken, len(batch))
+		for i, req := range batch {
+			tokens[i] = req.token
+		}
+		// Requests have their own deadlines; the batch must not inherit one of them
+		errs := b.repo.SaveTokens(context.Background(), tokens)
+		for i, req := range batch {
+			req.done <- errs[i]
+		}
+	}
+}
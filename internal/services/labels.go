@@ -0,0 +1,27 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// labelPartPattern keeps label keys and values safe to embed in Redis keys
+var labelPartPattern = regexp.MustCompile(`^[A-Za-z0-9_./-]{1,63}$`)
+
+// ParseLabels parses "key=value,key=value" as used by ?labels=, ?selector=
+// and the labels column of a bulk import
+func ParseLabels(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !labelPartPattern.MatchString(key) || !labelPartPattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
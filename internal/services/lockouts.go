@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// RecordVerifyFailure counts a failed verification by subject and reports
+// whether it locked the subject out
+func (s *TokenService) RecordVerifyFailure(ctx context.Context, subject string, threshold int, window, lockout time.Duration) (bool, error) {
+	return s.repo.RecordVerifyFailure(ctx, subject, threshold, window, lockout)
+}
+
+// VerifyLockout returns how long the longest lockout among subjects has left
+func (s *TokenService) VerifyLockout(ctx context.Context, subjects ...string) (time.Duration, error) {
+	return s.repo.VerifyLockout(ctx, subjects...)
+}
+
+// TokenWasIssued reports whether a token value was ever saved, so a failed
+// lookup of it is a holder whose token went away rather than a guess
+func (s *TokenService) TokenWasIssued(ctx context.Context, token string) (bool, error) {
+	return s.repo.WasIssued(ctx, token)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// Autoscale sizes a pool by demand, within its MinSize and MaxSize. The pool
+// grows when too many assigns find it empty or too many callers are queued,
+// and shrinks while too much of it sits idle.
+type Autoscale struct {
+	FailurePercent int           // grow when this share of assigns over Window found the pool empty; 0 ignores failures
+	QueueDepth     int           // grow when more callers than this are queued; 0 ignores the queue
+	IdlePercent    int           // retire surplus while more than this share of tokens is available; 0 never shrinks
+	Step           int           // tokens generated or retired per run
+	Window         time.Duration // trailing window the failure rate is measured over, in whole minutes
+}
+
+// Enabled reports whether any threshold is set
+func (a Autoscale) Enabled() bool {
+	return a.FailurePercent > 0 || a.QueueDepth > 0 || a.IdlePercent > 0
+}
+
+func (a Autoscale) validate() error {
+	if !a.Enabled() {
+		return nil
+	}
+	if a.FailurePercent > 100 || a.IdlePercent > 100 || a.FailurePercent < 0 || a.IdlePercent < 0 || a.QueueDepth < 0 {
+		return errors.New("Autoscale percents must be between 0 and 100 and QueueDepth not negative")
+	}
+	if a.Step <= 0 {
+		return errors.New("Autoscale.Step must be positive")
+	}
+	return nil
+}
+
+// recordDemand counts an assign attempt on pool for its autoscaler. Pools
+// without autoscaling skip the write. Best effort: losing a sample only
+// delays scaling a little.
+func (s *TokenService) recordDemand(ctx context.Context, pool string, err error) {
+	if !s.policyOf(pool).Autoscale.Enabled() {
+		return
+	}
+	_ = s.repo.RecordAssignOutcome(ctx, pool, errors.Is(err, constants.ErrNoAvailableTokens))
+}
+
+// ScalePool applies a pool's autoscaling policy once, generating or retiring
+// at most Step tokens, and returns how many it generated and retired
+func (s *TokenService) ScalePool(ctx context.Context, pool string) (int, int, error) {
+	policy := s.policyOf(pool)
+	scale := policy.Autoscale
+	if !scale.Enabled() {
+		return 0, 0, nil
+	}
+	counts, err := s.repo.CountPool(ctx, pool)
+	if err != nil {
+		return 0, 0, err
+	}
+	total := counts.Total()
+
+	grow, err := s.underProvisioned(ctx, pool, scale)
+	if err != nil {
+		return 0, 0, err
+	}
+	if grow {
+		target := total + int64(scale.Step)
+		if policy.MaxSize > 0 {
+			target = min(target, int64(policy.MaxSize))
+		}
+		if target <= total {
+			return 0, 0, nil
+		}
+		generated, err := s.WarmPool(ctx, pool, Warmup{Size: int(target)})
+		return generated, 0, err
+	}
+
+	if scale.IdlePercent == 0 || total == 0 || counts.Available*100 <= total*int64(scale.IdlePercent) {
+		return 0, 0, nil
+	}
+	// Retire down to the idle threshold, never below MinSize
+	surplus := counts.Available - total*int64(scale.IdlePercent)/100
+	surplus = min(surplus, int64(scale.Step), total-int64(policy.MinSize))
+	if surplus <= 0 {
+		return 0, 0, nil
+	}
+	retired, err := s.repo.RetireAvailable(ctx, pool, int(surplus))
+	return 0, retired, err
+}
+
+// underProvisioned reports whether a pool's failure rate or queue depth is
+// past its autoscaling thresholds
+func (s *TokenService) underProvisioned(ctx context.Context, pool string, scale Autoscale) (bool, error) {
+	if scale.QueueDepth > 0 && s.config.QueueEnabled {
+		waiting, err := s.repo.QueueLength(ctx, pool)
+		if err != nil {
+			return false, err
+		}
+		if waiting > int64(scale.QueueDepth) {
+			return true, nil
+		}
+	}
+	if scale.FailurePercent > 0 {
+		attempts, failures, err := s.repo.AssignOutcomes(ctx, pool, scale.Window)
+		if err != nil {
+			return false, err
+		}
+		if attempts > 0 && failures*100 > attempts*int64(scale.FailurePercent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
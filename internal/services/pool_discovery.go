@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"slices"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// Pool health as reported by DescribePools
+const (
+	PoolHealthy   = "healthy"   // tokens are ready for assignment
+	PoolDegraded  = "degraded"  // more tokens are quarantined than ready
+	PoolExhausted = "exhausted" // every token is taken, quarantined or pending
+	PoolEmpty     = "empty"     // the pool holds no tokens at all
+)
+
+// PoolInfo describes a pool to clients choosing which one to draw from
+type PoolInfo struct {
+	Name           string                     `json:"name"`
+	Parent         string                     `json:"parent,omitempty"` // pool the policy inherits unset fields from
+	Tags           []string                   `json:"tags"`
+	Labels         map[string]string          `json:"labels,omitempty"` // attached to every generated token
+	Fallback       string                     `json:"fallback,omitempty"`
+	Prefix         string                     `json:"prefix,omitempty"`
+	ReservePercent int                        `json:"reserve_percent,omitempty"`
+	MinSize        int                        `json:"min_size,omitempty"`
+	MaxSize        int                        `json:"max_size,omitempty"`
+	SoftMaxSize    int                        `json:"soft_max_size,omitempty"` // size past which growing the pool sends a quota.soft_limit warning
+	Timing         repositories.TimingSeconds `json:"timing"`
+	Size           int64                      `json:"size"`
+	Counts         repositories.PoolCounts    `json:"counts"`
+	Health         string                     `json:"health"`
+}
+
+// DescribePools returns every known pool with its policy, size and health.
+// With tags set only pools carrying all of them are returned.
+func (s *TokenService) DescribePools(ctx context.Context, tags []string) ([]PoolInfo, error) {
+	pools, err := s.repo.ListPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]PoolInfo, 0, len(pools))
+	for _, pool := range pools {
+		policy := s.policyOf(pool)
+		if !hasTags(policy.Tags, tags) {
+			continue
+		}
+		counts, err := s.repo.CountPool(ctx, pool)
+		if err != nil {
+			return nil, err
+		}
+		info := PoolInfo{
+			Name:           pool,
+			Parent:         policy.Parent,
+			Tags:           policy.Tags,
+			Labels:         policy.Labels,
+			Fallback:       s.config.Fallbacks[pool],
+			Prefix:         s.config.Prefixes[pool],
+			ReservePercent: s.config.Reserves[pool].Percent,
+			MinSize:        policy.MinSize,
+			MaxSize:        policy.MaxSize,
+			SoftMaxSize:    policy.SoftMaxSize,
+			Timing:         repositories.SecondsOf(s.repo.EffectiveTiming(pool)),
+			Size:           counts.Total(),
+			Counts:         counts,
+			Health:         poolHealth(counts),
+		}
+		if info.Tags == nil {
+			info.Tags = []string{}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func poolHealth(counts repositories.PoolCounts) string {
+	switch {
+	case counts.Total() == 0:
+		return PoolEmpty
+	case counts.Available == 0:
+		return PoolExhausted
+	case counts.Quarantined > counts.Available:
+		return PoolDegraded
+	default:
+		return PoolHealthy
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// PoolPolicy is the declared shape of a pool, applied by ReconcilePool
+type PoolPolicy struct {
+	Parent      string                    // pool whose policy fills in the fields left unset here, see ResolvePolicy
+	Timing      repositories.TimingConfig // unset fields inherit the global token timing
+	MinSize     int                       // reconciling generates tokens up to this many
+	MaxSize     int                       // generate and import refuse to grow the pool past this; 0 is unlimited
+	SoftMaxSize int                       // generate and import warn once the pool reaches this, ahead of MaxSize; 0 never warns
+	Generator   string                    // how token values are made, see Generators
+	Labels      map[string]string         // attached to every generated token, under any labels the request sets
+	Tags        []string                  // describe the pool to clients discovering pools, see DescribePools
+	Autoscale   Autoscale                 // grows and shrinks the pool between MinSize and MaxSize by demand
+}
+
+// Generators make new token values, by the name used in PoolPolicy.Generator
+var Generators = map[string]func() string{
+	"uuid": func() string { return uuid.New().String() },
+	"hex": func() string { // 256 random bits
+		b := make([]byte, 32)
+		rand.Read(b) // crypto/rand only fails if the OS has no entropy source
+		return hex.EncodeToString(b)
+	},
+}
+
+// ResolvePolicy returns the policy of pool with every field it leaves unset
+// taken from its parent, whose own unset fields come from its parent in turn.
+// Timing fields, the generator and the size limits are inherited when zero,
+// tags and autoscaling when none are set; labels are merged, the child's winning. policies
+// holds every declared pool by name, parents included.
+func ResolvePolicy(pool string, policies map[string]PoolPolicy) (PoolPolicy, error) {
+	policy := policies[pool]
+	visited := map[string]bool{pool: true}
+	for parent := policy.Parent; parent != ""; {
+		if visited[parent] {
+			return PoolPolicy{}, fmt.Errorf("Parent %q forms a cycle", parent)
+		}
+		visited[parent] = true
+		inherited, ok := policies[parent]
+		if !ok {
+			return PoolPolicy{}, fmt.Errorf("Parent %q is not a declared pool", parent)
+		}
+		policy = inherit(policy, inherited)
+		parent = inherited.Parent
+	}
+	return policy, nil
+}
+
+// inherit fills the unset fields of child from parent
+func inherit(child, parent PoolPolicy) PoolPolicy {
+	if child.Timing.AssignmentTTL == 0 {
+		child.Timing.AssignmentTTL = parent.Timing.AssignmentTTL
+	}
+	if child.Timing.KeepaliveGrace == 0 {
+		child.Timing.KeepaliveGrace = parent.Timing.KeepaliveGrace
+	}
+	if child.Timing.DeletionAfterIdle == 0 {
+		child.Timing.DeletionAfterIdle = parent.Timing.DeletionAfterIdle
+	}
+	if child.Timing.LockTTL == 0 {
+		child.Timing.LockTTL = parent.Timing.LockTTL
+	}
+	if child.Timing.MaxHold == 0 {
+		child.Timing.MaxHold = parent.Timing.MaxHold
+	}
+	if child.MinSize == 0 {
+		child.MinSize = parent.MinSize
+	}
+	if child.MaxSize == 0 {
+		child.MaxSize = parent.MaxSize
+	}
+	if child.SoftMaxSize == 0 {
+		child.SoftMaxSize = parent.SoftMaxSize
+	}
+	if child.Generator == "" {
+		child.Generator = parent.Generator
+	}
+	if len(parent.Labels) > 0 {
+		labels := maps.Clone(parent.Labels)
+		maps.Copy(labels, child.Labels)
+		child.Labels = labels
+	}
+	if len(child.Tags) == 0 {
+		child.Tags = parent.Tags
+	}
+	if !child.Autoscale.Enabled() {
+		child.Autoscale = parent.Autoscale
+	}
+	return child
+}
+
+// policyOf returns the declared policy of a pool, the zero policy for undeclared ones
+func (s *TokenService) policyOf(pool string) PoolPolicy {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.policies[pool]
+}
+
+// ReconcilePool brings a pool in line with its declared policy: it makes sure
+// the pool exists, applies its timing, size limits, generator and labels, and
+// tops it up to MinSize. It returns how many tokens it generated. A zero
+// policy returns the pool to the global defaults. Safe to call repeatedly,
+// including while the service is handling requests.
+func (s *TokenService) ReconcilePool(ctx context.Context, pool string, policy PoolPolicy) (int, error) {
+	if policy.Generator == "" {
+		policy.Generator = "uuid"
+	}
+	if _, ok := Generators[policy.Generator]; !ok {
+		return 0, fmt.Errorf("unknown generator %q", policy.Generator)
+	}
+	if policy.MaxSize > 0 && policy.MinSize > policy.MaxSize {
+		return 0, fmt.Errorf("MinSize (%d) exceeds MaxSize (%d)", policy.MinSize, policy.MaxSize)
+	}
+	if policy.MaxSize > 0 && policy.SoftMaxSize >= policy.MaxSize {
+		return 0, fmt.Errorf("SoftMaxSize (%d) must be below MaxSize (%d)", policy.SoftMaxSize, policy.MaxSize)
+	}
+	if slices.Contains(policy.Tags, "") {
+		return 0, errors.New("Tags must not be empty strings")
+	}
+	if err := policy.Autoscale.validate(); err != nil {
+		return 0, err
+	}
+	if err := s.repo.SetPoolTiming(pool, policy.Timing); err != nil {
+		return 0, err
+	}
+	s.policyMu.Lock()
+	if s.policies == nil {
+		s.policies = make(map[string]PoolPolicy)
+	}
+	s.policies[pool] = policy
+	s.policyMu.Unlock()
+
+	if err := s.repo.RegisterPool(ctx, pool); err != nil {
+		return 0, err
+	}
+	if policy.MinSize == 0 {
+		return 0, nil
+	}
+	return s.WarmPool(ctx, pool, Warmup{Size: policy.MinSize})
+}
+
+// checkCapacity refuses to add count tokens to a pool that would grow past its
+// MaxSize, and warns when they bring it to its SoftMaxSize. The check is best
+// effort: concurrent adds can overshoot it slightly.
+func (s *TokenService) checkCapacity(ctx context.Context, pool string, count int) error {
+	policy := s.policyOf(pool)
+	if policy.MaxSize <= 0 && policy.SoftMaxSize <= 0 {
+		return nil
+	}
+	size, err := s.repo.PoolSize(ctx, pool)
+	if err != nil {
+		return err
+	}
+	grown := size + int64(count)
+	if policy.MaxSize > 0 && grown > int64(policy.MaxSize) {
+		return constants.ErrPoolFull
+	}
+	if policy.SoftMaxSize > 0 && grown >= int64(policy.SoftMaxSize) {
+		s.repo.WarnSoftLimit(ctx, repositories.SoftLimitPoolSize, pool, grown, int64(policy.SoftMaxSize), int64(policy.MaxSize))
+	}
+	return nil
+}
+
+// newToken makes a token value for pool with its generator and prefix, and
+// merges the pool's default labels under the requested ones
+func (s *TokenService) newToken(pool string, labels map[string]string) (string, map[string]string) {
+	policy := s.policyOf(pool)
+	generate, ok := Generators[policy.Generator]
+	if !ok {
+		generate = Generators["uuid"]
+	}
+	if len(policy.Labels) > 0 {
+		merged := maps.Clone(policy.Labels)
+		maps.Copy(merged, labels)
+		labels = merged
+	}
+	return s.config.Prefixes[pool] + generate(), labels
+}
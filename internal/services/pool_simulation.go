@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// PolicyProposal is a change to a pool's policy to simulate. Nil sizes keep
+// the pool's declared ones.
+type PolicyProposal struct {
+	Timing  TimingPatch
+	MinSize *int
+	MaxSize *int // 0 is unlimited
+}
+
+// PolicySimulation compares what cleanup does to a pool under its current
+// timing with what it would do under a proposal, and how the pool's size
+// sits against the proposed limits
+type PolicySimulation struct {
+	Pool        string                       `json:"pool"`
+	Current     repositories.CleanupForecast `json:"current"`
+	Proposed    repositories.CleanupForecast `json:"proposed"`
+	Size        int64                        `json:"size"`
+	MinSize     int                          `json:"min_size"`
+	MaxSize     int                          `json:"max_size"`
+	ToGenerate  int64                        `json:"to_generate"`   // tokens reconciling would add to reach MinSize
+	OverMaxSize int64                        `json:"over_max_size"` // tokens above MaxSize; generate and import refuse until cleanup brings the pool under it
+}
+
+// SimulatePoolPolicy forecasts a proposed policy change against the pool's
+// current keepalive scores without applying it. The timing patch is read as
+// PATCH /admin/pools/:pool/policy reads it, so an invalid one fails the same
+// way, with ErrInvalidTiming.
+func (s *TokenService) SimulatePoolPolicy(ctx context.Context, pool string, proposal PolicyProposal) (PolicySimulation, error) {
+	override, err := s.repo.TimingOverrideOf(ctx, pool)
+	if err != nil {
+		return PolicySimulation{}, err
+	}
+	proposal.Timing.apply(&override)
+	timing, err := s.repo.TimingWith(pool, override)
+	if err != nil {
+		return PolicySimulation{}, err
+	}
+
+	current, proposed, err := s.repo.SimulateCleanup(ctx, pool, timing)
+	if err != nil {
+		return PolicySimulation{}, err
+	}
+	size, err := s.repo.PoolSize(ctx, pool)
+	if err != nil {
+		return PolicySimulation{}, err
+	}
+
+	policy := s.policyOf(pool)
+	sim := PolicySimulation{
+		Pool:     pool,
+		Current:  current,
+		Proposed: proposed,
+		Size:     size,
+		MinSize:  policy.MinSize,
+		MaxSize:  policy.MaxSize,
+	}
+	if proposal.MinSize != nil {
+		sim.MinSize = *proposal.MinSize
+	}
+	if proposal.MaxSize != nil {
+		sim.MaxSize = *proposal.MaxSize
+	}
+	sim.ToGenerate = max(int64(sim.MinSize)-size, 0)
+	if sim.MaxSize > 0 {
+		sim.OverMaxSize = max(size-int64(sim.MaxSize), 0)
+	}
+	return sim, nil
+}
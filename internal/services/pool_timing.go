@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// TimingPatch changes some of a pool's runtime timing. Nil fields keep their
+// current override, zero clears it back to the configured value.
+type TimingPatch struct {
+	AssignmentTTLSec     *int
+	KeepaliveGraceSec    *int
+	DeletionAfterIdleSec *int
+	LockTTLSec           *int
+	MaxHoldSec           *int
+}
+
+// apply sets the fields of override the patch changes and returns them by
+// name, for the audit entry
+func (patch TimingPatch) apply(override *repositories.TimingSeconds) map[string]string {
+	detail := map[string]string{}
+	for _, field := range []struct {
+		name  string
+		value *int
+		dst   *int
+	}{
+		{"assignment_ttl_sec", patch.AssignmentTTLSec, &override.AssignmentTTLSec},
+		{"keepalive_grace_sec", patch.KeepaliveGraceSec, &override.KeepaliveGraceSec},
+		{"deletion_after_idle_sec", patch.DeletionAfterIdleSec, &override.DeletionAfterIdleSec},
+		{"lock_ttl_sec", patch.LockTTLSec, &override.LockTTLSec},
+		{"max_hold_sec", patch.MaxHoldSec, &override.MaxHoldSec},
+	} {
+		if field.value != nil {
+			*field.dst = *field.value
+			detail[field.name] = strconv.Itoa(*field.value)
+		}
+	}
+	return detail
+}
+
+// PoolTiming returns a pool's runtime override and the timing it runs with
+func (s *TokenService) PoolTiming(ctx context.Context, pool string) (repositories.TimingSeconds, repositories.TimingSeconds, error) {
+	override, err := s.repo.TimingOverrideOf(ctx, pool)
+	if err != nil {
+		return override, repositories.TimingSeconds{}, err
+	}
+	return override, repositories.SecondsOf(s.repo.EffectiveTiming(pool)), nil
+}
+
+// UpdatePoolTiming applies a patch to a pool's runtime timing. The result is
+// stored in Redis so every replica converges on it, and recorded in the audit
+// history under actor.
+func (s *TokenService) UpdatePoolTiming(ctx context.Context, pool string, patch TimingPatch, actor string) (repositories.TimingSeconds, error) {
+	override, err := s.repo.TimingOverrideOf(ctx, pool)
+	if err != nil {
+		return override, err
+	}
+
+	detail := patch.apply(&override)
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "pool.timing",
+		Pool:   pool,
+		Actor:  actor,
+		Detail: detail,
+	})
+	return override, s.repo.SaveTimingOverride(ctx, pool, override)
+}
+
+// RefreshPoolTimings reloads the runtime timing overrides from Redis
+func (s *TokenService) RefreshPoolTimings(ctx context.Context) error {
+	return s.repo.LoadTimingOverrides(ctx)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// Warmup is what a pool should hold when the service starts
+type Warmup struct {
+	Size  int      // generate tokens until the pool holds at least this many
+	Seeds []string // tokens to import unless they already exist
+}
+
+// WarmPool brings a pool up to its warm-up spec and returns how many tokens
+// it added. It is idempotent: seeds already saved are skipped and Size counts
+// tokens in every state, so restarts don't grow the pool. When another
+// replica is warming the same pool it returns 0 without doing anything.
+func (s *TokenService) WarmPool(ctx context.Context, pool string, spec Warmup) (int, error) {
+	locked, err := s.repo.LockWarmup(ctx, pool)
+	if err != nil || !locked {
+		return 0, err
+	}
+	defer s.repo.UnlockWarmup(context.WithoutCancel(ctx), pool)
+
+	added := 0
+	for _, seed := range spec.Seeds {
+		exists, err := s.repo.HasToken(ctx, seed, s.config.HashOnly)
+		if err != nil {
+			return added, err
+		}
+		if exists {
+			continue
+		}
+		if _, err := s.ImportToken(ctx, pool, seed, nil, time.Time{}); err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	have, err := s.repo.PoolSize(ctx, pool)
+	if err != nil {
+		return added, err
+	}
+	for ; have < int64(spec.Size); have++ {
+		if _, err := s.GenerateToken(ctx, pool, nil, time.Time{}); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
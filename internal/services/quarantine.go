@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// QuarantinedTokens lists a pool's quarantined tokens for review, longest quarantined first
+func (s *TokenService) QuarantinedTokens(ctx context.Context, pool string) ([]repositories.QuarantinedToken, error) {
+	return s.repo.QuarantinedTokens(ctx, pool)
+}
+
+// QuarantineStats returns a pool's quarantine size and when its longest
+// quarantined token was pulled
+func (s *TokenService) QuarantineStats(ctx context.Context, pool string) (int64, time.Time, error) {
+	return s.repo.QuarantineStats(ctx, pool)
+}
+
+// ApproveQuarantined puts a quarantined token back in its pool without
+// waiting for it to pass a probe, recording actor in the audit history
+func (s *TokenService) ApproveQuarantined(ctx context.Context, token, actor string) error {
+	if err := s.checkTransition(ctx, token, TransitionRestore); err != nil {
+		return err
+	}
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "token.quarantine_approve",
+		Token:  token,
+		Actor:  actor,
+	})
+	_, err := s.repo.ApproveQuarantined(ctx, token)
+	return err
+}
+
+// PurgeQuarantined permanently deletes a quarantined token, recording actor
+// in the audit history. ErrTokenNotQuarantined means it isn't in quarantine.
+// The delete is conditional on the token not changing since it was checked,
+// so one restored by a probe in the meantime is kept; an ifVersion above 0
+// pins the revision instead.
+func (s *TokenService) PurgeQuarantined(ctx context.Context, token, actor string, ifVersion int64) error {
+	state, err := s.repo.StateOf(ctx, token)
+	if err != nil {
+		return err
+	}
+	if state != repositories.TokenStateQuarantined {
+		return constants.ErrTokenNotQuarantined
+	}
+	if ifVersion <= 0 {
+		if ifVersion, err = s.repo.VersionOf(ctx, token); err != nil {
+			return err
+		}
+	}
+	pool, err := s.repo.PoolOfToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "token.quarantine_purge",
+		Token:  token,
+		Pool:   pool,
+		Actor:  actor,
+	})
+	return s.repo.DeleteToken(ctx, token, ifVersion)
+}
+
+// PurgeQuarantine deletes every token in a pool's quarantine and reports how
+// many went. Tokens that leave quarantine while the purge runs are skipped.
+func (s *TokenService) PurgeQuarantine(ctx context.Context, pool, actor string) (int, error) {
+	tokens, err := s.repo.QuarantinedTokens(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, token := range tokens {
+		err := s.PurgeQuarantined(ctx, token.Token, actor, token.Version)
+		switch {
+		case err == nil:
+			purged++
+		case errors.Is(err, constants.ErrVersionMismatch), errors.Is(err, constants.ErrTokenNotQuarantined),
+			errors.Is(err, constants.ErrTokenNotFound):
+		default:
+			return purged, err
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// ImportRow is one token read from a bulk import, by its line in the file
+type ImportRow struct {
+	Line       int
+	Token      string
+	Labels     map[string]string
+	ActivateAt time.Time
+}
+
+// importColumns are the CSV columns a bulk import understands; only token is
+// required, and the header row may name them in any order
+var importColumns = map[string]bool{"token": true, "labels": true, "activate_at": true}
+
+// ImportHeader maps the columns named by a bulk import's header row to their
+// index, failing with ErrInvalidImport on an unknown or missing one
+func ImportHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !importColumns[name] {
+			return nil, fmt.Errorf("%w: unknown column %q", constants.ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["token"]; !ok {
+		return nil, fmt.Errorf("%w: no token column", constants.ErrInvalidImport)
+	}
+	return columns, nil
+}
+
+// NewImport starts the summary of a bulk import into pool
+func (s *TokenService) NewImport(pool string) *repositories.ImportSummary {
+	return &repositories.ImportSummary{ID: uuid.New().String(), Pool: pool, StartedAt: s.repo.Now()}
+}
+
+// SpoolImport checks the header row of an uploaded CSV and stores the file in
+// Redis a chunk at a time as it arrives, so the import job can run on any
+// replica without the upload ever being held in memory whole. The summary is
+// saved with the file's size.
+func (s *TokenService) SpoolImport(ctx context.Context, summary *repositories.ImportSummary, file io.Reader) error {
+	buffered := bufio.NewReaderSize(file, constants.ImportUploadChunkSize)
+	line, err := buffered.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read import: %w", err)
+	}
+	header, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return fmt.Errorf("%w: no header row", constants.ErrInvalidImport)
+	}
+	if _, err := ImportHeader(header); err != nil {
+		return err
+	}
+
+	upload := io.MultiReader(strings.NewReader(line), buffered)
+	chunk := make([]byte, constants.ImportUploadChunkSize)
+	for {
+		n, err := io.ReadFull(upload, chunk)
+		if n > 0 {
+			if err := s.repo.AppendImportUpload(ctx, summary.ID, chunk[:n]); err != nil {
+				return err
+			}
+			summary.BytesTotal += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read import: %w", err)
+		}
+	}
+	return s.repo.SaveImportSummary(ctx, *summary)
+}
+
+// spooledUpload reads back a file stored by SpoolImport, a chunk at a time
+type spooledUpload struct {
+	ctx   context.Context
+	repo  *repositories.TokenRepository
+	id    string
+	index int64
+	chunk []byte
+	read  int64
+}
+
+func (u *spooledUpload) Read(p []byte) (int, error) {
+	if len(u.chunk) == 0 {
+		chunk, err := u.repo.ImportUploadChunk(u.ctx, u.id, u.index)
+		if err != nil {
+			return 0, err
+		}
+		if chunk == nil {
+			return 0, io.EOF
+		}
+		u.chunk = chunk
+		u.index++
+	}
+	n := copy(p, u.chunk)
+	u.chunk = u.chunk[n:]
+	u.read += int64(n)
+	return n, nil
+}
+
+// RunImport imports the file spooled for import id into its pool, reporting
+// the counts so far to progress after every chunk; an error from progress,
+// such as ErrJobCancelled, stops it. The rows saved until it stops stay. The
+// spooled file is dropped once read, and the outcome recorded in the summary
+// it returns.
+func (s *TokenService) RunImport(ctx context.Context, id string, progress func(repositories.ImportSummary) error) (*repositories.ImportSummary, error) {
+	summary, err := s.repo.ImportSummaryOf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	upload := &spooledUpload{ctx: ctx, repo: s.repo, id: id}
+	began := s.repo.Now()
+	report := func() error {
+		summary.BytesRead = upload.read
+		summary.ETASeconds = nil
+		if elapsed := s.repo.Now().Sub(began); upload.read > 0 && upload.read < summary.BytesTotal {
+			eta := int64(elapsed.Seconds() * float64(summary.BytesTotal-upload.read) / float64(upload.read))
+			summary.ETASeconds = &eta
+		}
+		if err := s.repo.SaveImportSummary(ctx, *summary); err != nil {
+			return err
+		}
+		return progress(*summary)
+	}
+
+	err = s.importRows(ctx, summary, upload, report)
+	summary.BytesRead = upload.read
+	summary.ETASeconds = nil
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	finished := s.repo.Now()
+	summary.FinishedAt = &finished
+
+	// Recorded even when ctx ended the import
+	ctx = context.WithoutCancel(ctx)
+	if saveErr := s.repo.SaveImportSummary(ctx, *summary); saveErr != nil {
+		slog.Error("Failed to save import summary", slog.String("import", id), slog.Any("error", saveErr))
+	}
+	if dropErr := s.repo.DropImportUpload(ctx, id); dropErr != nil {
+		slog.Warn("Failed to drop import upload", slog.String("import", id), slog.Any("error", dropErr))
+	}
+	return summary, err
+}
+
+// importRows saves the data rows of an import a chunk at a time
+func (s *TokenService) importRows(ctx context.Context, summary *repositories.ImportSummary, file io.Reader, report func() error) error {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%w: no header row", constants.ErrInvalidImport)
+	}
+	columns, err := ImportHeader(header)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]ImportRow, 0, constants.ImportChunkSize)
+	var rejected []repositories.ImportRowError
+	flush := func() error {
+		if len(chunk) > 0 {
+			errs, err := s.ImportTokens(ctx, summary.Pool, chunk)
+			if err != nil {
+				return err
+			}
+			for i, err := range errs {
+				if err != nil {
+					rejected = append(rejected, repositories.ImportRowError{Line: chunk[i].Line, Error: err.Error()})
+				} else {
+					summary.Imported++
+				}
+			}
+			chunk = chunk[:0]
+		}
+
+		// Past the cap only the count of failed rows grows
+		keep := max(0, min(len(rejected), constants.MaxImportReportRows-summary.Failed))
+		summary.Failed += len(rejected)
+		summary.ReportTruncated = summary.Failed > constants.MaxImportReportRows
+		err := s.repo.AppendImportReport(ctx, summary.ID, rejected[:keep])
+		rejected = rejected[:0]
+		if err != nil {
+			return err
+		}
+		return report()
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			summary.Rows++
+			rejected = append(rejected, repositories.ImportRowError{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+		case err != nil:
+			return fmt.Errorf("failed to read import: %w", err)
+		default:
+			summary.Rows++
+			line, _ := reader.FieldPos(0)
+			row, err := importRow(record, columns)
+			if err != nil {
+				rejected = append(rejected, repositories.ImportRowError{Line: line, Error: err.Error()})
+				break
+			}
+			row.Line = line
+			chunk = append(chunk, row)
+		}
+
+		if len(chunk) == constants.ImportChunkSize || len(rejected) >= constants.ImportChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// importRow validates one data row as a single import would its fields
+func importRow(record []string, columns map[string]int) (ImportRow, error) {
+	cell := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var row ImportRow
+	row.Token = cell("token")
+	if row.Token == "" || len(row.Token) > 512 || strings.IndexFunc(row.Token, func(r rune) bool { return r < ' ' || r > '~' }) >= 0 {
+		return row, errors.New("invalid token")
+	}
+	labels, err := ParseLabels(cell("labels"))
+	if err != nil {
+		return row, err
+	}
+	row.Labels = labels
+	if raw := cell("activate_at"); raw != "" {
+		if row.ActivateAt, err = time.Parse(time.RFC3339, raw); err != nil {
+			return row, fmt.Errorf("invalid activate_at %q", raw)
+		}
+	}
+	return row, nil
+}
+
+// ImportTokens adds one chunk of a bulk import to pool and returns an error
+// per row, nil for those saved. A row is rejected when its token lacks the
+// pool's prefix, repeats an earlier row of the chunk or is already stored;
+// once the chunk no longer fits under the pool's MaxSize none of it is saved.
+// Earlier chunks are stored by then, so a repeat across chunks is caught as
+// an existing token. The error is for a lookup that failed the whole chunk.
+func (s *TokenService) ImportTokens(ctx context.Context, pool string, rows []ImportRow) ([]error, error) {
+	errs := make([]error, len(rows))
+	seen := make(map[string]bool, len(rows))
+	var pending []int
+	for i, row := range rows {
+		switch {
+		case !strings.HasPrefix(row.Token, s.config.Prefixes[pool]):
+			errs[i] = constants.ErrTokenPrefixMismatch
+		case seen[row.Token]:
+			errs[i] = constants.ErrDuplicateImportRow
+		default:
+			seen[row.Token] = true
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return errs, nil
+	}
+
+	tokens := make([]string, len(pending))
+	for j, i := range pending {
+		tokens[j] = rows[i].Token
+	}
+	exists, err := s.repo.TokensExist(ctx, tokens, s.config.HashOnly)
+	if err != nil {
+		return nil, err
+	}
+	batch := make([]repositories.NewToken, 0, len(pending))
+	saved := make([]int, 0, len(pending))
+	for j, i := range pending {
+		if exists[j] {
+			errs[i] = constants.ErrTokenExists
+			continue
+		}
+		row := rows[i]
+		batch = append(batch, repositories.NewToken{
+			Pool: pool, Token: row.Token, Labels: row.Labels, ActivateAt: row.ActivateAt, Hashed: s.config.HashOnly,
+		})
+		saved = append(saved, i)
+	}
+	if len(batch) == 0 {
+		return errs, nil
+	}
+
+	if err := s.checkCapacity(ctx, pool, len(batch)); err != nil {
+		if !errors.Is(err, constants.ErrPoolFull) {
+			return nil, err
+		}
+		for _, i := range saved {
+			errs[i] = err
+		}
+		return errs, nil
+	}
+	for j, err := range s.repo.SaveTokens(ctx, batch) {
+		errs[saved[j]] = err
+	}
+	return errs, nil
+}
+
+// ImportSummaryOf returns the counts of a bulk import
+func (s *TokenService) ImportSummaryOf(ctx context.Context, id string) (*repositories.ImportSummary, error) {
+	return s.repo.ImportSummaryOf(ctx, id)
+}
+
+// ImportReport pages through the rejected rows of a bulk import
+func (s *TokenService) ImportReport(ctx context.Context, id string, offset, count int64) ([]repositories.ImportRowError, error) {
+	return s.repo.ImportReport(ctx, id, offset, count)
+}
@@ -2,50 +2,760 @@ package services
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/callbacks"
 	"github.com/manankarani/token-manager/internal/repositories"
 
 	"github.com/google/uuid"
 )
 
 type TokenService struct {
-	repo *repositories.TokenRepository
+	repo    *repositories.TokenRepository
+	config  Config
+	batcher *generateBatcher // nil when generate batching is off
+
+	policyMu sync.RWMutex
+	policies map[string]PoolPolicy // declared pools, see ReconcilePool
+}
+
+// Config toggles optional service behaviour
+type Config struct {
+	QueueEnabled bool              // hold assign requests in a FIFO queue when the pool is empty
+	Fallbacks    map[string]string // pool -> pool to draw from when it is empty
+	HashOnly     bool              // store imported tokens as SHA-256 handles only
+	Reserves     map[string]Reserve
+	Prefixes     map[string]string // pool -> prefix its tokens start with, e.g. "stg_"
+	Batch        GenerateBatch
+	DedupeWindow time.Duration // a client retrying assign within this window gets the same token; 0 disables
+
+	KeepaliveAssignedOnly bool // reject keepalive on tokens that were never assigned unless the caller opts out
+
+	Callbacks     *callbacks.Notifier // warns holders before their token is reclaimed; nil disables callbacks
+	CallbackGrace time.Duration       // how long a warned holder has to keep alive or release
+	Webhooks      *callbacks.Notifier // delivers audit events to webhook subscriptions and checks their URLs
+}
+
+// Reserve holds back a share of a pool for high-priority clients
+type Reserve struct {
+	Percent int             // share of the pool's tokens other clients can't take
+	Clients map[string]bool // client IDs allowed into the reserve
+}
+
+// reserveFor returns the share of pool that client must leave untouched
+func (s *TokenService) reserveFor(pool, client string) int {
+	reserve, ok := s.config.Reserves[pool]
+	if !ok || reserve.Clients[client] {
+		return 0
+	}
+	return reserve.Percent
+}
+
+// prefixPattern keeps pool prefixes short and safe in URLs and headers
+var prefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+// ValidPrefix reports whether prefix can be used as a pool's token prefix
+func ValidPrefix(prefix string) bool {
+	return prefixPattern.MatchString(prefix)
+}
+
+// checkPrefix rejects a token that doesn't start with its pool's prefix.
+// A leaked token is attributable to its environment by the prefix alone,
+// so one without it was not issued by that pool.
+func (s *TokenService) checkPrefix(ctx context.Context, token string) error {
+	if len(s.config.Prefixes) == 0 {
+		return nil
+	}
+	pool, err := s.repo.PoolOfToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(token, s.config.Prefixes[pool]) {
+		return constants.ErrTokenPrefixMismatch
+	}
+	return nil
+}
+
+func NewTokenService(repo *repositories.TokenRepository, config Config) *TokenService {
+	s := &TokenService{repo: repo, config: config}
+	if config.Batch.Size > 1 {
+		s.batcher = newGenerateBatcher(repo, config.Batch)
+	}
+	return s
+}
+
+// GenerateToken creates a token in pool. With a future activateAt it is held
+// inactive until then; a zero activateAt makes it available right away. A
+// value that was issued before, which short or custom generators can produce,
+// is replaced by a fresh one up to MaxGenerateAttempts times before failing
+// with ErrTokenExists.
+func (s *TokenService) GenerateToken(ctx context.Context, pool string, requested map[string]string, activateAt time.Time) (*repositories.Token, error) {
+	if err := s.checkCapacity(ctx, pool, 1); err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		token, labels := s.newToken(pool, requested)
+		var err error
+		if s.batcher != nil {
+			err = s.batcher.Save(ctx, repositories.NewToken{Pool: pool, Token: token, Labels: labels, ActivateAt: activateAt})
+		} else {
+			err = s.repo.SaveToken(ctx, pool, token, labels, activateAt)
+		}
+		if errors.Is(err, constants.ErrTokenExists) && attempt < constants.MaxGenerateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return repositories.CreatedToken(pool, token, labels, activateAt, s.repo.Now()), nil
+	}
 }
 
-func NewTokenService(repo *repositories.TokenRepository) *TokenService {
-	return &TokenService{repo: repo}
+// ImportToken adds an externally issued token to a pool. In hash-only mode
+// only its handle is stored, and the handle is what callers get back as the
+// token's value. activateAt delays availability as for GenerateToken.
+func (s *TokenService) ImportToken(ctx context.Context, pool, token string, labels map[string]string, activateAt time.Time) (*repositories.Token, error) {
+	if !strings.HasPrefix(token, s.config.Prefixes[pool]) {
+		return nil, constants.ErrTokenPrefixMismatch
+	}
+	if err := s.checkCapacity(ctx, pool, 1); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.config.HashOnly {
+		token, err = s.repo.SaveHashedToken(ctx, pool, token, labels, activateAt)
+	} else {
+		err = s.repo.SaveToken(ctx, pool, token, labels, activateAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repositories.CreatedToken(pool, token, labels, activateAt, s.repo.Now()), nil
 }
 
-func (s *TokenService) GenerateToken(ctx context.Context) (string, error) {
-	token := uuid.New().String()
-	err := s.repo.SaveToken(ctx, token)
-	return token, err
+// SetRateLimit stores the upstream requests-per-minute hint handed out with a token
+func (s *TokenService) SetRateLimit(ctx context.Context, token string, perMinute int) error {
+	return s.repo.SetRateLimit(ctx, token, perMinute)
 }
 
-func (s *TokenService) AssignToken(ctx context.Context) (string, error) {
-	return s.repo.AssignToken(ctx)
+// RateLimitOf returns a token's requests-per-minute hint, 0 if it has none
+func (s *TokenService) RateLimitOf(ctx context.Context, token string) (int, error) {
+	return s.repo.RateLimitOf(ctx, token)
 }
 
-func (s *TokenService) KeepTokenAlive(ctx context.Context, token string) error {
-	return s.repo.KeepAlive(ctx, token)
+// RateLimitsOf is RateLimitOf for several tokens in one round trip
+func (s *TokenService) RateLimitsOf(ctx context.Context, tokens []string) ([]int, error) {
+	return s.repo.RateLimitsOf(ctx, tokens)
 }
 
-func (s *TokenService) DeleteToken(ctx context.Context, token string) error {
-	return s.repo.DeleteToken(ctx, token)
+// ReportUsage records quota a client consumed with a token, returning the
+// token's utilisation for the current minute
+func (s *TokenService) ReportUsage(ctx context.Context, token string, used int) (float64, error) {
+	return s.repo.ReportUsage(ctx, token, used)
+}
+
+// ActivateDueTokens makes a pool's pending tokens available once their
+// activation time has passed, for the activation sweep
+func (s *TokenService) ActivateDueTokens(ctx context.Context, pool string) (map[string]int64, error) {
+	n, err := s.repo.ActivateDueTokens(ctx, pool)
+	return map[string]int64{"activated": int64(n)}, err
+}
+
+// ProbeTargets returns the tokens the health prober should exercise in a pool
+func (s *TokenService) ProbeTargets(ctx context.Context, pool string) ([]repositories.ProbeTarget, error) {
+	return s.repo.ProbeTargets(ctx, pool)
+}
+
+// RecordProbe stores a probe result, quarantining or restoring the token to match
+func (s *TokenService) RecordProbe(ctx context.Context, pool, ref string, result repositories.ProbeResult) (bool, error) {
+	return s.repo.RecordProbe(ctx, pool, ref, result)
+}
+
+// ProvisionToken adds a token minted by an upstream provider, remembering its
+// lease so it can be revoked once the token is retired
+func (s *TokenService) ProvisionToken(ctx context.Context, pool, token, leaseID string) error {
+	if err := s.repo.SaveToken(ctx, pool, token, nil, time.Time{}); err != nil {
+		return err
+	}
+	if leaseID == "" {
+		return nil
+	}
+	return s.repo.SaveLease(ctx, pool, token, leaseID)
+}
+
+func (s *TokenService) AvailableCount(ctx context.Context, pool string) (int64, error) {
+	return s.repo.AvailableCount(ctx, pool)
+}
+
+func (s *TokenService) RetiredLeases(ctx context.Context, pool string) (map[string]string, error) {
+	return s.repo.RetiredLeases(ctx, pool)
+}
+
+func (s *TokenService) ForgetLease(ctx context.Context, pool, ref string) error {
+	return s.repo.ForgetLease(ctx, pool, ref)
+}
+
+// AssignToken assigns a token from pool, walking its fallback chain when the
+// pool is empty. The token's Pool is the one that actually served it.
+// A non-empty selector limits the candidates to tokens carrying all its labels,
+// and clients outside a pool's reserve list can't take its reserved share.
+//
+// With a DedupeWindow, a client retrying the same request (a network retry
+// that never saw the first response) gets the token it was just given instead
+// of taking another one. Anonymous callers share an ID, so they are excluded.
+func (s *TokenService) AssignToken(ctx context.Context, pool, client string, selector map[string]string) (*repositories.Token, error) {
+	dedupe := s.config.DedupeWindow > 0 && client != constants.AnonymousClientID
+	if dedupe {
+		token, err := s.repo.RecentAssignment(ctx, client, pool, selector)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			return token, nil
+		}
+	}
+
+	visited := make(map[string]bool)
+	for current := pool; current != "" && !visited[current]; current = s.config.Fallbacks[current] {
+		visited[current] = true
+
+		token, err := s.assignFrom(ctx, current, repositories.AssignOptions{
+			Selector:       selector,
+			ReservePercent: s.reserveFor(current, client),
+			Client:         client,
+		})
+		s.recordDemand(ctx, current, err)
+		if err == nil {
+			if dedupe {
+				// Best effort: the token is already assigned, failing here would only burn it
+				_ = s.repo.RememberAssignment(ctx, client, pool, selector, token, s.config.DedupeWindow)
+			}
+			return token, nil
+		}
+		if !errors.Is(err, constants.ErrNoAvailableTokens) {
+			return nil, err
+		}
+	}
+	return nil, constants.ErrNoAvailableTokens
+}
+
+// AssignSpecificToken claims a named token for client. It doesn't wait behind
+// queued callers, who only ever ask for any token.
+func (s *TokenService) AssignSpecificToken(ctx context.Context, token, client string) (*repositories.Token, error) {
+	if err := s.checkTransition(ctx, token, TransitionAssign); err != nil {
+		return nil, err
+	}
+	return s.repo.AssignSpecificToken(ctx, token, client)
+}
+
+// AssignTokens claims up to count tokens from pool for client at once and
+// returns those it got, which may be fewer than asked for or none. Unlike
+// AssignToken it stays in pool rather than walking its fallback chain, and it
+// doesn't dedupe retries. Queued waiters still go first.
+func (s *TokenService) AssignTokens(ctx context.Context, pool, client string, count int, selector map[string]string) ([]*repositories.Token, error) {
+	if s.config.QueueEnabled {
+		waiting, err := s.repo.QueueLength(ctx, pool)
+		if err != nil {
+			return nil, err
+		}
+		if waiting > 0 {
+			return []*repositories.Token{}, nil
+		}
+	}
+	return s.repo.AssignTokens(ctx, pool, count, repositories.AssignOptions{
+		Selector:       selector,
+		ReservePercent: s.reserveFor(pool, client),
+		Client:         client,
+	})
+}
+
+func (s *TokenService) assignFrom(ctx context.Context, pool string, opts repositories.AssignOptions) (*repositories.Token, error) {
+	if s.config.QueueEnabled {
+		// Tokens go to queued waiters first so direct callers can't jump the line
+		waiting, err := s.repo.QueueLength(ctx, pool)
+		if err != nil {
+			return nil, err
+		}
+		if waiting > 0 {
+			return nil, constants.ErrNoAvailableTokens
+		}
+	}
+	return s.repo.AssignToken(ctx, pool, opts)
+}
+
+func (s *TokenService) QueueEnabled() bool {
+	return s.config.QueueEnabled
+}
+
+func (s *TokenService) NextReleaseIn(ctx context.Context, pool string) (time.Duration, error) {
+	return s.repo.NextReleaseIn(ctx, pool)
+}
+
+// EnqueueWaiter places a client in a pool's wait queue and returns its ticket and position
+func (s *TokenService) EnqueueWaiter(ctx context.Context, pool, client string) (string, int64, error) {
+	ticket := uuid.New().String()
+	position, err := s.repo.EnqueueWaiter(ctx, pool, ticket, client)
+	return ticket, position, err
+}
+
+// WaitForToken long-polls on behalf of a queued ticket until it is served a
+// token, the timeout elapses, or ctx is cancelled. When no token was served
+// it returns nil and the ticket's current position instead.
+func (s *TokenService) WaitForToken(ctx context.Context, ticket string, timeout time.Duration) (token *repositories.Token, position int64, err error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(constants.QueuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		position, err = s.repo.QueuePosition(ctx, ticket)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// The scheduling policy, not the arrival position, decides who is served next
+		token, err = s.repo.AssignToWaiter(ctx, ticket)
+		if err == nil {
+			return token, 0, nil
+		}
+		if !errors.Is(err, constants.ErrNoAvailableTokens) && !errors.Is(err, constants.ErrNotQueueHead) &&
+			!errors.Is(err, constants.ErrAssignmentCapReached) {
+			return nil, 0, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, position, ctx.Err()
+		case <-deadline.C:
+			return nil, position, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *TokenService) LeaveQueue(ctx context.Context, ticket string) error {
+	return s.repo.LeaveQueue(ctx, ticket)
+}
+
+// KeepTokenAlive extends the expiry of a token, named by value or alias. A
+// keepalive on a token still in the pool fails with ErrKeepaliveNotAssigned
+// when assignedOnly is set, or when it is nil and KeepaliveAssignedOnly is on.
+// One on a token held for its pool's MaxHold fails with ErrMaxHoldReached.
+func (s *TokenService) KeepTokenAlive(ctx context.Context, token string, assignedOnly *bool) error {
+	opts := repositories.KeepaliveOptions{AssignedOnly: s.config.KeepaliveAssignedOnly}
+	if assignedOnly != nil {
+		opts.AssignedOnly = *assignedOnly
+	}
+	return s.orAlias(ctx, token, func(token string) error {
+		if err := s.checkPrefix(ctx, token); err != nil {
+			return err
+		}
+		return s.repo.KeepAlive(ctx, token, opts)
+	})
+}
+
+// ForceKeepAlive resets a token's expiry to a full assignment TTL from now,
+// even when that is earlier than the current one, and records it in the
+// audit history. It is held to the pool's MaxHold like any keepalive.
+func (s *TokenService) ForceKeepAlive(ctx context.Context, token, actor string) error {
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "token.force_keepalive",
+		Token:  token,
+		Actor:  actor,
+	})
+	return s.repo.KeepAlive(ctx, token, repositories.KeepaliveOptions{Force: true})
+}
+
+// DeleteToken removes a token, named by value or alias, for good. An
+// ifVersion above 0 makes the delete conditional on the token's revision
+// (ErrVersionMismatch otherwise).
+func (s *TokenService) DeleteToken(ctx context.Context, token string, ifVersion int64) error {
+	return s.orAlias(ctx, token, func(token string) error {
+		if err := s.checkPrefix(ctx, token); err != nil {
+			return err
+		}
+		if err := s.checkTransition(ctx, token, TransitionDelete); err != nil {
+			return err
+		}
+		return s.repo.DeleteToken(ctx, token, ifVersion)
+	})
 }
 
 func (s *TokenService) UnblockToken(ctx context.Context, token string) error {
-	return s.repo.UnblockToken(ctx, token)
+	if err := s.checkTransition(ctx, token, TransitionRelease); err != nil {
+		return err
+	}
+	return s.repo.UnblockToken(ctx, token, 0)
+}
+
+// TokenVersion returns a token's revision, which every change to it bumps
+func (s *TokenService) TokenVersion(ctx context.Context, token string) (int64, error) {
+	return s.repo.VersionOf(ctx, token)
+}
+
+// ClientTokens lists the tokens currently assigned to client
+func (s *TokenService) ClientTokens(ctx context.Context, client string) ([]repositories.ClientToken, error) {
+	return s.repo.ClientTokens(ctx, client)
+}
+
+// ReleaseClientTokens returns every token assigned to client to its pool and
+// reports how many were released. Tokens released concurrently are skipped.
+func (s *TokenService) ReleaseClientTokens(ctx context.Context, client string) (int, error) {
+	tokens, err := s.repo.ClientTokens(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, token := range tokens {
+		err := s.repo.UnblockToken(ctx, token.Token, 0)
+		if errors.Is(err, constants.ErrTokenNotAssigned) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// CallbacksEnabled reports whether holders may register callback URLs
+func (s *TokenService) CallbacksEnabled() bool {
+	return s.config.Callbacks != nil
+}
+
+// SetCallback registers the URL a holder is called on before its token is
+// reclaimed; check it with ValidateCallback before assigning
+func (s *TokenService) SetCallback(ctx context.Context, pool, token, url, client string) error {
+	return s.repo.SetCallback(ctx, pool, token, url, client)
+}
+
+// ValidateCallback checks a callback URL before a token is assigned for it
+func (s *TokenService) ValidateCallback(url string) error {
+	if s.config.Callbacks == nil {
+		return errors.New("callbacks are not enabled")
+	}
+	return s.config.Callbacks.Validate(url)
+}
+
+// ReclaimToken releases an assigned token on behalf of client. If another
+// client holds it with a callback, the holder is warned first and the token is
+// released after CallbackGrace; the returned time is then when that happens.
+// A zero time means the token was released right away. An ifVersion above 0
+// makes the release conditional on the token's revision.
+func (s *TokenService) ReclaimToken(ctx context.Context, token, client string, ifVersion int64) (time.Time, error) {
+	if err := s.checkTransition(ctx, token, TransitionRelease); err != nil {
+		return time.Time{}, err
+	}
+	if s.config.Callbacks == nil {
+		return time.Time{}, s.repo.UnblockToken(ctx, token, ifVersion)
+	}
+
+	callback, err := s.repo.CallbackOf(ctx, token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if callback == nil || callback.Client == client {
+		return time.Time{}, s.repo.UnblockToken(ctx, token, ifVersion)
+	}
+	// Warning the holder doesn't change the token, so the version can only be
+	// checked up front here
+	if ifVersion > 0 {
+		version, err := s.repo.VersionOf(ctx, token)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if version != ifVersion {
+			return time.Time{}, constants.ErrVersionMismatch
+		}
+	}
+	if !callback.ReclaimAt.IsZero() {
+		return callback.ReclaimAt, nil
+	}
+
+	releaseAt := s.repo.Now().Add(s.config.CallbackGrace)
+	event := callbacks.Event{Token: token, Pool: callback.Pool, Reason: callbacks.ReasonReclaim, ReleaseAt: releaseAt}
+	if err := s.config.Callbacks.Notify(ctx, callback.URL, event); err != nil {
+		// A holder that can't be reached can't acknowledge either
+		return time.Time{}, s.repo.UnblockToken(ctx, token, ifVersion)
+	}
+	return releaseAt, s.repo.ScheduleReclaim(ctx, callback.Pool, token, releaseAt)
+}
+
+// ForceRelease returns an assigned token to the pool immediately, skipping the
+// callback grace period. The release is recorded in the audit history with the
+// actor and reason code (admin, rotation or incident), and a holder with a
+// callback is told why (best effort).
+func (s *TokenService) ForceRelease(ctx context.Context, token, reason, note, actor string) error {
+	status, err := s.repo.GetTokenStatus(ctx, token)
+	if err != nil {
+		return err
+	}
+	if _, err := ValidateTransition(status.State, TransitionRelease); err != nil {
+		return err
+	}
+	callback, err := s.repo.CallbackOf(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	entry := repositories.AuditEntry{
+		Action: "token.force_release",
+		Token:  token,
+		Pool:   status.Pool,
+		Actor:  actor,
+		Reason: reason,
+	}
+	if note != "" {
+		entry.Detail = map[string]string{"note": note}
+	}
+	if err := s.repo.UnblockToken(repositories.WithEvent(ctx, entry), token, 0); err != nil {
+		return err
+	}
+
+	if callback != nil && s.config.Callbacks != nil {
+		event := callbacks.Event{Token: token, Pool: status.Pool, Reason: callbacks.ReasonReleased, Code: reason, ReleaseAt: s.repo.Now()}
+		_ = s.config.Callbacks.Notify(ctx, callback.URL, event)
+	}
+	return nil
+}
+
+// TransferToken hands an assigned token from one client to another for
+// worker handoff, restarting its keepalive. Both owners go into the audit
+// history. An ifVersion above 0 makes the transfer conditional on the token's revision.
+func (s *TokenService) TransferToken(ctx context.Context, token, from, to string, ifVersion int64) error {
+	if err := s.checkTransition(ctx, token, TransitionTransfer); err != nil {
+		return err
+	}
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "token.transfer",
+		Token:  token,
+		Actor:  from,
+		Detail: map[string]string{"from": from, "to": to},
+	})
+	_, err := s.repo.TransferToken(ctx, token, from, to, ifVersion)
+	return err
+}
+
+// SwapToken releases client's token and assigns it a replacement from the
+// same pool in one atomic step, e.g. when the token starts failing upstream
+// mid-job. The old token is kept when the pool has nothing to replace it with.
+// The replacement is drawn as AssignToken would, but only from the old
+// token's pool and without waiting behind queued callers, since the swap
+// gives a token back as it takes one.
+func (s *TokenService) SwapToken(ctx context.Context, token, client string, selector map[string]string, ifVersion int64) (*repositories.Token, error) {
+	if err := s.checkTransition(ctx, token, TransitionRelease); err != nil {
+		return nil, err
+	}
+	pool, err := s.repo.PoolOfToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "token.swap",
+		Token:  token,
+		Pool:   pool,
+		Actor:  client,
+	})
+	return s.repo.SwapToken(ctx, token, client, ifVersion, repositories.AssignOptions{
+		Selector:       selector,
+		ReservePercent: s.reserveFor(pool, client),
+		Client:         client,
+	})
+}
+
+// RecordSecretRetrieval writes an attempt by actor to read the secret behind
+// a hash-only handle to the audit history; outcome is success, not_found or error
+func (s *TokenService) RecordSecretRetrieval(ctx context.Context, handle, actor, remoteAddr, outcome string) error {
+	return s.repo.AppendAudit(ctx, repositories.AuditEntry{
+		Action: "secret.retrieve",
+		Actor:  actor,
+		Detail: map[string]string{"handle": handle, "outcome": outcome, "remote_addr": remoteAddr},
+	})
+}
+
+// AuditHistory returns recent audit entries, newest first, optionally for one token
+func (s *TokenService) AuditHistory(ctx context.Context, token string, limit int) ([]repositories.AuditEntry, error) {
+	return s.repo.AuditHistory(ctx, token, limit)
+}
+
+// RecordDelivery logs a callback attempt for GET /admin/webhooks/deliveries
+func (s *TokenService) RecordDelivery(ctx context.Context, delivery callbacks.Delivery) error {
+	return s.repo.RecordDelivery(ctx, delivery)
+}
+
+// Deliveries returns recent callback attempts, newest first
+func (s *TokenService) Deliveries(ctx context.Context, limit int) ([]callbacks.Delivery, error) {
+	return s.repo.Deliveries(ctx, limit)
+}
+
+// CompactHistory trims a history stream (repositories.HistoryAudit or
+// HistoryDeliveries) to its retention, returning how many entries it removed
+func (s *TokenService) CompactHistory(ctx context.Context, history string, retention repositories.Retention) (int64, error) {
+	return s.repo.CompactHistory(ctx, history, retention)
+}
+
+// StartAuditConsumer prepares the audit stream to be read by group
+func (s *TokenService) StartAuditConsumer(ctx context.Context, group string) error {
+	return s.repo.CreateAuditGroup(ctx, group)
+}
+
+// NextAuditEntries returns audit entries group has yet to handle, see TokenRepository.ReadAuditGroup
+func (s *TokenService) NextAuditEntries(ctx context.Context, group, consumer string, count int) ([]repositories.AuditEntry, error) {
+	return s.repo.ReadAuditGroup(ctx, group, consumer, count)
+}
+
+// AckAuditEntries marks audit entries as handled by group
+func (s *TokenService) AckAuditEntries(ctx context.Context, group string, ids ...string) error {
+	return s.repo.AckAuditGroup(ctx, group, ids...)
+}
+
+// StartEventStream creates the downstream consumer groups on the event
+// stream at key, see TokenRepository.CreateEventGroups
+func (s *TokenService) StartEventStream(ctx context.Context, key string, groups []string) error {
+	return s.repo.CreateEventGroups(ctx, key, groups)
 }
 
-func (s *TokenService) GetAvailableTokens(ctx context.Context) ([]string, error) {
-	return s.repo.GetAvailableTokens(ctx)
+// PublishEvents appends audit entries to the event stream at key
+func (s *TokenService) PublishEvents(ctx context.Context, key string, maxLen int64, entries []repositories.AuditEntry) error {
+	return s.repo.PublishEvents(ctx, key, maxLen, entries)
 }
 
-func (s *TokenService) GetAssignedTokensWithExpiry(ctx context.Context) (map[string]int64, error) {
-	return s.repo.GetAssignedTokensWithExpiry(ctx)
+func (s *TokenService) CallbacksDue(ctx context.Context, pool string, lead time.Duration) ([]repositories.DueCallback, error) {
+	return s.repo.CallbacksDue(ctx, pool, lead)
 }
 
-func (s *TokenService) CleanupExpiredTokens(ctx context.Context) (map[string]int64, error) {
+func (s *TokenService) DeferRelease(ctx context.Context, pool, token string, grace time.Duration) (time.Time, error) {
+	return s.repo.DeferRelease(ctx, pool, token, grace)
+}
+
+func (s *TokenService) ReclaimsDue(ctx context.Context, pool string) ([]repositories.DueReclaim, error) {
+	return s.repo.ReclaimsDue(ctx, pool)
+}
+
+func (s *TokenService) ForgetReclaim(ctx context.Context, pool, ref string) error {
+	return s.repo.ForgetReclaim(ctx, pool, ref)
+}
+
+// GetTokenStatus reports the state of a token named by value or alias
+func (s *TokenService) GetTokenStatus(ctx context.Context, token string) (*repositories.TokenStatus, error) {
+	var status *repositories.TokenStatus
+	err := s.orAlias(ctx, token, func(token string) (err error) {
+		status, err = s.repo.GetTokenStatus(ctx, token)
+		return err
+	})
+	return status, err
+}
+
+func (s *TokenService) GetAvailableTokens(ctx context.Context, pool string) ([]string, error) {
+	return s.repo.GetAvailableTokens(ctx, pool)
+}
+
+func (s *TokenService) GetAssignedTokensWithExpiry(ctx context.Context, pool string) ([]repositories.AssignedToken, error) {
+	return s.repo.GetAssignedTokensWithExpiry(ctx, pool)
+}
+
+// ScanAvailableTokens streams a pool's available tokens to fn in batches
+func (s *TokenService) ScanAvailableTokens(ctx context.Context, pool string, fn func([]string) error) error {
+	return s.repo.ScanAvailableTokens(ctx, pool, fn)
+}
+
+// ScanAssignedTokens streams a pool's assigned tokens and their remaining time to fn in batches
+func (s *TokenService) ScanAssignedTokens(ctx context.Context, pool string, fn func([]repositories.AssignedToken) error) error {
+	return s.repo.ScanAssignedTokens(ctx, pool, fn)
+}
+
+// MigrateRecords brings a pool's token records up to the current schema version
+func (s *TokenService) MigrateRecords(ctx context.Context, pool string) (int, error) {
+	return s.repo.MigrateRecords(ctx, pool)
+}
+
+func (s *TokenService) CleanupExpiredTokens(ctx context.Context) (repositories.CleanupReport, error) {
 	return s.repo.CleanupExpiredTokens(ctx)
 }
+
+// CleanupPool runs the given cleanup phases against one pool, or every pool
+// when pool is empty. While another run holds one of the pools it fails with
+// ErrCleanupInProgress, or with wait retries until that run finishes or ctx
+// is done.
+func (s *TokenService) CleanupPool(ctx context.Context, pool string, phase repositories.CleanupPhase, wait bool) (repositories.CleanupReport, error) {
+	pools := []string{pool}
+	if pool == "" {
+		var err error
+		if pools, err = s.repo.ListPools(ctx); err != nil {
+			return repositories.CleanupReport{}, err
+		}
+	}
+
+	ticker := time.NewTicker(constants.CleanupLockPoll)
+	defer ticker.Stop()
+	for {
+		report, err := s.repo.CleanupPools(ctx, pools, phase)
+		if !wait || !errors.Is(err, constants.ErrCleanupInProgress) {
+			return report, err
+		}
+		select {
+		case <-ctx.Done():
+			return report, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseExpiredTokens returns lapsed assignments in a pool to the available set
+func (s *TokenService) ReleaseExpiredTokens(ctx context.Context, pool string) (map[string]int64, error) {
+	report, err := s.repo.CleanupPool(ctx, pool, repositories.PhaseRelease)
+	return report.Counts(), err
+}
+
+// DeleteExpiredTokens removes tokens in a pool that have been idle past the
+// deletion threshold, releasing lapsed ones around it per the cleanup order
+func (s *TokenService) DeleteExpiredTokens(ctx context.Context, pool string) (map[string]int64, error) {
+	report, err := s.repo.DeletionPass(ctx, pool)
+	return report.Counts(), err
+}
+
+// CleanupExclusive reports whether the release and deletion sweeps of a pool must be serialised
+func (s *TokenService) CleanupExclusive() bool {
+	return s.repo.CleanupExclusive()
+}
+
+func (s *TokenService) ListPools(ctx context.Context) ([]string, error) {
+	return s.repo.ListPools(ctx)
+}
+
+// MemoryReport estimates the Redis memory taken by each pool's keys, by key
+// family, or by one pool's when pool is set, along with the keys pools share.
+// Pools measured before a failure are returned with the error.
+func (s *TokenService) MemoryReport(ctx context.Context, pool string) ([]repositories.PoolMemory, map[string]int64, error) {
+	pools := []string{pool}
+	if pool == "" {
+		var err error
+		if pools, err = s.repo.ListPools(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reports := make([]repositories.PoolMemory, 0, len(pools))
+	for _, pool := range pools {
+		report, err := s.repo.PoolMemory(ctx, pool)
+		if err != nil {
+			return reports, nil, err
+		}
+		reports = append(reports, report)
+	}
+	shared, err := s.repo.SharedMemory(ctx)
+	return reports, shared, err
+}
+
+// SyncClock refreshes the repository's view of Redis TIME and returns how far
+// it is ahead of this node's clock
+func (s *TokenService) SyncClock(ctx context.Context) (time.Duration, error) {
+	return s.repo.SyncClock(ctx)
+}
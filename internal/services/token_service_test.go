@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestService returns a service over a repository backed by an in-memory Redis
+func newTestService(t *testing.T, config Config) (*TokenService, *repositories.TokenRepository, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo := repositories.NewTokenRepository(client, repositories.Config{})
+	return NewTokenService(repo, config), repo, mr
+}
+
+func TestDirectAssignSkipsAbandonedWaiters(t *testing.T) {
+	s, repo, mr := newTestService(t, Config{QueueEnabled: true})
+	ctx := context.Background()
+	if _, _, err := s.EnqueueWaiter(ctx, constants.DefaultPool, "client-a"); err != nil {
+		t.Fatalf("EnqueueWaiter: %v", err)
+	}
+	if err := repo.SaveToken(ctx, constants.DefaultPool, "tok-1", nil, time.Time{}); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+
+	// The waiter is still polling: it goes first
+	if _, err := s.AssignToken(ctx, constants.DefaultPool, "client-b", nil); !errors.Is(err, constants.ErrNoAvailableTokens) {
+		t.Fatalf("AssignToken behind a live waiter = %v, want ErrNoAvailableTokens", err)
+	}
+
+	// The waiter stops polling and its ticket lapses
+	mr.FastForward(constants.QueueTicketTTL + time.Second)
+	token, err := s.AssignToken(ctx, constants.DefaultPool, "client-b", nil)
+	if err != nil {
+		t.Fatalf("AssignToken behind an abandoned waiter: %v", err)
+	}
+	if token.Value != "tok-1" {
+		t.Errorf("assigned %s, want tok-1", token.Value)
+	}
+	if n, err := repo.QueueLength(ctx, constants.DefaultPool); err != nil || n != 0 {
+		t.Errorf("QueueLength = %d, %v; want the abandoned ticket dropped", n, err)
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// Transition is an event that moves a token between states
+type Transition string
+
+const (
+	TransitionActivate   Transition = "activate"   // pending → available
+	TransitionAssign     Transition = "assign"     // available → assigned
+	TransitionRelease    Transition = "release"    // assigned → available, by the holder or another client
+	TransitionExpire     Transition = "expire"     // assigned → available, by cleanup
+	TransitionTransfer   Transition = "transfer"   // assigned → assigned, to another client
+	TransitionQuarantine Transition = "quarantine" // available → quarantined, after a failed probe
+	TransitionRestore    Transition = "restore"    // quarantined → available, after a passing probe or an operator's approval
+	TransitionDelete     Transition = "delete"     // any → deleted
+)
+
+// transitionRule lists the states a transition may start from, where it ends,
+// and the error callers relied on before transitions were validated
+type transitionRule struct {
+	from   []string
+	to     string
+	legacy error
+}
+
+// transitions is the token state machine. The pool sets stay the source of
+// truth: the repository's scripts and transactions re-check the state as they
+// apply a transition, so a token that changes between validation and write
+// still can't make an illegal move.
+var transitions = map[Transition]transitionRule{
+	TransitionActivate:   {from: []string{repositories.TokenStatePending}, to: repositories.TokenStateAvailable},
+	TransitionAssign:     {from: []string{repositories.TokenStateAvailable}, to: repositories.TokenStateAssigned, legacy: constants.ErrTokenAlreadyInUse},
+	TransitionRelease:    {from: []string{repositories.TokenStateAssigned}, to: repositories.TokenStateAvailable, legacy: constants.ErrTokenNotAssigned},
+	TransitionExpire:     {from: []string{repositories.TokenStateAssigned}, to: repositories.TokenStateAvailable, legacy: constants.ErrTokenNotAssigned},
+	TransitionTransfer:   {from: []string{repositories.TokenStateAssigned}, to: repositories.TokenStateAssigned, legacy: constants.ErrTokenNotAssigned},
+	TransitionQuarantine: {from: []string{repositories.TokenStateAvailable}, to: repositories.TokenStateQuarantined},
+	TransitionRestore:    {from: []string{repositories.TokenStateQuarantined}, to: repositories.TokenStateAvailable},
+	TransitionDelete: {from: []string{
+		repositories.TokenStatePending,
+		repositories.TokenStateAvailable,
+		repositories.TokenStateAssigned,
+		repositories.TokenStateQuarantined,
+	}, to: repositories.TokenStateDeleted},
+}
+
+// TransitionError rejects a transition the token's current state doesn't
+// allow. It matches constants.ErrInvalidTransition, and the error the
+// operation returned before transitions were validated, e.g.
+// constants.ErrTokenNotAssigned for releasing a token nobody holds.
+type TransitionError struct {
+	Transition Transition
+	From       string
+	legacy     error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s a token that is %s", e.Transition, e.From)
+}
+
+func (e *TransitionError) Unwrap() []error {
+	if e.legacy == nil {
+		return []error{constants.ErrInvalidTransition}
+	}
+	return []error{constants.ErrInvalidTransition, e.legacy}
+}
+
+// ValidateTransition checks that a token in state from may make transition t
+// and returns the state it ends in
+func ValidateTransition(from string, t Transition) (string, error) {
+	rule, ok := transitions[t]
+	if !ok {
+		return "", fmt.Errorf("unknown transition %q: %w", t, constants.ErrInvalidTransition)
+	}
+	if !slices.Contains(rule.from, from) {
+		return "", &TransitionError{Transition: t, From: from, legacy: rule.legacy}
+	}
+	return rule.to, nil
+}
+
+// checkTransition reads a token's state and validates t against it.
+// ErrTokenNotFound is returned as is for tokens that don't exist.
+func (s *TokenService) checkTransition(ctx context.Context, token string, t Transition) error {
+	state, err := s.repo.StateOf(ctx, token)
+	if err != nil {
+		return err
+	}
+	_, err = ValidateTransition(state, t)
+	return err
+}
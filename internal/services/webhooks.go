@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/repositories"
+)
+
+// eventPattern matches an audit action such as token.transfer, a prefix
+// wildcard such as token.*, or * for every event
+var eventPattern = regexp.MustCompile(`^(\*|[a-z_]+(\.[a-z_]+)*(\.\*)?)$`)
+
+// minWebhookSecret keeps signing secrets long enough to resist guessing
+const minWebhookSecret = 16
+
+// WebhookChange sets the fields of a webhook that are not nil
+type WebhookChange struct {
+	URL     *string
+	Secret  *string
+	Events  *[]string
+	Enabled *bool
+}
+
+// CreateWebhook subscribes a URL to audit events. URL and Secret are
+// required; the webhook is enabled unless Enabled says otherwise. The
+// subscription is recorded in the audit history under actor.
+func (s *TokenService) CreateWebhook(ctx context.Context, change WebhookChange, actor string) (repositories.Webhook, error) {
+	if change.URL == nil || change.Secret == nil {
+		return repositories.Webhook{}, fmt.Errorf("%w: url and secret are required", constants.ErrInvalidWebhook)
+	}
+	now := time.Now().UTC()
+	webhook := repositories.Webhook{ID: uuid.New().String(), Enabled: true, CreatedAt: now}
+	return webhook, s.saveWebhook(ctx, &webhook, change, now, "webhook.create", actor)
+}
+
+// UpdateWebhook changes the fields of a webhook set in change, recording it in
+// the audit history under actor
+func (s *TokenService) UpdateWebhook(ctx context.Context, id string, change WebhookChange, actor string) (repositories.Webhook, error) {
+	webhook, err := s.repo.Webhook(ctx, id)
+	if err != nil {
+		return webhook, err
+	}
+	return webhook, s.saveWebhook(ctx, &webhook, change, time.Now().UTC(), "webhook.update", actor)
+}
+
+func (s *TokenService) saveWebhook(ctx context.Context, webhook *repositories.Webhook, change WebhookChange, now time.Time, action, actor string) error {
+	if change.URL != nil {
+		if err := s.config.Webhooks.Validate(*change.URL); err != nil {
+			return fmt.Errorf("%w: %v", constants.ErrInvalidWebhook, err)
+		}
+		webhook.URL = *change.URL
+	}
+	if change.Secret != nil {
+		if len(*change.Secret) < minWebhookSecret {
+			return fmt.Errorf("%w: secret must be at least %d characters", constants.ErrInvalidWebhook, minWebhookSecret)
+		}
+		webhook.Secret = *change.Secret
+	}
+	if change.Events != nil {
+		for _, event := range *change.Events {
+			if !eventPattern.MatchString(event) {
+				return fmt.Errorf("%w: invalid event filter %q", constants.ErrInvalidWebhook, event)
+			}
+		}
+		webhook.Events = *change.Events
+	}
+	if change.Enabled != nil {
+		webhook.Enabled = *change.Enabled
+	}
+	webhook.UpdatedAt = now
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: action,
+		Actor:  actor,
+		Detail: map[string]string{"webhook": webhook.ID, "enabled": strconv.FormatBool(webhook.Enabled)},
+	})
+	return s.repo.SaveWebhook(ctx, *webhook)
+}
+
+// Webhook returns one webhook
+func (s *TokenService) Webhook(ctx context.Context, id string) (repositories.Webhook, error) {
+	return s.repo.Webhook(ctx, id)
+}
+
+// Webhooks returns every webhook, oldest first
+func (s *TokenService) Webhooks(ctx context.Context) ([]repositories.Webhook, error) {
+	return s.repo.Webhooks(ctx)
+}
+
+// BindWebhookSecrets reseals webhook secrets stored before they were bound to
+// their webhook and returns how many it rebound
+func (s *TokenService) BindWebhookSecrets(ctx context.Context) (int, error) {
+	return s.repo.BindWebhookSecrets(ctx)
+}
+
+// DeleteWebhook removes a webhook, recording it in the audit history under actor
+func (s *TokenService) DeleteWebhook(ctx context.Context, id, actor string) error {
+	ctx = repositories.WithEvent(ctx, repositories.AuditEntry{
+		Action: "webhook.delete",
+		Actor:  actor,
+		Detail: map[string]string{"webhook": id},
+	})
+	return s.repo.DeleteWebhook(ctx, id)
+}
@@ -0,0 +1,55 @@
+// Package tracing carries the IDs of the request that caused some work, so
+// events it emits can be tied back to the originating trace
+package tracing
+
+import (
+	"context"
+	"strings"
+)
+
+// Headers the IDs are read from
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceparent = "traceparent" // W3C Trace Context
+)
+
+// IDs identify the request behind some work. Either may be empty.
+type IDs struct {
+	TraceID   string
+	RequestID string
+}
+
+type idsKey struct{}
+
+// WithIDs returns a context carrying ids
+func WithIDs(ctx context.Context, ids IDs) context.Context {
+	return context.WithValue(ctx, idsKey{}, ids)
+}
+
+// FromContext returns the IDs ctx carries, or zero IDs
+func FromContext(ctx context.Context) IDs {
+	ids, _ := ctx.Value(idsKey{}).(IDs)
+	return ids
+}
+
+// TraceID extracts the trace ID from a traceparent header
+// (version-traceid-parentid-flags), or returns "" if it is malformed
+func TraceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || !isHex(parts[1]) {
+		return ""
+	}
+	if strings.Trim(parts[1], "0") == "" { // all zeroes is invalid
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/auditsink"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var (
+	auditExported = metrics.NewCounterVec(
+		"audit_export_entries_total",
+		"Audit entries delivered to the export sink.",
+	)
+	auditExportFailures = metrics.NewCounterVec(
+		"audit_export_failures_total",
+		"Failed deliveries of an audit batch to the export sink.",
+	)
+)
+
+// AuditExporter ships the audit stream to an external sink with at-least-once
+// delivery. Entries are acknowledged only after the sink accepts them; while
+// the sink is down they stay buffered in the stream and the batch is retried
+// with backoff. Replicas share the work through a consumer group, and entries
+// a crashed replica left unacknowledged are taken over by another.
+type AuditExporter struct {
+	service   *services.TokenService
+	sink      auditsink.Sink
+	consumer  string
+	batchSize int
+	logger    *slog.Logger
+}
+
+func NewAuditExporter(service *services.TokenService, sink auditsink.Sink, consumer string, batchSize int, logger *slog.Logger) *AuditExporter {
+	if batchSize <= 0 {
+		batchSize = constants.DefaultAuditExportBatch
+	}
+	return &AuditExporter{service: service, sink: sink, consumer: consumer, batchSize: batchSize, logger: logger}
+}
+
+// Run exports entries until ctx is cancelled, then closes the sink
+func (e *AuditExporter) Run(ctx context.Context) {
+	defer e.sink.Close()
+	for {
+		err := e.service.StartAuditConsumer(ctx, constants.AuditExportGroup)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.logger.Error("Failed to start audit export", slog.String("error", err.Error()))
+		sleep(ctx, constants.AuditExportBlock)
+	}
+
+	for ctx.Err() == nil {
+		entries, err := e.service.NextAuditEntries(ctx, constants.AuditExportGroup, e.consumer, e.batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				e.logger.Error("Failed to read audit entries for export", slog.String("error", err.Error()))
+				sleep(ctx, constants.AuditExportBlock)
+			}
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		e.deliver(ctx, entries)
+	}
+}
+
+// deliver writes a batch to the sink until it is accepted or ctx ends, then
+// acknowledges it
+func (e *AuditExporter) deliver(ctx context.Context, entries []repositories.AuditEntry) {
+	backoff := time.Second
+	for {
+		err := e.sink.Write(ctx, entries)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return // left pending, delivered after restart
+		}
+		auditExportFailures.Inc()
+		e.logger.Warn("Audit sink unavailable, retrying",
+			slog.Int("entries", len(entries)), slog.Duration("backoff", backoff), slog.String("error", err.Error()))
+		sleep(ctx, backoff)
+		backoff = min(2*backoff, constants.AuditExportMaxBackoff)
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	if err := e.service.AckAuditEntries(ctx, constants.AuditExportGroup, ids...); err != nil {
+		// The batch is delivered again once reclaimed, which at-least-once allows
+		e.logger.Error("Failed to acknowledge exported audit entries", slog.String("error", err.Error()))
+		return
+	}
+	auditExported.Add(float64(len(entries)))
+}
+
+// sleep waits for d unless ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,110 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/backup"
+	"github.com/manankarani/token-manager/internal/encryption"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	backupRuns = metrics.NewCounterVec(
+		"backup_runs_total",
+		"Scheduled snapshots by outcome.",
+		"outcome",
+	)
+	backupLastSuccess = metrics.NewGaugeVec(
+		"backup_last_success_timestamp_seconds",
+		"Unix time of the last snapshot uploaded.",
+	)
+)
+
+// BackupConfig describes where and how often snapshots are taken
+type BackupConfig struct {
+	Schedule  Schedule
+	Prefix    string        // object key prefix in the bucket
+	Retention time.Duration // snapshots older than this are pruned; 0 keeps all
+}
+
+// BackupWorker snapshots all Redis state on a schedule, compresses and
+// encrypts it, and uploads it to object storage. One replica takes each
+// scheduled snapshot.
+type BackupWorker struct {
+	client *redis.Client
+	store  backup.Store
+	cipher *encryption.Cipher
+	config BackupConfig
+	logger *slog.Logger
+}
+
+func NewBackupWorker(client *redis.Client, store backup.Store, cipher *encryption.Cipher, config BackupConfig, logger *slog.Logger) *BackupWorker {
+	return &BackupWorker{client: client, store: store, cipher: cipher, config: config, logger: logger}
+}
+
+// Run takes a snapshot at each scheduled time until ctx is cancelled
+func (w *BackupWorker) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(w.config.Schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		locked, err := w.client.SetNX(ctx, constants.KeyBackupLock, 1, constants.BackupLockTTL).Result()
+		if err != nil {
+			backupRuns.Inc("error")
+			w.logger.Error("Failed to take backup lock", slog.String("error", err.Error()))
+			continue
+		}
+		if !locked {
+			continue
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, constants.BackupTimeout)
+		key, keys, err := w.Backup(runCtx)
+		cancel()
+		if err != nil {
+			backupRuns.Inc("error")
+			w.logger.Error("Backup failed", slog.String("error", err.Error()))
+			continue
+		}
+		backupRuns.Inc("success")
+		backupLastSuccess.Set(float64(time.Now().Unix()))
+		w.logger.Info("Backed up pool state", slog.String("object", key), slog.Int("keys", keys))
+
+		if w.config.Retention > 0 {
+			pruned, err := backup.Prune(ctx, w.store, w.config.Prefix, time.Now().Add(-w.config.Retention))
+			if err != nil {
+				w.logger.Error("Failed to prune old backups", slog.String("error", err.Error()))
+			} else if pruned > 0 {
+				w.logger.Info("Pruned old backups", slog.Int("deleted", pruned))
+			}
+		}
+	}
+}
+
+// Backup takes one snapshot and uploads it, returning its object key and the
+// number of keys it holds
+func (w *BackupWorker) Backup(ctx context.Context) (string, int, error) {
+	snap, err := backup.Export(ctx, w.client, constants.BackupBatchSize)
+	if err != nil {
+		return "", 0, err
+	}
+	data, err := backup.Encode(snap, w.cipher)
+	if err != nil {
+		return "", 0, err
+	}
+	key := backup.ObjectKey(w.config.Prefix, snap.CreatedAt)
+	if err := w.store.Put(ctx, key, data); err != nil {
+		return "", 0, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	return key, len(snap.Keys), nil
+}
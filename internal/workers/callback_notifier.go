@@ -0,0 +1,73 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/callbacks"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+// CallbackNotifier warns holders with a callback URL before cleanup reclaims
+// their token, and releases tokens whose requested reclaim grace has run out
+type CallbackNotifier struct {
+	service  *services.TokenService
+	notifier *callbacks.Notifier
+	lead     time.Duration // how far ahead of release holders are warned
+	grace    time.Duration // how long a warned holder has to keep alive or release
+	logger   *slog.Logger
+}
+
+func NewCallbackNotifier(service *services.TokenService, notifier *callbacks.Notifier, lead, grace time.Duration, logger *slog.Logger) *CallbackNotifier {
+	return &CallbackNotifier{service: service, notifier: notifier, lead: lead, grace: grace, logger: logger}
+}
+
+// Sweep returns the callback sweep; it should run more often than lead so no
+// expiring token slips past unwarned
+func (n *CallbackNotifier) Sweep(schedule Schedule) Sweep {
+	return Sweep{Name: "callbacks", Run: n.Run, DefaultSchedule: schedule}
+}
+
+// Run warns the holders of tokens about to expire, deferring their release by
+// the grace period, then releases tokens whose reclaim is due
+func (n *CallbackNotifier) Run(ctx context.Context, pool string) (map[string]int64, error) {
+	res := map[string]int64{"notified": 0, "reclaimed": 0}
+
+	due, err := n.service.CallbacksDue(ctx, pool, n.lead)
+	if err != nil {
+		return res, err
+	}
+	for _, d := range due {
+		event := callbacks.Event{Token: d.Token, Pool: pool, Reason: callbacks.ReasonExpiring, ReleaseAt: time.Now().Add(n.grace)}
+		if err := n.notifier.Notify(ctx, d.Callback.URL, event); err != nil {
+			// Leave the release as scheduled; the holder can't acknowledge anyway
+			n.logger.Warn("Holder callback failed", slog.String("pool", pool), slog.String("error", err.Error()))
+			continue
+		}
+		if _, err := n.service.DeferRelease(ctx, pool, d.Token, n.grace); err != nil {
+			return res, err
+		}
+		res["notified"]++
+	}
+
+	reclaims, err := n.service.ReclaimsDue(ctx, pool)
+	if err != nil {
+		return res, err
+	}
+	for _, reclaim := range reclaims {
+		err := n.service.UnblockToken(ctx, reclaim.Token)
+		if err != nil && !errors.Is(err, constants.ErrTokenNotAssigned) {
+			return res, err
+		}
+		if err == nil {
+			res["reclaimed"]++
+		}
+		if err := n.service.ForgetReclaim(ctx, pool, reclaim.Ref); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
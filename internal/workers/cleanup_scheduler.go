@@ -0,0 +1,263 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/metrics"
+)
+
+var (
+	sweepRuns = metrics.NewCounterVec(
+		"cleanup_sweep_runs_total",
+		"Cleanup sweep runs by sweep and outcome.",
+		"sweep", "outcome",
+	)
+	sweepDuration = metrics.NewHistogramVec(
+		"cleanup_sweep_duration_seconds",
+		"Duration of a single pool cleanup sweep.",
+		metrics.DefaultLatencyBuckets,
+		"sweep",
+	)
+	sweepTokens = metrics.NewCounterVec(
+		"cleanup_sweep_tokens_total",
+		"Tokens released or deleted by cleanup sweeps.",
+		"sweep", "action",
+	)
+)
+
+// Sweep is one kind of cleanup work, run for every pool on its own schedule
+type Sweep struct {
+	Name            string
+	Run             func(ctx context.Context, pool string) (map[string]int64, error)
+	DefaultSchedule Schedule
+	PoolSchedules   map[string]Schedule // overrides DefaultSchedule per pool
+
+	// Sweeps sharing a non-empty Exclusive group never run at the same time
+	// for the same pool. One that comes due while another of its group is
+	// queued or running is skipped until its next slot.
+	Exclusive string
+
+	// Pausable sweeps are skipped while CleanupScheduleConfig.Paused reports
+	// cleanup paused
+	Pausable bool
+}
+
+// CleanupScheduler enqueues each sweep for each pool on its own schedule; the
+// sweeps themselves run as jobs on the shared queue. Interval schedules start
+// at a random offset within their period and every run is jittered, so pools
+// sharing a schedule don't all hit Redis in the same instant.
+type CleanupScheduler struct {
+	sweeps    []Sweep
+	listPools func(ctx context.Context) ([]string, error)
+	queue     *jobs.Queue
+	config    CleanupScheduleConfig
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// CleanupScheduleConfig holds the timing knobs shared by all sweeps
+type CleanupScheduleConfig struct {
+	MaxJitter         time.Duration // each run is shifted by up to ±MaxJitter
+	DiscoveryInterval time.Duration // how often newly created pools are picked up
+
+	// Paused reports whether pausable sweeps are paused, checked as each
+	// sweep job starts so jobs queued before a pause are held too; nil never pauses
+	Paused func(ctx context.Context) (bool, error)
+}
+
+// NewCleanupScheduler creates a scheduler and registers its sweeps as jobs on
+// the queue; call Run to start it
+func NewCleanupScheduler(
+	sweeps []Sweep,
+	listPools func(context.Context) ([]string, error),
+	queue *jobs.Queue,
+	config CleanupScheduleConfig,
+	logger *slog.Logger,
+) *CleanupScheduler {
+	s := &CleanupScheduler{
+		sweeps:    sweeps,
+		listPools: listPools,
+		queue:     queue,
+		config:    config,
+		logger:    logger,
+		running:   make(map[string]bool),
+	}
+	for _, sweep := range sweeps {
+		queue.Register(sweep.jobName(), s.sweepJob(sweep))
+	}
+	return s
+}
+
+func (sw Sweep) jobName() string {
+	return "cleanup." + sw.Name
+}
+
+// uniqueKey is what a queued or running sweep for pool holds, so duplicates are skipped
+func (sw Sweep) uniqueKey(pool string) string {
+	if sw.Exclusive != "" {
+		return "cleanup.group." + sw.Exclusive + ":" + pool
+	}
+	return sw.jobName() + ":" + pool
+}
+
+// Run discovers pools and schedules their sweeps until ctx is cancelled
+func (s *CleanupScheduler) Run(ctx context.Context) {
+	s.logger.Info("Cleanup scheduler started")
+
+	ticker := time.NewTicker(s.config.DiscoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		s.discover(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.logger.Info("Cleanup scheduler stopping...")
+			s.wg.Wait()
+			return
+		}
+	}
+}
+
+// discover starts a loop for any sweep and pool pair that doesn't have one yet
+func (s *CleanupScheduler) discover(ctx context.Context) {
+	pools, err := s.listPools(ctx)
+	if err != nil {
+		s.logger.Error("Error listing pools for cleanup", slog.String("error", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pool := range pools {
+		for _, sweep := range s.sweeps {
+			key := sweep.Name + "/" + pool
+			if s.running[key] {
+				continue
+			}
+			s.running[key] = true
+			s.wg.Add(1)
+			go s.runSweep(ctx, sweep, pool)
+		}
+	}
+}
+
+func (sw Sweep) scheduleFor(pool string) Schedule {
+	if schedule, ok := sw.PoolSchedules[pool]; ok {
+		return schedule
+	}
+	return sw.DefaultSchedule
+}
+
+// runSweep runs one sweep against a single pool whenever its schedule comes due
+func (s *CleanupScheduler) runSweep(ctx context.Context, sweep Sweep, pool string) {
+	defer s.wg.Done()
+
+	schedule := sweep.scheduleFor(pool)
+	next := schedule.Next(time.Now())
+	if interval, ok := schedule.(IntervalSchedule); ok {
+		// Spread interval pools over their period instead of starting them together
+		next = time.Now().Add(time.Duration(rand.Int64N(int64(interval))))
+	}
+	s.logger.Debug("Scheduling pool cleanup",
+		slog.String("sweep", sweep.Name),
+		slog.String("pool", pool),
+		slog.Time("first_run", next))
+
+	timer := time.NewTimer(s.jitter(time.Until(next)))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			s.enqueue(ctx, sweep, pool)
+
+			// Plan from the previous slot so jitter and run time don't accumulate drift
+			next = schedule.Next(next)
+			if now := time.Now(); next.Before(now) {
+				next = schedule.Next(now)
+			}
+			timer.Reset(s.jitter(time.Until(next)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue hands a due sweep to the job queue. A sweep for the same pool that
+// is still queued or running, possibly on another replica, is not duplicated.
+func (s *CleanupScheduler) enqueue(ctx context.Context, sweep Sweep, pool string) {
+	_, err := s.queue.EnqueueUnique(ctx, sweep.jobName(), sweep.uniqueKey(pool), map[string]string{"pool": pool})
+	if errors.Is(err, constants.ErrDuplicateJob) {
+		sweepRuns.Inc(sweep.Name, "skipped")
+		return
+	}
+	if err != nil {
+		s.logger.Error("Error enqueuing cleanup sweep",
+			slog.String("sweep", sweep.Name),
+			slog.String("pool", pool),
+			slog.String("error", err.Error()))
+	}
+}
+
+// sweepJob runs a sweep for the pool named in the job payload
+func (s *CleanupScheduler) sweepJob(sweep Sweep) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) error {
+		pool := job.Payload["pool"]
+		if sweep.Pausable && s.config.Paused != nil {
+			paused, err := s.config.Paused(ctx)
+			if err != nil {
+				sweepRuns.Inc(sweep.Name, "error")
+				return err
+			}
+			if paused {
+				sweepRuns.Inc(sweep.Name, "paused")
+				return nil
+			}
+		}
+
+		start := time.Now()
+		res, err := sweep.Run(datasources.WithPool(ctx, pool), pool)
+		sweepDuration.Observe(time.Since(start).Seconds(), sweep.Name)
+
+		// A manual run holds the pool; this slot is skipped like a duplicate
+		if errors.Is(err, constants.ErrCleanupInProgress) {
+			sweepRuns.Inc(sweep.Name, "skipped")
+			return nil
+		}
+		if err != nil {
+			sweepRuns.Inc(sweep.Name, "error")
+			s.logger.Error("Error cleaning expired tokens",
+				slog.String("sweep", sweep.Name),
+				slog.String("pool", pool),
+				slog.String("error", err.Error()))
+			return err
+		}
+
+		sweepRuns.Inc(sweep.Name, "success")
+		sweepTokens.Add(float64(res["released"]), sweep.Name, "released")
+		sweepTokens.Add(float64(res["deleted"]), sweep.Name, "deleted")
+		return nil
+	}
+}
+
+// jitter shifts a wait by a random amount within ±MaxJitter
+func (s *CleanupScheduler) jitter(wait time.Duration) time.Duration {
+	if s.config.MaxJitter <= 0 {
+		return max(wait, 0)
+	}
+	shift := time.Duration(rand.Int64N(int64(2*s.config.MaxJitter))) - s.config.MaxJitter
+	return max(wait+shift, 0)
+}
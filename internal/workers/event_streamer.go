@@ -0,0 +1,93 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var eventsPublished = metrics.NewCounterVec(
+	"event_stream_entries_total",
+	"Lifecycle events published to the event stream.",
+)
+
+// EventStreamConfig says where lifecycle events are published
+type EventStreamConfig struct {
+	Key    string   // stream downstream services read; "events:stream" when empty
+	MaxLen int64    // approximate cap on the stream
+	Groups []string // consumer groups created for downstream services
+}
+
+// EventStreamer republishes the audit stream's lifecycle events to a Redis
+// stream of their own, where each downstream service reads through its own
+// consumer group at its own pace: XREADGROUP, XACK once handled, and
+// XAUTOCLAIM to take over what a crashed consumer left pending. Unlike pub/sub
+// nothing is lost while a consumer is away, as long as it catches up before
+// MaxLen trims its backlog. Delivery is at least once; consumers dedupe by
+// the "id" field. Replicas share the publishing through a consumer group.
+type EventStreamer struct {
+	service  *services.TokenService
+	config   EventStreamConfig
+	consumer string
+	logger   *slog.Logger
+}
+
+func NewEventStreamer(service *services.TokenService, config EventStreamConfig, consumer string, logger *slog.Logger) *EventStreamer {
+	if config.Key == "" {
+		config.Key = constants.DefaultEventStreamKey
+	}
+	if config.MaxLen <= 0 {
+		config.MaxLen = constants.DefaultEventStreamMaxLen
+	}
+	return &EventStreamer{service: service, config: config, consumer: consumer, logger: logger}
+}
+
+// Run publishes events until ctx is cancelled
+func (e *EventStreamer) Run(ctx context.Context) {
+	for {
+		err := e.service.StartEventStream(ctx, e.config.Key, e.config.Groups)
+		if err == nil {
+			err = e.service.StartAuditConsumer(ctx, constants.EventStreamGroup)
+		}
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.logger.Error("Failed to start event stream", slog.String("error", err.Error()))
+		sleep(ctx, constants.AuditExportBlock)
+	}
+
+	for ctx.Err() == nil {
+		entries, err := e.service.NextAuditEntries(ctx, constants.EventStreamGroup, e.consumer, constants.DefaultAuditExportBatch)
+		if err == nil && len(entries) > 0 {
+			err = e.publish(ctx, entries)
+		}
+		if err != nil && ctx.Err() == nil {
+			e.logger.Error("Event stream publishing failed", slog.String("error", err.Error()))
+			sleep(ctx, constants.AuditExportBlock)
+		}
+	}
+}
+
+// publish appends a batch to the event stream, then acknowledges it. A batch
+// that fails is left pending and published again once reclaimed.
+func (e *EventStreamer) publish(ctx context.Context, entries []repositories.AuditEntry) error {
+	if err := e.service.PublishEvents(ctx, e.config.Key, e.config.MaxLen, entries); err != nil {
+		return err
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	if err := e.service.AckAuditEntries(ctx, constants.EventStreamGroup, ids...); err != nil {
+		return err
+	}
+	eventsPublished.Add(float64(len(entries)))
+	return nil
+}
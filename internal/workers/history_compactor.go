@@ -0,0 +1,70 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var historyTrimmed = metrics.NewCounterVec(
+	"history_entries_trimmed_total",
+	"History entries removed by compaction, by stream.",
+	"history",
+)
+
+// HistoryConfig sets how long each history stream is kept
+type HistoryConfig struct {
+	Schedule  Schedule
+	Retention map[string]repositories.Retention // by history name, see repositories.HistoryAudit
+}
+
+// HistoryCompactor trims the audit and delivery history streams to their
+// retention on a schedule. Trimming is idempotent, so every replica runs it
+// without coordinating.
+type HistoryCompactor struct {
+	service *services.TokenService
+	config  HistoryConfig
+	logger  *slog.Logger
+}
+
+func NewHistoryCompactor(service *services.TokenService, config HistoryConfig, logger *slog.Logger) *HistoryCompactor {
+	return &HistoryCompactor{service: service, config: config, logger: logger}
+}
+
+// Run compacts at each scheduled time until ctx is cancelled
+func (c *HistoryCompactor) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(c.config.Schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, constants.HistoryCompactionTimeout)
+		c.Compact(runCtx)
+		cancel()
+	}
+}
+
+// Compact trims every history stream once. A stream that fails is logged and
+// left for the next run.
+func (c *HistoryCompactor) Compact(ctx context.Context) {
+	for history, retention := range c.config.Retention {
+		removed, err := c.service.CompactHistory(ctx, history, retention)
+		historyTrimmed.Add(float64(removed), history)
+		if err != nil {
+			c.logger.Error("History compaction failed", slog.String("history", history), slog.String("error", err.Error()))
+			continue
+		}
+		if removed > 0 {
+			c.logger.Info("Compacted history", slog.String("history", history), slog.Int64("removed", removed))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var importRows = metrics.NewCounterVec(
+	"import_rows_total",
+	"Rows read by bulk CSV imports, by outcome.",
+	"outcome",
+)
+
+// ImportJob runs the bulk CSV imports queued by POST /tokens/import. Each
+// chunk's counts are recorded as the job's progress, and a cancelled job
+// stops before its next chunk.
+func ImportJob(service *services.TokenService, queue *jobs.Queue, logger *slog.Logger) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) error {
+		id, pool := job.Payload["import"], job.Payload["pool"]
+		summary, err := service.RunImport(datasources.WithPool(ctx, pool), id, func(progress repositories.ImportSummary) error {
+			if queue.Cancelled(ctx, job.ID) {
+				return constants.ErrJobCancelled
+			}
+			if err := queue.SetProgress(ctx, job.ID, progress); err != nil {
+				logger.Warn("Failed to record import progress", slog.String("import", id), slog.String("error", err.Error()))
+			}
+			return nil
+		})
+		if summary != nil {
+			if err := queue.SetProgress(context.WithoutCancel(ctx), job.ID, summary); err != nil {
+				logger.Warn("Failed to record import progress", slog.String("import", id), slog.String("error", err.Error()))
+			}
+			importRows.Add(float64(summary.Imported), "imported")
+			importRows.Add(float64(summary.Failed), "failed")
+			logger.Info("Import finished",
+				slog.String("import", id),
+				slog.String("pool", pool),
+				slog.Int("rows", summary.Rows),
+				slog.Int("imported", summary.Imported),
+				slog.Int("failed", summary.Failed))
+		}
+		if err != nil && !errors.Is(err, constants.ErrJobCancelled) {
+			// The spooled file is gone, and a rerun would only find every saved row already there
+			return fmt.Errorf("%w: %w", constants.ErrJobPermanent, err)
+		}
+		return err
+	}
+}
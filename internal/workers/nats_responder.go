@@ -0,0 +1,209 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+	"github.com/nats-io/nats.go"
+)
+
+var natsRequests = metrics.NewCounterVec(
+	"nats_requests_total",
+	"Requests served over NATS, by operation and status.",
+	"op", "status",
+)
+
+// NATSConfig sets the subjects the responder answers on
+type NATSConfig struct {
+	Conn            datasources.NATSConfig
+	Subject         string // prefix: requests arrive on <Subject>.assign, .release and .keepalive; "tokens" when empty
+	Queue           string // queue group shared by replicas, so each request is answered once; "token-manager" when empty
+	Workers         int    // requests handled at once
+	EmptyPoolStatus int    // status an assign on an empty pool answers with, as Server.EmptyPoolStatusCode
+}
+
+// NATSResponder serves assign, release and keepalive as NATS request-reply
+// for message-driven workers. Each operation behaves as its HTTP
+// counterpart: the same errors, answered with the HTTP status they would
+// get, and the same lease, which the holder keeps with keepalive requests.
+type NATSResponder struct {
+	service *services.TokenService
+	config  NATSConfig
+	logger  *slog.Logger
+}
+
+func NewNATSResponder(service *services.TokenService, config NATSConfig, logger *slog.Logger) *NATSResponder {
+	if config.Subject == "" {
+		config.Subject = constants.DefaultNATSSubject
+	}
+	if config.Queue == "" {
+		config.Queue = constants.DefaultNATSQueue
+	}
+	if config.Workers <= 0 {
+		config.Workers = constants.DefaultNATSWorkers
+	}
+	if config.EmptyPoolStatus == 0 {
+		config.EmptyPoolStatus = http.StatusServiceUnavailable
+	}
+	return &NATSResponder{service: service, config: config, logger: logger}
+}
+
+// NATSAssignRequest asks for a token from Pool, the default pool when empty,
+// for Client, anonymous when empty. Selector narrows it as on HTTP assign.
+type NATSAssignRequest struct {
+	Pool     string            `json:"pool"`
+	Client   string            `json:"client"`
+	Selector map[string]string `json:"selector"`
+}
+
+// NATSTokenRequest names the token a release or keepalive acts on
+type NATSTokenRequest struct {
+	Token  string `json:"token"`
+	Client string `json:"client"` // release: the caller, for the holder's callback
+}
+
+// NATSReply answers every request. Status is what the HTTP API answers the
+// same call with.
+type NATSReply struct {
+	Status    int                 `json:"status"`
+	Error     string              `json:"error,omitempty"`
+	Token     *repositories.Token `json:"token,omitempty"`
+	ReleaseAt *time.Time          `json:"release_at,omitempty"` // release: when a warned holder's token goes
+}
+
+// Run answers requests until ctx is cancelled. The connection reconnects by
+// itself, so requests sent while it is down go to other replicas or time out
+// at the caller.
+func (r *NATSResponder) Run(ctx context.Context) {
+	conn, err := datasources.ConnectNATS(r.config.Conn)
+	if err != nil {
+		r.logger.Error("NATS responder disabled", slog.String("error", err.Error()))
+		return
+	}
+	defer conn.Close()
+
+	msgs := make(chan *nats.Msg, constants.NATSPendingRequests)
+	for _, op := range []string{"assign", "release", "keepalive"} {
+		if _, err := conn.ChanQueueSubscribe(r.config.Subject+"."+op, r.config.Queue, msgs); err != nil {
+			r.logger.Error("NATS responder disabled", slog.String("op", op), slog.String("error", err.Error()))
+			return
+		}
+	}
+	r.logger.Info("Serving token requests over NATS", slog.String("subject", r.config.Subject+".>"))
+
+	// Answers still being worked on go out before the connection closes
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, r.config.Workers)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-msgs:
+			if msg.Reply == "" {
+				continue // not a request, nobody to answer
+			}
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-slots; wg.Done() }()
+				op := strings.TrimPrefix(msg.Subject, r.config.Subject+".")
+				reply := r.handle(context.WithoutCancel(ctx), op, msg.Data)
+				natsRequests.Inc(op, strconv.Itoa(reply.Status))
+				data, err := json.Marshal(reply)
+				if err == nil {
+					err = msg.Respond(data)
+				}
+				if err != nil {
+					r.logger.Warn("Failed to answer NATS request", slog.String("op", op), slog.String("error", err.Error()))
+				}
+			}()
+		}
+	}
+}
+
+func (r *NATSResponder) handle(ctx context.Context, op string, data []byte) NATSReply {
+	switch op {
+	case "assign":
+		var req NATSAssignRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return NATSReply{Status: http.StatusBadRequest, Error: "Invalid request"}
+		}
+		return r.assign(ctx, req)
+	case "release", "keepalive":
+		var req NATSTokenRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.Token == "" {
+			return NATSReply{Status: http.StatusBadRequest, Error: "Invalid request"}
+		}
+		if op == "release" {
+			return r.release(ctx, req)
+		}
+		return r.keepalive(ctx, req)
+	default:
+		return NATSReply{Status: http.StatusNotFound, Error: "unknown operation " + op}
+	}
+}
+
+func (r *NATSResponder) assign(ctx context.Context, req NATSAssignRequest) NATSReply {
+	if req.Pool == "" {
+		req.Pool = constants.DefaultPool
+	}
+	if req.Client == "" {
+		req.Client = constants.AnonymousClientID
+	}
+	token, err := r.service.AssignToken(ctx, req.Pool, req.Client, req.Selector)
+	switch {
+	case errors.Is(err, constants.ErrAssignmentCapReached):
+		return NATSReply{Status: http.StatusTooManyRequests, Error: err.Error()}
+	case errors.Is(err, constants.ErrNoAvailableTokens):
+		return NATSReply{Status: r.config.EmptyPoolStatus, Error: err.Error()}
+	case err != nil:
+		return NATSReply{Status: http.StatusInternalServerError, Error: "Failed to assign token"}
+	}
+	return NATSReply{Status: http.StatusOK, Token: token}
+}
+
+func (r *NATSResponder) release(ctx context.Context, req NATSTokenRequest) NATSReply {
+	if req.Client == "" {
+		req.Client = constants.AnonymousClientID
+	}
+	releaseAt, err := r.service.ReclaimToken(ctx, req.Token, req.Client, 0)
+	switch {
+	case errors.Is(err, constants.ErrTokenNotFound):
+		return NATSReply{Status: http.StatusNotFound, Error: err.Error()}
+	case errors.Is(err, constants.ErrInvalidTransition):
+		return NATSReply{Status: http.StatusConflict, Error: err.Error()}
+	case err != nil:
+		return NATSReply{Status: http.StatusInternalServerError, Error: "Failed to unblock token"}
+	case !releaseAt.IsZero():
+		return NATSReply{Status: http.StatusAccepted, ReleaseAt: &releaseAt}
+	}
+	return NATSReply{Status: http.StatusOK}
+}
+
+func (r *NATSResponder) keepalive(ctx context.Context, req NATSTokenRequest) NATSReply {
+	err := r.service.KeepTokenAlive(ctx, req.Token, nil)
+	switch {
+	case errors.Is(err, constants.ErrTokenPrefixMismatch):
+		return NATSReply{Status: http.StatusBadRequest, Error: err.Error()}
+	case errors.Is(err, constants.ErrTokenNotFound):
+		return NATSReply{Status: http.StatusNotFound, Error: err.Error()}
+	case errors.Is(err, constants.ErrKeepaliveNotAssigned), errors.Is(err, constants.ErrMaxHoldReached):
+		return NATSReply{Status: http.StatusConflict, Error: err.Error()}
+	case err != nil:
+		return NATSReply{Status: http.StatusInternalServerError, Error: "Failed to keep token alive"}
+	}
+	return NATSReply{Status: http.StatusOK}
+}
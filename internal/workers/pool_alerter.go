@@ -0,0 +1,167 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/alerts"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var alertsSent = metrics.NewCounterVec(
+	"alerts_sent_total",
+	"Alerts sent to Slack or PagerDuty by kind and outcome.",
+	"kind", "outcome",
+)
+
+// AlertConfig sets when the PoolAlerter raises alerts
+type AlertConfig struct {
+	LowPercent   int // alert when available tokens fall below this share of the pool; 0 only alerts when exhausted
+	ErrorPercent int // alert when this share of cleanup runs in ErrorWindow failed; 0 disables
+	ErrorWindow  time.Duration
+	Throttle     time.Duration // least time between two sends of the same alert for a pool
+}
+
+// PoolAlerter notifies Slack and PagerDuty when a pool runs out of tokens,
+// runs low, or its cleanup keeps failing, and again once the condition clears.
+// State lives in Redis, so replicas share the throttle and send each alert once.
+type PoolAlerter struct {
+	service  *services.TokenService
+	channels []alerts.Channel
+	config   AlertConfig
+	logger   *slog.Logger
+}
+
+func NewPoolAlerter(service *services.TokenService, channels []alerts.Channel, config AlertConfig, logger *slog.Logger) *PoolAlerter {
+	if config.ErrorWindow < time.Minute {
+		config.ErrorWindow = constants.DefaultAlertErrorWindow
+	}
+	if config.Throttle <= 0 {
+		config.Throttle = constants.DefaultAlertThrottle
+	}
+	return &PoolAlerter{service: service, channels: channels, config: config, logger: logger}
+}
+
+// Sweep returns the alerting sweep, run for every pool on schedule
+func (a *PoolAlerter) Sweep(schedule Schedule) Sweep {
+	return Sweep{Name: "alerts", Run: a.Check, DefaultSchedule: schedule}
+}
+
+// Track wraps a cleanup sweep so its failures count toward the error rate
+func (a *PoolAlerter) Track(run func(ctx context.Context, pool string) (map[string]int64, error)) func(ctx context.Context, pool string) (map[string]int64, error) {
+	return func(ctx context.Context, pool string) (map[string]int64, error) {
+		res, err := run(ctx, pool)
+		if ctx.Err() == nil && !errors.Is(err, constants.ErrCleanupInProgress) {
+			if recordErr := a.service.RecordSweepOutcome(ctx, pool, err != nil); recordErr != nil {
+				a.logger.Warn("Failed to record sweep outcome", slog.String("pool", pool), slog.String("error", recordErr.Error()))
+			}
+		}
+		return res, err
+	}
+}
+
+// Check evaluates every alert condition for pool
+func (a *PoolAlerter) Check(ctx context.Context, pool string) (map[string]int64, error) {
+	res := map[string]int64{"raised": 0, "resolved": 0}
+
+	available, err := a.service.AvailableCount(ctx, pool)
+	if err != nil {
+		return res, err
+	}
+	size, err := a.service.PoolSize(ctx, pool)
+	if err != nil {
+		return res, err
+	}
+	// An empty pool was never provisioned, which isn't an outage
+	exhausted := size > 0 && available == 0
+	low := !exhausted && available*100 < int64(a.config.LowPercent)*size
+	details := map[string]any{"available": available, "size": size}
+
+	err = a.update(ctx, res, alerts.Alert{
+		Kind:     alerts.KindExhausted,
+		Pool:     pool,
+		Severity: "critical",
+		Summary:  fmt.Sprintf("Pool %s is exhausted: 0 of %d tokens available", pool, size),
+		Details:  details,
+	}, exhausted)
+	if err != nil {
+		return res, err
+	}
+	err = a.update(ctx, res, alerts.Alert{
+		Kind:     alerts.KindLow,
+		Pool:     pool,
+		Severity: "warning",
+		Summary:  fmt.Sprintf("Pool %s is low: %d of %d tokens available", pool, available, size),
+		Details:  details,
+	}, low)
+	if err != nil {
+		return res, err
+	}
+
+	if a.config.ErrorPercent <= 0 {
+		return res, nil
+	}
+	runs, failed, err := a.service.SweepOutcomes(ctx, pool, a.config.ErrorWindow)
+	if err != nil {
+		return res, err
+	}
+	failing := runs >= constants.AlertMinSweeps && failed*100 >= int64(a.config.ErrorPercent)*runs
+	err = a.update(ctx, res, alerts.Alert{
+		Kind:     alerts.KindCleanupErrors,
+		Pool:     pool,
+		Severity: "error",
+		Summary:  fmt.Sprintf("Cleanup of pool %s failed %d of %d runs in the last %s", pool, failed, runs, a.config.ErrorWindow),
+		Details:  map[string]any{"runs": runs, "errors": failed},
+	}, failing)
+	return res, err
+}
+
+// update raises alert when its condition holds, subject to the throttle, and
+// resolves it when the condition has cleared
+func (a *PoolAlerter) update(ctx context.Context, res map[string]int64, alert alerts.Alert, active bool) error {
+	if active {
+		send, err := a.service.RaiseAlert(ctx, alert.Pool, alert.Kind, a.config.Throttle)
+		if err != nil || !send {
+			return err
+		}
+		a.send(ctx, alert)
+		res["raised"]++
+		return nil
+	}
+
+	cleared, err := a.service.ResolveAlert(ctx, alert.Pool, alert.Kind)
+	if err != nil || !cleared {
+		return err
+	}
+	alert.Resolved = true
+	a.send(ctx, alert)
+	res["resolved"]++
+	return nil
+}
+
+// send delivers alert to every channel. Failures are logged rather than
+// retried: the throttle stops the next check from resending a raised alert
+// anyway, and a lost resolve only leaves a stale incident open.
+func (a *PoolAlerter) send(ctx context.Context, alert alerts.Alert) {
+	for _, channel := range a.channels {
+		if err := channel.Send(ctx, alert); err != nil {
+			alertsSent.Inc(alert.Kind, "error")
+			a.logger.Error("Failed to send alert",
+				slog.String("pool", alert.Pool),
+				slog.String("kind", alert.Kind),
+				slog.String("error", err.Error()))
+			continue
+		}
+		alertsSent.Inc(alert.Kind, "success")
+	}
+	a.logger.Warn("Pool alert",
+		slog.String("pool", alert.Pool),
+		slog.String("kind", alert.Kind),
+		slog.Bool("resolved", alert.Resolved),
+		slog.String("summary", alert.Summary))
+}
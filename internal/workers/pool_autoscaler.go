@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+var autoscaleTokens = metrics.NewCounterVec(
+	"pool_autoscale_tokens_total",
+	"Tokens generated or retired by pool autoscaling.",
+	"pool", "action",
+)
+
+// PoolAutoscaler grows and shrinks pools with an autoscaling policy by demand
+type PoolAutoscaler struct {
+	service *services.TokenService
+	logger  *slog.Logger
+}
+
+func NewPoolAutoscaler(service *services.TokenService, logger *slog.Logger) *PoolAutoscaler {
+	return &PoolAutoscaler{service: service, logger: logger}
+}
+
+// Sweep returns the autoscale sweep; pools without a policy are left alone,
+// as is every pool while cleanup is paused
+func (a *PoolAutoscaler) Sweep(schedule Schedule) Sweep {
+	return Sweep{Name: "autoscale", Run: a.Scale, DefaultSchedule: schedule, Pausable: true}
+}
+
+// Scale applies the pool's autoscaling policy once
+func (a *PoolAutoscaler) Scale(ctx context.Context, pool string) (map[string]int64, error) {
+	generated, retired, err := a.service.ScalePool(ctx, pool)
+	autoscaleTokens.Add(float64(generated), pool, "generated")
+	autoscaleTokens.Add(float64(retired), pool, "retired")
+	if generated > 0 || retired > 0 {
+		a.logger.Info("Autoscaled pool", slog.String("pool", pool), slog.Int("generated", generated), slog.Int("retired", retired))
+	}
+	return map[string]int64{"generated": int64(generated), "retired": int64(retired)}, err
+}
@@ -0,0 +1,218 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	replicationRuns = metrics.NewCounterVec(
+		"replication_runs_total",
+		"Replication runs to the secondary region by outcome.",
+		"outcome",
+	)
+	replicationKeys = metrics.NewCounterVec(
+		"replication_keys_total",
+		"Keys copied to or removed from the secondary region.",
+		"action",
+	)
+	replicationLastSuccess = metrics.NewGaugeVec(
+		"replication_last_success_timestamp_seconds",
+		"Unix time of the last complete replication run.",
+	)
+)
+
+// ReplicationConfig tunes the Replicator
+type ReplicationConfig struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// ReplicationResult counts what one replication run did
+type ReplicationResult struct {
+	Copied  int64
+	Removed int64
+}
+
+// Replicator mirrors the primary Redis to a secondary in another region. It
+// runs asynchronously, so the secondary trails the primary by up to Interval.
+// Conflicts always resolve in favour of the primary: its keys overwrite the
+// secondary's and keys the primary no longer has are removed. Only one
+// replica replicates at a time.
+type Replicator struct {
+	source *redis.Client
+	target *redis.Client
+	config ReplicationConfig
+	logger *slog.Logger
+}
+
+func NewReplicator(source, target *redis.Client, config ReplicationConfig, logger *slog.Logger) *Replicator {
+	if config.Interval <= 0 {
+		config.Interval = constants.DefaultReplicationInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = constants.DefaultReplicationBatch
+	}
+	return &Replicator{source: source, target: target, config: config, logger: logger}
+}
+
+// Run replicates every Interval until ctx is cancelled, then closes the
+// secondary's client
+func (r *Replicator) Run(ctx context.Context) {
+	defer r.target.Close()
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		r.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Replicator) runOnce(ctx context.Context) {
+	// The lock expires with the interval, so a replica that dies mid-run
+	// only delays the next one
+	locked, err := r.source.SetNX(ctx, constants.KeyReplicationLock, 1, r.config.Interval).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			replicationRuns.Inc("error")
+			r.logger.Error("Failed to take replication lock", slog.String("error", err.Error()))
+		}
+		return
+	}
+	if !locked {
+		return
+	}
+
+	start := time.Now()
+	res, err := r.Sync(ctx)
+	replicationKeys.Add(float64(res.Copied), "copied")
+	replicationKeys.Add(float64(res.Removed), "removed")
+	if err != nil {
+		if ctx.Err() == nil {
+			replicationRuns.Inc("error")
+			r.logger.Error("Replication failed",
+				slog.Int64("copied", res.Copied), slog.Int64("removed", res.Removed), slog.String("error", err.Error()))
+		}
+		return
+	}
+	replicationRuns.Inc("success")
+	replicationLastSuccess.Set(float64(time.Now().Unix()))
+	r.logger.Debug("Replicated to secondary region",
+		slog.Int64("copied", res.Copied), slog.Int64("removed", res.Removed), slog.Duration("took", time.Since(start)))
+}
+
+// Sync copies every key of the primary to the secondary, keeping their TTLs,
+// then removes keys from the secondary that the primary no longer has
+func (r *Replicator) Sync(ctx context.Context) (ReplicationResult, error) {
+	var res ReplicationResult
+	err := scanKeys(ctx, r.source, r.config.BatchSize, func(keys []string) error {
+		copied, err := r.copyKeys(ctx, keys)
+		res.Copied += copied
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	err = scanKeys(ctx, r.target, r.config.BatchSize, func(keys []string) error {
+		removed, err := r.removeStale(ctx, keys)
+		res.Removed += removed
+		return err
+	})
+	return res, err
+}
+
+// copyKeys dumps keys from the primary and restores them over the secondary's
+func (r *Replicator) copyKeys(ctx context.Context, keys []string) (int64, error) {
+	dumps := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := r.source.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			dumps[i] = pipe.Dump(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("failed to dump keys: %w", err)
+	}
+
+	var copied int64
+	_, err = r.target.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			payload, err := dumps[i].Result()
+			if err != nil { // expired or deleted since the scan
+				continue
+			}
+			ttl := ttls[i].Val()
+			if ttl < 0 { // no expiry
+				ttl = 0
+			}
+			pipe.RestoreReplace(ctx, key, ttl, payload)
+			copied++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore keys on secondary: %w", err)
+	}
+	return copied, nil
+}
+
+// removeStale deletes keys from the secondary that the primary doesn't have
+func (r *Replicator) removeStale(ctx context.Context, keys []string) (int64, error) {
+	exists := make([]*redis.IntCmd, len(keys))
+	_, err := r.source.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			exists[i] = pipe.Exists(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to check keys on primary: %w", err)
+	}
+
+	var stale []string
+	for i, key := range keys {
+		if exists[i].Val() == 0 {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	if err := r.target.Del(ctx, stale...).Err(); err != nil {
+		return 0, fmt.Errorf("failed to remove stale keys from secondary: %w", err)
+	}
+	return int64(len(stale)), nil
+}
+
+// scanKeys calls fn with each batch of keys SCAN returns from client
+func scanKeys(ctx context.Context, client *redis.Client, count int, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "*", int64(count)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
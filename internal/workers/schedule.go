@@ -0,0 +1,167 @@
+package workers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a recurring job runs next
+type Schedule interface {
+	// Next returns the first activation time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule accepts either an interval ("10s", "@every 5m") or a standard
+// five-field cron expression ("minute hour day-of-month month day-of-week").
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		spec = strings.TrimSpace(every)
+	}
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("schedule interval must be positive: %q", spec)
+		}
+		return IntervalSchedule(d), nil
+	}
+
+	return parseCron(spec)
+}
+
+// IntervalSchedule fires at a fixed period
+type IntervalSchedule time.Duration
+
+func (s IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronSchedule stores each cron field as a bitset of allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week, Sunday = 0
+}
+
+func parseCron(spec string) (Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want a duration or 5 cron fields", spec)
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		bits[i] = b
+	}
+
+	return cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+// parseCronField handles "*", "a", "a-b", "*/n", "a-b/n" and comma separated lists of those
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", item)
+			}
+			step = n
+		}
+
+		lo, hi := bounds.min, bounds.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("bad value in %q", item)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("bad range in %q", item)
+				}
+			} else if hasStep {
+				hi = bounds.max
+			}
+		}
+
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", item, bounds.min, bounds.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Any valid expression matches within a few years; bail out rather than spin forever
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			// Built from fields rather than Truncate, which works in UTC and breaks half-hour zones
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted a day
+// matching either of them counts
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,148 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/internal/metrics"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/secrets"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+// probeTokenPlaceholder is replaced by the token in the probe URL and headers
+const probeTokenPlaceholder = "{token}"
+
+var (
+	quarantineSize = metrics.NewGaugeVec(
+		"token_quarantine_size",
+		"Tokens held in quarantine, per pool.",
+		"pool",
+	)
+	quarantineOldestAge = metrics.NewGaugeVec(
+		"token_quarantine_oldest_age_seconds",
+		"How long the longest quarantined token has been out of its pool.",
+		"pool",
+	)
+)
+
+// ProbeConfig describes the upstream request used to check a token
+type ProbeConfig struct {
+	URL     string            // may contain {token}, which is query escaped
+	Method  string            // GET when empty
+	Headers map[string]string // values may contain {token}
+	Timeout time.Duration
+}
+
+// TokenProber exercises available and quarantined tokens against an upstream
+// health URL. Tokens that fail are quarantined out of the pool and put back
+// once they pass again.
+type TokenProber struct {
+	service *services.TokenService
+	config  ProbeConfig
+	client  *http.Client
+	logger  *slog.Logger
+}
+
+func NewTokenProber(service *services.TokenService, config ProbeConfig, logger *slog.Logger) *TokenProber {
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	return &TokenProber{
+		service: service,
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		logger:  logger,
+	}
+}
+
+// Sweep returns the probe sweep, run for every pool on schedule
+func (p *TokenProber) Sweep(schedule Schedule) Sweep {
+	return Sweep{Name: "probe", Run: p.Probe, DefaultSchedule: schedule}
+}
+
+// Probe checks every available and quarantined token in the pool
+func (p *TokenProber) Probe(ctx context.Context, pool string) (map[string]int64, error) {
+	targets, err := p.service.ProbeTargets(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]int64{"probed": 0, "quarantined": 0, "restored": 0}
+	for _, target := range targets {
+		// Hash-only handles can't be sent upstream without the secret behind them
+		if secrets.IsHandle(target.Token) {
+			continue
+		}
+
+		result := p.check(ctx, target.Token)
+		moved, err := p.service.RecordProbe(ctx, pool, target.Ref, result)
+		if err != nil {
+			return res, err
+		}
+		res["probed"]++
+		if !moved {
+			continue
+		}
+		if result.Healthy {
+			res["restored"]++
+		} else {
+			res["quarantined"]++
+			p.logger.Warn("Quarantined token after failed probe",
+				slog.String("pool", pool),
+				slog.Int("status", result.StatusCode),
+				slog.String("error", result.Error))
+		}
+	}
+
+	size, oldest, err := p.service.QuarantineStats(ctx, pool)
+	if err != nil {
+		return res, err
+	}
+	quarantineSize.Set(float64(size), pool)
+	age := 0.0
+	if !oldest.IsZero() {
+		age = time.Since(oldest).Seconds()
+	}
+	quarantineOldestAge.Set(age, pool)
+	return res, nil
+}
+
+// check sends one probe request; any 2xx response counts as healthy
+func (p *TokenProber) check(ctx context.Context, token string) repositories.ProbeResult {
+	result := repositories.ProbeResult{CheckedAt: time.Now()}
+
+	target := strings.ReplaceAll(p.config.URL, probeTokenPlaceholder, url.QueryEscape(token))
+	req, err := http.NewRequestWithContext(ctx, p.config.Method, target, nil)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to build probe request: %v", err)
+		return result
+	}
+	for name, value := range p.config.Headers {
+		req.Header.Set(name, strings.ReplaceAll(value, probeTokenPlaceholder, token))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// url.Error carries the request URL, which may contain the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	result.StatusCode = resp.StatusCode
+	result.Healthy = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return result
+}
@@ -0,0 +1,112 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/datasources"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+// VaultPool describes where a pool's tokens are minted in Vault
+type VaultPool struct {
+	Path         string
+	Method       string
+	Field        string
+	MinAvailable int
+}
+
+// VaultProvisioner keeps Vault backed pools topped up and revokes the leases
+// of tokens that have been deleted
+type VaultProvisioner struct {
+	vault   *datasources.VaultClient
+	service *services.TokenService
+	pools   map[string]VaultPool
+	logger  *slog.Logger
+}
+
+func NewVaultProvisioner(vault *datasources.VaultClient, service *services.TokenService, pools map[string]VaultPool, logger *slog.Logger) *VaultProvisioner {
+	return &VaultProvisioner{vault: vault, service: service, pools: pools, logger: logger}
+}
+
+// Sweeps returns the replenish and revoke sweeps, both run on schedule
+func (p *VaultProvisioner) Sweeps(schedule Schedule) []Sweep {
+	return []Sweep{
+		{Name: "replenish", Run: p.Replenish, DefaultSchedule: schedule},
+		{Name: "revoke", Run: p.RevokeRetired, DefaultSchedule: schedule},
+	}
+}
+
+// Replenish mints credentials until the pool has MinAvailable tokens ready
+func (p *VaultProvisioner) Replenish(ctx context.Context, pool string) (map[string]int64, error) {
+	cfg, ok := p.pools[pool]
+	if !ok {
+		return nil, nil
+	}
+
+	available, err := p.service.AvailableCount(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	missing := min(int64(cfg.MinAvailable)-available, constants.VaultReplenishLimit)
+
+	var minted int64
+	for ; minted < missing; minted++ {
+		secret, err := p.vault.Mint(ctx, cfg.Method, cfg.Path)
+		if err != nil {
+			return map[string]int64{"minted": minted}, err
+		}
+		token, ok := secret.Data[cfg.Field].(string)
+		if !ok || token == "" {
+			return map[string]int64{"minted": minted}, fmt.Errorf("vault secret at %s has no %q field", cfg.Path, cfg.Field)
+		}
+		if err := p.service.ProvisionToken(ctx, pool, token, secret.LeaseID); err != nil {
+			return map[string]int64{"minted": minted}, err
+		}
+	}
+
+	if minted > 0 {
+		p.logger.Info("Replenished pool from Vault", slog.String("pool", pool), slog.Int64("minted", minted))
+	}
+	return map[string]int64{"minted": minted}, nil
+}
+
+// Rotate revokes the leases of a pool's deleted tokens and mints their
+// replacements straight away, rather than waiting for both sweeps to come due
+func (p *VaultProvisioner) Rotate(ctx context.Context, pool string) error {
+	if _, err := p.RevokeRetired(ctx, pool); err != nil {
+		return err
+	}
+	_, err := p.Replenish(ctx, pool)
+	return err
+}
+
+// RevokeRetired revokes the Vault leases of tokens that no longer exist
+func (p *VaultProvisioner) RevokeRetired(ctx context.Context, pool string) (map[string]int64, error) {
+	if _, ok := p.pools[pool]; !ok {
+		return nil, nil
+	}
+
+	retired, err := p.service.RetiredLeases(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked int64
+	for ref, leaseID := range retired {
+		if err := p.vault.Revoke(ctx, leaseID); err != nil {
+			return map[string]int64{"revoked": revoked}, err
+		}
+		if err := p.service.ForgetLease(ctx, pool, ref); err != nil {
+			return map[string]int64{"revoked": revoked}, err
+		}
+		revoked++
+	}
+
+	if revoked > 0 {
+		p.logger.Info("Revoked retired Vault leases", slog.String("pool", pool), slog.Int64("revoked", revoked))
+	}
+	return map[string]int64{"revoked": revoked}, nil
+}
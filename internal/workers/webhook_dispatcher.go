@@ -0,0 +1,125 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/callbacks"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+)
+
+// WebhookDispatcher delivers audit events to the webhook subscriptions
+// managed through the admin API. Each event is POSTed to every enabled
+// subscription whose filter matches, signed with that subscription's secret
+// and with the audit entry ID as event ID. Every delivery is a job on the
+// shared queue, so a failing subscription is retried with the queue's
+// backoff and, after Jobs.MaxAttempts, dead-lettered without holding up the
+// others; every attempt shows up in the delivery log. Replicas share the
+// events through a consumer group.
+type WebhookDispatcher struct {
+	service  *services.TokenService
+	notifier *callbacks.Notifier
+	queue    *jobs.Queue
+	consumer string
+	logger   *slog.Logger
+}
+
+// NewWebhookDispatcher creates a dispatcher and registers its delivery job on
+// the queue; call Run to start it
+func NewWebhookDispatcher(service *services.TokenService, notifier *callbacks.Notifier, queue *jobs.Queue, consumer string, logger *slog.Logger) *WebhookDispatcher {
+	d := &WebhookDispatcher{service: service, notifier: notifier, queue: queue, consumer: consumer, logger: logger}
+	queue.Register(constants.WebhookDeliveryJob, d.deliver)
+	return d
+}
+
+// Run hands events to the delivery job until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	for {
+		err := d.service.StartAuditConsumer(ctx, constants.WebhookGroup)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		d.logger.Error("Failed to start webhook dispatch", slog.String("error", err.Error()))
+		sleep(ctx, constants.AuditExportBlock)
+	}
+
+	for ctx.Err() == nil {
+		entries, err := d.service.NextAuditEntries(ctx, constants.WebhookGroup, d.consumer, constants.DefaultAuditExportBatch)
+		if err == nil && len(entries) > 0 {
+			err = d.dispatch(ctx, entries)
+		}
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("Webhook dispatch failed", slog.String("error", err.Error()))
+			sleep(ctx, constants.AuditExportBlock)
+		}
+	}
+}
+
+// dispatch enqueues a delivery of each event to each matching webhook, then
+// acknowledges the batch. A batch that fails part way is read again, so a
+// webhook may receive an event twice; the event ID lets it tell.
+func (d *WebhookDispatcher) dispatch(ctx context.Context, entries []repositories.AuditEntry) error {
+	webhooks, err := d.service.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+		var encoded []byte
+		for _, webhook := range webhooks {
+			if !webhook.Enabled || !webhook.Matches(entry.Action) {
+				continue
+			}
+			if encoded == nil {
+				if encoded, err = json.Marshal(entry); err != nil {
+					return fmt.Errorf("failed to encode event %s: %w", entry.ID, err)
+				}
+			}
+			payload := map[string]string{"webhook": webhook.ID, "event": string(encoded)}
+			if _, err := d.queue.Enqueue(ctx, constants.WebhookDeliveryJob, payload); err != nil {
+				return err
+			}
+		}
+	}
+	return d.service.AckAuditEntries(ctx, constants.WebhookGroup, ids...)
+}
+
+// deliver POSTs one event to one webhook. Returning the error lets the queue
+// retry it; a webhook deleted or disabled since is skipped.
+func (d *WebhookDispatcher) deliver(ctx context.Context, job jobs.Job) error {
+	var entry repositories.AuditEntry
+	if err := json.Unmarshal([]byte(job.Payload["event"]), &entry); err != nil {
+		return fmt.Errorf("%w: undecodable event: %v", constants.ErrJobPermanent, err)
+	}
+	webhook, err := d.service.Webhook(ctx, job.Payload["webhook"])
+	if errors.Is(err, constants.ErrWebhookNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !webhook.Enabled {
+		return nil
+	}
+
+	return d.notifier.Send(ctx, callbacks.Message{
+		EventID: entry.ID,
+		URL:     webhook.URL,
+		Secret:  []byte(webhook.Secret),
+		Payload: entry,
+		Webhook: webhook.ID,
+		Pool:    entry.Pool,
+		Reason:  entry.Action,
+	})
+}
@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// New builds the application logger. Local runs get a human-readable text
+// handler, every other environment logs JSON for the log pipeline.
+func New(w io.Writer, env, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+
+	if env == "local" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// ParseLevel maps the configured LogLevel onto a slog level, defaulting to Info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToUpper(strings.TrimSpace(level)) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
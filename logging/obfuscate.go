@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Obfuscator hides a token value where it would otherwise be shown in full,
+// e.g. log lines and listings. It must not return the value itself.
+type Obfuscator func(token string) string
+
+// NewObfuscator returns the obfuscator named by mode: mask (MaskToken), hash
+// (HashToken), or nil for "" and none, which leave tokens as they are
+func NewObfuscator(mode string) (Obfuscator, error) {
+	switch mode {
+	case "", "none":
+		return nil, nil
+	case "mask":
+		return MaskToken, nil
+	case "hash":
+		return HashToken, nil
+	default:
+		return nil, fmt.Errorf("unknown token obfuscation %q, must be none, mask or hash", mode)
+	}
+}
+
+// MaskToken keeps a token's pool prefix (up to and including its first "_")
+// and its last 4 characters, so a leak can still be traced to a pool and told
+// apart from its neighbours: stg_3f9c...a81e becomes stg_…a81e. Tokens too
+// short for that are masked entirely.
+func MaskToken(token string) string {
+	prefix := ""
+	if i := strings.IndexByte(token, '_'); i >= 0 && i < 16 {
+		prefix, token = token[:i+1], token[i+1:]
+	}
+	if len(token) <= 8 {
+		return prefix + "****"
+	}
+	return prefix + "…" + token[len(token)-4:]
+}
+
+// HashToken replaces a token with the start of its SHA-256, which is stable
+// across log lines without showing any of the value
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+var tokenObfuscator atomic.Pointer[Obfuscator]
+
+// SetTokenObfuscator sets how Token shows tokens in log lines process-wide;
+// nil shows them in full
+func SetTokenObfuscator(o Obfuscator) {
+	tokenObfuscator.Store(&o)
+}
+
+// Token returns token as it should appear in a log line
+func Token(token string) string {
+	if o := tokenObfuscator.Load(); o != nil && *o != nil {
+		return (*o)(token)
+	}
+	return token
+}
@@ -0,0 +1,88 @@
+package receipts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+)
+
+// Claims is what a receipt vouches for: who was handed which token, until when
+type Claims struct {
+	Token     string `json:"token"`
+	Pool      string `json:"pool"`
+	Client    string `json:"client"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Signer issues and verifies checkout receipts. A receipt is
+// base64url(claims JSON) + "." + base64url(HMAC-SHA256), so any service
+// holding the same key can verify it without calling the token manager.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns nil for an empty key, which disables receipts
+func NewSigner(key string) *Signer {
+	if key == "" {
+		return nil
+	}
+	return &Signer{key: []byte(key)}
+}
+
+// Issue signs a receipt for a token assigned now and valid for ttl
+func (s *Signer) Issue(token, pool, client string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(Claims{
+		Token:     token,
+		Pool:      pool,
+		Client:    client,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode receipt: %w", err)
+	}
+
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(s.sign(body)), nil
+}
+
+// Verify checks the signature and expiry of a receipt and returns its claims
+func (s *Signer) Verify(receipt string) (*Claims, error) {
+	body, sig, ok := strings.Cut(receipt, ".")
+	if !ok {
+		return nil, constants.ErrInvalidReceipt
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.sign(body)) {
+		return nil, constants.ErrInvalidReceipt
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, constants.ErrInvalidReceipt
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, constants.ErrInvalidReceipt
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return &claims, constants.ErrReceiptExpired
+	}
+	return &claims, nil
+}
+
+func (s *Signer) sign(body string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(body))
+	return h.Sum(nil)
+}
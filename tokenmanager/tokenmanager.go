@@ -0,0 +1,230 @@
+// Package tokenmanager embeds the token manager core (store, service and
+// background workers) into another Go service without the HTTP API.
+package tokenmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/manankarani/token-manager/constants"
+	"github.com/manankarani/token-manager/internal/encryption"
+	"github.com/manankarani/token-manager/internal/jobs"
+	"github.com/manankarani/token-manager/internal/repositories"
+	"github.com/manankarani/token-manager/internal/services"
+	"github.com/manankarani/token-manager/internal/workers"
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by Manager methods, for use with errors.Is
+var (
+	ErrNoAvailableTokens = constants.ErrNoAvailableTokens
+	ErrTokenNotFound     = constants.ErrTokenNotFound
+	ErrTokenNotAssigned  = constants.ErrTokenNotAssigned
+	ErrPrefixMismatch    = constants.ErrTokenPrefixMismatch
+	ErrCapReached        = constants.ErrAssignmentCapReached
+	ErrMaxHoldReached    = constants.ErrMaxHoldReached
+	ErrAlreadyStarted    = errors.New("manager already started")
+)
+
+// DefaultPool is used by the methods that don't take a pool argument
+const DefaultPool = constants.DefaultPool
+
+// Config configures an embedded manager. Zero values fall back to the same
+// defaults as the standalone server.
+type Config struct {
+	Redis     *redis.Client     // required; the caller owns and closes it
+	Logger    *slog.Logger      // defaults to slog.Default()
+	Fallbacks map[string]string // pool -> pool to draw from when it is empty
+	Prefixes  map[string]string // pool -> prefix prepended to its generated tokens
+
+	EncryptionKey string // base64 AES-256 key; empty stores tokens in plaintext
+
+	MaxConcurrentAssignments int           // tokens assigned at once across every pool; 0 is unlimited
+	MaxHold                  time.Duration // longest a token may be held however often it is kept alive; 0 is unlimited
+
+	ReleaseSchedule   string // interval or cron; defaults to "@every 5s"
+	DeletionSchedule  string // defaults to "@every 5m"
+	MaxJitter         time.Duration
+	DiscoveryInterval time.Duration
+
+	CleanupWorkers int // concurrent cleanup batches per sweep
+	JobWorkers     int // background jobs processed concurrently
+	JobMaxAttempts int // attempts before a failed job is dead-lettered
+}
+
+// Assignment is a token handed to a caller
+type Assignment struct {
+	Token string
+	Pool  string // pool that served the token, possibly a fallback of the requested one
+}
+
+// Manager is the embeddable token manager. Token operations work as soon as
+// New returns; Start runs the background cleanup until Stop is called.
+type Manager struct {
+	service   *services.TokenService
+	jobs      *jobs.Queue
+	scheduler *workers.CleanupScheduler
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New builds a manager on the given Redis client
+func New(config Config) (*Manager, error) {
+	if config.Redis == nil {
+		return nil, errors.New("tokenmanager: Config.Redis is required")
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.DiscoveryInterval <= 0 {
+		config.DiscoveryInterval = constants.DefaultPoolDiscoveryInterval
+	}
+
+	var cipher *encryption.Cipher
+	if config.EncryptionKey != "" {
+		var err error
+		if cipher, err = encryption.NewCipher(config.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("tokenmanager: %w", err)
+		}
+	}
+
+	timing := repositories.TimingConfig{MaxHold: config.MaxHold}
+	if err := timing.Validate(); err != nil {
+		return nil, fmt.Errorf("tokenmanager: %w", err)
+	}
+
+	repo := repositories.NewTokenRepository(config.Redis, repositories.Config{
+		Cleanup: repositories.CleanupConfig{Workers: config.CleanupWorkers},
+		Cipher:  cipher,
+		Timing:  timing,
+
+		AssignmentCap: config.MaxConcurrentAssignments,
+	})
+	for pool, prefix := range config.Prefixes {
+		if !services.ValidPrefix(prefix) {
+			return nil, fmt.Errorf("tokenmanager: invalid prefix %q for pool %s", prefix, pool)
+		}
+	}
+	service := services.NewTokenService(repo, services.Config{Fallbacks: config.Fallbacks, Prefixes: config.Prefixes})
+
+	release, err := workers.ParseSchedule(orDefault(config.ReleaseSchedule, constants.DefaultReleaseSchedule))
+	if err != nil {
+		return nil, fmt.Errorf("tokenmanager: ReleaseSchedule: %w", err)
+	}
+	deletion, err := workers.ParseSchedule(orDefault(config.DeletionSchedule, constants.DefaultDeletionSchedule))
+	if err != nil {
+		return nil, fmt.Errorf("tokenmanager: DeletionSchedule: %w", err)
+	}
+
+	queue := jobs.NewQueue(config.Redis, jobs.Config{
+		Workers:     config.JobWorkers,
+		MaxAttempts: config.JobMaxAttempts,
+	}, config.Logger)
+	scheduler := workers.NewCleanupScheduler(
+		[]workers.Sweep{
+			{Name: "release", Run: service.ReleaseExpiredTokens, DefaultSchedule: release},
+			{Name: "delete", Run: service.DeleteExpiredTokens, DefaultSchedule: deletion},
+		},
+		service.ListPools,
+		queue,
+		workers.CleanupScheduleConfig{MaxJitter: config.MaxJitter, DiscoveryInterval: config.DiscoveryInterval},
+		config.Logger,
+	)
+
+	return &Manager{service: service, jobs: queue, scheduler: scheduler, logger: config.Logger}, nil
+}
+
+// Start runs the cleanup scheduler and job workers in the background
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return ErrAlreadyStarted
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.scheduler.Run(ctx)
+	}()
+	go func() {
+		defer m.wg.Done()
+		if err := m.jobs.Run(ctx); err != nil {
+			m.logger.Error("Job queue error", slog.String("error", err.Error()))
+		}
+	}()
+	return nil
+}
+
+// Stop halts the background workers and waits for in-flight work to finish
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	cancel := m.cancel
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		m.wg.Wait()
+	}
+}
+
+// Generate creates a new token in pool
+func (m *Manager) Generate(ctx context.Context, pool string) (string, error) {
+	token, err := m.service.GenerateToken(ctx, pool, nil, time.Time{})
+	if err != nil {
+		return "", err
+	}
+	return token.Value, nil
+}
+
+// Import adds an externally issued token to pool
+func (m *Manager) Import(ctx context.Context, pool, token string) error {
+	_, err := m.service.ImportToken(ctx, pool, token, nil, time.Time{})
+	return err
+}
+
+// Assign hands out a token from pool, walking its fallback chain if it is empty
+func (m *Manager) Assign(ctx context.Context, pool string) (Assignment, error) {
+	token, err := m.service.AssignToken(ctx, pool, constants.AnonymousClientID, nil)
+	if err != nil {
+		return Assignment{}, err
+	}
+	return Assignment{Token: token.Value, Pool: token.Pool}, nil
+}
+
+// KeepAlive extends a held token's lease, failing with ErrMaxHoldReached once
+// it has been held for MaxHold
+func (m *Manager) KeepAlive(ctx context.Context, token string) error {
+	return m.service.KeepTokenAlive(ctx, token, nil)
+}
+
+// Release returns a held token to its pool
+func (m *Manager) Release(ctx context.Context, token string) error {
+	return m.service.UnblockToken(ctx, token)
+}
+
+// Delete removes a token permanently
+func (m *Manager) Delete(ctx context.Context, token string) error {
+	return m.service.DeleteToken(ctx, token, 0)
+}
+
+// Available lists the tokens ready for assignment in pool
+func (m *Manager) Available(ctx context.Context, pool string) ([]string, error) {
+	return m.service.GetAvailableTokens(ctx, pool)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}